@@ -0,0 +1,508 @@
+package archive
+
+import (
+	"archive/tar"
+	azip "archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/calvine/filejitsu/encrypt"
+	fgzip "github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	gnuSparsePAXPrefix = "GNU.sparse."
+)
+
+var (
+	ErrSameFormat = errors.New("source and target formats are the same")
+)
+
+type EncryptionOptions struct {
+	Passphrase []byte
+}
+
+type ConvertParams struct {
+	Input  io.Reader
+	Output io.Writer
+	From   Format
+	To     Format
+	// CompressionLevel is used for gzip or zstd compression of a tar target, and deflate compression of zip members.
+	CompressionLevel  fgzip.GZipCompressionLevel
+	EncryptionOptions EncryptionOptions
+}
+
+// ConvertReport summarizes a conversion.
+type ConvertReport struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	NumEntries int    `json:"numEntries"`
+	// NumSkipped is the number of entries that could not be represented in the target format at all.
+	NumSkipped int `json:"numSkipped"`
+	// Warnings describe the metadata that was lost, with the number of entries each applies to.
+	Warnings []string `json:"warnings"`
+}
+
+// warningCollector counts each distinct warning so a conversion of many entries does not repeat itself.
+type warningCollector struct {
+	logger *slog.Logger
+	order  []string
+	counts map[string]int
+}
+
+func newWarningCollector(logger *slog.Logger) *warningCollector {
+	return &warningCollector{
+		logger: logger,
+		order:  make([]string, 0),
+		counts: make(map[string]int),
+	}
+}
+
+func (w *warningCollector) add(name, warning string) {
+	w.logger.Debug("metadata can not be represented in target format", slog.String("name", name), slog.String("warning", warning))
+	if _, ok := w.counts[warning]; !ok {
+		w.logger.Warn(warning, slog.String("firstEntry", name))
+		w.order = append(w.order, warning)
+	}
+	w.counts[warning]++
+}
+
+func (w *warningCollector) warnings() []string {
+	warnings := make([]string, 0, len(w.order))
+	for _, warning := range w.order {
+		warnings = append(warnings, fmt.Sprintf("%s (%d entries)", warning, w.counts[warning]))
+	}
+	return warnings
+}
+
+// entryWriter writes converted entries to the target archive.
+type entryWriter interface {
+	// writeEntry writes the entry described by the tar header. It returns false if the entry can not be represented and was skipped.
+	writeEntry(header *tar.Header, content io.Reader) (bool, error)
+	close() error
+}
+
+// Convert streams every entry of the input archive into an archive of the target format.
+// Metadata is kept where the target format can store it, anything else is reported as a warning.
+func Convert(logger *slog.Logger, params ConvertParams) (ConvertReport, error) {
+	report := ConvertReport{
+		From: params.From.String(),
+		To:   params.To.String(),
+	}
+	logger.Debug("converting archive", slog.String("from", report.From), slog.String("to", report.To))
+	if params.From == params.To {
+		logger.Error("source and target formats are the same", slog.String("format", report.From))
+		return report, ErrSameFormat
+	}
+	warnings := newWarningCollector(logger)
+
+	out := params.Output
+	var closers []func() error
+	closeAll := func() error {
+		var closeErr error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				closeErr = errors.Join(closeErr, err)
+			}
+		}
+		closers = nil
+		return closeErr
+	}
+	defer closeAll()
+	if params.To.Encrypted {
+		encryptedOut, err := encrypt.NewAESEncryptionWriter(logger, out, params.EncryptionOptions.Passphrase)
+		if err != nil {
+			logger.Error("failed to create encrypted stream writer", slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+		out = encryptedOut
+		closers = append(closers, encryptedOut.Close)
+	}
+	compressedOut, err := NewCompressionWriter(logger, out, params.To.Compression, params.CompressionLevel)
+	if err != nil {
+		return report, err
+	}
+	out = compressedOut
+	closers = append(closers, compressedOut.Close)
+
+	var target entryWriter
+	if params.To.Container == ContainerZip {
+		target, err = newZipEntryWriter(out, params.CompressionLevel, warnings)
+		if err != nil {
+			return report, err
+		}
+	} else {
+		target = newTarEntryWriter(out, warnings)
+	}
+
+	if params.From.Container == ContainerZip {
+		err = convertFromZip(logger, params, target, &report, warnings)
+	} else {
+		err = convertFromTar(logger, params, target, &report)
+	}
+	if err != nil {
+		return report, err
+	}
+	if err := target.close(); err != nil {
+		logger.Error("failed to finish target archive", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	if err := closeAll(); err != nil {
+		logger.Error("failed to close target archive", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	report.Warnings = warnings.warnings()
+	logger.Info("finished converting archive", slog.Int("numEntries", report.NumEntries), slog.Int("numSkipped", report.NumSkipped), slog.Int("numWarnings", len(report.Warnings)))
+	return report, nil
+}
+
+func convertFromTar(logger *slog.Logger, params ConvertParams, target entryWriter, report *ConvertReport) error {
+	in := params.Input
+	if params.From.Encrypted {
+		decryptionReader, err := encrypt.NewAESDecryptionReader(logger, in, params.EncryptionOptions.Passphrase)
+		if err != nil {
+			logger.Error("failed to create decryption reader", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		in = decryptionReader
+	}
+	decompressedIn, closeDecompression, err := NewDecompressionReader(logger, in, params.From.Compression)
+	if err != nil {
+		return err
+	}
+	defer closeDecompression()
+	tarReader := tar.NewReader(decompressedIn)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Error("failed to read next from tar archive", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if err := writeConvertedEntry(logger, target, header, tarReader, report); err != nil {
+			return err
+		}
+	}
+}
+
+func convertFromZip(logger *slog.Logger, params ConvertParams, target entryWriter, report *ConvertReport, warnings *warningCollector) error {
+	zipReader, closeZipReader, err := zip.OpenZipReader(logger, zip.ZipReadParams{
+		Input:             params.Input,
+		UseEncryption:     params.From.Encrypted,
+		EncryptionOptions: zip.EncryptionOptions(params.EncryptionOptions),
+	})
+	if err != nil {
+		return err
+	}
+	defer closeZipReader()
+	if len(zipReader.Comment) > 0 && params.To.Container != ContainerZip {
+		warnings.add("", "zip archive comment is not stored in tar archives")
+	}
+	for _, f := range zipReader.File {
+		if zipTarget, ok := target.(*zipEntryWriter); ok {
+			// zip to zip only changes the encryption, so members are copied without recompressing them
+			if err := zipTarget.copyFile(f); err != nil {
+				logger.Error("failed to copy zip member", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+				return err
+			}
+			report.NumEntries++
+			continue
+		}
+		if len(f.Comment) > 0 {
+			warnings.add(f.Name, "zip member comments are not stored in tar archives")
+		}
+		if err := convertZipFile(logger, f, target, report, warnings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertZipFile(logger *slog.Logger, f *azip.File, target entryWriter, report *ConvertReport, warnings *warningCollector) error {
+	info := f.FileInfo()
+	name := cleanMemberName(f.Name)
+	if len(name) == 0 {
+		warnings.add(f.Name, "members without a name inside the archive root were skipped")
+		report.NumSkipped++
+		return nil
+	}
+	if name != strings.TrimSuffix(f.Name, "/") {
+		warnings.add(f.Name, "member names were cleaned so they stay inside the archive root")
+	}
+	if info.IsDir() {
+		name += "/"
+	}
+	rc, err := f.Open()
+	if err != nil {
+		logger.Error("failed to open zip member", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer rc.Close()
+	link := ""
+	var content io.Reader = rc
+	if info.Mode()&fs.ModeSymlink != 0 {
+		// zip stores the target of a symlink as its content
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		link = string(data)
+		content = strings.NewReader("")
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		logger.Error("failed to create tar header for zip member", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	header.Name = name
+	header.ModTime = f.Modified
+	return writeConvertedEntry(logger, target, header, content, report)
+}
+
+// cleanMemberName returns the name as a relative slash separated path, so a member like ../evil or /etc/passwd can not be extracted outside
+// of the target directory. Directory names lose their trailing slash.
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+func writeConvertedEntry(logger *slog.Logger, target entryWriter, header *tar.Header, content io.Reader, report *ConvertReport) error {
+	written, err := target.writeEntry(header, content)
+	if err != nil {
+		logger.Error("failed to write converted entry", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if !written {
+		report.NumSkipped++
+		return nil
+	}
+	report.NumEntries++
+	return nil
+}
+
+type tarEntryWriter struct {
+	tarWriter *tar.Writer
+	warnings  *warningCollector
+}
+
+func newTarEntryWriter(w io.Writer, warnings *warningCollector) *tarEntryWriter {
+	return &tarEntryWriter{
+		tarWriter: tar.NewWriter(w),
+		warnings:  warnings,
+	}
+}
+
+func (t *tarEntryWriter) writeEntry(header *tar.Header, content io.Reader) (bool, error) {
+	if header.Typeflag == tar.TypeGNUSparse || hasSparsePAXRecords(header) {
+		// the reader already expands sparse files, the holes are written out as zeros
+		t.warnings.add(header.Name, "sparse file holes are written as zeros")
+		header.Typeflag = tar.TypeReg
+		for key := range header.PAXRecords {
+			if strings.HasPrefix(key, gnuSparsePAXPrefix) {
+				delete(header.PAXRecords, key)
+			}
+		}
+	}
+	if err := t.tarWriter.WriteHeader(header); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(t.tarWriter, content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *tarEntryWriter) close() error {
+	return t.tarWriter.Close()
+}
+
+type zipEntryWriter struct {
+	zipWriter *azip.Writer
+	method    uint16
+	warnings  *warningCollector
+}
+
+func newZipEntryWriter(w io.Writer, level fgzip.GZipCompressionLevel, warnings *warningCollector) (*zipEntryWriter, error) {
+	z := &zipEntryWriter{
+		zipWriter: azip.NewWriter(w),
+		method:    azip.Deflate,
+		warnings:  warnings,
+	}
+	if level == fgzip.NoCompression {
+		z.method = azip.Store
+		return z, nil
+	}
+	flateLevel := flate.DefaultCompression
+	if len(level) > 0 {
+		var err error
+		flateLevel, err = fgzip.GZipCompressionLevelToLevel(level)
+		if err != nil {
+			return nil, err
+		}
+	}
+	z.zipWriter.RegisterCompressor(azip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flateLevel)
+	})
+	return z, nil
+}
+
+func (z *zipEntryWriter) writeEntry(header *tar.Header, content io.Reader) (bool, error) {
+	name := cleanMemberName(header.Name)
+	if len(name) == 0 {
+		z.warnings.add(header.Name, "members without a name inside the archive root were skipped")
+		return false, nil
+	}
+	if name != strings.TrimSuffix(header.Name, "/") {
+		z.warnings.add(header.Name, "member names were cleaned so they stay inside the archive root")
+	}
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeGNUSparse:
+	case tar.TypeLink:
+		z.warnings.add(header.Name, "hard links can not be stored in zip archives and were skipped")
+		return false, nil
+	case tar.TypeXGlobalHeader:
+		z.warnings.add(header.Name, "tar global headers can not be stored in zip archives and were skipped")
+		return false, nil
+	default:
+		z.warnings.add(header.Name, "devices and named pipes can not be stored in zip archives and were skipped")
+		return false, nil
+	}
+	if header.Uid != 0 || header.Gid != 0 || len(header.Uname) > 0 || len(header.Gname) > 0 {
+		z.warnings.add(header.Name, "owner and group are not stored in zip archives")
+	}
+	if !header.AccessTime.IsZero() || !header.ChangeTime.IsZero() {
+		z.warnings.add(header.Name, "access and change times are not stored in zip archives")
+	}
+	if hasNonSparsePAXRecords(header) {
+		z.warnings.add(header.Name, "extended attributes and other PAX records are not stored in zip archives")
+	}
+	zipHeader, err := azip.FileInfoHeader(header.FileInfo())
+	if err != nil {
+		return false, err
+	}
+	zipHeader.Name = name
+	zipHeader.Modified = header.ModTime
+	zipHeader.Method = z.method
+	if header.Typeflag == tar.TypeDir {
+		zipHeader.Name += "/"
+		zipHeader.Method = azip.Store
+	}
+	w, err := z.zipWriter.CreateHeader(zipHeader)
+	if err != nil {
+		return false, err
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		// zip stores the target of a symlink as its content
+		_, err = io.WriteString(w, header.Linkname)
+	case tar.TypeDir:
+	default:
+		_, err = io.Copy(w, content)
+	}
+	return err == nil, err
+}
+
+// copyFile copies a zip member as is without decompressing it.
+func (z *zipEntryWriter) copyFile(f *azip.File) error {
+	return z.zipWriter.Copy(f)
+}
+
+func (z *zipEntryWriter) close() error {
+	return z.zipWriter.Close()
+}
+
+func hasSparsePAXRecords(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, gnuSparsePAXPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNonSparsePAXRecords(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if !strings.HasPrefix(key, gnuSparsePAXPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewCompressionWriter wraps the writer with the compression. The returned writer must be closed to flush the compressed stream.
+func NewCompressionWriter(logger *slog.Logger, w io.Writer, compression string, level fgzip.GZipCompressionLevel) (io.WriteCloser, error) {
+	if len(level) == 0 {
+		level = fgzip.DefaultCompression
+	}
+	switch compression {
+	case CompressionGzip:
+		gzipLevel, err := fgzip.GZipCompressionLevelToLevel(level)
+		if err != nil {
+			logger.Error("invalid compression level provided", slog.String("compressionLevel", string(level)))
+			return nil, err
+		}
+		return fgzip.NewGZIPWriter(logger, w, gzipLevel, gzip.Header{})
+	case CompressionZstd:
+		zstdLevel, err := zstdEncoderLevel(level)
+		if err != nil {
+			logger.Error("invalid compression level provided", slog.String("compressionLevel", string(level)))
+			return nil, err
+		}
+		logger.Debug("creating zstd writer", slog.String("level", zstdLevel.String()))
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// NewDecompressionReader wraps the reader with decompression. The returned func must be called when done reading.
+func NewDecompressionReader(logger *slog.Logger, r io.Reader, compression string) (io.Reader, func(), error) {
+	switch compression {
+	case CompressionGzip:
+		gzipReader, _, err := fgzip.NewGZIPReader(logger, r)
+		if err != nil {
+			logger.Error("failed to create gzip reader", slog.String("errorMessage", err.Error()))
+			return nil, func() {}, err
+		}
+		return gzipReader, func() { gzipReader.Close() }, nil
+	case CompressionZstd:
+		logger.Debug("creating zstd reader")
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			logger.Error("failed to create zstd reader", slog.String("errorMessage", err.Error()))
+			return nil, func() {}, err
+		}
+		return zstdReader, zstdReader.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// zstdEncoderLevel maps the gzip compression level names onto the closest zstd encoder level.
+func zstdEncoderLevel(level fgzip.GZipCompressionLevel) (zstd.EncoderLevel, error) {
+	switch level {
+	case fgzip.NoCompression, fgzip.BestSpeed, fgzip.HuffmanOnly:
+		return zstd.SpeedFastest, nil
+	case fgzip.DefaultCompression:
+		return zstd.SpeedDefault, nil
+	case fgzip.BestCompression:
+		return zstd.SpeedBestCompression, nil
+	}
+	return zstd.SpeedDefault, fgzip.ErrInvalidGZipLevel
+}
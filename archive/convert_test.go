@@ -0,0 +1,196 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fgzip "github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+type testEntry struct {
+	Header  tar.Header
+	Content string
+}
+
+func makeTestTarGz(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, e := range entries {
+		header := e.Header
+		header.Size = int64(len(e.Content))
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := io.WriteString(tarWriter, e.Content); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readTarContent(t *testing.T, r io.Reader) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("failed to read tar member: %v", err)
+		}
+		// stash the content in the link name of regular files to keep the comparison simple
+		if header.Typeflag == tar.TypeReg {
+			header.Linkname = string(data)
+		}
+		headers[header.Name] = header
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	logger := mock.NewMockLogger()
+	modTime := time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC)
+	input := makeTestTarGz(t, []testEntry{
+		{Header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755, ModTime: modTime}},
+		{Header: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file1.txt", Mode: 0600, ModTime: modTime, Uid: 1000, Uname: "someone"}, Content: "hello world"},
+		{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "file1.txt", Mode: 0777, ModTime: modTime}},
+		{Header: tar.Header{Typeflag: tar.TypeLink, Name: "dir/hardlink", Linkname: "dir/file1.txt", Mode: 0600, ModTime: modTime}},
+	})
+	encryptionOptions := EncryptionOptions{Passphrase: []byte("test1")}
+
+	var zipOut bytes.Buffer
+	report, err := Convert(logger, ConvertParams{
+		Input:            bytes.NewReader(input),
+		Output:           &zipOut,
+		From:             Format{Container: ContainerTar, Compression: CompressionGzip},
+		To:               Format{Container: ContainerZip},
+		CompressionLevel: fgzip.BestCompression,
+	})
+	if err != nil {
+		t.Fatalf("failed to convert tar.gz to zip: %v", err)
+	}
+	if report.NumEntries != 3 || report.NumSkipped != 1 {
+		t.Errorf("expected 3 entries and 1 skipped got %d and %d", report.NumEntries, report.NumSkipped)
+	}
+	warnings := strings.Join(report.Warnings, "\n")
+	if !strings.Contains(warnings, "hard links") || !strings.Contains(warnings, "owner and group") {
+		t.Errorf("expected hard link and owner warnings got %v", report.Warnings)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(zipOut.Bytes()), int64(zipOut.Len()))
+	if err != nil {
+		t.Fatalf("converted zip is not valid: %v", err)
+	}
+	if len(zipReader.File) != 3 || zipReader.File[0].Name != "dir/" {
+		t.Errorf("unexpected zip members: %+v", zipReader.File)
+	}
+
+	var zstdOut bytes.Buffer
+	_, err = Convert(logger, ConvertParams{
+		Input:             bytes.NewReader(zipOut.Bytes()),
+		Output:            &zstdOut,
+		From:              Format{Container: ContainerZip},
+		To:                Format{Container: ContainerTar, Compression: CompressionZstd, Encrypted: true},
+		EncryptionOptions: encryptionOptions,
+	})
+	if err != nil {
+		t.Fatalf("failed to convert zip to tar.zst.enc: %v", err)
+	}
+
+	var tarOut bytes.Buffer
+	_, err = Convert(logger, ConvertParams{
+		Input:             bytes.NewReader(zstdOut.Bytes()),
+		Output:            &tarOut,
+		From:              Format{Container: ContainerTar, Compression: CompressionZstd, Encrypted: true},
+		To:                Format{Container: ContainerTar},
+		EncryptionOptions: encryptionOptions,
+	})
+	if err != nil {
+		t.Fatalf("failed to convert tar.zst.enc to tar: %v", err)
+	}
+	headers := readTarContent(t, &tarOut)
+	if len(headers) != 3 {
+		t.Fatalf("expected 3 members got %d", len(headers))
+	}
+	file := headers["dir/file1.txt"]
+	if file == nil || file.Linkname != "hello world" || file.Mode&0777 != 0600 || !file.ModTime.Equal(modTime) {
+		t.Errorf("file did not survive round trip: %+v", file)
+	}
+	link := headers["dir/link"]
+	if link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "file1.txt" {
+		t.Errorf("symlink did not survive round trip: %+v", link)
+	}
+	dir := headers["dir/"]
+	if dir == nil || dir.Typeflag != tar.TypeDir {
+		t.Errorf("directory did not survive round trip: %+v", dir)
+	}
+}
+
+func TestConvertZipCleansMemberNames(t *testing.T) {
+	logger := mock.NewMockLogger()
+	var zipIn bytes.Buffer
+	zipWriter := zip.NewWriter(&zipIn)
+	for _, name := range []string{"../evil", "/abs/file", "ok.txt", "../"} {
+		w, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip member: %v", err)
+		}
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		if _, err := io.WriteString(w, "content"); err != nil {
+			t.Fatalf("failed to write zip member: %v", err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	var tarOut bytes.Buffer
+	report, err := Convert(logger, ConvertParams{
+		Input:  bytes.NewReader(zipIn.Bytes()),
+		Output: &tarOut,
+		From:   Format{Container: ContainerZip},
+		To:     Format{Container: ContainerTar},
+	})
+	if err != nil {
+		t.Fatalf("failed to convert zip to tar: %v", err)
+	}
+	headers := readTarContent(t, &tarOut)
+	if len(headers) != 3 || headers["evil"] == nil || headers["abs/file"] == nil || headers["ok.txt"] == nil {
+		t.Errorf("expected member names inside the archive root: %v", headers)
+	}
+	warnings := strings.Join(report.Warnings, "\n")
+	if !strings.Contains(warnings, "member names were cleaned so they stay inside the archive root (2 entries)") || report.NumSkipped != 1 {
+		t.Errorf("expected the renamed and skipped members to be reported: %v %d", report.Warnings, report.NumSkipped)
+	}
+}
+
+func TestConvertSameFormat(t *testing.T) {
+	format := Format{Container: ContainerZip}
+	_, err := Convert(mock.NewMockLogger(), ConvertParams{
+		Input:  bytes.NewReader(nil),
+		Output: io.Discard,
+		From:   format,
+		To:     format,
+	})
+	if err != ErrSameFormat {
+		t.Errorf("expected ErrSameFormat got %v", err)
+	}
+}
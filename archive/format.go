@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ContainerTar = "tar"
+	ContainerZip = "zip"
+
+	CompressionNone = ""
+	CompressionGzip = "gz"
+	CompressionZstd = "zst"
+
+	// EncryptedSuffix is appended to a format name to mark it as AES256 encrypted with filejitsu, like tar.gz.enc.
+	EncryptedSuffix = ".enc"
+
+	tarMagicOffset = 257
+	// sniffSize is the number of bytes needed to detect every supported format.
+	sniffSize = 512
+)
+
+var (
+	ErrUnknownFormat      = errors.New("unknown archive format")
+	ErrCompressedZip      = errors.New("zip archives can not be wrapped in gzip or zstd compression")
+	ErrCannotDetectFormat = errors.New("could not detect archive format")
+	gzipMagic             = []byte{0x1f, 0x8b}
+	zstdMagic             = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic              = []byte("PK\x03\x04")
+	emptyZipMagic         = []byte("PK\x05\x06")
+	tarMagic              = []byte("ustar")
+	formatAliases         = map[string]string{
+		"tgz":      "tar.gz",
+		"tzst":     "tar.zst",
+		"tar.gzip": "tar.gz",
+		"tar.zstd": "tar.zst",
+	}
+)
+
+// Format describes how an archive is stored.
+type Format struct {
+	// Container is ContainerTar or ContainerZip.
+	Container string
+	// Compression is the compression around a tar container. Zip archives compress each member instead.
+	Compression string
+	// Encrypted if true the whole archive is AES256 encrypted with filejitsu.
+	Encrypted bool
+}
+
+func (f Format) String() string {
+	name := f.Container
+	if len(f.Compression) > 0 {
+		name += "." + f.Compression
+	}
+	if f.Encrypted {
+		name += EncryptedSuffix
+	}
+	return name
+}
+
+// ParseFormat parses a format name like tar, tar.gz, tgz, tar.zst, zip or any of those followed by .enc.
+func ParseFormat(name string) (Format, error) {
+	format := Format{}
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "."))
+	if strings.HasSuffix(name, EncryptedSuffix) {
+		format.Encrypted = true
+		name = strings.TrimSuffix(name, EncryptedSuffix)
+	}
+	if alias, ok := formatAliases[name]; ok {
+		name = alias
+	}
+	container, compression, _ := strings.Cut(name, ".")
+	switch container {
+	case ContainerTar, ContainerZip:
+		format.Container = container
+	default:
+		return format, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+	}
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		format.Compression = compression
+	default:
+		return format, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+	}
+	if format.Container == ContainerZip && format.Compression != CompressionNone {
+		return format, ErrCompressedZip
+	}
+	return format, nil
+}
+
+// FormatFromPath returns the format implied by the extension of the path, like archive.tar.gz.enc. The bool is false if the extension is not a known format.
+func FormatFromPath(path string) (Format, bool) {
+	base := strings.ToLower(filepath.Base(path))
+	parts := strings.Split(base, ".")
+	// try the longest extension first so name.tar.gz is not detected as gz
+	for i := 1; i < len(parts); i++ {
+		format, err := ParseFormat(strings.Join(parts[i:], "."))
+		if err == nil {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// DetectFormat detects an unencrypted archive format from the first bytes of the reader without consuming them.
+func DetectFormat(r *bufio.Reader) (Format, error) {
+	header, err := r.Peek(sniffSize)
+	if err != nil && len(header) == 0 {
+		return Format{}, fmt.Errorf("%w: %w", ErrCannotDetectFormat, err)
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Format{Container: ContainerTar, Compression: CompressionGzip}, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return Format{Container: ContainerTar, Compression: CompressionZstd}, nil
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return Format{Container: ContainerZip}, nil
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return Format{Container: ContainerTar}, nil
+	}
+	return Format{}, ErrCannotDetectFormat
+}
@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	type testCase struct {
+		Name          string
+		Input         string
+		Expected      Format
+		ExpectedError error
+	}
+	testCases := []testCase{
+		{Name: "tar", Input: "tar", Expected: Format{Container: ContainerTar}},
+		{Name: "tar.gz", Input: "tar.gz", Expected: Format{Container: ContainerTar, Compression: CompressionGzip}},
+		{Name: "tgz alias", Input: "tgz", Expected: Format{Container: ContainerTar, Compression: CompressionGzip}},
+		{Name: "encrypted tar.zst", Input: ".TAR.ZST.enc", Expected: Format{Container: ContainerTar, Compression: CompressionZstd, Encrypted: true}},
+		{Name: "encrypted zip", Input: "zip.enc", Expected: Format{Container: ContainerZip, Encrypted: true}},
+		{Name: "compressed zip", Input: "zip.gz", ExpectedError: ErrCompressedZip},
+		{Name: "unknown container", Input: "rar", ExpectedError: ErrUnknownFormat},
+		{Name: "unknown compression", Input: "tar.bz2", ExpectedError: ErrUnknownFormat},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			format, err := ParseFormat(tc.Input)
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected error %v got %v", tc.ExpectedError, err)
+				return
+			}
+			if err == nil && format != tc.Expected {
+				t.Errorf("expected %+v got %+v", tc.Expected, format)
+			}
+		})
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	type testCase struct {
+		Path     string
+		Expected Format
+		OK       bool
+	}
+	testCases := []testCase{
+		{Path: "/tmp/backup.tar.gz", Expected: Format{Container: ContainerTar, Compression: CompressionGzip}, OK: true},
+		{Path: "my.backup.tar.zst.enc", Expected: Format{Container: ContainerTar, Compression: CompressionZstd, Encrypted: true}, OK: true},
+		{Path: "photos.zip", Expected: Format{Container: ContainerZip}, OK: true},
+		{Path: "notes.txt", OK: false},
+		{Path: "stdin", OK: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Path, func(t *testing.T) {
+			format, ok := FormatFromPath(tc.Path)
+			if ok != tc.OK || format != tc.Expected {
+				t.Errorf("expected %+v %v got %+v %v", tc.Expected, tc.OK, format, ok)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[tarMagicOffset:], tarMagic)
+	type testCase struct {
+		Name          string
+		Data          []byte
+		Expected      Format
+		ExpectedError error
+	}
+	testCases := []testCase{
+		{Name: "gzip", Data: []byte{0x1f, 0x8b, 0x08, 0x00}, Expected: Format{Container: ContainerTar, Compression: CompressionGzip}},
+		{Name: "zstd", Data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, Expected: Format{Container: ContainerTar, Compression: CompressionZstd}},
+		{Name: "zip", Data: []byte("PK\x03\x04rest"), Expected: Format{Container: ContainerZip}},
+		{Name: "tar", Data: tarHeader, Expected: Format{Container: ContainerTar}},
+		{Name: "unknown", Data: []byte("hello world"), ExpectedError: ErrCannotDetectFormat},
+		{Name: "empty", Data: []byte{}, ExpectedError: ErrCannotDetectFormat},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tc.Data))
+			format, err := DetectFormat(r)
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected error %v got %v", tc.ExpectedError, err)
+				return
+			}
+			if err == nil && format != tc.Expected {
+				t.Errorf("expected %+v got %+v", tc.Expected, format)
+			}
+			if r.Buffered() != len(tc.Data) && len(tc.Data) > 0 {
+				t.Errorf("expected detection to not consume input")
+			}
+		})
+	}
+}
@@ -97,6 +97,28 @@ func CalculateJobs(ctx context.Context, logger *slog.Logger, params Params) ([]R
 	return results, nil
 }
 
+// NewDestinationTemplate parses a destination template with the functions available to every rename, like padLeft and padRight.
+// Using a named capture group that is not in the target regex is an error when the template is executed.
+func NewDestinationTemplate(templateString string) (*template.Template, error) {
+	return template.New("filePart").Option("missingkey=error").Funcs(template.FuncMap{
+		"padLeft":  util.PadLeft,
+		"padRight": util.PadRight,
+	}).Parse(templateString)
+}
+
+// RenameName calculates the new name for a single name the same way CalculateJobs does for file names.
+// If the target regex does not match the name it is returned unchanged and false.
+func RenameName(logger *slog.Logger, name string, targetRegex *regexp.Regexp, destinationTemplate *template.Template) (string, bool, error) {
+	if !targetRegex.MatchString(name) {
+		return name, false, nil
+	}
+	result, err := calculateRename(logger, util.File{Name: name, Extension: path.Ext(name)}, targetRegex, destinationTemplate)
+	if err != nil {
+		return name, false, err
+	}
+	return result.New.Name, result.DidChange, nil
+}
+
 func calculateRename(logger *slog.Logger, original util.File, captureRegex *regexp.Regexp, template *template.Template) (ResultEntry, error) {
 	valueMap := make(map[string]string)
 	subMatches := captureRegex.FindStringSubmatch(original.Name)
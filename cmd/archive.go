@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/calvine/filejitsu/archive"
+	"github.com/calvine/filejitsu/gzip"
+	"github.com/spf13/cobra"
+)
+
+type ArchiveConvertArgs struct {
+	From             string
+	To               string
+	CompressionLevel gzip.GZipCompressionLevel
+	Passphrase       string
+	PassphraseFile   string
+	ReportPath       string
+}
+
+const (
+	archiveCommandName        = "archive"
+	archiveConvertCommandName = "convert"
+)
+
+func newArchiveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   archiveCommandName,
+		Short: "Tools that work across archive formats",
+		Long:  "Tools that work across the tar and zip archive formats",
+	}
+}
+
+func newArchiveConvertCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   archiveConvertCommandName,
+		Short: "Convert an archive from one format to another",
+		Long:  "Streams every entry of the input archive into an archive of another format. Supported formats are tar, tar.gz, tar.zst and zip, each optionally followed by .enc for AES256 encryption. Metadata that can not be represented in the target format is reported as a warning",
+		RunE:  archiveConvertRun,
+	}
+}
+
+var (
+	archiveConvertArgs = ArchiveConvertArgs{}
+)
+
+func archiveInit(parentCmd *cobra.Command) {
+	archiveCommand := newArchiveCommand()
+	parentCmd.AddCommand(archiveCommand)
+	archiveConvertCommand := newArchiveConvertCommand()
+	archiveConvertCommand.PersistentFlags().StringVar(&archiveConvertArgs.From, "from", "", "The format of the input archive like tar.gz or zip.enc. Detected from the input path extension or content if not provided. Encrypted input can not be detected from its content")
+	archiveConvertCommand.PersistentFlags().StringVar(&archiveConvertArgs.To, "to", "", "The format of the output archive like zip or tar.zst.enc. Detected from the output path extension if not provided")
+	archiveConvertCommand.PersistentFlags().StringVarP((*string)(&archiveConvertArgs.CompressionLevel), "CompressionLevel", "q", string(gzip.DefaultCompression), "The compression level of the output archive. Used for gzip and zstd compressed tar archives and for each member of a zip archive")
+	archiveConvertCommand.PersistentFlags().StringVarP(&archiveConvertArgs.Passphrase, "passphrase", "p", "", "The passphrase used to decrypt the input or encrypt the output")
+	archiveConvertCommand.PersistentFlags().StringVarP(&archiveConvertArgs.PassphraseFile, "passphraseFile", "f", "", "The file which will be read to get the passphrase used for decryption or encryption")
+	archiveConvertCommand.PersistentFlags().StringVar(&archiveConvertArgs.ReportPath, "report", "", "If provided a JSON report of the conversion including any warnings is written to this path")
+	archiveCommand.AddCommand(archiveConvertCommand)
+}
+
+func ValidateArchiveConvertArgs(logger *slog.Logger, convertArgs ArchiveConvertArgs) (archive.ConvertParams, error) {
+	params := archive.ConvertParams{
+		Input:            inputFile,
+		Output:           outputFile,
+		CompressionLevel: convertArgs.CompressionLevel,
+	}
+	if len(convertArgs.From) > 0 {
+		from, err := archive.ParseFormat(convertArgs.From)
+		if err != nil {
+			logger.Error("invalid from format provided", slog.String("from", convertArgs.From), slog.String("errorMessage", err.Error()))
+			return params, err
+		}
+		params.From = from
+	} else {
+		from, input, err := detectInputFormat(logger)
+		if err != nil {
+			return params, err
+		}
+		params.From = from
+		params.Input = input
+	}
+	if len(convertArgs.To) > 0 {
+		to, err := archive.ParseFormat(convertArgs.To)
+		if err != nil {
+			logger.Error("invalid to format provided", slog.String("to", convertArgs.To), slog.String("errorMessage", err.Error()))
+			return params, err
+		}
+		params.To = to
+	} else {
+		to, ok := archive.FormatFromPath(outputPath)
+		if !ok || outputPath == stdOutFileName {
+			errMsg := "to format not provided and could not be detected from the output path"
+			logger.Error(errMsg, slog.String("outputPath", outputPath))
+			return params, errors.New(errMsg)
+		}
+		params.To = to
+	}
+	if params.From.Encrypted || params.To.Encrypted {
+		passphrase, err := getPassphrase(logger, convertArgs.PassphraseFile, convertArgs.Passphrase)
+		if err != nil {
+			errMsg := "error getting passphrase"
+			logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+			return params, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		params.EncryptionOptions.Passphrase = passphrase
+	}
+	logger.Debug("archive formats set", slog.String("from", params.From.String()), slog.String("to", params.To.String()))
+	return params, nil
+}
+
+// detectInputFormat detects the input archive format from the input path extension, or failing that from the first bytes of the input.
+// The returned reader must be used in place of the input because it may have consumed the first bytes.
+func detectInputFormat(logger *slog.Logger) (archive.Format, io.Reader, error) {
+	if inputPath != stdInFileName {
+		if format, ok := archive.FormatFromPath(inputPath); ok {
+			logger.Debug("detected input format from input path", slog.String("format", format.String()))
+			return format, inputFile, nil
+		}
+	}
+	var sniffReader *bufio.Reader
+	input := inputFile
+	if f, ok := inputFile.(*os.File); ok && inputPath != stdInFileName {
+		// read the start of the file without moving the offset so a zip input can still be read in place
+		sniffReader = bufio.NewReader(io.NewSectionReader(f, 0, 512))
+	} else {
+		sniffReader = bufio.NewReader(inputFile)
+		input = sniffReader
+	}
+	format, err := archive.DetectFormat(sniffReader)
+	if err != nil {
+		logger.Error("failed to detect input format, encrypted input requires the from flag", slog.String("errorMessage", err.Error()))
+		return format, input, err
+	}
+	logger.Debug("detected input format from content", slog.String("format", format.String()))
+	return format, input, nil
+}
+
+func archiveConvertRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running archive convert")
+	params, err := ValidateArchiveConvertArgs(commandLogger, archiveConvertArgs)
+	if err != nil {
+		errMsg := "archive convert arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	report, err := archive.Convert(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to convert archive", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if len(archiveConvertArgs.ReportPath) > 0 {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			commandLogger.Error("failed to marshal conversion report", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if err := os.WriteFile(archiveConvertArgs.ReportPath, data, 0644); err != nil {
+			commandLogger.Error("failed to write conversion report", slog.String("reportPath", archiveConvertArgs.ReportPath), slog.String("errorMessage", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
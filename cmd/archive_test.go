@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestArchiveConvertTarToZip(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar.gz")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetArgs([]string{"tar", "-z", "-o", tarPath, testRootDir})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar on dir: %v", err)
+		return
+	}
+	// the input format is detected from the content and the output format from the extension
+	renamedTarPath := filepath.Join(tmpDir, "output.bin")
+	if err := os.Rename(tarPath, renamedTarPath); err != nil {
+		t.Errorf("failed to rename tar file: %v", err)
+		return
+	}
+	zipPath := filepath.Join(tmpDir, "output.zip.enc")
+	convertCmd := SetupCommand("", "", "")
+	convertCmd.SetArgs([]string{"archive", "convert", "-i", renamedTarPath, "-o", zipPath, "-p", "test1", "--report", filepath.Join(tmpDir, "report.json")})
+	if err := convertCmd.Execute(); err != nil {
+		t.Errorf("failed to run archive convert: %v", err)
+		return
+	}
+	unzipPath := filepath.Join(tmpDir, "test_unzip")
+	unzipCmd := SetupCommand("", "", "")
+	unzipCmd.SetArgs([]string{"zip", "-i", zipPath, "-u", "-e", "-p", "test1", unzipPath})
+	if err := unzipCmd.Execute(); err != nil {
+		t.Errorf("failed to run unzip on converted file: %v", err)
+		return
+	}
+	if err := mock.ConfirmContentMapMatches(unzipPath, content); err != nil {
+		t.Errorf("failed in comparison of unzipped files: %v", err)
+	}
+}
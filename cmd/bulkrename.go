@@ -5,12 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
-	"text/template"
 
 	"log/slog"
 
 	"github.com/calvine/filejitsu/bulkrename"
-	"github.com/calvine/filejitsu/util"
 	"github.com/spf13/cobra"
 )
 
@@ -125,10 +123,7 @@ func validateBulkrenameArgs(ctx context.Context, args BulkRenameArgs) (bulkrenam
 	// it looks like the template struct contains a lot of data we can possibly use to find the variable names used in the template
 	// need to look more into it, but NodeAction (1) nodes in the tree can be navigated and within them there are Pipe.Cmd.Args that
 	// contain NodeField(8) that hold the value of the variable name in the template...
-	destinationTemplate, err := template.New("filePart").Option("missingkey=error").Funcs(template.FuncMap{
-		"padLeft":  util.PadLeft,
-		"padRight": util.PadRight,
-	}).Parse(args.DestinationTemplateString)
+	destinationTemplate, err := bulkrename.NewDestinationTemplate(args.DestinationTemplateString)
 	if err != nil {
 		return params, fmt.Errorf("failed to parse destination template: %w", err) // errors.New("failed to parse destination template")
 	}
@@ -126,6 +126,12 @@ func encryptDecryptRun(cmd *cobra.Command, args []string) error {
 		commandLogger.Error("failed to validate args", slog.String("errorMessage", err.Error()))
 		return err
 	}
+	tracker, stopProgress, err := startProgress(cmd, getInputSize(encryptDecryptArgs.InputText))
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
+	params.Input = tracker.Reader(params.Input)
 	switch encryptDecryptArgs.Operation {
 	case encrypt.OpDecrypt:
 		commandLogger.Debug("decrypt operation selected")
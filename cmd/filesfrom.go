@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/calvine/filejitsu/spaceanalyzer"
+	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/archivepath"
+	"github.com/spf13/cobra"
+)
+
+const (
+	filesFromStdin      = "-"
+	filesFromFormatList = "list"
+)
+
+// FilesFromArgs are the flags shared by the archive commands for packaging a list of paths.
+type FilesFromArgs struct {
+	FilesFrom  string
+	Format     string
+	Recursive  bool
+	EntityType string
+	MinSize    string
+	Include    []string
+}
+
+func (f FilesFromArgs) hasReportFilter() bool {
+	return len(f.EntityType) > 0 || len(f.MinSize) > 0 || len(f.Include) > 0
+}
+
+func addFilesFromFlags(command *cobra.Command, filesFromArgs *FilesFromArgs, archiveName string) {
+	usagePostfix := fmt.Sprintf(" - (USED ONLY WITH CREATING A %s ARCHIVE)", archiveName)
+	command.PersistentFlags().StringVar(&filesFromArgs.FilesFrom, "files-from", "", "A file listing paths to package, or - to read the list from stdin. Paths are separated by newlines, or by NUL characters if the list has any (like find -print0 writes). Listed directories are not walked unless files-from-recursive is present"+usagePostfix)
+	command.PersistentFlags().StringVar(&filesFromArgs.Format, "files-from-format", filesFromFormatList, "The format of the files-from list. Options are 'list' for a path list, or 'json' and 'sjson' for a space-analyzer report"+usagePostfix)
+	command.PersistentFlags().BoolVar(&filesFromArgs.Recursive, "files-from-recursive", false, "If present listed directories are walked and everything in them is packaged"+usagePostfix)
+	command.PersistentFlags().StringVar(&filesFromArgs.EntityType, "files-from-type", "", "Only entities of this type are taken from a space-analyzer report. Options are 'file' or 'directory'"+usagePostfix)
+	command.PersistentFlags().StringVar(&filesFromArgs.MinSize, "files-from-min-size", "", "Only entities at least this size (like 100M) are taken from a space-analyzer report"+usagePostfix)
+	command.PersistentFlags().StringArrayVar(&filesFromArgs.Include, "files-from-include", nil, "A gitignore style pattern relative to the report root. Only matching entities are taken from a space-analyzer report. Can be specified multiple times"+usagePostfix)
+}
+
+// getFilesFrom reads the list of paths to package from the files-from flag. The list is empty if the flag was not provided.
+func getFilesFrom(logger *slog.Logger, filesFromArgs FilesFromArgs) (archivepath.FilesFrom, error) {
+	filesFrom := archivepath.FilesFrom{
+		Recursive: filesFromArgs.Recursive,
+	}
+	if len(filesFromArgs.FilesFrom) == 0 {
+		return filesFrom, nil
+	}
+	isReport := filesFromArgs.Format == spaceanalyzer.OutputFormatJSON || filesFromArgs.Format == spaceanalyzer.OutputFormatStreamingJSON
+	if !isReport && filesFromArgs.Format != filesFromFormatList {
+		errMsg := "invalid files-from-format provided"
+		logger.Error(errMsg, slog.String("format", filesFromArgs.Format))
+		return filesFrom, fmt.Errorf("%s: %s", errMsg, filesFromArgs.Format)
+	}
+	if !isReport && filesFromArgs.hasReportFilter() {
+		errMsg := "files-from type, min size and include filters require a space-analyzer report format"
+		logger.Error(errMsg)
+		return filesFrom, errors.New(errMsg)
+	}
+	var r io.Reader
+	if filesFromArgs.FilesFrom == filesFromStdin {
+		logger.Debug("reading files-from list from stdin")
+		r = inputFile
+	} else {
+		logger.Debug("reading files-from list from file", slog.String("file", filesFromArgs.FilesFrom))
+		f, err := os.Open(filesFromArgs.FilesFrom)
+		if err != nil {
+			errMsg := "failed to open files-from list"
+			logger.Error(errMsg, slog.String("file", filesFromArgs.FilesFrom), slog.String("errorMessage", err.Error()))
+			return filesFrom, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if !isReport {
+		paths, err := archivepath.ReadPathList(r)
+		if err != nil {
+			logger.Error("failed to read files-from list", slog.String("errorMessage", err.Error()))
+			return filesFrom, err
+		}
+		filesFrom.Paths = paths
+		logger.Debug("read files-from list", slog.Int("numPaths", len(paths)))
+		return filesFrom, nil
+	}
+	filter := spaceanalyzer.ReportFilter{
+		EntityType: spaceanalyzer.EntityType(filesFromArgs.EntityType),
+		Include:    filesFromArgs.Include,
+	}
+	switch filter.EntityType {
+	case "", spaceanalyzer.FileType, spaceanalyzer.DirectoryType:
+	default:
+		errMsg := "invalid files-from-type provided"
+		logger.Error(errMsg, slog.String("type", filesFromArgs.EntityType))
+		return filesFrom, fmt.Errorf("%s: %s", errMsg, filesFromArgs.EntityType)
+	}
+	if len(filesFromArgs.MinSize) > 0 {
+		minSize, err := util.ParseBytesSize(filesFromArgs.MinSize)
+		if err != nil {
+			logger.Error("invalid files-from-min-size provided", slog.String("minSize", filesFromArgs.MinSize), slog.String("errorMessage", err.Error()))
+			return filesFrom, err
+		}
+		filter.MinSize = minSize
+	}
+	entities, err := spaceanalyzer.ReadReport(context.Background(), r, filesFromArgs.Format)
+	if err != nil {
+		logger.Error("failed to read space-analyzer report", slog.String("errorMessage", err.Error()))
+		return filesFrom, err
+	}
+	paths, err := spaceanalyzer.FilterReportPaths(entities, filter)
+	if err != nil {
+		logger.Error("failed to filter space-analyzer report", slog.String("errorMessage", err.Error()))
+		return filesFrom, err
+	}
+	filesFrom.Paths = paths
+	logger.Debug("read files-from space-analyzer report", slog.Int("numEntities", len(entities)), slog.Int("numPaths", len(paths)))
+	return filesFrom, nil
+}
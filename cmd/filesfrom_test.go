@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestTarFilesFromStdin(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar")
+	list := strings.Join([]string{
+		filepath.Join(testRootDir, "file1.txt"),
+		filepath.Join(testRootDir, "nested"),
+	}, "\x00")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetIn(strings.NewReader(list))
+	tarCmd.SetArgs([]string{"tar", "--files-from", "-", "-o", tarPath})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar with files-from: %v", err)
+		return
+	}
+	untarPath := filepath.Join(tmpDir, "test_untar")
+	untarCmd := SetupCommand("", "", "")
+	untarCmd.SetArgs([]string{"tar", "-i", tarPath, "-u", untarPath})
+	if err := untarCmd.Execute(); err != nil {
+		t.Errorf("failed to run untar: %v", err)
+		return
+	}
+	// listed paths keep their full path without the leading separator
+	extractedRoot := filepath.Join(untarPath, strings.TrimPrefix(testRootDir, string(filepath.Separator)))
+	data, err := os.ReadFile(filepath.Join(extractedRoot, "file1.txt"))
+	if err != nil || string(data) != content["file1.txt"].Content {
+		t.Errorf("expected listed file to be extracted: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(extractedRoot, "nested")); err != nil || !info.IsDir() {
+		t.Errorf("expected listed directory to be extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(extractedRoot, "nested", "bigfile.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected listed directory to not be walked: %v", err)
+	}
+}
+
+func TestZipFilesFromSpaceAnalyzerReport(t *testing.T) {
+	testRootDir, _, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	for _, format := range []string{"json", "sjson"} {
+		t.Run(format, func(t *testing.T) {
+			reportPath := filepath.Join(tmpDir, "report."+format)
+			saCmd := SetupCommand("", "", "")
+			saCmd.SetArgs([]string{"space-analyzer", "-p", testRootDir, "-f", format, "-o", reportPath})
+			if err := saCmd.Execute(); err != nil {
+				t.Errorf("failed to run space-analyzer: %v", err)
+				return
+			}
+			zipPath := filepath.Join(tmpDir, format+".zip")
+			zipCmd := SetupCommand("", "", "")
+			zipCmd.SetArgs([]string{"zip", "--files-from", reportPath, "--files-from-format", format, "--files-from-type", "file", "--files-from-min-size", "1K", "-o", zipPath})
+			if err := zipCmd.Execute(); err != nil {
+				t.Errorf("failed to run zip with files-from report: %v", err)
+				return
+			}
+			listPath := filepath.Join(tmpDir, format+".json")
+			listCmd := SetupCommand("", "", "")
+			listCmd.SetArgs([]string{"zip", "--list", "-i", zipPath, "-o", listPath})
+			if err := listCmd.Execute(); err != nil {
+				t.Errorf("failed to run zip list: %v", err)
+				return
+			}
+			data, err := os.ReadFile(listPath)
+			if err != nil {
+				t.Errorf("failed to read zip list: %v", err)
+				return
+			}
+			listing := string(data)
+			if !strings.Contains(listing, "bigfile.txt") || strings.Contains(listing, "file1.txt") {
+				t.Errorf("expected only the big file in the zip archive: %s", listing)
+			}
+		})
+	}
+}
@@ -106,6 +106,11 @@ func runGZIP(cmd *cobra.Command, args []string) error {
 		commandLogger.Error("failed to validate gzip args", slog.String("errorMessage", err.Error()))
 		return err
 	}
+	tracker, stopProgress, err := startProgress(cmd, getInputSize(gzipArgs.InputText))
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
 
 	// TODO: populate the header with input file info if args are not set and input is a real file
 	out, err := fgzip.NewGZIPWriter(commandLogger, params.Output, params.Level, params.Header)
@@ -113,7 +118,7 @@ func runGZIP(cmd *cobra.Command, args []string) error {
 		commandLogger.Error("failed to create gzip writer", slog.String("errorMessage", err.Error()))
 		return err
 	}
-	if err := fgzip.Compress(commandLogger, params.Input, out); err != nil {
+	if err := fgzip.Compress(commandLogger, tracker.Reader(params.Input), out); err != nil {
 		commandLogger.Error("failed to gzip input", slog.String("errorMessage", err.Error()))
 		return err
 	}
@@ -133,11 +138,16 @@ func runGUNZIP(cmd *cobra.Command, args []string) error {
 		commandLogger.Error("failed to validate gunzip args", slog.String("errorMessage", err.Error()))
 		return err
 	}
+	tracker, stopProgress, err := startProgress(cmd, getInputSize(gunzipArgs.InputText))
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
 	// TODO: what to do with header?
 	// My thoughts are to have an init function that returns the header before decompressing.
 	// that way you can use the header to prep a decompress target file if that is what someone desires.
 	// For now I will ignore the header, until I find a need for it, or someone requests the functionality.
-	in, header, err := fgzip.NewGZIPReader(commandLogger, params.Input)
+	in, header, err := fgzip.NewGZIPReader(commandLogger, tracker.Reader(params.Input))
 	if err != nil {
 		commandLogger.Error("failed to create gzip reader", slog.String("errorMessage", err.Error()))
 		return err
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/calvine/filejitsu/parity"
+	"github.com/calvine/filejitsu/util"
+	"github.com/spf13/cobra"
+)
+
+type ParityArgs struct {
+	ParityPath string
+	Redundancy string
+	BlockSize  string
+}
+
+const (
+	parityCommandName       = "parity"
+	parityCreateCommandName = "create"
+	parityVerifyCommandName = "verify"
+	parityRepairCommandName = "repair"
+)
+
+func newParityCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   parityCommandName,
+		Short: "Reed-Solomon recovery data for repairing damaged files",
+		Long:  "Writes Reed-Solomon recovery blocks for a file to a parity file, which can later detect and repair damaged blocks of the file, like bad sectors in a long term archive",
+	}
+}
+
+func newParityCreateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   parityCreateCommandName,
+		Short: "Create a parity file for a file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  parityCreateRun,
+	}
+}
+
+func newParityVerifyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   parityVerifyCommandName,
+		Short: "Check a file against its parity file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  parityVerifyRun,
+	}
+}
+
+func newParityRepairCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   parityRepairCommandName,
+		Short: "Repair the damaged blocks of a file and its parity file in place",
+		Args:  cobra.ExactArgs(1),
+		RunE:  parityRepairRun,
+	}
+}
+
+var (
+	parityArgs = ParityArgs{}
+)
+
+func parityInit(parentCmd *cobra.Command) {
+	parityCommand := newParityCommand()
+	parityCommand.PersistentFlags().StringVar(&parityArgs.ParityPath, "parityPath", "", "The path of the parity file. Defaults to the file path with .par added")
+	parentCmd.AddCommand(parityCommand)
+	util.HideGlobalFlags(parityCommand, map[string]util.FlagModifier{
+		"input": {
+			Hide: true,
+		},
+	})
+
+	parityCreateCommand := newParityCreateCommand()
+	parityCreateCommand.Flags().StringVar(&parityArgs.Redundancy, "redundancy", fmt.Sprintf("%d%%", parity.DefaultRedundancy), "The size of the recovery data as a percent of the file size, from 1% to 100%. About this much of the file can be repaired")
+	parityCreateCommand.Flags().StringVar(&parityArgs.BlockSize, "block-size", "64K", "The size of the blocks damage is detected and repaired in")
+	parityCommand.AddCommand(parityCreateCommand)
+	parityCommand.AddCommand(newParityVerifyCommand())
+	parityCommand.AddCommand(newParityRepairCommand())
+}
+
+func ValidateParityCreateArgs(logger *slog.Logger, parityArgs ParityArgs, filePath string) (parity.CreateParams, error) {
+	params := parity.CreateParams{
+		FilePath:   filePath,
+		ParityPath: parityArgs.ParityPath,
+	}
+	redundancy, err := parity.ParseRedundancy(parityArgs.Redundancy)
+	if err != nil {
+		logger.Error("invalid redundancy provided", slog.String("redundancy", parityArgs.Redundancy), slog.String("errorMessage", err.Error()))
+		return params, err
+	}
+	params.Redundancy = redundancy
+	blockSize, err := util.ParseBytesSize(parityArgs.BlockSize)
+	if err != nil || blockSize <= 0 || blockSize > 1<<30 {
+		errMsg := "invalid block size provided"
+		logger.Error(errMsg, slog.String("blockSize", parityArgs.BlockSize))
+		return params, fmt.Errorf("%s: %s", errMsg, parityArgs.BlockSize)
+	}
+	params.BlockSize = int(blockSize)
+	return params, nil
+}
+
+func parityCreateRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running parity create")
+	params, err := ValidateParityCreateArgs(commandLogger, parityArgs, args[0])
+	if err != nil {
+		errMsg := "parity create arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	report, err := parity.Create(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to create parity file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, report)
+}
+
+func parityVerifyRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running parity verify")
+	params := parity.VerifyParams{
+		FilePath:   args[0],
+		ParityPath: parityArgs.ParityPath,
+	}
+	report, verifyErr := parity.Verify(commandLogger, params)
+	if verifyErr != nil && !errors.Is(verifyErr, parity.ErrDamageFound) {
+		commandLogger.Error("failed to verify file", slog.String("errorMessage", verifyErr.Error()))
+		return verifyErr
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return flushOutputBeforeError(commandLogger, verifyErr)
+	}
+	return nil
+}
+
+func parityRepairRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running parity repair")
+	params := parity.RepairParams{
+		VerifyParams: parity.VerifyParams{
+			FilePath:   args[0],
+			ParityPath: parityArgs.ParityPath,
+		},
+	}
+	report, repairErr := parity.Repair(commandLogger, params)
+	if repairErr != nil && !errors.Is(repairErr, parity.ErrUnrepairable) && !errors.Is(repairErr, parity.ErrRepairVerifyFailed) {
+		commandLogger.Error("failed to repair file", slog.String("errorMessage", repairErr.Error()))
+		return repairErr
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if repairErr != nil {
+		commandLogger.Error("failed to repair file", slog.String("errorMessage", repairErr.Error()))
+		return flushOutputBeforeError(commandLogger, repairErr)
+	}
+	return nil
+}
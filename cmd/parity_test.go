@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/parity"
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestTarParityRepair(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar.gz")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetArgs([]string{"tar", "-z", "--parity", "50%", "-o", tarPath, testRootDir})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar with parity: %v", err)
+		return
+	}
+	data, err := os.ReadFile(tarPath)
+	if err != nil {
+		t.Errorf("failed to read tar file: %v", err)
+		return
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(tarPath, data, 0644); err != nil {
+		t.Errorf("failed to damage tar file: %v", err)
+		return
+	}
+	verifyCmd := SetupCommand("", "", "")
+	verifyCmd.SetArgs([]string{"parity", "verify", "-o", filepath.Join(tmpDir, "verify.json"), tarPath})
+	if err := verifyCmd.Execute(); !errors.Is(err, parity.ErrDamageFound) {
+		t.Errorf("expected parity verify to find damage, got %v", err)
+		return
+	}
+	repairCmd := SetupCommand("", "", "")
+	repairCmd.SetArgs([]string{"parity", "repair", "-o", filepath.Join(tmpDir, "repair.json"), tarPath})
+	if err := repairCmd.Execute(); err != nil {
+		t.Errorf("failed to run parity repair: %v", err)
+		return
+	}
+	untarPath := filepath.Join(tmpDir, "test_untar")
+	untarCmd := SetupCommand("", "", "")
+	untarCmd.SetArgs([]string{"tar", "-i", tarPath, "-u", "-z", untarPath})
+	if err := untarCmd.Execute(); err != nil {
+		t.Errorf("failed to run untar on repaired file: %v", err)
+		return
+	}
+	if err := mock.ConfirmContentMapMatches(untarPath, content); err != nil {
+		t.Errorf("failed in comparison of untarred files: %v", err)
+	}
+}
+
+func TestTarAppendRewritesParity(t *testing.T) {
+	testRootDir, _, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar.gz")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetArgs([]string{"tar", "-z", "--parity", "20%", "-o", tarPath, testRootDir})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar with parity: %v", err)
+		return
+	}
+	appendedPath := filepath.Join(tmpDir, "appended.txt")
+	if err := os.WriteFile(appendedPath, []byte("appended after the parity file was written"), 0644); err != nil {
+		t.Errorf("failed to write file to append: %v", err)
+		return
+	}
+	// damage in the archive must not be written into the new parity file
+	data, err := os.ReadFile(tarPath)
+	if err != nil {
+		t.Errorf("failed to read tar file: %v", err)
+		return
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(tarPath, data, 0644); err != nil {
+		t.Errorf("failed to damage tar file: %v", err)
+		return
+	}
+	damagedAppendCmd := SetupCommand("", "", "")
+	damagedAppendCmd.SetArgs([]string{"tar", "-z", "--append", "-o", tarPath, appendedPath})
+	if err := damagedAppendCmd.Execute(); !errors.Is(err, parity.ErrDamageFound) {
+		t.Errorf("expected appending to a damaged archive to fail, got %v", err)
+		return
+	}
+	repairCmd := SetupCommand("", "", "")
+	repairCmd.SetArgs([]string{"parity", "repair", "-o", filepath.Join(tmpDir, "repair.json"), tarPath})
+	if err := repairCmd.Execute(); err != nil {
+		t.Errorf("failed to run parity repair: %v", err)
+		return
+	}
+	appendCmd := SetupCommand("", "", "")
+	appendCmd.SetArgs([]string{"tar", "-z", "--append", "-o", tarPath, appendedPath})
+	if err := appendCmd.Execute(); err != nil {
+		t.Errorf("failed to append to tar: %v", err)
+		return
+	}
+	verifyCmd := SetupCommand("", "", "")
+	verifyCmd.SetArgs([]string{"parity", "verify", "-o", filepath.Join(tmpDir, "verify.json"), tarPath})
+	if err := verifyCmd.Execute(); err != nil {
+		t.Errorf("expected the parity file to be rewritten after appending: %v", err)
+		return
+	}
+	header, err := parity.ReadHeader(parity.DefaultParityPath(tarPath))
+	if err != nil {
+		t.Errorf("failed to read parity header: %v", err)
+		return
+	}
+	if header.Redundancy != 20 {
+		t.Errorf("expected the parity file to keep its redundancy, got %d", header.Redundancy)
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/calvine/filejitsu/util/progress"
+	"github.com/spf13/cobra"
+)
+
+// startProgress starts reporting progress to stderr as set by the progress flag. The tracker is nil when progress is off, which the tracker methods allow.
+// totalBytes is the number of bytes the job is expected to process, or 0 if unknown. The returned func stops reporting and logs a summary, and must be called when the job is done.
+func startProgress(cmd *cobra.Command, totalBytes int64) (*progress.Tracker, func(), error) {
+	mode, err := progress.ParseMode(progressModeString)
+	if err != nil {
+		commandLogger.Error("invalid progress mode provided", slog.String("progress", progressModeString), slog.String("errorMessage", err.Error()))
+		return nil, nil, err
+	}
+	if mode == progress.ModeNone {
+		return nil, func() {}, nil
+	}
+	tracker := progress.NewTracker()
+	tracker.SetTotalBytes(totalBytes)
+	reporter := progress.NewReporter(tracker, cmd.ErrOrStderr(), mode, progressInterval)
+	commandLogger.Debug("starting progress reporting", slog.String("mode", string(mode)), slog.Duration("interval", progressInterval), slog.Int64("totalBytes", totalBytes))
+	reporter.Start()
+	return tracker, func() {
+		summary := reporter.Stop()
+		commandLogger.Info("progress summary", slog.Any("summary", summary))
+	}, nil
+}
+
+// getInputSize returns the number of bytes that will be read as input, or 0 if it is not known ahead of time like when reading stdin.
+func getInputSize(inputText string) int64 {
+	if len(inputText) > 0 {
+		return int64(len(inputText))
+	}
+	f, ok := inputFile.(*os.File)
+	if !ok {
+		return 0
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
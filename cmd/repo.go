@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/calvine/filejitsu/archive"
+	"github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/repo"
+	"github.com/calvine/filejitsu/util"
+	"github.com/spf13/cobra"
+)
+
+type RepoArgs struct {
+	RepoPath       string
+	Passphrase     string
+	PassphraseFile string
+}
+
+type RepoInitArgs struct {
+	Compression      string
+	CompressionLevel gzip.GZipCompressionLevel
+	UseEncryption    bool
+}
+
+type RepoBackupArgs struct {
+	InputPaths         []string
+	Excludes           []string
+	ExcludeFrom        []string
+	RespectIgnoreFiles bool
+	Tags               []string
+	Hostname           string
+}
+
+type RepoRestoreArgs struct {
+	SnapshotID string
+	OutputPath string
+	Include    []string
+}
+
+type RepoPruneArgs struct {
+	KeepLast int
+	Forget   []string
+	DryRun   bool
+}
+
+type RepoCheckArgs struct {
+	ReadData bool
+}
+
+const (
+	repoCommandName        = "repo"
+	repoInitCommandName    = "init"
+	repoBackupCommandName  = "backup"
+	repoRestoreCommandName = "restore"
+	repoListCommandName    = "list"
+	repoPruneCommandName   = "prune"
+	repoCheckCommandName   = "check"
+
+	repoCompressionNone = "none"
+)
+
+func newRepoCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoCommandName,
+		Short: "A deduplicating backup repository",
+		Long:  "Back up directories into a repository that splits files into content defined chunks and stores each chunk once, with optional compression and AES256 encryption. Every backup is kept as a snapshot that can be restored",
+	}
+}
+
+func newRepoInitCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoInitCommandName,
+		Short: "Create a new empty repository",
+		RunE:  repoInitRun,
+	}
+}
+
+func newRepoBackupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoBackupCommandName,
+		Short: "Back up files and directories as a new snapshot",
+		Long:  "Stores the input paths as a new snapshot. Only chunks the repository does not already have are stored, and files unchanged since the previous snapshot of the same paths are not read",
+		RunE:  repoBackupRun,
+	}
+}
+
+func newRepoRestoreCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoRestoreCommandName,
+		Short: "Restore a snapshot to a directory",
+		RunE:  repoRestoreRun,
+	}
+}
+
+func newRepoListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoListCommandName,
+		Short: "List the snapshots in the repository as JSON",
+		RunE:  repoListRun,
+	}
+}
+
+func newRepoPruneCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoPruneCommandName,
+		Short: "Remove snapshots and the chunks only they used",
+		RunE:  repoPruneRun,
+	}
+}
+
+func newRepoCheckCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   repoCheckCommandName,
+		Short: "Check the repository for missing or damaged data",
+		RunE:  repoCheckRun,
+	}
+}
+
+var (
+	repoArgs        = RepoArgs{}
+	repoInitArgs    = RepoInitArgs{}
+	repoBackupArgs  = RepoBackupArgs{}
+	repoRestoreArgs = RepoRestoreArgs{}
+	repoPruneArgs   = RepoPruneArgs{}
+	repoCheckArgs   = RepoCheckArgs{}
+)
+
+func repoInit(parentCmd *cobra.Command) {
+	repoCommand := newRepoCommand()
+	repoCommand.PersistentFlags().StringVarP(&repoArgs.RepoPath, "repo", "r", "", "The path of the repository directory")
+	repoCommand.PersistentFlags().StringVarP(&repoArgs.Passphrase, "passphrase", "p", "", "The passphrase of an encrypted repository")
+	repoCommand.PersistentFlags().StringVarP(&repoArgs.PassphraseFile, "passphraseFile", "f", "", "The file which will be read to get the passphrase of an encrypted repository")
+	parentCmd.AddCommand(repoCommand)
+	util.HideGlobalFlags(repoCommand, map[string]util.FlagModifier{
+		"input": {
+			Hide: true,
+		},
+	})
+
+	repoInitCommand := newRepoInitCommand()
+	repoInitCommand.Flags().StringVar(&repoInitArgs.Compression, "compression", archive.CompressionZstd, "The compression used for chunks and snapshots. Valid values are gz, zst and none")
+	repoInitCommand.Flags().StringVarP((*string)(&repoInitArgs.CompressionLevel), "CompressionLevel", "q", string(gzip.DefaultCompression), "The compression level used for chunks and snapshots. zstd uses its closest level")
+	repoInitCommand.Flags().BoolVarP(&repoInitArgs.UseEncryption, "encrypt", "e", false, "If present every chunk and snapshot is encrypted with AES256. Requires a passphrase or passphrase file be provided")
+	repoCommand.AddCommand(repoInitCommand)
+
+	repoBackupCommand := newRepoBackupCommand()
+	repoBackupCommand.Flags().StringArrayVar(&repoBackupArgs.InputPaths, "inputPath", nil, "The input path to back up. Can be file or directory. Can be specified multiple times")
+	repoBackupCommand.Flags().StringArrayVar(&repoBackupArgs.Excludes, "exclude", nil, "A gitignore style pattern for entities to leave out of the snapshot. Can be specified multiple times")
+	repoBackupCommand.Flags().StringArrayVar(&repoBackupArgs.ExcludeFrom, "exclude-from", nil, "A file containing gitignore style patterns for entities to leave out of the snapshot. Can be specified multiple times")
+	repoBackupCommand.Flags().BoolVar(&repoBackupArgs.RespectIgnoreFiles, "respect-ignore-files", false, "If present .gitignore and .filejitsuignore files found while backing up will be honored")
+	repoBackupCommand.Flags().StringArrayVar(&repoBackupArgs.Tags, "tag", nil, "A tag to add to the snapshot. Can be specified multiple times")
+	repoBackupCommand.Flags().StringVar(&repoBackupArgs.Hostname, "host", "", "The hostname stored in the snapshot. Defaults to the hostname of the machine")
+	repoCommand.AddCommand(repoBackupCommand)
+
+	repoRestoreCommand := newRepoRestoreCommand()
+	repoRestoreCommand.Flags().StringVar(&repoRestoreArgs.SnapshotID, "snapshot", repo.LatestSnapshotID, "The id or a unique prefix of the id of the snapshot to restore, or latest for the newest snapshot")
+	repoRestoreCommand.Flags().StringVar(&repoRestoreArgs.OutputPath, "outputPath", "", "The directory to restore the snapshot to")
+	repoRestoreCommand.Flags().StringArrayVar(&repoRestoreArgs.Include, "include", nil, "A gitignore style pattern for entities to restore. If provided only matching entities are restored. Can be specified multiple times")
+	repoCommand.AddCommand(repoRestoreCommand)
+
+	repoCommand.AddCommand(newRepoListCommand())
+
+	repoPruneCommand := newRepoPruneCommand()
+	repoPruneCommand.Flags().IntVar(&repoPruneArgs.KeepLast, "keep-last", 0, "If greater than zero only the newest snapshots of each host and set of paths are kept")
+	repoPruneCommand.Flags().StringArrayVar(&repoPruneArgs.Forget, "forget", nil, "The id or a unique prefix of the id of a snapshot to remove. Can be specified multiple times")
+	repoPruneCommand.Flags().BoolVar(&repoPruneArgs.DryRun, "dry-run", false, "If present nothing is removed, the report only says what would be")
+	repoCommand.AddCommand(repoPruneCommand)
+
+	repoCheckCommand := newRepoCheckCommand()
+	repoCheckCommand.Flags().BoolVar(&repoCheckArgs.ReadData, "read-data", false, "If present every chunk is read and checked against its id, instead of only checking it exists")
+	repoCommand.AddCommand(repoCheckCommand)
+}
+
+func ValidateRepoOpenArgs(logger *slog.Logger, repoArgs RepoArgs) (repo.OpenParams, error) {
+	params := repo.OpenParams{}
+	if len(repoArgs.RepoPath) == 0 {
+		errMsg := "repo flag not set"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	params.Path = repoArgs.RepoPath
+	if len(repoArgs.Passphrase) > 0 || len(repoArgs.PassphraseFile) > 0 {
+		passphrase, err := getPassphrase(logger, repoArgs.PassphraseFile, repoArgs.Passphrase)
+		if err != nil {
+			errMsg := "error getting passphrase"
+			logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+			return params, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		params.Passphrase = passphrase
+	}
+	return params, nil
+}
+
+func ValidateRepoInitArgs(logger *slog.Logger, repoArgs RepoArgs, initArgs RepoInitArgs) (repo.InitParams, error) {
+	params := repo.InitParams{
+		CompressionLevel: initArgs.CompressionLevel,
+		Encrypt:          initArgs.UseEncryption,
+	}
+	openParams, err := ValidateRepoOpenArgs(logger, repoArgs)
+	if err != nil {
+		return params, err
+	}
+	params.OpenParams = openParams
+	switch initArgs.Compression {
+	case repoCompressionNone:
+		params.Compression = archive.CompressionNone
+	case archive.CompressionGzip, archive.CompressionZstd:
+		params.Compression = initArgs.Compression
+	default:
+		errMsg := "invalid compression provided"
+		logger.Error(errMsg, slog.String("compression", initArgs.Compression))
+		return params, fmt.Errorf("%s: %s", errMsg, initArgs.Compression)
+	}
+	if params.Encrypt && len(params.Passphrase) == 0 {
+		errMsg := "encrypt flag set but no passphrase or passphrase file provided"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	return params, nil
+}
+
+func repoInitRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo init")
+	params, err := ValidateRepoInitArgs(commandLogger, repoArgs, repoInitArgs)
+	if err != nil {
+		errMsg := "repo init arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	config, err := repo.Init(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to initialize repository", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, config)
+}
+
+func ValidateRepoBackupArgs(logger *slog.Logger, repoArgs RepoArgs, backupArgs RepoBackupArgs, args []string) (repo.BackupParams, error) {
+	params := repo.BackupParams{
+		Tags:     backupArgs.Tags,
+		Hostname: backupArgs.Hostname,
+	}
+	openParams, err := ValidateRepoOpenArgs(logger, repoArgs)
+	if err != nil {
+		return params, err
+	}
+	params.OpenParams = openParams
+	if len(backupArgs.InputPaths) == 0 {
+		logger.Debug("input path flag not set, trying to set from remaining args")
+		numArgs := len(args)
+		if numArgs == 0 {
+			errMsg := "no arguments provided and inputPath not set"
+			logger.Error(errMsg, slog.Int("numArgs", numArgs))
+			return params, errors.New(errMsg)
+		}
+		backupArgs.InputPaths = args
+	}
+	params.InputPaths = backupArgs.InputPaths
+	logger.Debug("input path set", slog.Any("inputPath", params.InputPaths))
+	excludeOptions, err := getExcludeOptions(logger, backupArgs.Excludes, backupArgs.ExcludeFrom, backupArgs.RespectIgnoreFiles)
+	if err != nil {
+		return params, err
+	}
+	params.ExcludeOptions = excludeOptions
+	return params, nil
+}
+
+func repoBackupRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo backup")
+	params, err := ValidateRepoBackupArgs(commandLogger, repoArgs, repoBackupArgs, args)
+	if err != nil {
+		errMsg := "repo backup arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	report, err := repo.Backup(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to back up to repository", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, report)
+}
+
+func ValidateRepoRestoreArgs(logger *slog.Logger, repoArgs RepoArgs, restoreArgs RepoRestoreArgs, args []string) (repo.RestoreParams, error) {
+	params := repo.RestoreParams{
+		SnapshotID: restoreArgs.SnapshotID,
+		Include:    restoreArgs.Include,
+	}
+	openParams, err := ValidateRepoOpenArgs(logger, repoArgs)
+	if err != nil {
+		return params, err
+	}
+	params.OpenParams = openParams
+	if len(restoreArgs.OutputPath) == 0 {
+		logger.Debug("output path flag not set, trying to set from remaining args")
+		numArgs := len(args)
+		if numArgs != 1 {
+			errMsg := "no arguments or too many arguments provided and output path not set"
+			logger.Error(errMsg, slog.Int("numArgs", numArgs))
+			return params, errors.New(errMsg)
+		}
+		restoreArgs.OutputPath = args[0]
+	}
+	params.OutputPath = restoreArgs.OutputPath
+	return params, nil
+}
+
+func repoRestoreRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo restore")
+	params, err := ValidateRepoRestoreArgs(commandLogger, repoArgs, repoRestoreArgs, args)
+	if err != nil {
+		errMsg := "repo restore arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	report, err := repo.Restore(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to restore snapshot", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, report)
+}
+
+func repoListRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo list")
+	params, err := ValidateRepoOpenArgs(commandLogger, repoArgs)
+	if err != nil {
+		errMsg := "repo list arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	snapshots, err := repo.List(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to list snapshots", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, snapshots)
+}
+
+func repoPruneRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo prune")
+	openParams, err := ValidateRepoOpenArgs(commandLogger, repoArgs)
+	if err != nil {
+		errMsg := "repo prune arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	params := repo.PruneParams{
+		OpenParams: openParams,
+		KeepLast:   repoPruneArgs.KeepLast,
+		Forget:     repoPruneArgs.Forget,
+		DryRun:     repoPruneArgs.DryRun,
+	}
+	report, err := repo.Prune(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to prune repository", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, report)
+}
+
+func repoCheckRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running repo check")
+	openParams, err := ValidateRepoOpenArgs(commandLogger, repoArgs)
+	if err != nil {
+		errMsg := "repo check arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	params := repo.CheckParams{
+		OpenParams: openParams,
+		ReadData:   repoCheckArgs.ReadData,
+	}
+	report, err := repo.Check(commandLogger, params)
+	if err != nil && !errors.Is(err, repo.ErrCheckFailed) {
+		commandLogger.Error("failed to check repository", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if !report.OK {
+		return flushOutputBeforeError(commandLogger, repo.ErrCheckFailed)
+	}
+	return nil
+}
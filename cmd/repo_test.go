@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestRepoBackupRestore(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	repoPath := filepath.Join(tmpDir, "repo")
+	commands := [][]string{
+		{"repo", "init", "-r", repoPath, "-e", "-p", "test1", "--compression", "gz", "-o", filepath.Join(tmpDir, "config.json")},
+		{"repo", "backup", "-r", repoPath, "-p", "test1", "--tag", "test", "-o", filepath.Join(tmpDir, "backup.json"), testRootDir},
+		{"repo", "list", "-r", repoPath, "-p", "test1", "-o", filepath.Join(tmpDir, "list.json")},
+		{"repo", "restore", "-r", repoPath, "-p", "test1", "-o", filepath.Join(tmpDir, "restore.json"), filepath.Join(tmpDir, "restore")},
+		{"repo", "check", "-r", repoPath, "-p", "test1", "--read-data", "-o", filepath.Join(tmpDir, "check.json")},
+	}
+	for _, args := range commands {
+		repoCmd := SetupCommand("", "", "")
+		repoCmd.SetArgs(args)
+		if err := repoCmd.Execute(); err != nil {
+			t.Errorf("failed to run %v: %v", args[:2], err)
+			return
+		}
+	}
+	if err := mock.ConfirmContentMapMatches(filepath.Join(tmpDir, "restore", filepath.Base(testRootDir)), content); err != nil {
+		t.Errorf("failed in comparison of restored files: %v", err)
+	}
+}
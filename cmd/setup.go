@@ -14,6 +14,7 @@ import (
 	"log/slog"
 
 	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/progress"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
@@ -67,6 +68,9 @@ var (
 	//TODO: take this out of global scope
 	outputFile *bufio.Writer // *os.File
 	startTime  time.Time
+
+	progressModeString string
+	progressInterval   time.Duration
 )
 
 func NewRootCMD() *cobra.Command {
@@ -252,12 +256,18 @@ func SetupCommand(_buildHash, _buildDate, _buildTag string) *cobra.Command {
 	rootCmd.PersistentFlags().StringVar(&logOutputPath, "logOutput", stdErrFileName, "Where to write the logs from the command to. Default is stderr")
 	rootCmd.PersistentFlags().StringVarP(&inputPath, "input", "i", stdInFileName, "Where to read the input of the command (If there is any). Default is stdin")
 	rootCmd.PersistentFlags().StringVarP(&outputPath, "output", "o", stdOutFileName, "Where to write the output of the command. Default is stdout")
+	rootCmd.PersistentFlags().StringVar(&progressModeString, "progress", string(progress.ModeNone), "How to report progress of tar, gzip and encrypt jobs on stderr. Options are none, bar for a terminal progress bar, json for a JSON line per interval, or auto for bar when stderr is a terminal and json otherwise")
+	rootCmd.PersistentFlags().DurationVar(&progressInterval, "progress-interval", progress.DefaultInterval, "How often progress is reported")
 	bulkRenameInit(rootCmd)
 	encryptDecryptInit(rootCmd)
 	base64CommandInit(rootCmd)
 	spaceAnalyzerInit(rootCmd)
 	gzipInit(rootCmd)
 	tarInit(rootCmd)
+	zipInit(rootCmd)
+	archiveInit(rootCmd)
+	repoInit(rootCmd)
+	parityInit(rootCmd)
 	versionInit(rootCmd, buildDate, buildHash, version)
 	return rootCmd
 }
@@ -271,6 +281,15 @@ func getInputReader(logger *slog.Logger, inputFile io.Reader, inputText string)
 	return inputFile
 }
 
+// flushOutputBeforeError flushes the output and returns err. The post run does not flush the output when a command fails,
+// so commands that write a report and then fail call this so the report is not lost.
+func flushOutputBeforeError(logger *slog.Logger, err error) error {
+	if flushErr := outputFile.Flush(); flushErr != nil {
+		logger.Warn("failed to flush output", slog.String("errorMessage", flushErr.Error()))
+	}
+	return err
+}
+
 func getPassphrase(logger *slog.Logger, passphraseFile string, passphrase string) ([]byte, error) {
 	if len(passphrase) > 0 {
 		logger.Debug("passphrase provided so taking it")
@@ -3,26 +3,85 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"regexp"
+	"time"
 
 	"log/slog"
 
 	"github.com/calvine/filejitsu/spaceanalyzer"
+	"github.com/calvine/filejitsu/spaceanalyzer/browse"
 	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/ignore"
 	"github.com/calvine/filejitsu/util/streamingjson"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 type SpaceAnalyzerArgs struct {
-	RootPath            string `json:"rootPath"`
-	MaxRecursion        int    `json:"maxRecursion"`
-	CalculateFileHashes bool   `json:"calculateFileHashes"`
-	OutputFormat        string `json:"outputFormat"`
-	ConcurrencyLimit    int    `json:"concurrencyLimit"`
-	// ExistingAnalysisFile string `json:"existingAnalysisFile"`
+	RootPath            string   `json:"rootPath"`
+	MaxRecursion        int      `json:"maxRecursion"`
+	CalculateFileHashes bool     `json:"calculateFileHashes"`
+	OutputFormat        string   `json:"outputFormat"`
+	ConcurrencyLimit    int      `json:"concurrencyLimit"`
+	Excludes            []string `json:"excludes"`
+	ExcludeRegexes      []string `json:"excludeRegexes"`
+	ExcludeFrom         []string `json:"excludeFrom"`
+	Includes            []string `json:"includes"`
+	IncludeRegexes      []string `json:"includeRegexes"`
+	RespectIgnoreFiles  bool     `json:"respectIgnoreFiles"`
+	MinSize             string   `json:"minSize"`
+	MaxSize             string   `json:"maxSize"`
+	NewerThan           string   `json:"newerThan"`
+	OlderThan           string   `json:"olderThan"`
+	FollowSymlinks      bool     `json:"followSymlinks"`
+	OneFileSystem       bool     `json:"oneFileSystem"`
+	SizeMode            string   `json:"sizeMode"`
+	TopFiles            int      `json:"topFiles"`
+	FindDuplicates      bool     `json:"findDuplicates"`
+	BaselinePath        string   `json:"baselinePath"`
+	BaselineFormat      string   `json:"baselineFormat"`
+	BaselineTrustFiles  bool     `json:"baselineTrustFiles"`
 }
 
-const spaceAnalyzerCommandName = "space-analyzer"
+type SpaceAnalyzerDuplicatesArgs struct {
+	PartialHashSize string   `json:"partialHashSize"`
+	Action          string   `json:"action"`
+	Keep            string   `json:"keep"`
+	KeepPrefixes    []string `json:"keepPrefixes"`
+	Execute         bool     `json:"execute"`
+	UndoLogPath     string   `json:"undoLogPath"`
+}
+
+type SpaceAnalyzerBrowseArgs struct {
+	ReportPath   string `json:"reportPath"`
+	ReportFormat string `json:"reportFormat"`
+}
+
+type SpaceAnalyzerSummaryArgs struct {
+	ReportPath   string `json:"reportPath"`
+	ReportFormat string `json:"reportFormat"`
+	Top          int    `json:"top"`
+}
+
+type SpaceAnalyzerDiffArgs struct {
+	ReportFormat    string `json:"reportFormat"`
+	MinDelta        string `json:"minDelta"`
+	GrowthThreshold string `json:"growthThreshold"`
+	Top             int    `json:"top"`
+}
+
+const (
+	spaceAnalyzerCommandName                  = "space-analyzer"
+	spaceAnalyzerDuplicatesCommandName        = "duplicates"
+	spaceAnalyzerRestoreDuplicatesCommandName = "restore-duplicates"
+	spaceAnalyzerBrowseCommandName            = "browse"
+	spaceAnalyzerDiffCommandName              = "diff"
+	spaceAnalyzerSummaryCommandName           = "summary"
+)
 
 func newSpaceAnalyzerCommand() *cobra.Command {
 	return &cobra.Command{
@@ -34,32 +93,144 @@ func newSpaceAnalyzerCommand() *cobra.Command {
 	}
 }
 
-var spaceAnalyzerArgs = SpaceAnalyzerArgs{}
+func newSpaceAnalyzerDuplicatesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     spaceAnalyzerDuplicatesCommandName,
+		Aliases: []string{"dupes"},
+		Short:   "Find files with identical content in a given directory",
+		Long:    "Find files with identical content in a given directory. Files are grouped by size, then by a hash of their first and last few KB, and only the files left are fully hashed. Outputs the sets of duplicates sorted by reclaimable space.",
+		RunE:    spaceAnalyzerDuplicatesRun,
+	}
+}
+
+func newSpaceAnalyzerRestoreDuplicatesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   spaceAnalyzerRestoreDuplicatesCommandName + " <undo log>",
+		Short: "Put back independent copies of the duplicates recorded in an undo log",
+		Args:  cobra.ExactArgs(1),
+		RunE:  spaceAnalyzerRestoreDuplicatesRun,
+	}
+}
+
+func newSpaceAnalyzerBrowseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   spaceAnalyzerBrowseCommandName,
+		Short: "Interactively browse storage usage in the terminal",
+		Long:  "Interactively browse storage usage in the terminal, like ncdu. Scans the root path, or loads a saved report, and shows each directory sorted by size. Items can be searched for, marked and deleted. Press ? in the browser for the keys.",
+		RunE:  spaceAnalyzerBrowseRun,
+	}
+}
+
+func newSpaceAnalyzerDiffCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   spaceAnalyzerDiffCommandName + " <old report> <new report>",
+		Short: "Compare two saved reports to see what grew",
+		Long:  "Compare two saved reports to see what grew. Entities are matched by their path relative to the report roots. Outputs the added, removed and changed directories and files with their size deltas, sorted by growth.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  spaceAnalyzerDiffRun,
+	}
+}
+
+func newSpaceAnalyzerSummaryCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   spaceAnalyzerSummaryCommandName,
+		Short: "Break down storage usage by extension, owner, age and depth",
+		Long:  "Break down storage usage by extension, owner, age and depth. Scans the root path, or loads a saved report, and outputs the size and number of files per extension, owning user and group, modification age bucket and depth, along with the largest files and directories.",
+		RunE:  spaceAnalyzerSummaryRun,
+	}
+}
+
+var (
+	spaceAnalyzerArgs           = SpaceAnalyzerArgs{}
+	spaceAnalyzerDuplicatesArgs = SpaceAnalyzerDuplicatesArgs{}
+	spaceAnalyzerBrowseArgs     = SpaceAnalyzerBrowseArgs{}
+	spaceAnalyzerDiffArgs       = SpaceAnalyzerDiffArgs{}
+	spaceAnalyzerSummaryArgs    = SpaceAnalyzerSummaryArgs{}
+)
 
 func spaceAnalyzerInit(parentCmd *cobra.Command) {
 	spaceAnalyzerCommand := newSpaceAnalyzerCommand()
 	spaceAnalyzerCommand.PersistentFlags().StringVarP(&spaceAnalyzerArgs.RootPath, "rootPath", "p", ".", "The root path to analyze. Default is current directory.")
 	spaceAnalyzerCommand.PersistentFlags().IntVarP(&spaceAnalyzerArgs.MaxRecursion, "maxRecursion", "m", -1, "Max number of recursive calls allowed. -1 means no limit")
 	spaceAnalyzerCommand.PersistentFlags().BoolVarP(&spaceAnalyzerArgs.CalculateFileHashes, "calculateFileHashes", "c", false, "If present file hashes will be calculated on files")
-	spaceAnalyzerCommand.PersistentFlags().StringVarP(&spaceAnalyzerArgs.OutputFormat, "outputFormat", "f", "json", "Output format for scan data. Options are 'json' or 'sjson' for streaming json")
+	spaceAnalyzerCommand.PersistentFlags().StringVarP(&spaceAnalyzerArgs.OutputFormat, "outputFormat", "f", spaceanalyzer.OutputFormatJSON, "Output format for scan data. Options are 'json', 'sjson' for streaming json or 'html' for a self contained report page")
 	spaceAnalyzerCommand.PersistentFlags().IntVar(&spaceAnalyzerArgs.ConcurrencyLimit, "concurrencyLimit", 0, "Limits the number of concurrent files being processed at a time. 0 will default to the number of logical processor cores available. Defaults to 0")
-	// spaceAnalyzerCommand.PersistentFlags().BoolVarP(&spaceAnalyzerArgs.ExistingAnalysisFile, "existingAnalyzerFile", "e", "", "An existing analysis file from a previous")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.BaselinePath, "baseline", "", "A report from a previous scan of the root path. Directories that have not changed since are not read again and file hashes are reused from it")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.BaselineFormat, "baseline-format", spaceanalyzer.OutputFormatJSON, "The format of the baseline report. Options are 'json' or 'sjson' for streaming json")
+	spaceAnalyzerCommand.PersistentFlags().BoolVar(&spaceAnalyzerArgs.BaselineTrustFiles, "baseline-trust-files", false, "If present the files in unchanged directories are reused from the baseline without a stat, so files changed in place are reported as they were. Can not be used with calculateFileHashes, duplicates or the html output")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.SizeMode, "size-mode", string(spaceanalyzer.SizeModeApparent), "The size used for sorting and summaries. Options are 'apparent' for the size of the file contents or 'disk' for the space allocated on disk like du")
+	spaceAnalyzerCommand.PersistentFlags().BoolVar(&spaceAnalyzerArgs.FollowSymlinks, "follow-symlinks", false, "If present symlinks are scanned as the file or directory they point to. Directories reached more than once, like through a symlink cycle, are only scanned the first time")
+	spaceAnalyzerCommand.PersistentFlags().BoolVar(&spaceAnalyzerArgs.OneFileSystem, "one-file-system", false, "If present directories on a different file system than the root path, like network mounts, are not scanned")
+	spaceAnalyzerCommand.PersistentFlags().StringArrayVar(&spaceAnalyzerArgs.Excludes, "exclude", nil, "A gitignore style pattern relative to the root path for files and directories to leave out. Excluded directories are not scanned. Can be specified multiple times")
+	spaceAnalyzerCommand.PersistentFlags().StringArrayVar(&spaceAnalyzerArgs.ExcludeRegexes, "exclude-regex", nil, "A regex matched against the / separated path relative to the root path for files and directories to leave out. Can be specified multiple times")
+	spaceAnalyzerCommand.PersistentFlags().StringArrayVar(&spaceAnalyzerArgs.ExcludeFrom, "exclude-from", nil, "A file containing gitignore style patterns (one per line) for files and directories to leave out. Can be specified multiple times")
+	spaceAnalyzerCommand.PersistentFlags().StringArrayVar(&spaceAnalyzerArgs.Includes, "include", nil, "A gitignore style pattern relative to the root path. If present only matching files, or files in matching directories, are reported. Can be specified multiple times")
+	spaceAnalyzerCommand.PersistentFlags().StringArrayVar(&spaceAnalyzerArgs.IncludeRegexes, "include-regex", nil, "A regex matched against the / separated path relative to the root path. If present only matching files are reported. Can be specified multiple times")
+	spaceAnalyzerCommand.PersistentFlags().BoolVar(&spaceAnalyzerArgs.RespectIgnoreFiles, "respect-ignore-files", false, "If present .gitignore and .filejitsuignore files found while scanning will be honored")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.MinSize, "min-size", "", "Files smaller than this (like 1M) are left out")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.MaxSize, "max-size", "", "Files larger than this (like 4G) are left out")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.NewerThan, "newer-than", "", "Files last modified before this are left out. A date like 2006-01-02, a time like 2006-01-02 15:04:05 (UTC) or an age like 90d, 2w or 12h")
+	spaceAnalyzerCommand.PersistentFlags().StringVar(&spaceAnalyzerArgs.OlderThan, "older-than", "", "Files last modified after this are left out. A date like 2006-01-02, a time like 2006-01-02 15:04:05 (UTC) or an age like 90d, 2w or 12h")
+	spaceAnalyzerCommand.Flags().IntVar(&spaceAnalyzerArgs.TopFiles, "top", spaceanalyzer.DefaultHTMLTopFiles, "How many of the largest files and duplicate sets the html report lists")
+	spaceAnalyzerCommand.Flags().BoolVar(&spaceAnalyzerArgs.FindDuplicates, "find-duplicates", false, "If present the html report hashes files to find duplicates. Otherwise only files hashed by calculateFileHashes are compared")
 	parentCmd.AddCommand(spaceAnalyzerCommand)
+
+	duplicatesCommand := newSpaceAnalyzerDuplicatesCommand()
+	duplicatesCommand.Flags().StringVar(&spaceAnalyzerDuplicatesArgs.PartialHashSize, "partial-hash-size", "4K", "How much of the start and the end of each file is hashed before files that still match are fully hashed")
+	duplicatesCommand.Flags().StringVar(&spaceAnalyzerDuplicatesArgs.Action, "action", "", "What to do with every duplicate except the one kept. Options are 'delete', 'hardlink', 'symlink' or 'reflink'. Only a plan is output unless the execute flag is present")
+	duplicatesCommand.Flags().StringVar(&spaceAnalyzerDuplicatesArgs.Keep, "keep", spaceanalyzer.KeepShortestPath, "Which file of each set is kept. Options are 'oldest', 'newest', 'shortest-path' or 'prefix'")
+	duplicatesCommand.Flags().StringArrayVar(&spaceAnalyzerDuplicatesArgs.KeepPrefixes, "keep-prefix", nil, "A path prefix for the 'prefix' keep rule. Files under earlier prefixes are kept first. Can be specified multiple times and implies --keep prefix")
+	duplicatesCommand.Flags().BoolVar(&spaceAnalyzerDuplicatesArgs.Execute, "execute", false, "If present the action is carried out instead of only output as a plan. Requires undo-log")
+	duplicatesCommand.Flags().StringVar(&spaceAnalyzerDuplicatesArgs.UndoLogPath, "undo-log", "", "The path to write the JSON undo log to before any duplicate is changed")
+	spaceAnalyzerCommand.AddCommand(duplicatesCommand)
+	spaceAnalyzerCommand.AddCommand(newSpaceAnalyzerRestoreDuplicatesCommand())
+
+	browseCommand := newSpaceAnalyzerBrowseCommand()
+	browseCommand.Flags().StringVar(&spaceAnalyzerBrowseArgs.ReportPath, "report", "", "A report saved by space-analyzer to browse instead of scanning the root path")
+	browseCommand.Flags().StringVar(&spaceAnalyzerBrowseArgs.ReportFormat, "report-format", spaceanalyzer.OutputFormatJSON, "The format of the report. Options are 'json' or 'sjson' for streaming json")
+	spaceAnalyzerCommand.AddCommand(browseCommand)
+
+	diffCommand := newSpaceAnalyzerDiffCommand()
+	diffCommand.Flags().StringVar(&spaceAnalyzerDiffArgs.ReportFormat, "report-format", spaceanalyzer.OutputFormatJSON, "The format of both reports. Options are 'json' or 'sjson' for streaming json")
+	diffCommand.Flags().StringVar(&spaceAnalyzerDiffArgs.MinDelta, "min-delta", "", "Directories and files whose size changed by less than this (like 100M) are left out")
+	diffCommand.Flags().StringVar(&spaceAnalyzerDiffArgs.GrowthThreshold, "growth-threshold", "", "If a directory grew by more than this (like 10G) it is flagged and the command fails after writing the output")
+	diffCommand.Flags().IntVar(&spaceAnalyzerDiffArgs.Top, "top", 0, "Only this many directories and files with the most growth are listed. 0 lists all of them")
+	spaceAnalyzerCommand.AddCommand(diffCommand)
+
+	summaryCommand := newSpaceAnalyzerSummaryCommand()
+	summaryCommand.Flags().StringVar(&spaceAnalyzerSummaryArgs.ReportPath, "report", "", "A report saved by space-analyzer to summarize instead of scanning the root path")
+	summaryCommand.Flags().StringVar(&spaceAnalyzerSummaryArgs.ReportFormat, "report-format", spaceanalyzer.OutputFormatJSON, "The format of the report. Options are 'json' or 'sjson' for streaming json")
+	summaryCommand.Flags().IntVar(&spaceAnalyzerSummaryArgs.Top, "top", spaceanalyzer.DefaultSummaryTop, "How many of the largest files and directories are listed")
+	spaceAnalyzerCommand.AddCommand(summaryCommand)
 }
 
 func spaceAnalyzerScanRun(cmd *cobra.Command, args []string) error {
 	commandLogger.Debug("args provided", slog.Any("args", spaceAnalyzerArgs))
-	info, err := spaceanalyzer.Scan(commandLogger, spaceanalyzer.ScanParams{
-		RootPath:            spaceAnalyzerArgs.RootPath,
-		MaxRecursion:        spaceAnalyzerArgs.MaxRecursion,
-		CalculateFileHashes: spaceAnalyzerArgs.CalculateFileHashes,
-	})
+	params, err := ValidateSpaceAnalyzerScanArgs(commandLogger, spaceAnalyzerArgs)
+	if err != nil {
+		return err
+	}
+	info, err := spaceanalyzer.Scan(commandLogger, params)
 	if err != nil {
 		return err
 	}
+	if spaceAnalyzerArgs.OutputFormat == spaceanalyzer.OutputFormatHTML {
+		commandLogger.Info("writing output as HTML")
+		err := spaceanalyzer.WriteHTMLReport(commandLogger, outputFile, info, spaceanalyzer.HTMLReportParams{
+			SizeMode:         params.SizeMode,
+			TopFiles:         spaceAnalyzerArgs.TopFiles,
+			FindDuplicates:   spaceAnalyzerArgs.FindDuplicates,
+			ConcurrencyLimit: params.ConcurrencyLimit,
+		})
+		if err != nil {
+			commandLogger.Error("failed to write html report", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		return nil
+	}
 	output := outputFile
 	var bytesWritten int
-	streamingOutput := spaceAnalyzerArgs.OutputFormat == "sjson"
+	streamingOutput := spaceAnalyzerArgs.OutputFormat == spaceanalyzer.OutputFormatStreamingJSON
 	if streamingOutput {
 		commandLogger.Info("writing output as streaming JSON")
 		jsonStreamer := streamingjson.NewLengthPrefixStreamJSONHandler[spaceanalyzer.FSEntity]()
@@ -90,6 +261,440 @@ func spaceAnalyzerScanRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func ValidateSpaceAnalyzerScanArgs(logger *slog.Logger, args SpaceAnalyzerArgs) (spaceanalyzer.ScanParams, error) {
+	params := spaceanalyzer.ScanParams{
+		RootPath:            args.RootPath,
+		MaxRecursion:        args.MaxRecursion,
+		CalculateFileHashes: args.CalculateFileHashes,
+		ConcurrencyLimit:    args.ConcurrencyLimit,
+		FollowSymlinks:      args.FollowSymlinks,
+		OneFileSystem:       args.OneFileSystem,
+	}
+	sizeMode, err := spaceanalyzer.ParseSizeMode(args.SizeMode)
+	if err != nil {
+		logger.Error("invalid size mode provided", slog.String("sizeMode", args.SizeMode))
+		return params, err
+	}
+	params.SizeMode = sizeMode
+	excludeOptions, err := getExcludeOptions(logger, args.Excludes, args.ExcludeFrom, args.RespectIgnoreFiles)
+	if err != nil {
+		return params, err
+	}
+	filter := spaceanalyzer.ScanFilter{
+		Excludes:           excludeOptions.Patterns,
+		Includes:           args.Includes,
+		RespectIgnoreFiles: excludeOptions.RespectIgnoreFiles,
+	}
+	if _, err := ignore.NewMatcher(filter.Includes); err != nil {
+		errMsg := "invalid include pattern"
+		logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return params, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	compileRegexes := func(name string, values []string) ([]*regexp.Regexp, error) {
+		regexes := make([]*regexp.Regexp, 0, len(values))
+		for _, v := range values {
+			r, err := regexp.Compile(v)
+			if err != nil {
+				errMsg := fmt.Sprintf("invalid %s provided", name)
+				logger.Error(errMsg, slog.String("regex", v), slog.String("errorMessage", err.Error()))
+				return nil, fmt.Errorf("%s: %w", errMsg, err)
+			}
+			regexes = append(regexes, r)
+		}
+		return regexes, nil
+	}
+	if filter.ExcludeRegexes, err = compileRegexes("exclude regex", args.ExcludeRegexes); err != nil {
+		return params, err
+	}
+	if filter.IncludeRegexes, err = compileRegexes("include regex", args.IncludeRegexes); err != nil {
+		return params, err
+	}
+	parseSize := func(name, value string) (int64, error) {
+		if len(value) == 0 {
+			return 0, nil
+		}
+		size, err := util.ParseBytesSize(value)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid %s provided", name)
+			logger.Error(errMsg, slog.String("size", value))
+			return 0, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		return size, nil
+	}
+	if filter.MinSize, err = parseSize("min size", args.MinSize); err != nil {
+		return params, err
+	}
+	if filter.MaxSize, err = parseSize("max size", args.MaxSize); err != nil {
+		return params, err
+	}
+	now := time.Now()
+	parseTime := func(name, value string) (time.Time, error) {
+		if len(value) == 0 {
+			return time.Time{}, nil
+		}
+		t, err := util.ParseTimeOrAge(value, now)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid %s provided", name)
+			logger.Error(errMsg, slog.String("value", value))
+			return t, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		return t, nil
+	}
+	if filter.NewerThan, err = parseTime("newer than", args.NewerThan); err != nil {
+		return params, err
+	}
+	if filter.OlderThan, err = parseTime("older than", args.OlderThan); err != nil {
+		return params, err
+	}
+	params.Filter = filter
+	logger.Debug("scan filter set", slog.Any("filter", filter))
+	if len(args.BaselinePath) > 0 {
+		entities, err := readSpaceAnalyzerReport(context.Background(), logger, args.BaselinePath, args.BaselineFormat)
+		if err != nil {
+			return params, err
+		}
+		baseline, err := spaceanalyzer.NewBaseline(entities)
+		if err != nil {
+			errMsg := "invalid baseline report"
+			logger.Error(errMsg, slog.String("baselinePath", args.BaselinePath), slog.String("errorMessage", err.Error()))
+			return params, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		baseline.TrustUnchangedFiles = args.BaselineTrustFiles
+		params.Baseline = baseline
+	} else if args.BaselineTrustFiles {
+		errMsg := "baseline trust files requires a baseline"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	if args.BaselineTrustFiles && (args.CalculateFileHashes || args.OutputFormat == spaceanalyzer.OutputFormatHTML) {
+		errMsg := "baseline trust files can not be used with calculate file hashes or the html output, since files changed in place would keep their old size and hash"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	return params, nil
+}
+
+func ValidateSpaceAnalyzerDuplicatesArgs(logger *slog.Logger, args SpaceAnalyzerArgs, duplicatesArgs SpaceAnalyzerDuplicatesArgs) (spaceanalyzer.DuplicatesParams, error) {
+	params := spaceanalyzer.DuplicatesParams{}
+	scanParams, err := ValidateSpaceAnalyzerScanArgs(logger, args)
+	if err != nil {
+		return params, err
+	}
+	params.ScanParams = scanParams
+	if args.BaselineTrustFiles {
+		errMsg := "baseline trust files can not be used with duplicates, since files changed in place would keep their old size and hash"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	switch args.OutputFormat {
+	case spaceanalyzer.OutputFormatJSON, spaceanalyzer.OutputFormatCSV, spaceanalyzer.OutputFormatTable:
+	default:
+		errMsg := "invalid output format provided for duplicates"
+		logger.Error(errMsg, slog.String("outputFormat", args.OutputFormat))
+		return params, fmt.Errorf("%s: %s - options are json, csv or table", errMsg, args.OutputFormat)
+	}
+	partialHashSize, err := util.ParseBytesSize(duplicatesArgs.PartialHashSize)
+	if err != nil || partialHashSize <= 0 {
+		errMsg := "invalid partial hash size provided"
+		logger.Error(errMsg, slog.String("partialHashSize", duplicatesArgs.PartialHashSize))
+		return params, fmt.Errorf("%s: %s", errMsg, duplicatesArgs.PartialHashSize)
+	}
+	params.PartialHashSize = partialHashSize
+	return params, nil
+}
+
+func ValidateSpaceAnalyzerResolveArgs(logger *slog.Logger, args SpaceAnalyzerArgs, duplicatesArgs SpaceAnalyzerDuplicatesArgs, keepChanged bool) (spaceanalyzer.ResolveParams, error) {
+	params := spaceanalyzer.ResolveParams{
+		Action:       duplicatesArgs.Action,
+		Keep:         duplicatesArgs.Keep,
+		KeepPrefixes: duplicatesArgs.KeepPrefixes,
+		Execute:      duplicatesArgs.Execute,
+		UndoLogPath:  duplicatesArgs.UndoLogPath,
+	}
+	if len(params.KeepPrefixes) > 0 && !keepChanged {
+		params.Keep = spaceanalyzer.KeepPrefix
+	}
+	if args.OutputFormat != spaceanalyzer.OutputFormatJSON {
+		errMsg := "the plan for a duplicates action is only output as json"
+		logger.Error(errMsg, slog.String("outputFormat", args.OutputFormat))
+		return params, fmt.Errorf("%s: %s", errMsg, args.OutputFormat)
+	}
+	if err := spaceanalyzer.ValidateResolveParams(params); err != nil {
+		logger.Error("invalid duplicates action args", slog.String("errorMessage", err.Error()))
+		return params, err
+	}
+	return params, nil
+}
+
+func spaceAnalyzerDuplicatesRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("args provided", slog.Any("args", spaceAnalyzerArgs), slog.Any("duplicatesArgs", spaceAnalyzerDuplicatesArgs))
+	params, err := ValidateSpaceAnalyzerDuplicatesArgs(commandLogger, spaceAnalyzerArgs, spaceAnalyzerDuplicatesArgs)
+	if err != nil {
+		return err
+	}
+	resolve := len(spaceAnalyzerDuplicatesArgs.Action) > 0
+	var resolveParams spaceanalyzer.ResolveParams
+	if resolve {
+		resolveParams, err = ValidateSpaceAnalyzerResolveArgs(commandLogger, spaceAnalyzerArgs, spaceAnalyzerDuplicatesArgs, cmd.Flags().Changed("keep"))
+		if err != nil {
+			return err
+		}
+	} else if spaceAnalyzerDuplicatesArgs.Execute {
+		errMsg := "execute requires an action"
+		commandLogger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	report, err := spaceanalyzer.FindDuplicates(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to find duplicates", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if resolve {
+		return spaceAnalyzerResolveDuplicates(report, resolveParams)
+	}
+	switch spaceAnalyzerArgs.OutputFormat {
+	case spaceanalyzer.OutputFormatCSV:
+		err = spaceanalyzer.WriteDuplicatesCSV(outputFile, report)
+	case spaceanalyzer.OutputFormatTable:
+		err = spaceanalyzer.WriteDuplicatesTable(outputFile, report)
+	default:
+		return writeJSONOutput(commandLogger, report)
+	}
+	if err != nil {
+		commandLogger.Error("failed to write duplicates report", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func spaceAnalyzerResolveDuplicates(duplicates spaceanalyzer.DuplicatesReport, params spaceanalyzer.ResolveParams) error {
+	report, resolveErr := spaceanalyzer.ResolveDuplicates(commandLogger, duplicates, params)
+	if resolveErr != nil && !errors.Is(resolveErr, spaceanalyzer.ErrDuplicatesUnresolved) {
+		commandLogger.Error("failed to resolve duplicates", slog.String("errorMessage", resolveErr.Error()))
+		return resolveErr
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if resolveErr != nil {
+		return flushOutputBeforeError(commandLogger, resolveErr)
+	}
+	return nil
+}
+
+func spaceAnalyzerRestoreDuplicatesRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running restore duplicates", slog.String("undoLogPath", args[0]))
+	undoLog, err := spaceanalyzer.ReadUndoLog(args[0])
+	if err != nil {
+		commandLogger.Error("failed to read undo log", slog.String("undoLogPath", args[0]), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	report, restoreErr := spaceanalyzer.RestoreDuplicates(commandLogger, undoLog)
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if restoreErr != nil {
+		return flushOutputBeforeError(commandLogger, restoreErr)
+	}
+	return nil
+}
+
+func spaceAnalyzerBrowseRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("args provided", slog.Any("args", spaceAnalyzerArgs), slog.Any("browseArgs", spaceAnalyzerBrowseArgs))
+	stdinFd, stdoutFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdinFd) || !term.IsTerminal(stdoutFd) {
+		errMsg := "browse requires stdin and stdout to be a terminal"
+		commandLogger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	sizeMode, err := spaceanalyzer.ParseSizeMode(spaceAnalyzerArgs.SizeMode)
+	if err != nil {
+		commandLogger.Error("invalid size mode provided", slog.String("sizeMode", spaceAnalyzerArgs.SizeMode))
+		return err
+	}
+	if len(spaceAnalyzerBrowseArgs.ReportPath) == 0 {
+		fmt.Fprintf(os.Stderr, "scanning %s...\n", spaceAnalyzerArgs.RootPath)
+	}
+	root, err := loadSpaceAnalyzerRoot(cmd.Context(), commandLogger, spaceAnalyzerArgs, spaceAnalyzerBrowseArgs.ReportPath, spaceAnalyzerBrowseArgs.ReportFormat)
+	if err != nil {
+		return err
+	}
+	browser := browse.New(commandLogger, root, browse.Options{
+		SizeMode: sizeMode,
+	})
+	oldState, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		commandLogger.Error("failed to put the terminal in raw mode", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer term.Restore(stdinFd, oldState)
+	err = browser.Run(browse.NewKeyReader(os.Stdin), os.Stdout, func() (int, int, error) {
+		return term.GetSize(stdoutFd)
+	})
+	if err != nil {
+		commandLogger.Error("browser failed", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// loadSpaceAnalyzerRoot reads the report if one was provided, and scans the root path otherwise.
+func loadSpaceAnalyzerRoot(ctx context.Context, logger *slog.Logger, args SpaceAnalyzerArgs, reportPath, reportFormat string) (spaceanalyzer.FSEntity, error) {
+	if len(reportPath) == 0 {
+		params, err := ValidateSpaceAnalyzerScanArgs(logger, args)
+		if err != nil {
+			return spaceanalyzer.FSEntity{}, err
+		}
+		return spaceanalyzer.Scan(logger, params)
+	}
+	entities, err := readSpaceAnalyzerReport(ctx, logger, reportPath, reportFormat)
+	if err != nil {
+		return spaceanalyzer.FSEntity{}, err
+	}
+	root, err := spaceanalyzer.BuildTree(entities)
+	if err != nil {
+		logger.Error("failed to read report", slog.String("reportPath", reportPath), slog.String("errorMessage", err.Error()))
+		return root, err
+	}
+	return root, nil
+}
+
+// readSpaceAnalyzerReport reads every entity in a report saved by space-analyzer.
+func readSpaceAnalyzerReport(ctx context.Context, logger *slog.Logger, reportPath, format string) ([]spaceanalyzer.FSEntity, error) {
+	f, err := os.Open(reportPath)
+	if err != nil {
+		errMsg := "failed to open report"
+		logger.Error(errMsg, slog.String("reportPath", reportPath), slog.String("errorMessage", err.Error()))
+		return nil, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	defer f.Close()
+	entities, err := spaceanalyzer.ReadReport(ctx, f, format)
+	if err != nil {
+		logger.Error("failed to read report", slog.String("reportPath", reportPath), slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	logger.Debug("read report", slog.String("reportPath", reportPath), slog.Int("numEntities", len(entities)))
+	return entities, nil
+}
+
+func ValidateSpaceAnalyzerDiffArgs(logger *slog.Logger, args SpaceAnalyzerArgs, diffArgs SpaceAnalyzerDiffArgs) (spaceanalyzer.DiffParams, error) {
+	params := spaceanalyzer.DiffParams{
+		Top: diffArgs.Top,
+	}
+	sizeMode, err := spaceanalyzer.ParseSizeMode(args.SizeMode)
+	if err != nil {
+		logger.Error("invalid size mode provided", slog.String("sizeMode", args.SizeMode))
+		return params, err
+	}
+	params.SizeMode = sizeMode
+	switch args.OutputFormat {
+	case spaceanalyzer.OutputFormatJSON, spaceanalyzer.OutputFormatTable:
+	default:
+		errMsg := "invalid output format provided for diff"
+		logger.Error(errMsg, slog.String("outputFormat", args.OutputFormat))
+		return params, fmt.Errorf("%s: %s - options are json or table", errMsg, args.OutputFormat)
+	}
+	parseSize := func(name, value string) (int64, error) {
+		if len(value) == 0 {
+			return 0, nil
+		}
+		size, err := util.ParseBytesSize(value)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid %s provided", name)
+			logger.Error(errMsg, slog.String("size", value))
+			return 0, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		return size, nil
+	}
+	if params.MinDelta, err = parseSize("min delta", diffArgs.MinDelta); err != nil {
+		return params, err
+	}
+	if params.GrowthThreshold, err = parseSize("growth threshold", diffArgs.GrowthThreshold); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+func spaceAnalyzerDiffRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("args provided", slog.Any("args", spaceAnalyzerArgs), slog.Any("diffArgs", spaceAnalyzerDiffArgs), slog.Any("reports", args))
+	params, err := ValidateSpaceAnalyzerDiffArgs(commandLogger, spaceAnalyzerArgs, spaceAnalyzerDiffArgs)
+	if err != nil {
+		return err
+	}
+	oldEntities, err := readSpaceAnalyzerReport(cmd.Context(), commandLogger, args[0], spaceAnalyzerDiffArgs.ReportFormat)
+	if err != nil {
+		return err
+	}
+	newEntities, err := readSpaceAnalyzerReport(cmd.Context(), commandLogger, args[1], spaceAnalyzerDiffArgs.ReportFormat)
+	if err != nil {
+		return err
+	}
+	report, diffErr := spaceanalyzer.DiffReports(commandLogger, oldEntities, newEntities, params)
+	if diffErr != nil && !errors.Is(diffErr, spaceanalyzer.ErrGrowthThresholdExceeded) {
+		commandLogger.Error("failed to compare reports", slog.String("errorMessage", diffErr.Error()))
+		return diffErr
+	}
+	if spaceAnalyzerArgs.OutputFormat == spaceanalyzer.OutputFormatTable {
+		if err := spaceanalyzer.WriteDiffTable(outputFile, report); err != nil {
+			commandLogger.Error("failed to write diff table", slog.String("errorMessage", err.Error()))
+			return err
+		}
+	} else if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if diffErr != nil {
+		commandLogger.Warn("growth threshold exceeded", slog.String("errorMessage", diffErr.Error()))
+		return flushOutputBeforeError(commandLogger, diffErr)
+	}
+	return nil
+}
+
+func ValidateSpaceAnalyzerSummaryArgs(logger *slog.Logger, args SpaceAnalyzerArgs, summaryArgs SpaceAnalyzerSummaryArgs) (spaceanalyzer.SummaryParams, error) {
+	params := spaceanalyzer.SummaryParams{
+		Top: summaryArgs.Top,
+	}
+	sizeMode, err := spaceanalyzer.ParseSizeMode(args.SizeMode)
+	if err != nil {
+		logger.Error("invalid size mode provided", slog.String("sizeMode", args.SizeMode))
+		return params, err
+	}
+	params.SizeMode = sizeMode
+	switch args.OutputFormat {
+	case spaceanalyzer.OutputFormatJSON, spaceanalyzer.OutputFormatCSV, spaceanalyzer.OutputFormatTable:
+	default:
+		errMsg := "invalid output format provided for summary"
+		logger.Error(errMsg, slog.String("outputFormat", args.OutputFormat))
+		return params, fmt.Errorf("%s: %s - options are json, csv or table", errMsg, args.OutputFormat)
+	}
+	return params, nil
+}
+
+func spaceAnalyzerSummaryRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("args provided", slog.Any("args", spaceAnalyzerArgs), slog.Any("summaryArgs", spaceAnalyzerSummaryArgs))
+	params, err := ValidateSpaceAnalyzerSummaryArgs(commandLogger, spaceAnalyzerArgs, spaceAnalyzerSummaryArgs)
+	if err != nil {
+		return err
+	}
+	root, err := loadSpaceAnalyzerRoot(cmd.Context(), commandLogger, spaceAnalyzerArgs, spaceAnalyzerSummaryArgs.ReportPath, spaceAnalyzerSummaryArgs.ReportFormat)
+	if err != nil {
+		return err
+	}
+	summary := spaceanalyzer.Summarize(commandLogger, root, params)
+	switch spaceAnalyzerArgs.OutputFormat {
+	case spaceanalyzer.OutputFormatCSV:
+		err = spaceanalyzer.WriteSummaryCSV(outputFile, summary)
+	case spaceanalyzer.OutputFormatTable:
+		err = spaceanalyzer.WriteSummaryTable(outputFile, summary)
+	default:
+		return writeJSONOutput(commandLogger, summary)
+	}
+	if err != nil {
+		commandLogger.Error("failed to write summary", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
 func WriteOutputAsStreamingJSON(ctx context.Context, rootInfo spaceanalyzer.FSEntity, writer io.Writer, streamingHandler streamingjson.StreamingJSONWriter[spaceanalyzer.FSEntity]) (int, error) {
 	var bytesWritten int
 	var err error
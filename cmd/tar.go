@@ -3,16 +3,30 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/calvine/filejitsu/bulkrename"
 	"github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/parity"
 	"github.com/calvine/filejitsu/tar"
 	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/archivepath"
+	"github.com/calvine/filejitsu/util/ignore"
+	"github.com/calvine/filejitsu/util/volume"
 	"github.com/spf13/cobra"
 )
 
 type TarArgs struct {
+	FilesFromArgs
 	InputPaths           []string
+	Excludes             []string
+	ExcludeFrom          []string
+	RespectIgnoreFiles   bool
 	OutputPath           string
 	Unpackage            bool
 	UseGZip              bool
@@ -20,10 +34,31 @@ type TarArgs struct {
 	UseEncryption        bool
 	Passphrase           string
 	PassphraseFile       string
+	Reproducible         bool
+	ModTime              string
+	ListedIncremental    string
+	Differential         bool
+	Incremental          bool
+	Increments           []string
+	VolumeSize           string
+	Volumes              string
+	Manifest             bool
+	Verify               bool
+	Against              string
+	Append               bool
+	Update               bool
+	TransformRegex       string
+	TransformTemplate    string
+	Sparse               bool
+	Parity               string
+	Indexed              bool
+	ExtractMembers       []string
 }
 
 const (
 	tarCommandName = "tar"
+
+	sourceDateEpochEnvVar = "SOURCE_DATE_EPOCH"
 )
 
 func newTarCommand() *cobra.Command {
@@ -32,6 +67,12 @@ func newTarCommand() *cobra.Command {
 		Short: "A tool for creating and unpacking tar archives",
 		Long:  "A tool to package or unpackage a tar archive with optional gzip compression and AES256 encryption",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if tarArgs.Verify {
+				return tarVerifyRun(cmd, args)
+			}
+			if tarArgs.Append || tarArgs.Update {
+				return tarAppendRun(cmd, args)
+			}
 			if tarArgs.Unpackage {
 				return tarUnpackageRun(cmd, args)
 			} else {
@@ -50,6 +91,9 @@ var (
 func tarInit(parentCmd *cobra.Command) {
 	tarCommand := newTarCommand()
 	tarCommand.PersistentFlags().StringArrayVar(&tarArgs.InputPaths, "inputPath", nil, "The input path to tar. Can be file or directory. Can be specified multiple times - (USED ONLY WITH CREATING A TAR ARCHIVE I.E. NO unpackage flag)")
+	tarCommand.PersistentFlags().StringArrayVar(&tarArgs.Excludes, "exclude", nil, "A gitignore style pattern for entities to leave out of the tar archive. Can be specified multiple times - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().StringArrayVar(&tarArgs.ExcludeFrom, "exclude-from", nil, "A file containing gitignore style patterns for entities to leave out of the tar archive. Can be specified multiple times - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.RespectIgnoreFiles, "respect-ignore-files", false, "If present .gitignore and .filejitsuignore files found while packaging will be honored - (USED ONLY WITH CREATING A TAR ARCHIVE)")
 	tarCommand.PersistentFlags().StringVar(&tarArgs.OutputPath, "outputPath", "", "The output path to untar the contents of a tar archive to. Must be a directory - (USED ONLY WITH THE unpackage FLAG)")
 	tarCommand.PersistentFlags().BoolVarP(&tarArgs.UseGZip, "useGzip", "z", false, "If present the contents being packaged will be gzipped or unpackaged will be gunzipped")
 	tarCommand.PersistentFlags().StringVarP((*string)(&tarArgs.GzipCompressionLevel), "CompressionLevel", "q", string(gzip.DefaultCompression), "The compression level to use for gzip compression")
@@ -57,6 +101,26 @@ func tarInit(parentCmd *cobra.Command) {
 	tarCommand.PersistentFlags().BoolVarP(&tarArgs.UseEncryption, "encrypt", "e", false, "If present the tar will be encrypted while created, or decrypted while unpacked. Requires a passphrase or passphrase file be provided")
 	tarCommand.PersistentFlags().StringVarP(&tarArgs.Passphrase, "passphrase", "p", "", "The passphrase used to encrypt or decrypt the data")
 	tarCommand.PersistentFlags().StringVarP(&tarArgs.PassphraseFile, "passphraseFile", "f", "", "The file which will be read to get the passphrase used for encryption or decryption")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Reproducible, "reproducible", false, "If present the tar archive will be byte for byte reproducible. Entries are sorted, owners are zeroed, permissions are normalized and modification times are clamped to --mtime or SOURCE_DATE_EPOCH - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.ModTime, "mtime", "", "The time modification times are clamped to for reproducible archives. Unix seconds or the format 2006-01-02 15:04:05. Overrides SOURCE_DATE_EPOCH - (USED ONLY WITH THE reproducible FLAG)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.ListedIncremental, "listed-incremental", "", "The snapshot file used for incremental backups. Only entities changed since the snapshot are packaged and deletions are recorded. The snapshot is created if it does not exist - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Differential, "differential", false, "If present the listed-incremental snapshot is not updated, so each archive holds all changes since the snapshot was created - (USED ONLY WITH THE listed-incremental FLAG)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Incremental, "incremental", false, "If present deletions recorded in incremental archives are applied while unpacking - (USED ONLY WITH THE unpackage FLAG)")
+	tarCommand.PersistentFlags().StringArrayVar(&tarArgs.Increments, "increment", nil, "An incremental archive to apply after the input archive. Can be specified multiple times and are applied in order. Implies incremental - (USED ONLY WITH THE unpackage FLAG)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.VolumeSize, "volume-size", "", "If provided the archive is split into volumes of this size (like 2G or 500M) named <output>.001, <output>.002 and so on with a <output>.manifest.json manifest. Must be a multiple of 512 bytes and requires the output flag - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.Volumes, "volumes", "", "The first volume (name.tar.001) or a glob (\"name.tar.*\") of a split archive to unpackage. Used instead of input. An input ending in .001 is treated as the first volume automatically - (USED ONLY WITH THE unpackage FLAG)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Manifest, "manifest", false, "If present a .filejitsu-manifest.json member listing the path, size, mode and SHA-256 of every file is added to the end of the archive - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Verify, "verify", false, "If present the input archive is verified and a JSON report of mismatches, missing files and extras is written to the output. Without the against flag the archive members are re-hashed and compared to the embedded manifest")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.Against, "against", "", "A directory of extracted files to compare to the archive manifest, or the archive members if there is no manifest - (USED ONLY WITH THE verify FLAG)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Append, "append", false, "If present the input paths are added to the end of the existing archive at the output path instead of creating a new archive")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Update, "update", false, "Like append, but only entities that are not in the archive or are newer than the archived copy are added")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.TransformRegex, "transform-regex", "", "A regex matched against each member name while packaging or unpackaging. The matched part is replaced by the transform-template. Named capture groups are available in the template like they are for bulk-rename")
+	tarCommand.PersistentFlags().BoolVarP(&tarArgs.Sparse, "sparse", "S", false, "If present files with holes, like VM disk images, are packaged as GNU sparse 1.0 members holding only their data. Holes are only detected on Linux. Sparse members are always unpackaged with their holes recreated - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.TransformTemplate, "transform-template", "", "A go text template that replaces the part of each member name matched by transform-regex. padLeft and padRight are available like they are for bulk-rename - (USED ONLY WITH THE transform-regex FLAG)")
+	tarCommand.PersistentFlags().BoolVar(&tarArgs.Indexed, "indexed", false, "If present the gzip stream is restarted at member boundaries and an index of every member is added to the end of the archive, so single members can be extracted without decompressing the whole archive. Requires the useGzip flag and can not be encrypted. With append or update the index is rebuilt - (USED ONLY WITH CREATING OR APPENDING TO A TAR ARCHIVE)")
+	tarCommand.PersistentFlags().StringArrayVar(&tarArgs.ExtractMembers, "extract-member", nil, "The name of a member to extract instead of the whole archive. A directory name extracts everything in it. Indexed archives read from a file are seeked straight to the member. Can be specified multiple times - (USED ONLY WITH THE unpackage FLAG)")
+	tarCommand.PersistentFlags().StringVar(&tarArgs.Parity, "parity", "", "If provided a Reed-Solomon parity file with this redundancy (like 10%) is written next to the archive, or next to each volume, so damage can be repaired with the parity command. Requires the output flag - (USED ONLY WITH CREATING A TAR ARCHIVE)")
+	addFilesFromFlags(tarCommand, &tarArgs.FilesFromArgs, "TAR")
 	parentCmd.AddCommand(tarCommand)
 	util.HideGlobalFlags(tarCommand, map[string]util.FlagModifier{
 		"input": {
@@ -75,20 +139,50 @@ func ValidateTarPackageArgs(logger *slog.Logger, tarArgs TarArgs, args []string)
 	if tarArgs.Unpackage {
 		return params, errors.New("unpackage flag set for package command")
 	}
+	filesFrom, err := getFilesFrom(logger, tarArgs.FilesFromArgs)
+	if err != nil {
+		return params, err
+	}
+	params.FilesFrom = filesFrom
 	if len(tarArgs.InputPaths) == 0 {
 		logger.Debug("input path flag not set, trying to set from remaining args")
 		numArgs := len(args)
-		if numArgs > 0 {
+		if numArgs > 0 || len(tarArgs.FilesFrom) > 0 {
 			tarArgs.InputPaths = args
 			logger.Debug("pulling input path from remaining args")
 		} else {
-			errMsg := "no arguments provided and inputPaths not set"
+			errMsg := "no arguments provided and neither inputPaths or files-from set"
 			logger.Error(errMsg, slog.Int("numArgs", numArgs))
 			return params, errors.New(errMsg)
 		}
 	}
 	params.InputPaths = tarArgs.InputPaths
 	logger.Debug("input path set", slog.Any("inputPath", params.InputPaths))
+	excludeOptions, err := getExcludeOptions(logger, tarArgs.Excludes, tarArgs.ExcludeFrom, tarArgs.RespectIgnoreFiles)
+	if err != nil {
+		return params, err
+	}
+	params.ExcludeOptions = excludeOptions
+	if tarArgs.Reproducible {
+		params.Reproducible = true
+		modTime, err := getReproducibleModTime(logger, tarArgs.ModTime)
+		if err != nil {
+			return params, err
+		}
+		params.ReproducibleOptions.ModTime = modTime
+	} else if len(tarArgs.ModTime) > 0 {
+		logger.Warn("mtime provided without the reproducible flag, so it will be ignored")
+	}
+	if len(tarArgs.ListedIncremental) > 0 {
+		params.Incremental = true
+		params.IncrementalOptions.SnapshotPath = tarArgs.ListedIncremental
+		params.IncrementalOptions.Differential = tarArgs.Differential
+		logger.Debug("incremental options set", slog.Any("incrementalOptions", params.IncrementalOptions))
+	} else if tarArgs.Differential {
+		errMsg := "differential flag requires the listed-incremental flag"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
 	// gzip stuff
 	if tarArgs.UseGZip {
 		params.UseGzip = true
@@ -104,6 +198,13 @@ func ValidateTarPackageArgs(logger *slog.Logger, tarArgs TarArgs, args []string)
 		}
 		params.EncryptionOptions.Passphrase = passphrase
 	}
+	params.IncludeManifest = tarArgs.Manifest
+	params.Sparse = tarArgs.Sparse
+	params.Indexed = tarArgs.Indexed
+	params.Transform, err = getTransformOptions(logger, tarArgs.TransformRegex, tarArgs.TransformTemplate)
+	if err != nil {
+		return params, err
+	}
 	params.Output = outputFile
 	return params, nil
 }
@@ -116,13 +217,219 @@ func tarPackageRun(cmd *cobra.Command, args []string) error {
 		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
 		return fmt.Errorf("%s: %w", errMsg, err)
 	}
+	parityRedundancy, err := getTarParityRedundancy(commandLogger, tarArgs.Parity)
+	if err != nil {
+		return err
+	}
+	var volumeWriter *volume.Writer
+	if len(tarArgs.VolumeSize) > 0 {
+		volumeWriter, err = newTarVolumeWriter(commandLogger, tarArgs.VolumeSize)
+		if err != nil {
+			return err
+		}
+		params.Output = volumeWriter
+	}
+	tracker, stopProgress, err := startProgress(cmd, 0)
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
+	params.Progress = tracker
+	// the archive must be completely written before an incremental snapshot is saved, so the volumes are closed and the output flushed first
+	params.FinishOutput = func() error {
+		if volumeWriter != nil {
+			if err := volumeWriter.Close(); err != nil {
+				commandLogger.Error("failed to finish writing volumes", slog.String("errorMessage", err.Error()))
+				return err
+			}
+			return nil
+		}
+		return outputFile.Flush()
+	}
 	if err := tar.TarPackage(commandLogger, params); err != nil {
 		commandLogger.Error("failed to package tar file", slog.String("errorMessage", err.Error()))
 		return err
 	}
+	if volumeWriter != nil {
+		removeEmptyOutputPlaceholder(commandLogger)
+	}
+	if parityRedundancy > 0 {
+		return writeTarParity(commandLogger, parityRedundancy, volumeWriter)
+	}
+	return nil
+}
+
+// getTarParityRedundancy returns the parity redundancy percent, or zero if no parity file should be written.
+func getTarParityRedundancy(logger *slog.Logger, parityArg string) (int, error) {
+	if len(parityArg) == 0 {
+		return 0, nil
+	}
+	if outputPath == stdOutFileName {
+		errMsg := "parity requires the output flag to be set to a file path"
+		logger.Error(errMsg)
+		return 0, errors.New(errMsg)
+	}
+	redundancy, err := parity.ParseRedundancy(parityArg)
+	if err != nil {
+		logger.Error("invalid parity redundancy provided", slog.String("parity", parityArg), slog.String("errorMessage", err.Error()))
+		return 0, err
+	}
+	return redundancy, nil
+}
+
+// writeTarParity writes a parity file for the archive, or for each volume if the archive was split.
+func writeTarParity(logger *slog.Logger, redundancy int, volumeWriter *volume.Writer) error {
+	paths := []string{outputPath}
+	if volumeWriter != nil {
+		paths = paths[:0]
+		for i := range volumeWriter.Manifest().Volumes {
+			paths = append(paths, volume.Path(outputPath, i+1))
+		}
+	} else if err := outputFile.Flush(); err != nil {
+		// the archive has to be on disk before its parity can be computed
+		logger.Error("failed to flush archive before writing parity", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	for _, p := range paths {
+		params := parity.CreateParams{
+			FilePath:   p,
+			Redundancy: redundancy,
+			BlockSize:  parity.DefaultBlockSize,
+		}
+		if _, err := parity.Create(logger, params); err != nil {
+			logger.Error("failed to write parity file", slog.String("filePath", p), slog.String("errorMessage", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func ValidateTarAppendArgs(logger *slog.Logger, tarArgs TarArgs, args []string) (tar.TarAppendParams, error) {
+	params := tar.TarAppendParams{}
+	if outputPath == stdOutFileName {
+		errMsg := "append and update require the output flag to be set to the path of the existing archive"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	if len(tarArgs.VolumeSize) > 0 || len(tarArgs.ListedIncremental) > 0 {
+		errMsg := "append and update can not be used with the volume-size or listed-incremental flags"
+		logger.Error(errMsg)
+		return params, errors.New(errMsg)
+	}
+	packageParams, err := ValidateTarPackageArgs(logger, tarArgs, args)
+	if err != nil {
+		return params, err
+	}
+	params.TarPackageParams = packageParams
+	params.ArchivePath = outputPath
+	params.Update = tarArgs.Update
+	return params, nil
+}
+
+func tarAppendRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running tar append")
+	params, err := ValidateTarAppendArgs(commandLogger, tarArgs, args)
+	if err != nil {
+		errMsg := "failed to validate tar append args"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	tracker, stopProgress, err := startProgress(cmd, 0)
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
+	params.Progress = tracker
+	parityHeader, hasParity, err := verifyTarParity(commandLogger, params.ArchivePath)
+	if err != nil {
+		return err
+	}
+	if err := tar.TarAppend(commandLogger, params); err != nil {
+		commandLogger.Error("failed to append to tar file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if !hasParity {
+		return nil
+	}
+	return rewriteTarParity(commandLogger, params.ArchivePath, parityHeader)
+}
+
+// verifyTarParity checks an archive that is about to be appended to against its parity file, so damage already in the archive is not
+// written into the new parity file as if it was the archive's content. False is returned if the archive has no parity file.
+func verifyTarParity(logger *slog.Logger, archivePath string) (parity.Header, bool, error) {
+	parityPath := parity.DefaultParityPath(archivePath)
+	header, err := parity.ReadHeader(parityPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return header, false, nil
+	}
+	if err != nil {
+		errMsg := "failed to read the parity file of the archive, recreate or remove it before appending"
+		logger.Error(errMsg, slog.String("parityPath", parityPath), slog.String("errorMessage", err.Error()))
+		return header, false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	if _, err := parity.Verify(logger, parity.VerifyParams{FilePath: archivePath, ParityPath: parityPath}); err != nil {
+		errMsg := "the archive does not match its parity file, run parity repair on it before appending"
+		logger.Error(errMsg, slog.String("archivePath", archivePath), slog.String("errorMessage", err.Error()))
+		return header, false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	return header, true, nil
+}
+
+// rewriteTarParity rewrites the parity file of an archive that was appended to, with the redundancy and block size it had, since the old
+// one no longer matches the archive.
+func rewriteTarParity(logger *slog.Logger, archivePath string, header parity.Header) error {
+	params := parity.CreateParams{
+		FilePath:   archivePath,
+		ParityPath: parity.DefaultParityPath(archivePath),
+		Redundancy: header.Redundancy,
+		BlockSize:  header.BlockSize,
+	}
+	if _, err := parity.Create(logger, params); err != nil {
+		logger.Error("failed to rewrite parity file after appending", slog.String("parityPath", params.ParityPath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	logger.Info("rewrote parity file after appending", slog.String("parityPath", params.ParityPath))
 	return nil
 }
 
+func newTarVolumeWriter(logger *slog.Logger, volumeSizeArg string) (*volume.Writer, error) {
+	if outputPath == stdOutFileName {
+		errMsg := "volume-size requires the output flag to be set to a file path"
+		logger.Error(errMsg)
+		return nil, errors.New(errMsg)
+	}
+	volumeSize, err := util.ParseBytesSize(volumeSizeArg)
+	if err != nil {
+		logger.Error("failed to parse volume size", slog.String("volumeSize", volumeSizeArg), slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	logger.Debug("splitting output into volumes", slog.String("basePath", outputPath), slog.Int64("volumeSize", volumeSize))
+	return volume.NewWriter(logger, outputPath, volumeSize)
+}
+
+// removeEmptyOutputPlaceholder removes the output file created by the root command when the content was written somewhere else, like volumes next to it.
+func removeEmptyOutputPlaceholder(logger *slog.Logger) {
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() > 0 {
+		return
+	}
+	if err := os.Remove(outputPath); err != nil {
+		logger.Warn("failed to remove empty output placeholder file", slog.String("outputPath", outputPath), slog.String("errorMessage", err.Error()))
+	}
+}
+
+// getTarVolumesPattern returns the first volume or glob to unpackage, or an empty string if the input is not a split archive.
+func getTarVolumesPattern(logger *slog.Logger, tarArgs TarArgs) string {
+	if len(tarArgs.Volumes) > 0 {
+		return tarArgs.Volumes
+	}
+	if inputPath != stdInFileName && volume.IsFirstVolume(inputPath) {
+		logger.Debug("input path is the first volume of a split archive", slog.String("inputPath", inputPath))
+		return inputPath
+	}
+	return ""
+}
+
 func ValidateTarUnpackageArgs(logger *slog.Logger, tarArgs TarArgs, args []string) (tar.TarUnpackageParams, error) {
 	params := tar.TarUnpackageParams{}
 	if !tarArgs.Unpackage {
@@ -157,6 +464,12 @@ func ValidateTarUnpackageArgs(logger *slog.Logger, tarArgs TarArgs, args []strin
 		}
 		params.EncryptionOptions.Passphrase = passphrase
 	}
+	params.Incremental = tarArgs.Incremental || len(tarArgs.Increments) > 0
+	transform, err := getTransformOptions(logger, tarArgs.TransformRegex, tarArgs.TransformTemplate)
+	if err != nil {
+		return params, err
+	}
+	params.Transform = transform
 	return params, nil
 }
 
@@ -169,9 +482,250 @@ func tarUnpackageRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("%s: %w", errMsg, err)
 	}
 	commandLogger.Debug("output path set", slog.String("outputPath", tarArgs.OutputPath))
+	volumeReader, err := openTarVolumesInput(commandLogger, tarArgs)
+	if err != nil {
+		return err
+	}
+	if volumeReader != nil {
+		defer func() {
+			if err := volumeReader.Close(); err != nil {
+				commandLogger.Warn("failed to close volumes", slog.String("errorMessage", err.Error()))
+			}
+		}()
+		params.Input = volumeReader
+	}
+	if len(tarArgs.ExtractMembers) > 0 {
+		return tarExtractMembersRun(params, tarArgs.ExtractMembers)
+	}
+	var totalBytes int64
+	if volumeReader == nil && len(tarArgs.Increments) == 0 {
+		totalBytes = getInputSize("")
+	}
+	tracker, stopProgress, err := startProgress(cmd, totalBytes)
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
+	params.Progress = tracker
+	if len(tarArgs.Increments) > 0 {
+		return tarUnpackageIncrementalChainRun(params, tarArgs.Increments)
+	}
 	if err := tar.TarUnpackage(commandLogger, params); err != nil {
 		commandLogger.Error("failed to unpackage tar file", slog.String("errorMessage", err.Error()))
 		return err
 	}
 	return nil
 }
+
+// openTarVolumesInput opens the volumes of a split archive if the args point to one. A nil reader is returned if the input is not a split archive.
+func openTarVolumesInput(logger *slog.Logger, tarArgs TarArgs) (*volume.Reader, error) {
+	volumesPattern := getTarVolumesPattern(logger, tarArgs)
+	if len(volumesPattern) == 0 {
+		return nil, nil
+	}
+	volumePaths, err := volume.ResolveVolumes(logger, volumesPattern)
+	if err != nil {
+		logger.Error("failed to resolve volumes", slog.String("volumes", volumesPattern), slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	return volume.OpenReader(logger, volumePaths)
+}
+
+func ValidateTarVerifyArgs(logger *slog.Logger, tarArgs TarArgs) (tar.TarVerifyParams, error) {
+	params := tar.TarVerifyParams{}
+	if !tarArgs.Verify {
+		return params, errors.New("verify flag not set for verify command")
+	}
+	if tarArgs.Unpackage {
+		return params, errors.New("verify flag can not be used with the unpackage flag")
+	}
+	params.Input = inputFile
+	params.AgainstPath = tarArgs.Against
+	if len(params.AgainstPath) > 0 {
+		info, err := os.Stat(params.AgainstPath)
+		if err != nil {
+			logger.Error("failed to stat against path", slog.String("against", params.AgainstPath), slog.String("errorMessage", err.Error()))
+			return params, err
+		}
+		if !info.IsDir() {
+			errMsg := "against path must be a directory"
+			logger.Error(errMsg, slog.String("against", params.AgainstPath))
+			return params, errors.New(errMsg)
+		}
+	}
+	params.UseGzip = tarArgs.UseGZip
+	if tarArgs.UseEncryption {
+		params.UseEncryption = true
+		passphrase, err := getPassphrase(logger, tarArgs.PassphraseFile, tarArgs.Passphrase)
+		if err != nil {
+			errMsg := "error getting passphrase"
+			logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+			return params, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		params.EncryptionOptions.Passphrase = passphrase
+	}
+	return params, nil
+}
+
+func tarVerifyRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running tar verify")
+	params, err := ValidateTarVerifyArgs(commandLogger, tarArgs)
+	if err != nil {
+		errMsg := "tar verify arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	volumeReader, err := openTarVolumesInput(commandLogger, tarArgs)
+	if err != nil {
+		return err
+	}
+	if volumeReader != nil {
+		defer func() {
+			if err := volumeReader.Close(); err != nil {
+				commandLogger.Warn("failed to close volumes", slog.String("errorMessage", err.Error()))
+			}
+		}()
+		params.Input = volumeReader
+	}
+	report, err := tar.TarVerify(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to verify tar file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if !report.OK {
+		return flushOutputBeforeError(commandLogger, tar.ErrVerificationFailed)
+	}
+	return nil
+}
+
+func tarExtractMembersRun(params tar.TarUnpackageParams, names []string) error {
+	if params.Transform.TargetRegex != nil || params.Incremental {
+		errMsg := "extract-member can not be combined with transforms or incremental unpackaging"
+		commandLogger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	extractParams := tar.TarExtractParams{
+		Input:             params.Input,
+		Names:             names,
+		OutputPath:        params.OutputPath,
+		UseGzip:           params.UseGzip,
+		UseEncryption:     params.UseEncryption,
+		EncryptionOptions: params.EncryptionOptions,
+	}
+	if err := tar.TarExtractMembers(commandLogger, extractParams); err != nil {
+		commandLogger.Error("failed to extract tar members", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func tarUnpackageIncrementalChainRun(params tar.TarUnpackageParams, incrementPaths []string) error {
+	increments := make([]io.Reader, 0, len(incrementPaths))
+	defer func() {
+		for _, increment := range increments {
+			if err := util.TryCloseReader(increment); err != nil {
+				commandLogger.Warn("failed to close increment file", slog.String("errorMessage", err.Error()))
+			}
+		}
+	}()
+	for _, incrementPath := range incrementPaths {
+		f, err := util.OpenFile(incrementPath, os.O_RDONLY, 0644)
+		if err != nil {
+			commandLogger.Error("failed to open increment file", slog.String("path", incrementPath), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		increments = append(increments, f)
+	}
+	if err := tar.TarUnpackageIncrementalChain(commandLogger, params, increments); err != nil {
+		commandLogger.Error("failed to unpackage incremental chain", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func getExcludeOptions(logger *slog.Logger, excludes, excludeFrom []string, respectIgnoreFiles bool) (archivepath.ExcludeOptions, error) {
+	options := archivepath.ExcludeOptions{
+		RespectIgnoreFiles: respectIgnoreFiles,
+	}
+	patterns := make([]string, 0, len(excludes))
+	patterns = append(patterns, excludes...)
+	for _, f := range excludeFrom {
+		logger.Debug("reading exclude patterns from file", slog.String("file", f))
+		filePatterns, err := ignore.ReadPatternFile(f)
+		if err != nil {
+			errMsg := "failed to read exclude file"
+			logger.Error(errMsg, slog.String("file", f), slog.String("errorMessage", err.Error()))
+			return options, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	// validate the patterns up front so a bad pattern is reported as an argument error
+	if _, err := ignore.NewMatcher(patterns); err != nil {
+		errMsg := "invalid exclude pattern"
+		logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return options, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	options.Patterns = patterns
+	logger.Debug("exclude options set", slog.Any("excludeOptions", options))
+	return options, nil
+}
+
+// getReproducibleModTime returns the time to clamp modification times to. The mtime flag takes precedence over SOURCE_DATE_EPOCH. If neither is set the zero time is returned.
+func getReproducibleModTime(logger *slog.Logger, modTimeArg string) (time.Time, error) {
+	value := modTimeArg
+	source := "mtime"
+	if len(value) == 0 {
+		value = os.Getenv(sourceDateEpochEnvVar)
+		source = sourceDateEpochEnvVar
+	}
+	if len(value) == 0 {
+		logger.Debug("no mtime or SOURCE_DATE_EPOCH provided, clamping to the unix epoch")
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		modTime := time.Unix(seconds, 0).UTC()
+		logger.Debug("reproducible mod time set", slog.String("source", source), slog.Time("modTime", modTime))
+		return modTime, nil
+	}
+	if source == sourceDateEpochEnvVar {
+		errMsg := "SOURCE_DATE_EPOCH must be an integer number of seconds"
+		logger.Error(errMsg, slog.String("value", value))
+		return time.Time{}, errors.New(errMsg)
+	}
+	modTime, err := time.ParseInLocation(time.DateTime, value, time.UTC)
+	if err != nil {
+		errMsg := "failed to parse mtime"
+		logger.Error(errMsg, slog.String("value", value), slog.String("errorMessage", err.Error()))
+		return time.Time{}, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	logger.Debug("reproducible mod time set", slog.String("source", source), slog.Time("modTime", modTime))
+	return modTime, nil
+}
+
+func getTransformOptions(logger *slog.Logger, transformRegex, transformTemplate string) (tar.TransformOptions, error) {
+	options := tar.TransformOptions{}
+	if len(transformRegex) == 0 && len(transformTemplate) == 0 {
+		return options, nil
+	}
+	if len(transformRegex) == 0 || len(transformTemplate) == 0 {
+		errMsg := "transform-regex and transform-template must be provided together"
+		logger.Error(errMsg)
+		return options, errors.New(errMsg)
+	}
+	targetRegex, err := regexp.Compile(transformRegex)
+	if err != nil {
+		logger.Error("transform regex failed to compile", slog.String("transformRegex", transformRegex), slog.String("errorMessage", err.Error()))
+		return options, fmt.Errorf("transform regex failed to compile: %w", err)
+	}
+	destinationTemplate, err := bulkrename.NewDestinationTemplate(transformTemplate)
+	if err != nil {
+		logger.Error("failed to parse transform template", slog.String("transformTemplate", transformTemplate), slog.String("errorMessage", err.Error()))
+		return options, fmt.Errorf("failed to parse transform template: %w", err)
+	}
+	options.TargetRegex = targetRegex
+	options.DestinationTemplate = destinationTemplate
+	return options, nil
+}
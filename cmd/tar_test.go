@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/calvine/filejitsu/util/mock"
+	"github.com/calvine/filejitsu/util/progress"
 )
 
 func TestRoundTripTar(t *testing.T) {
@@ -172,3 +176,168 @@ func TestTarHelp(t *testing.T) {
 		return
 	}
 }
+
+func TestRoundTripTarVolumes(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar.gz")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetArgs([]string{
+		"tar",
+		"-z",
+		"-q",
+		"NoCompression",
+		"--volume-size",
+		"1K",
+		"-o",
+		tarPath,
+		testRootDir,
+	})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar on dir: %v", err)
+		return
+	}
+	if _, err := os.Stat(tarPath); !os.IsNotExist(err) {
+		t.Errorf("expected empty output placeholder to be removed: %v", err)
+	}
+	if _, err := os.Stat(tarPath + ".002"); err != nil {
+		t.Errorf("expected archive to be split into multiple volumes: %v", err)
+		return
+	}
+	untarPath := filepath.Join(tmpDir, "test_untar")
+	untarCmd := SetupCommand("", "", "")
+	untarCmd.SetArgs([]string{
+		"tar",
+		"-z",
+		"-i",
+		tarPath + ".001",
+		"-u",
+		untarPath,
+	})
+	if err := untarCmd.Execute(); err != nil {
+		t.Errorf("failed to run untar on volumes: %v", err)
+		return
+	}
+	if err := mock.ConfirmContentMapMatches(untarPath, content); err != nil {
+		t.Errorf("failed in comparison of untar'ed files: %v", err)
+	}
+}
+
+func TestTarProgressJSON(t *testing.T) {
+	testRootDir, _, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tarPath := filepath.Join(t.TempDir(), "output.tar")
+	tarCmd := SetupCommand("", "", "")
+	progressOutput := bytes.NewBuffer([]byte{})
+	tarCmd.SetErr(progressOutput)
+	tarCmd.SetArgs([]string{
+		"tar",
+		"--progress",
+		"json",
+		"-o",
+		tarPath,
+		testRootDir,
+	})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar on dir: %v", err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(progressOutput.String()), "\n")
+	summary := progress.Snapshot{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil {
+		t.Errorf("failed to parse final progress line: %v", err)
+		return
+	}
+	if summary.TotalEntries == 0 || summary.EntriesProcessed != summary.TotalEntries {
+		t.Errorf("expected every entry to be processed: %+v", summary)
+	}
+	if summary.TotalBytes == 0 || summary.BytesProcessed != summary.TotalBytes || summary.Percent != 100 {
+		t.Errorf("expected every byte to be processed: %+v", summary)
+	}
+}
+
+func TestTarInvalidProgressMode(t *testing.T) {
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetErr(bytes.NewBuffer([]byte{}))
+	tarCmd.SetArgs([]string{
+		"tar",
+		"--progress",
+		"fancy",
+		"-o",
+		filepath.Join(t.TempDir(), "output.tar"),
+		t.TempDir(),
+	})
+	if err := tarCmd.Execute(); err == nil {
+		t.Error("expected error for invalid progress mode")
+	}
+}
+
+func TestTarIndexedExtractMember(t *testing.T) {
+	testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	tmpDir := t.TempDir()
+	tarPath := filepath.Join(tmpDir, "output.tar.gz")
+	tarCmd := SetupCommand("", "", "")
+	tarCmd.SetArgs([]string{
+		"tar",
+		"-z",
+		"--indexed",
+		"-o",
+		tarPath,
+		testRootDir,
+	})
+	if err := tarCmd.Execute(); err != nil {
+		t.Errorf("failed to run tar on dir: %v", err)
+		return
+	}
+	untarPath := filepath.Join(tmpDir, "test_untar")
+	untarCmd := SetupCommand("", "", "")
+	untarCmd.SetArgs([]string{
+		"tar",
+		"-z",
+		"-u",
+		"-i",
+		tarPath,
+		"--extract-member",
+		"nested/bigfile.txt",
+		untarPath,
+	})
+	if err := untarCmd.Execute(); err != nil {
+		t.Errorf("failed to extract member: %v", err)
+		return
+	}
+	name := filepath.Join("nested", "bigfile.txt")
+	data, err := os.ReadFile(filepath.Join(untarPath, name))
+	if err != nil || string(data) != content[name].Content {
+		t.Errorf("extracted member does not match: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(untarPath, "file1.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected only the named member to be extracted: %v", err)
+	}
+
+	indexedCmd := SetupCommand("", "", "")
+	indexedCmd.SetErr(bytes.NewBuffer([]byte{}))
+	indexedCmd.SetArgs([]string{
+		"tar",
+		"--indexed",
+		"-o",
+		filepath.Join(tmpDir, "plain.tar"),
+		testRootDir,
+	})
+	if err := indexedCmd.Execute(); err == nil {
+		t.Errorf("expected indexed without gzip to fail")
+	}
+}
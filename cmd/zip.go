@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/zip"
+	"github.com/spf13/cobra"
+)
+
+type ZipArgs struct {
+	FilesFromArgs
+	InputPaths         []string
+	Excludes           []string
+	ExcludeFrom        []string
+	RespectIgnoreFiles bool
+	OutputPath         string
+	Unpackage          bool
+	List               bool
+	Test               bool
+	CompressionLevel   gzip.GZipCompressionLevel
+	UseEncryption      bool
+	Passphrase         string
+	PassphraseFile     string
+}
+
+const (
+	zipCommandName = "zip"
+)
+
+func newZipCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   zipCommandName,
+		Short: "A tool for creating and unpacking zip archives",
+		Long:  "A tool to package, unpackage, list or test a zip archive with optional AES256 encryption of the whole archive",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case zipArgs.List:
+				return zipListRun(cmd, args)
+			case zipArgs.Test:
+				return zipTestRun(cmd, args)
+			case zipArgs.Unpackage:
+				return zipUnpackageRun(cmd, args)
+			default:
+				return zipPackageRun(cmd, args)
+			}
+		},
+	}
+}
+
+var (
+	zipArgs = ZipArgs{}
+)
+
+func zipInit(parentCmd *cobra.Command) {
+	zipCommand := newZipCommand()
+	zipCommand.PersistentFlags().StringArrayVar(&zipArgs.InputPaths, "inputPath", nil, "The input path to zip. Can be file or directory. Can be specified multiple times - (USED ONLY WITH CREATING A ZIP ARCHIVE)")
+	zipCommand.PersistentFlags().StringArrayVar(&zipArgs.Excludes, "exclude", nil, "A gitignore style pattern for entities to leave out of the zip archive. Can be specified multiple times - (USED ONLY WITH CREATING A ZIP ARCHIVE)")
+	zipCommand.PersistentFlags().StringArrayVar(&zipArgs.ExcludeFrom, "exclude-from", nil, "A file containing gitignore style patterns for entities to leave out of the zip archive. Can be specified multiple times - (USED ONLY WITH CREATING A ZIP ARCHIVE)")
+	zipCommand.PersistentFlags().BoolVar(&zipArgs.RespectIgnoreFiles, "respect-ignore-files", false, "If present .gitignore and .filejitsuignore files found while packaging will be honored - (USED ONLY WITH CREATING A ZIP ARCHIVE)")
+	zipCommand.PersistentFlags().StringVar(&zipArgs.OutputPath, "outputPath", "", "The output path to unzip the contents of a zip archive to. Must be a directory - (USED ONLY WITH THE unpackage FLAG)")
+	zipCommand.PersistentFlags().BoolVarP(&zipArgs.Unpackage, "unpackage", "u", false, "If present the input zip archive will be unpacked at the outputPath")
+	zipCommand.PersistentFlags().BoolVar(&zipArgs.List, "list", false, "If present the members of the input zip archive are written to the output as JSON")
+	zipCommand.PersistentFlags().BoolVar(&zipArgs.Test, "test", false, "If present every member of the input zip archive is read and checked against its CRC-32, and a JSON report is written to the output")
+	zipCommand.PersistentFlags().StringVarP((*string)(&zipArgs.CompressionLevel), "CompressionLevel", "q", string(gzip.DefaultCompression), "The deflate compression level to use for each member. NoCompression stores members as is")
+	zipCommand.PersistentFlags().BoolVarP(&zipArgs.UseEncryption, "encrypt", "e", false, "If present the whole zip archive will be encrypted while created, or decrypted while read. Requires a passphrase or passphrase file be provided")
+	zipCommand.PersistentFlags().StringVarP(&zipArgs.Passphrase, "passphrase", "p", "", "The passphrase used to encrypt or decrypt the data")
+	zipCommand.PersistentFlags().StringVarP(&zipArgs.PassphraseFile, "passphraseFile", "f", "", "The file which will be read to get the passphrase used for encryption or decryption")
+	addFilesFromFlags(zipCommand, &zipArgs.FilesFromArgs, "ZIP")
+	parentCmd.AddCommand(zipCommand)
+	util.HideGlobalFlags(zipCommand, map[string]util.FlagModifier{
+		"input": {
+			Hide:         false,
+			UsagePostFix: "(NOT USED FOR ZIP PACKAGING, USE inputPath FLAG INSTEAD)",
+		},
+		"output": {
+			Hide:         false,
+			UsagePostFix: "(NOT USED FOR ZIP UNPACKING, USE outputPath FLAG INSTEAD)",
+		},
+	})
+}
+
+func getZipEncryptionOptions(logger *slog.Logger, zipArgs ZipArgs) (zip.EncryptionOptions, error) {
+	options := zip.EncryptionOptions{}
+	if !zipArgs.UseEncryption {
+		return options, nil
+	}
+	passphrase, err := getPassphrase(logger, zipArgs.PassphraseFile, zipArgs.Passphrase)
+	if err != nil {
+		errMsg := "error getting passphrase"
+		logger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return options, fmt.Errorf("%s: %w", errMsg, err)
+	}
+	options.Passphrase = passphrase
+	return options, nil
+}
+
+func ValidateZipPackageArgs(logger *slog.Logger, zipArgs ZipArgs, args []string) (zip.ZipPackageParams, error) {
+	params := zip.ZipPackageParams{}
+	if zipArgs.Unpackage || zipArgs.List || zipArgs.Test {
+		return params, errors.New("unpackage, list or test flag set for package command")
+	}
+	filesFrom, err := getFilesFrom(logger, zipArgs.FilesFromArgs)
+	if err != nil {
+		return params, err
+	}
+	params.FilesFrom = filesFrom
+	if len(zipArgs.InputPaths) == 0 {
+		logger.Debug("input path flag not set, trying to set from remaining args")
+		numArgs := len(args)
+		if numArgs > 0 || len(zipArgs.FilesFrom) > 0 {
+			zipArgs.InputPaths = args
+			logger.Debug("pulling input path from remaining args")
+		} else {
+			errMsg := "no arguments provided and neither inputPaths or files-from set"
+			logger.Error(errMsg, slog.Int("numArgs", numArgs))
+			return params, errors.New(errMsg)
+		}
+	}
+	params.InputPaths = zipArgs.InputPaths
+	logger.Debug("input path set", slog.Any("inputPath", params.InputPaths))
+	excludeOptions, err := getExcludeOptions(logger, zipArgs.Excludes, zipArgs.ExcludeFrom, zipArgs.RespectIgnoreFiles)
+	if err != nil {
+		return params, err
+	}
+	params.ExcludeOptions = excludeOptions
+	params.CompressionLevel = zipArgs.CompressionLevel
+	params.UseEncryption = zipArgs.UseEncryption
+	params.EncryptionOptions, err = getZipEncryptionOptions(logger, zipArgs)
+	if err != nil {
+		return params, err
+	}
+	params.Output = outputFile
+	return params, nil
+}
+
+func zipPackageRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running zip package")
+	params, err := ValidateZipPackageArgs(commandLogger, zipArgs, args)
+	if err != nil {
+		errMsg := "failed to validate zip packaging args"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	if err := zip.ZipPackage(commandLogger, params); err != nil {
+		commandLogger.Error("failed to package zip file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func ValidateZipReadArgs(logger *slog.Logger, zipArgs ZipArgs) (zip.ZipReadParams, error) {
+	params := zip.ZipReadParams{
+		Input:         inputFile,
+		UseEncryption: zipArgs.UseEncryption,
+	}
+	encryptionOptions, err := getZipEncryptionOptions(logger, zipArgs)
+	if err != nil {
+		return params, err
+	}
+	params.EncryptionOptions = encryptionOptions
+	return params, nil
+}
+
+func ValidateZipUnpackageArgs(logger *slog.Logger, zipArgs ZipArgs, args []string) (zip.ZipUnpackageParams, error) {
+	params := zip.ZipUnpackageParams{}
+	if !zipArgs.Unpackage {
+		return params, errors.New("unpackage flag not set for unpackage command")
+	}
+	readParams, err := ValidateZipReadArgs(logger, zipArgs)
+	if err != nil {
+		return params, err
+	}
+	params.ZipReadParams = readParams
+	if len(zipArgs.OutputPath) == 0 {
+		logger.Debug("output path flag not set, trying to set from remaining args")
+		numArgs := len(args)
+		if numArgs == 1 {
+			zipArgs.OutputPath = args[0]
+			logger.Debug("pulling output path from remaining args")
+		} else {
+			errMsg := "no arguments or too many arguments provided and output path not set"
+			logger.Error(errMsg, slog.Int("numArgs", numArgs))
+			return params, errors.New(errMsg)
+		}
+	}
+	logger.Debug("setting outputPath", slog.String("outputPath", zipArgs.OutputPath))
+	params.OutputPath = zipArgs.OutputPath
+	return params, nil
+}
+
+func zipUnpackageRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running zip unpackage")
+	params, err := ValidateZipUnpackageArgs(commandLogger, zipArgs, args)
+	if err != nil {
+		errMsg := "unzip arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	if err := zip.ZipUnpackage(commandLogger, params); err != nil {
+		commandLogger.Error("failed to unpackage zip file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func zipListRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running zip list")
+	params, err := ValidateZipReadArgs(commandLogger, zipArgs)
+	if err != nil {
+		errMsg := "zip list arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	entries, err := zip.ZipList(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to list zip file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return writeJSONOutput(commandLogger, entries)
+}
+
+func zipTestRun(cmd *cobra.Command, args []string) error {
+	commandLogger.Debug("running zip test")
+	params, err := ValidateZipReadArgs(commandLogger, zipArgs)
+	if err != nil {
+		errMsg := "zip test arg validation failed"
+		commandLogger.Error(errMsg, slog.String("errorMessage", err.Error()))
+		return fmt.Errorf("%s: %w", errMsg, err)
+	}
+	report, err := zip.ZipTest(commandLogger, params)
+	if err != nil {
+		commandLogger.Error("failed to test zip file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := writeJSONOutput(commandLogger, report); err != nil {
+		return err
+	}
+	if !report.OK {
+		return flushOutputBeforeError(commandLogger, zip.ErrTestFailed)
+	}
+	return nil
+}
+
+// writeJSONOutput writes the value to the output file as indented JSON.
+func writeJSONOutput(logger *slog.Logger, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		logger.Error("failed to marshal data to JSON", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if _, err := outputFile.Write(data); err != nil {
+		logger.Error("failed to write JSON to output", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
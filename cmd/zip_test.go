@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestRoundTripZip(t *testing.T) {
+	type testCase struct {
+		Name      string
+		ExtraArgs []string
+	}
+	testCases := []testCase{
+		{
+			Name: "normal",
+		},
+		{
+			Name: "encrypted",
+			ExtraArgs: []string{
+				"-e",
+				"-p",
+				"test1",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testRootDir, content, cleanup, err := mock.MakeGenericMockDirTree()
+			if err != nil {
+				t.Errorf("failed to create test dir tree: %v", err)
+				return
+			}
+			defer cleanup()
+			tmpDir := t.TempDir()
+			zipPath := filepath.Join(tmpDir, "output.zip")
+			zipCmd := SetupCommand("", "", "")
+			zipArgs := append([]string{"zip", "-o", zipPath}, tc.ExtraArgs...)
+			zipCmd.SetArgs(append(zipArgs, testRootDir))
+			if err := zipCmd.Execute(); err != nil {
+				t.Errorf("failed to run zip on dir: %v", err)
+				return
+			}
+			testCmd := SetupCommand("", "", "")
+			testArgs := append([]string{"zip", "--test", "-i", zipPath, "-o", filepath.Join(tmpDir, "test.json")}, tc.ExtraArgs...)
+			testCmd.SetArgs(testArgs)
+			if err := testCmd.Execute(); err != nil {
+				t.Errorf("failed to run zip test: %v", err)
+				return
+			}
+			unzipPath := filepath.Join(tmpDir, "test_unzip")
+			unzipCmd := SetupCommand("", "", "")
+			unzipArgs := append([]string{"zip", "-i", zipPath, "-u"}, tc.ExtraArgs...)
+			unzipCmd.SetArgs(append(unzipArgs, unzipPath))
+			if err := unzipCmd.Execute(); err != nil {
+				t.Errorf("failed to run unzip on zip file: %v", err)
+				return
+			}
+			if err := mock.ConfirmContentMapMatches(unzipPath, content); err != nil {
+				t.Errorf("failed in comparison of unzipped files: %v", err)
+			}
+		})
+	}
+}
+
+func TestZipHelp(t *testing.T) {
+	zipCmd := SetupCommand("", "", "")
+	zipCmd.SetArgs([]string{
+		"zip",
+		"-h",
+	})
+	if err := zipCmd.Execute(); err != nil {
+		t.Errorf("failed to run zip help: %v", err)
+	}
+}
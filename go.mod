@@ -1,12 +1,17 @@
 module github.com/calvine/filejitsu
 
-go 1.21
+go 1.22
 
 require (
 	github.com/google/uuid v1.3.1
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.7.0
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/exp v0.0.0-20230522175609-2e198f4a06a1
+	golang.org/x/term v0.22.0
 )
 
-require github.com/inconshreveable/mousetrap v1.1.0 // indirect
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+)
@@ -0,0 +1,94 @@
+package parity
+
+import "errors"
+
+var (
+	ErrSingularMatrix = errors.New("matrix is singular")
+)
+
+// gfPolynomial is the field polynomial x^8 + x^4 + x^3 + x^2 + 1 used for GF(2^8).
+const gfPolynomial = 0x11d
+
+var (
+	gfExp [512]byte
+	gfLog [256]int
+	// gfMulTable holds every product so multiplying a whole block by a constant is a table lookup per byte.
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	// the exp table is doubled so the sum of two logs never has to be reduced
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[gfLog[a]+gfLog[b]]
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	return gfMulTable[a][b]
+}
+
+// gfInv returns the multiplicative inverse of a, which must not be zero.
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfMulAdd adds c * in to out. Addition in GF(2^8) is xor.
+func gfMulAdd(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	row := &gfMulTable[c]
+	for i, v := range in {
+		out[i] ^= row[v]
+	}
+}
+
+// gfInvertMatrix returns the inverse of the square matrix with Gauss-Jordan elimination.
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range m {
+		// each row is the matrix row followed by the identity row
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, ErrSingularMatrix
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		scale := gfInv(work[col][col])
+		for i := range work[col] {
+			work[col][i] = gfMul(work[col][i], scale)
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				gfMulAdd(work[row][col], work[col], work[row])
+			}
+		}
+	}
+	inverse := make([][]byte, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
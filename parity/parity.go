@@ -0,0 +1,398 @@
+package parity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatVersion is the version of the parity file format written by Create.
+	FormatVersion = 1
+	// FileSuffix is appended to the path of a file to get the default path of its parity file.
+	FileSuffix = ".par"
+	// DefaultBlockSize is the size of the blocks damage is detected and repaired in.
+	DefaultBlockSize = 64 * 1024
+	// DefaultRedundancy is the default size of the recovery data as a percent of the file size.
+	DefaultRedundancy = 10
+
+	// a parity file starts with the magic, followed by the parity blocks, the block hashes and the JSON header, and ends with the trailer
+	// which holds the header length, the SHA-256 of the header and the magic again
+	magic       = "FJPARITY"
+	trailerSize = 4 + sha256.Size + len(magic)
+)
+
+var (
+	ErrNotParityFile      = errors.New("not a filejitsu parity file")
+	ErrParityFileDamaged  = errors.New("the parity file header or block hashes are damaged")
+	ErrInvalidRedundancy  = errors.New("redundancy must be a percent from 1 to 100")
+	ErrInvalidBlockSize   = errors.New("block size must be positive")
+	ErrDamageFound        = errors.New("damaged blocks found")
+	ErrFileSizeMismatch   = errors.New("the file size does not match the parity file")
+	ErrUnrepairable       = errors.New("too many damaged blocks to repair")
+	ErrRepairVerifyFailed = errors.New("the repaired file does not match the parity file")
+)
+
+// Header describes the file the parity file protects and how its recovery blocks are laid out.
+//
+// The file is split into blocks of BlockSize, with the last block padded with zeros. Blocks are spread over NumStripes stripes so block b is
+// data shard b / NumStripes of stripe b % NumStripes, and blocks past the end of the file are treated as zeros. Each stripe has ParityShards
+// parity blocks and can be repaired as long as at most ParityShards of its blocks are damaged. Interleaving the stripes means a run of damaged
+// blocks, like a bad area of a disk, is spread over many stripes.
+type Header struct {
+	Version      int    `json:"version"`
+	FileName     string `json:"fileName"`
+	FileSize     int64  `json:"fileSize"`
+	FileSHA256   string `json:"fileSha256"`
+	BlockSize    int    `json:"blockSize"`
+	Redundancy   int    `json:"redundancy"`
+	DataShards   int    `json:"dataShards"`
+	ParityShards int    `json:"parityShards"`
+	NumStripes   int    `json:"numStripes"`
+	// HashesSHA256 is the SHA-256 of the block hash table, so a damaged table is not mistaken for damaged blocks.
+	HashesSHA256 string `json:"hashesSha256"`
+}
+
+// NumDataBlocks returns the number of blocks the file is split into.
+func (h Header) NumDataBlocks() int {
+	return int((h.FileSize + int64(h.BlockSize) - 1) / int64(h.BlockSize))
+}
+
+// NumParityBlocks returns the number of recovery blocks in the parity file.
+func (h Header) NumParityBlocks() int {
+	return h.NumStripes * h.ParityShards
+}
+
+// dataBlock returns the data block index of the shard of the stripe. It may be past the last block of the file.
+func (h Header) dataBlock(stripe, shard int) int {
+	return shard*h.NumStripes + stripe
+}
+
+// stripeLayout returns the number of data and parity shards per stripe and the number of stripes for a file with numBlocks blocks.
+func stripeLayout(numBlocks, redundancy int) (int, int, int) {
+	if numBlocks == 0 {
+		return 0, 0, 0
+	}
+	parityFor := func(dataShards int) int {
+		return (dataShards*redundancy + 99) / 100
+	}
+	dataShards := min(numBlocks, MaxShards*100/(100+redundancy))
+	for dataShards+parityFor(dataShards) > MaxShards {
+		dataShards--
+	}
+	numStripes := (numBlocks + dataShards - 1) / dataShards
+	// spread the blocks evenly, so the last stripe is not mostly padding
+	dataShards = (numBlocks + numStripes - 1) / numStripes
+	return dataShards, parityFor(dataShards), numStripes
+}
+
+// ParseRedundancy parses a redundancy percent like 10% or 10.
+func ParseRedundancy(redundancy string) (int, error) {
+	value, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(redundancy), "%"))
+	if err != nil || value < 1 || value > 100 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidRedundancy, redundancy)
+	}
+	return value, nil
+}
+
+// DefaultParityPath returns the default path of the parity file for the file.
+func DefaultParityPath(filePath string) string {
+	return filePath + FileSuffix
+}
+
+// ReadHeader returns the header of the parity file, which records the file size and layout the parity file was created with.
+func ReadHeader(parityPath string) (Header, error) {
+	f, err := os.Open(parityPath)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+	p, err := openParityFile(f)
+	if err != nil {
+		return Header{}, err
+	}
+	return p.header, nil
+}
+
+// parityFile is an opened parity file.
+type parityFile struct {
+	header       Header
+	dataHashes   [][]byte
+	parityHashes [][]byte
+	r            io.ReaderAt
+}
+
+// parityBlockOffset returns where the parity block is stored in the parity file.
+func (p *parityFile) parityBlockOffset(block int) int64 {
+	return int64(len(magic)) + int64(block)*int64(p.header.BlockSize)
+}
+
+func (p *parityFile) readParityBlock(block int, buf []byte) error {
+	_, err := p.r.ReadAt(buf, p.parityBlockOffset(block))
+	return err
+}
+
+// openParityFile reads the header and block hashes of the parity file.
+func openParityFile(f *os.File) (*parityFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(len(magic)+trailerSize) {
+		return nil, ErrNotParityFile
+	}
+	start := make([]byte, len(magic))
+	if _, err := f.ReadAt(start, 0); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := f.ReadAt(trailer, size-int64(trailerSize)); err != nil {
+		return nil, err
+	}
+	if string(trailer[trailerSize-len(magic):]) != magic {
+		if string(start) == magic {
+			return nil, fmt.Errorf("%w: trailer not found", ErrParityFileDamaged)
+		}
+		return nil, ErrNotParityFile
+	}
+	headerSize := int64(binary.BigEndian.Uint32(trailer))
+	headerOffset := size - int64(trailerSize) - headerSize
+	if headerOffset < int64(len(magic)) {
+		return nil, ErrParityFileDamaged
+	}
+	headerData := make([]byte, headerSize)
+	if _, err := f.ReadAt(headerData, headerOffset); err != nil {
+		return nil, err
+	}
+	headerSum := sha256.Sum256(headerData)
+	if !bytes.Equal(headerSum[:], trailer[4:4+sha256.Size]) {
+		return nil, fmt.Errorf("%w: header checksum mismatch", ErrParityFileDamaged)
+	}
+	p := &parityFile{r: f}
+	if err := json.Unmarshal(headerData, &p.header); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParityFileDamaged, err)
+	}
+	if p.header.Version != FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotParityFile, p.header.Version)
+	}
+	if p.header.BlockSize <= 0 || p.header.FileSize < 0 || p.header.NumStripes < 0 || p.header.ParityShards < 0 {
+		return nil, fmt.Errorf("%w: invalid layout", ErrParityFileDamaged)
+	}
+	numHashes := p.header.NumDataBlocks() + p.header.NumParityBlocks()
+	hashesOffset := p.parityBlockOffset(p.header.NumParityBlocks())
+	if hashesOffset+int64(numHashes*sha256.Size) != headerOffset {
+		return nil, fmt.Errorf("%w: unexpected size", ErrParityFileDamaged)
+	}
+	hashes := make([]byte, numHashes*sha256.Size)
+	if _, err := f.ReadAt(hashes, hashesOffset); err != nil {
+		return nil, err
+	}
+	hashesSum := sha256.Sum256(hashes)
+	if hex.EncodeToString(hashesSum[:]) != p.header.HashesSHA256 {
+		return nil, fmt.Errorf("%w: block hash checksum mismatch", ErrParityFileDamaged)
+	}
+	for i := 0; i < numHashes; i++ {
+		hash := hashes[i*sha256.Size : (i+1)*sha256.Size]
+		if i < p.header.NumDataBlocks() {
+			p.dataHashes = append(p.dataHashes, hash)
+		} else {
+			p.parityHashes = append(p.parityHashes, hash)
+		}
+	}
+	return p, nil
+}
+
+// readDataBlock reads the block of the file into buf, padding with zeros past the end of the file.
+func readDataBlock(r io.ReaderAt, header Header, block int, buf []byte) error {
+	clear(buf)
+	if block >= header.NumDataBlocks() {
+		return nil
+	}
+	_, err := r.ReadAt(buf, int64(block)*int64(header.BlockSize))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func blockHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+type CreateParams struct {
+	FilePath string
+	// ParityPath if empty DefaultParityPath of the file path is used.
+	ParityPath string
+	// Redundancy is the size of the recovery data as a percent of the file size. Up to this much damage can be repaired.
+	Redundancy int
+	BlockSize  int
+}
+
+type CreateReport struct {
+	FilePath        string `json:"filePath"`
+	ParityPath      string `json:"parityPath"`
+	FileSize        int64  `json:"fileSize"`
+	BlockSize       int    `json:"blockSize"`
+	Redundancy      int    `json:"redundancy"`
+	NumDataBlocks   int    `json:"numDataBlocks"`
+	NumParityBlocks int    `json:"numParityBlocks"`
+	ParitySize      int64  `json:"paritySize"`
+}
+
+// Create writes a parity file with Reed-Solomon recovery blocks for the file. The parity file is written to a temporary file
+// next to it and renamed into place once it is complete.
+func Create(logger *slog.Logger, params CreateParams) (CreateReport, error) {
+	report := CreateReport{FilePath: params.FilePath, ParityPath: params.ParityPath, Redundancy: params.Redundancy, BlockSize: params.BlockSize}
+	if len(report.ParityPath) == 0 {
+		report.ParityPath = DefaultParityPath(params.FilePath)
+	}
+	if params.Redundancy < 1 || params.Redundancy > 100 {
+		return report, fmt.Errorf("%w: %d", ErrInvalidRedundancy, params.Redundancy)
+	}
+	if params.BlockSize <= 0 {
+		return report, ErrInvalidBlockSize
+	}
+	f, err := os.Open(params.FilePath)
+	if err != nil {
+		logger.Error("failed to open file", slog.String("filePath", params.FilePath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return report, err
+	}
+	header := Header{
+		Version:    FormatVersion,
+		FileName:   filepath.Base(params.FilePath),
+		FileSize:   info.Size(),
+		BlockSize:  params.BlockSize,
+		Redundancy: params.Redundancy,
+	}
+	header.DataShards, header.ParityShards, header.NumStripes = stripeLayout(header.NumDataBlocks(), params.Redundancy)
+	logger.Debug("parity layout", slog.Any("header", header))
+	out, err := os.CreateTemp(filepath.Dir(report.ParityPath), ".tmp-"+filepath.Base(report.ParityPath)+"-*")
+	if err != nil {
+		logger.Error("failed to create parity file", slog.String("parityPath", report.ParityPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	tmpPath := out.Name()
+	defer func() {
+		out.Close()
+		os.Remove(tmpPath)
+	}()
+	hashes, err := writeParity(f, out, &header)
+	if err != nil {
+		logger.Error("failed to write parity blocks", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	if err := writeTrailer(out, header, hashes); err != nil {
+		logger.Error("failed to write parity header", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	if err := out.Sync(); err != nil {
+		return report, err
+	}
+	if err := out.Close(); err != nil {
+		return report, err
+	}
+	if err := os.Rename(tmpPath, report.ParityPath); err != nil {
+		logger.Error("failed to move parity file into place", slog.String("parityPath", report.ParityPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	parityInfo, err := os.Stat(report.ParityPath)
+	if err != nil {
+		return report, err
+	}
+	report.FileSize = header.FileSize
+	report.NumDataBlocks = header.NumDataBlocks()
+	report.NumParityBlocks = header.NumParityBlocks()
+	report.ParitySize = parityInfo.Size()
+	logger.Info("created parity file", slog.Any("report", report))
+	return report, nil
+}
+
+// writeParity writes the magic and parity blocks, sets the file hash on the header and returns the data block hashes followed by the parity block hashes.
+func writeParity(f *os.File, out io.Writer, header *Header) ([]byte, error) {
+	if _, err := io.WriteString(out, magic); err != nil {
+		return nil, err
+	}
+	// the first pass reads the file in order to hash it, the second reads each stripe to compute its parity
+	fileHash := sha256.New()
+	hashes := make([]byte, 0, (header.NumDataBlocks()+header.NumParityBlocks())*sha256.Size)
+	buf := make([]byte, header.BlockSize)
+	for block := 0; block < header.NumDataBlocks(); block++ {
+		if err := readDataBlock(f, *header, block, buf); err != nil {
+			return nil, err
+		}
+		size := min(int64(header.BlockSize), header.FileSize-int64(block)*int64(header.BlockSize))
+		fileHash.Write(buf[:size])
+		hashes = append(hashes, blockHash(buf)...)
+	}
+	header.FileSHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	if header.NumStripes == 0 {
+		return hashes, nil
+	}
+	enc, err := newEncoder(header.DataShards, header.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	data := makeShards(header.DataShards, header.BlockSize)
+	parity := makeShards(header.ParityShards, header.BlockSize)
+	for stripe := 0; stripe < header.NumStripes; stripe++ {
+		for shard := range data {
+			if err := readDataBlock(f, *header, header.dataBlock(stripe, shard), data[shard]); err != nil {
+				return nil, err
+			}
+		}
+		enc.encode(data, parity)
+		for _, p := range parity {
+			if _, err := out.Write(p); err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, blockHash(p)...)
+		}
+	}
+	return hashes, nil
+}
+
+// writeTrailer writes the block hashes, the header and the trailer.
+func writeTrailer(out io.Writer, header Header, hashes []byte) error {
+	hashesSum := sha256.Sum256(hashes)
+	header.HashesSHA256 = hex.EncodeToString(hashesSum[:])
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	headerSum := sha256.Sum256(headerData)
+	trailer := make([]byte, 0, trailerSize)
+	trailer = binary.BigEndian.AppendUint32(trailer, uint32(len(headerData)))
+	trailer = append(trailer, headerSum[:]...)
+	trailer = append(trailer, magic...)
+	for _, data := range [][]byte{hashes, headerData, trailer} {
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func makeShards(n, size int) [][]byte {
+	shards := make([][]byte, n)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+	}
+	return shards
+}
@@ -0,0 +1,231 @@
+package parity
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestReconstructAnyShards(t *testing.T) {
+	enc, err := newEncoder(5, 3)
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	data := makeShards(5, 64)
+	for _, d := range data {
+		rng.Read(d)
+	}
+	parity := makeShards(3, 64)
+	enc.encode(data, parity)
+	original := append(append([][]byte{}, data...), parity...)
+	// every combination of up to 3 lost shards must be recoverable
+	for lost := 0; lost < 1<<8; lost++ {
+		numLost := 0
+		for i := 0; i < 8; i++ {
+			numLost += (lost >> i) & 1
+		}
+		if numLost > 3 {
+			continue
+		}
+		shards := make([][]byte, 8)
+		intact := make([]bool, 8)
+		for i := range shards {
+			shards[i] = bytes.Clone(original[i])
+			intact[i] = lost&(1<<i) == 0
+			if !intact[i] {
+				rng.Read(shards[i])
+			}
+		}
+		if err := enc.reconstruct(shards, intact); err != nil {
+			t.Fatalf("failed to reconstruct with lost shards %08b: %v", lost, err)
+		}
+		for i := range shards {
+			if !bytes.Equal(shards[i], original[i]) {
+				t.Errorf("shard %d not reconstructed with lost shards %08b", i, lost)
+			}
+		}
+	}
+	shards := makeShards(8, 64)
+	if err := enc.reconstruct(shards, []bool{true, false, false, false, false, true, true, true}); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("expected ErrTooFewShards, got %v", err)
+	}
+}
+
+func TestStripeLayout(t *testing.T) {
+	type testCase struct {
+		NumBlocks  int
+		Redundancy int
+	}
+	testCases := []testCase{
+		{NumBlocks: 1, Redundancy: 10},
+		{NumBlocks: 10, Redundancy: 10},
+		{NumBlocks: 1000, Redundancy: 10},
+		{NumBlocks: 1000, Redundancy: 100},
+		{NumBlocks: 12345, Redundancy: 33},
+	}
+	for _, tc := range testCases {
+		dataShards, parityShards, numStripes := stripeLayout(tc.NumBlocks, tc.Redundancy)
+		if dataShards+parityShards > MaxShards || parityShards < 1 {
+			t.Errorf("invalid shard counts for %+v: %d data and %d parity", tc, dataShards, parityShards)
+		}
+		if dataShards*numStripes < tc.NumBlocks {
+			t.Errorf("stripes do not cover every block for %+v: %d stripes of %d", tc, numStripes, dataShards)
+		}
+		if parityShards*100 < dataShards*tc.Redundancy {
+			t.Errorf("not enough parity for %+v: %d parity for %d data", tc, parityShards, dataShards)
+		}
+	}
+}
+
+func writeRandomFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	return data
+}
+
+func TestCreateVerifyRepair(t *testing.T) {
+	logger := mock.NewMockLogger()
+	filePath := filepath.Join(t.TempDir(), "archive.tar.gz.enc")
+	// the size is not a multiple of the block size so the padded last block is covered
+	original := writeRandomFile(t, filePath, 300*1024+123)
+	report, err := Create(logger, CreateParams{FilePath: filePath, Redundancy: 10, BlockSize: 1024})
+	if err != nil {
+		t.Fatalf("failed to create parity: %v", err)
+	}
+	if report.NumParityBlocks == 0 || report.ParityPath != filePath+FileSuffix {
+		t.Fatalf("unexpected create report: %+v", report)
+	}
+	if _, err := Verify(logger, VerifyParams{FilePath: filePath}); err != nil {
+		t.Fatalf("expected intact file to verify: %v", err)
+	}
+	// damage a contiguous run of 5% of the file and one parity block
+	damaged := bytes.Clone(original)
+	for i := 1000; i < 1000+len(original)/20; i++ {
+		damaged[i] ^= 0xff
+	}
+	damaged[len(damaged)-1] ^= 0x01
+	if err := os.WriteFile(filePath, damaged, 0644); err != nil {
+		t.Fatalf("failed to damage file: %v", err)
+	}
+	pf, err := os.OpenFile(report.ParityPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open parity file: %v", err)
+	}
+	if _, err := pf.WriteAt([]byte("damage"), int64(len(magic))+10); err != nil {
+		t.Fatalf("failed to damage parity file: %v", err)
+	}
+	pf.Close()
+	verifyReport, err := Verify(logger, VerifyParams{FilePath: filePath})
+	if !errors.Is(err, ErrDamageFound) {
+		t.Fatalf("expected ErrDamageFound, got %v", err)
+	}
+	if !verifyReport.Repairable || len(verifyReport.DamagedDataBlocks) == 0 || len(verifyReport.DamagedParityBlocks) != 1 {
+		t.Errorf("unexpected verify report: %+v", verifyReport)
+	}
+	repairReport, err := Repair(logger, RepairParams{VerifyParams{FilePath: filePath}})
+	if err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+	if !repairReport.Repaired || repairReport.RepairedDataBlocks != len(verifyReport.DamagedDataBlocks) || repairReport.RepairedParityBlocks != 1 {
+		t.Errorf("unexpected repair report: %+v", repairReport)
+	}
+	repaired, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read repaired file: %v", err)
+	}
+	if !bytes.Equal(repaired, original) {
+		t.Errorf("repaired file does not match the original")
+	}
+}
+
+func TestRepairTruncatedFile(t *testing.T) {
+	logger := mock.NewMockLogger()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	original := writeRandomFile(t, filePath, 64*1024)
+	if _, err := Create(logger, CreateParams{FilePath: filePath, Redundancy: 20, BlockSize: 512}); err != nil {
+		t.Fatalf("failed to create parity: %v", err)
+	}
+	if err := os.Truncate(filePath, int64(len(original)-2000)); err != nil {
+		t.Fatalf("failed to truncate file: %v", err)
+	}
+	if _, err := Repair(logger, RepairParams{VerifyParams{FilePath: filePath}}); err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+	repaired, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read repaired file: %v", err)
+	}
+	if !bytes.Equal(repaired, original) {
+		t.Errorf("repaired file does not match the original")
+	}
+}
+
+func TestVerifyFileSizeMismatch(t *testing.T) {
+	logger := mock.NewMockLogger()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	original := writeRandomFile(t, filePath, 8*1024)
+	if _, err := Create(logger, CreateParams{FilePath: filePath, Redundancy: 10, BlockSize: 512}); err != nil {
+		t.Fatalf("failed to create parity: %v", err)
+	}
+	// like an archive appended to after its parity file was written
+	if err := os.WriteFile(filePath, append(original, make([]byte, 1024)...), 0644); err != nil {
+		t.Fatalf("failed to extend file: %v", err)
+	}
+	report, err := Verify(logger, VerifyParams{FilePath: filePath})
+	if !errors.Is(err, ErrFileSizeMismatch) || !errors.Is(err, ErrDamageFound) {
+		t.Errorf("expected a file size mismatch, got %v", err)
+	}
+	if report.OK || report.FileSize != report.ExpectedFileSize+1024 {
+		t.Errorf("unexpected verify report: %+v", report)
+	}
+}
+
+func TestRepairTooMuchDamage(t *testing.T) {
+	logger := mock.NewMockLogger()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	original := writeRandomFile(t, filePath, 64*1024)
+	if _, err := Create(logger, CreateParams{FilePath: filePath, Redundancy: 5, BlockSize: 512}); err != nil {
+		t.Fatalf("failed to create parity: %v", err)
+	}
+	damaged := bytes.Clone(original)
+	for i := 0; i < len(damaged)/2; i++ {
+		damaged[i] ^= 0xff
+	}
+	if err := os.WriteFile(filePath, damaged, 0644); err != nil {
+		t.Fatalf("failed to damage file: %v", err)
+	}
+	if _, err := Repair(logger, RepairParams{VerifyParams{FilePath: filePath}}); !errors.Is(err, ErrUnrepairable) {
+		t.Fatalf("expected ErrUnrepairable, got %v", err)
+	}
+	unchanged, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(unchanged, damaged) {
+		t.Errorf("expected an unrepairable file to be left as it was")
+	}
+}
+
+func TestParseRedundancy(t *testing.T) {
+	valid := map[string]int{"10%": 10, "25": 25, " 100% ": 100}
+	for input, expected := range valid {
+		if value, err := ParseRedundancy(input); err != nil || value != expected {
+			t.Errorf("expected %s to parse as %d, got %d: %v", input, expected, value, err)
+		}
+	}
+	for _, input := range []string{"0%", "101%", "ten", ""} {
+		if _, err := ParseRedundancy(input); !errors.Is(err, ErrInvalidRedundancy) {
+			t.Errorf("expected ErrInvalidRedundancy for %q, got %v", input, err)
+		}
+	}
+}
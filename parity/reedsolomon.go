@@ -0,0 +1,105 @@
+package parity
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the most data and parity shards a stripe can have, since every shard needs its own element of GF(2^8).
+const MaxShards = 256
+
+var (
+	ErrInvalidShardCount = fmt.Errorf("data and parity shard counts must be positive and add up to at most %d", MaxShards)
+	ErrTooFewShards      = errors.New("too few intact shards to reconstruct the stripe")
+)
+
+// encoder is a systematic Reed-Solomon code. The data shards are kept as they are and the parity shards are computed from them with a Cauchy matrix,
+// so any dataShards of the dataShards+parityShards shards are enough to get all of them back.
+type encoder struct {
+	dataShards   int
+	parityShards int
+	// parityRows[i][j] is what data shard j is multiplied by when it is added to parity shard i.
+	parityRows [][]byte
+}
+
+func newEncoder(dataShards, parityShards int) (*encoder, error) {
+	if dataShards <= 0 || parityShards <= 0 || dataShards+parityShards > MaxShards {
+		return nil, ErrInvalidShardCount
+	}
+	parityRows := make([][]byte, parityShards)
+	for i := range parityRows {
+		parityRows[i] = make([]byte, dataShards)
+		for j := range parityRows[i] {
+			// x = dataShards+i and y = j never overlap, so x ^ y is never zero
+			parityRows[i][j] = gfInv(byte(dataShards+i) ^ byte(j))
+		}
+	}
+	return &encoder{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		parityRows:   parityRows,
+	}, nil
+}
+
+// encode computes the parity shards from the data shards. Every shard must be the same size.
+func (e *encoder) encode(data, parity [][]byte) {
+	for i, p := range parity {
+		clear(p)
+		for j, d := range data {
+			gfMulAdd(e.parityRows[i][j], d, p)
+		}
+	}
+}
+
+// generatorRow returns the row of the generator matrix for the shard. Data shards are rows of the identity matrix.
+func (e *encoder) generatorRow(shard int) []byte {
+	if shard >= e.dataShards {
+		return e.parityRows[shard-e.dataShards]
+	}
+	row := make([]byte, e.dataShards)
+	row[shard] = 1
+	return row
+}
+
+// reconstruct rewrites every shard that is not intact from the ones that are. The data shards come first followed by the parity shards,
+// and every shard must be allocated to the same size.
+func (e *encoder) reconstruct(shards [][]byte, intact []bool) error {
+	rows := make([][]byte, 0, e.dataShards)
+	sources := make([][]byte, 0, e.dataShards)
+	for i := range shards {
+		if len(rows) == e.dataShards {
+			break
+		}
+		if intact[i] {
+			rows = append(rows, e.generatorRow(i))
+			sources = append(sources, shards[i])
+		}
+	}
+	if len(rows) < e.dataShards {
+		return ErrTooFewShards
+	}
+	decode, err := gfInvertMatrix(rows)
+	if err != nil {
+		return err
+	}
+	for j := 0; j < e.dataShards; j++ {
+		if intact[j] {
+			continue
+		}
+		clear(shards[j])
+		for i, source := range sources {
+			gfMulAdd(decode[j][i], source, shards[j])
+		}
+	}
+	for i := 0; i < e.parityShards; i++ {
+		shard := e.dataShards + i
+		if intact[shard] {
+			continue
+		}
+		clear(shards[shard])
+		for j := 0; j < e.dataShards; j++ {
+			gfMulAdd(e.parityRows[i][j], shards[j], shards[shard])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package parity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+type VerifyParams struct {
+	FilePath string
+	// ParityPath if empty DefaultParityPath of the file path is used.
+	ParityPath string
+}
+
+// VerifyReport lists the damaged blocks of a file and its parity file.
+type VerifyReport struct {
+	OK               bool   `json:"ok"`
+	FilePath         string `json:"filePath"`
+	ParityPath       string `json:"parityPath"`
+	FileSize         int64  `json:"fileSize"`
+	ExpectedFileSize int64  `json:"expectedFileSize"`
+	FileSHA256       string `json:"fileSha256"`
+	BlockSize        int    `json:"blockSize"`
+	NumDataBlocks    int    `json:"numDataBlocks"`
+	NumParityBlocks  int    `json:"numParityBlocks"`
+	// DamagedDataBlocks are the indexes of file blocks that could not be read or do not match their hash.
+	DamagedDataBlocks []int `json:"damagedDataBlocks"`
+	// DamagedParityBlocks are the indexes of recovery blocks that could not be read or do not match their hash.
+	DamagedParityBlocks []int `json:"damagedParityBlocks"`
+	// Repairable is true if no stripe has more damaged blocks than it has parity blocks.
+	Repairable bool `json:"repairable"`
+}
+
+// Verify reads every block of the file and its parity file and checks them against the hashes in the parity file.
+// The report is returned with ErrDamageFound if anything is damaged, which also wraps ErrFileSizeMismatch if the file is not the size
+// the parity file was created for.
+func Verify(logger *slog.Logger, params VerifyParams) (VerifyReport, error) {
+	report, err := verify(logger, params)
+	if err != nil {
+		return report, err
+	}
+	if report.FileSize >= 0 && report.FileSize != report.ExpectedFileSize {
+		// a file that was changed on purpose, like an archive that was appended to, looks damaged everywhere, so say why
+		logger.Error("file size does not match parity file", slog.Int64("fileSize", report.FileSize), slog.Int64("expectedFileSize", report.ExpectedFileSize))
+		return report, fmt.Errorf("%w: %w: the file is %d bytes but the parity file was created for %d bytes. If the file was changed on purpose create a new parity file",
+			ErrDamageFound, ErrFileSizeMismatch, report.FileSize, report.ExpectedFileSize)
+	}
+	if !report.OK {
+		logger.Error("damage found", slog.Int("numDamagedDataBlocks", len(report.DamagedDataBlocks)), slog.Int("numDamagedParityBlocks", len(report.DamagedParityBlocks)), slog.Bool("repairable", report.Repairable))
+		return report, ErrDamageFound
+	}
+	logger.Info("file matches parity file", slog.String("filePath", report.FilePath))
+	return report, nil
+}
+
+func verify(logger *slog.Logger, params VerifyParams) (VerifyReport, error) {
+	report := VerifyReport{
+		FilePath:            params.FilePath,
+		ParityPath:          params.ParityPath,
+		DamagedDataBlocks:   make([]int, 0),
+		DamagedParityBlocks: make([]int, 0),
+	}
+	if len(report.ParityPath) == 0 {
+		report.ParityPath = DefaultParityPath(params.FilePath)
+	}
+	pf, err := os.Open(report.ParityPath)
+	if err != nil {
+		logger.Error("failed to open parity file", slog.String("parityPath", report.ParityPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	defer pf.Close()
+	p, err := openParityFile(pf)
+	if err != nil {
+		logger.Error("failed to read parity file", slog.String("parityPath", report.ParityPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	report.ExpectedFileSize = p.header.FileSize
+	report.FileSHA256 = p.header.FileSHA256
+	report.BlockSize = p.header.BlockSize
+	report.NumDataBlocks = p.header.NumDataBlocks()
+	report.NumParityBlocks = p.header.NumParityBlocks()
+	f, err := os.Open(params.FilePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Error("failed to open file", slog.String("filePath", params.FilePath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	buf := make([]byte, p.header.BlockSize)
+	if f == nil {
+		// a missing file is damaged everywhere, which may still be repairable with 100% redundancy
+		logger.Warn("file not found", slog.String("filePath", params.FilePath))
+		report.FileSize = -1
+		for block := 0; block < report.NumDataBlocks; block++ {
+			report.DamagedDataBlocks = append(report.DamagedDataBlocks, block)
+		}
+	} else {
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return report, err
+		}
+		report.FileSize = info.Size()
+		for block := 0; block < report.NumDataBlocks; block++ {
+			if err := readDataBlock(f, p.header, block, buf); err != nil {
+				logger.Warn("failed to read file block", slog.Int("block", block), slog.String("errorMessage", err.Error()))
+				report.DamagedDataBlocks = append(report.DamagedDataBlocks, block)
+				continue
+			}
+			if !bytes.Equal(blockHash(buf), p.dataHashes[block]) {
+				logger.Debug("file block does not match its hash", slog.Int("block", block))
+				report.DamagedDataBlocks = append(report.DamagedDataBlocks, block)
+			}
+		}
+	}
+	for block := 0; block < report.NumParityBlocks; block++ {
+		if err := p.readParityBlock(block, buf); err != nil {
+			logger.Warn("failed to read parity block", slog.Int("block", block), slog.String("errorMessage", err.Error()))
+			report.DamagedParityBlocks = append(report.DamagedParityBlocks, block)
+			continue
+		}
+		if !bytes.Equal(blockHash(buf), p.parityHashes[block]) {
+			logger.Debug("parity block does not match its hash", slog.Int("block", block))
+			report.DamagedParityBlocks = append(report.DamagedParityBlocks, block)
+		}
+	}
+	report.Repairable = true
+	for _, numDamaged := range damagedPerStripe(p.header, report) {
+		if numDamaged > p.header.ParityShards {
+			report.Repairable = false
+		}
+	}
+	report.OK = report.FileSize == report.ExpectedFileSize && len(report.DamagedDataBlocks) == 0 && len(report.DamagedParityBlocks) == 0
+	return report, nil
+}
+
+// damagedPerStripe returns the number of damaged data and parity blocks of each stripe that has any.
+func damagedPerStripe(header Header, report VerifyReport) map[int]int {
+	damaged := make(map[int]int)
+	for _, block := range report.DamagedDataBlocks {
+		damaged[block%header.NumStripes]++
+	}
+	for _, block := range report.DamagedParityBlocks {
+		damaged[block/header.ParityShards]++
+	}
+	return damaged
+}
+
+type RepairParams struct {
+	VerifyParams
+}
+
+type RepairReport struct {
+	// Found is what was found damaged before the repair.
+	Found                VerifyReport `json:"found"`
+	RepairedDataBlocks   int          `json:"repairedDataBlocks"`
+	RepairedParityBlocks int          `json:"repairedParityBlocks"`
+	// Repaired is true if the file and parity file are intact after the repair, including when nothing needed repairing.
+	Repaired bool `json:"repaired"`
+}
+
+// Repair rebuilds the damaged blocks of the file and its parity file in place and fixes the file size. It fails with ErrUnrepairable,
+// without changing anything, if a stripe has more damaged blocks than it has parity blocks.
+func Repair(logger *slog.Logger, params RepairParams) (RepairReport, error) {
+	report := RepairReport{}
+	found, err := verify(logger, params.VerifyParams)
+	report.Found = found
+	if err != nil {
+		return report, err
+	}
+	if found.OK {
+		logger.Info("nothing to repair", slog.String("filePath", found.FilePath))
+		report.Repaired = true
+		return report, nil
+	}
+	if !found.Repairable {
+		logger.Error("too many damaged blocks to repair", slog.Int("numDamagedDataBlocks", len(found.DamagedDataBlocks)), slog.Int("numDamagedParityBlocks", len(found.DamagedParityBlocks)))
+		return report, ErrUnrepairable
+	}
+	pf, err := os.OpenFile(found.ParityPath, os.O_RDWR, 0)
+	if err != nil {
+		logger.Error("failed to open parity file", slog.String("parityPath", found.ParityPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	defer pf.Close()
+	p, err := openParityFile(pf)
+	if err != nil {
+		return report, err
+	}
+	f, err := os.OpenFile(found.FilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logger.Error("failed to open file", slog.String("filePath", found.FilePath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	defer f.Close()
+	if err := repairStripes(logger, p, f, pf, found, &report); err != nil {
+		return report, err
+	}
+	if err := f.Truncate(p.header.FileSize); err != nil {
+		logger.Error("failed to set file size", slog.String("filePath", found.FilePath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	if err := f.Sync(); err != nil {
+		return report, err
+	}
+	if err := pf.Sync(); err != nil {
+		return report, err
+	}
+	after, err := verify(logger, params.VerifyParams)
+	if err != nil {
+		return report, err
+	}
+	if !after.OK {
+		logger.Error("file still damaged after repair", slog.Int("numDamagedDataBlocks", len(after.DamagedDataBlocks)), slog.Int("numDamagedParityBlocks", len(after.DamagedParityBlocks)))
+		return report, ErrRepairVerifyFailed
+	}
+	report.Repaired = true
+	logger.Info("repair complete", slog.Int("repairedDataBlocks", report.RepairedDataBlocks), slog.Int("repairedParityBlocks", report.RepairedParityBlocks))
+	return report, nil
+}
+
+// repairStripes reconstructs every stripe with a damaged block and writes the rebuilt blocks back.
+func repairStripes(logger *slog.Logger, p *parityFile, f, pf *os.File, found VerifyReport, report *RepairReport) error {
+	header := p.header
+	damagedData := make(map[int]struct{}, len(found.DamagedDataBlocks))
+	for _, block := range found.DamagedDataBlocks {
+		damagedData[block] = struct{}{}
+	}
+	damagedParity := make(map[int]struct{}, len(found.DamagedParityBlocks))
+	for _, block := range found.DamagedParityBlocks {
+		damagedParity[block] = struct{}{}
+	}
+	enc, err := newEncoder(header.DataShards, header.ParityShards)
+	if err != nil {
+		return err
+	}
+	shards := makeShards(header.DataShards+header.ParityShards, header.BlockSize)
+	intact := make([]bool, len(shards))
+	for stripe := range damagedPerStripe(header, found) {
+		for shard := 0; shard < header.DataShards; shard++ {
+			block := header.dataBlock(stripe, shard)
+			_, isDamaged := damagedData[block]
+			intact[shard] = !isDamaged
+			if isDamaged {
+				continue
+			}
+			if err := readDataBlock(f, header, block, shards[shard]); err != nil {
+				return fmt.Errorf("failed to read file block %d: %w", block, err)
+			}
+		}
+		for i := 0; i < header.ParityShards; i++ {
+			block := stripe*header.ParityShards + i
+			_, isDamaged := damagedParity[block]
+			intact[header.DataShards+i] = !isDamaged
+			if isDamaged {
+				continue
+			}
+			if err := p.readParityBlock(block, shards[header.DataShards+i]); err != nil {
+				return fmt.Errorf("failed to read parity block %d: %w", block, err)
+			}
+		}
+		if err := enc.reconstruct(shards, intact); err != nil {
+			logger.Error("failed to reconstruct stripe", slog.Int("stripe", stripe), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		for shard := 0; shard < header.DataShards; shard++ {
+			block := header.dataBlock(stripe, shard)
+			if intact[shard] || block >= header.NumDataBlocks() {
+				continue
+			}
+			offset := int64(block) * int64(header.BlockSize)
+			size := min(int64(header.BlockSize), header.FileSize-offset)
+			if _, err := f.WriteAt(shards[shard][:size], offset); err != nil {
+				logger.Error("failed to write repaired file block", slog.Int("block", block), slog.String("errorMessage", err.Error()))
+				return err
+			}
+			report.RepairedDataBlocks++
+		}
+		for i := 0; i < header.ParityShards; i++ {
+			if intact[header.DataShards+i] {
+				continue
+			}
+			block := stripe*header.ParityShards + i
+			if _, err := pf.WriteAt(shards[header.DataShards+i], p.parityBlockOffset(block)); err != nil {
+				logger.Error("failed to write repaired parity block", slog.Int("block", block), slog.String("errorMessage", err.Error()))
+				return err
+			}
+			report.RepairedParityBlocks++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileType is a kind of file stored in a repository backend.
+type FileType string
+
+const (
+	ConfigFile   FileType = "config"
+	ChunkFile    FileType = "chunks"
+	SnapshotFile FileType = "snapshots"
+	LockFile     FileType = "locks"
+
+	// tempFilePrefix marks files that are still being written, so a crashed write is never mistaken for a complete file.
+	tempFilePrefix = ".tmp-"
+)
+
+var (
+	ErrFileNotFound = errors.New("repository file not found")
+)
+
+// Backend stores the files of a repository. Files are never modified once saved, only added and removed.
+type Backend interface {
+	// Save stores the data under the name. Saving a name that already exists replaces it.
+	Save(t FileType, name string, data []byte) error
+	// Load returns the data stored under the name, or an error wrapping ErrFileNotFound.
+	Load(t FileType, name string) ([]byte, error)
+	// Stat returns the size of the data stored under the name, or an error wrapping ErrFileNotFound.
+	Stat(t FileType, name string) (int64, error)
+	// List returns the names of all files of the type.
+	List(t FileType) ([]string, error)
+	// Remove deletes the file stored under the name.
+	Remove(t FileType, name string) error
+}
+
+// LocalBackend stores a repository in a directory on the local file system.
+// Chunks are spread over sub directories named after the first two characters of their names to keep directories small.
+type LocalBackend struct {
+	Root string
+}
+
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{
+		Root: root,
+	}
+}
+
+func (b *LocalBackend) filePath(t FileType, name string) string {
+	switch t {
+	case ConfigFile:
+		return filepath.Join(b.Root, string(ConfigFile))
+	case ChunkFile:
+		return filepath.Join(b.Root, string(t), name[:2], name)
+	}
+	return filepath.Join(b.Root, string(t), name)
+}
+
+// Save writes the data to a temporary file that is renamed into place, so readers never see a partially written file.
+func (b *LocalBackend) Save(t FileType, name string, data []byte) error {
+	if t == ChunkFile && len(name) < 2 {
+		return fmt.Errorf("invalid chunk name: %s", name)
+	}
+	target := b.filePath(t, name)
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (b *LocalBackend) Load(t FileType, name string) ([]byte, error) {
+	data, err := os.ReadFile(b.filePath(t, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrFileNotFound, t, name)
+	}
+	return data, err
+}
+
+func (b *LocalBackend) Stat(t FileType, name string) (int64, error) {
+	info, err := os.Stat(b.filePath(t, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("%w: %s/%s", ErrFileNotFound, t, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (b *LocalBackend) List(t FileType) ([]string, error) {
+	names := make([]string, 0)
+	dir := filepath.Join(b.Root, string(t))
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		names = append(names, d.Name())
+		return nil
+	})
+	return names, err
+}
+
+func (b *LocalBackend) Remove(t FileType, name string) error {
+	err := os.Remove(b.filePath(t, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s/%s", ErrFileNotFound, t, name)
+	}
+	return err
+}
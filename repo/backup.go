@@ -0,0 +1,236 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+var (
+	ErrDuplicateInputName = errors.New("input paths have the same base name")
+)
+
+type BackupParams struct {
+	OpenParams
+	InputPaths     []string
+	ExcludeOptions archivepath.ExcludeOptions
+	Tags           []string
+	// Hostname if empty the hostname of the machine is used.
+	Hostname string
+}
+
+// BackupReport describes a backup and how much it added to the repository.
+type BackupReport struct {
+	SnapshotID string `json:"snapshotId"`
+	ParentID   string `json:"parentId,omitempty"`
+	NumFiles   int    `json:"numFiles"`
+	NumDirs    int    `json:"numDirs"`
+	// NumUnchangedFiles were the same size and modification time as in the parent snapshot, so they were not read.
+	NumUnchangedFiles int   `json:"numUnchangedFiles"`
+	TotalSize         int64 `json:"totalSize"`
+	NumChunks         int   `json:"numChunks"`
+	NumNewChunks      int   `json:"numNewChunks"`
+	// NewSize is the size of the new chunks before compression and encryption, StoredSize is what they take up in the repository.
+	NewSize    int64 `json:"newSize"`
+	StoredSize int64 `json:"storedSize"`
+}
+
+// Backup stores the input paths in the repository as a new snapshot. Files are split into content defined chunks and only chunks the repository
+// does not already have are stored. Each input path is stored under its base name. Only regular files and directories are backed up.
+// Backup takes a shared lock, so backups can run together but fail with ErrRepositoryLocked while a prune runs.
+func Backup(logger *slog.Logger, params BackupParams) (BackupReport, error) {
+	report := BackupReport{}
+	r, err := open(logger, params.OpenParams)
+	if err != nil {
+		return report, err
+	}
+	if len(params.InputPaths) == 0 {
+		errMsg := "no input paths provided for backup"
+		logger.Error(errMsg)
+		return report, errors.New(errMsg)
+	}
+	unlock, err := r.lock(logger, false)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	snapshot := Snapshot{
+		Time:     time.Now().UTC(),
+		Hostname: params.Hostname,
+		Tags:     params.Tags,
+	}
+	if len(snapshot.Hostname) == 0 {
+		if snapshot.Hostname, err = os.Hostname(); err != nil {
+			logger.Warn("failed to get hostname", slog.String("errorMessage", err.Error()))
+		}
+	}
+	entries, err := collectBackupEntries(logger, params, &snapshot)
+	if err != nil {
+		return report, err
+	}
+	knownChunks, err := r.listChunks()
+	if err != nil {
+		logger.Error("failed to list repository chunks", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	parentNodes, err := r.findParentNodes(logger, &snapshot)
+	if err != nil {
+		return report, err
+	}
+	report.ParentID = snapshot.ParentID
+	for _, entry := range entries {
+		node := Node{
+			Name:    filepath.ToSlash(entry.Name),
+			Mode:    entry.Info.Mode(),
+			ModTime: entry.Info.ModTime().UTC(),
+		}
+		if entry.Info.IsDir() {
+			node.Type = NodeTypeDirectory
+			snapshot.NumDirs++
+			snapshot.Nodes = append(snapshot.Nodes, node)
+			continue
+		}
+		node.Type = NodeTypeFile
+		node.Size = entry.Info.Size()
+		if parent, ok := parentNodes[node.Name]; ok && isUnchanged(parent, node, knownChunks) {
+			logger.Debug("file unchanged since parent snapshot", slog.String("path", entry.Path))
+			node.Chunks = parent.Chunks
+			report.NumUnchangedFiles++
+		} else if node.Chunks, err = r.backupFile(logger, entry.Path, knownChunks, &report); err != nil {
+			logger.Error("failed to back up file", slog.String("path", entry.Path), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+		report.NumChunks += len(node.Chunks)
+		snapshot.NumFiles++
+		snapshot.TotalSize += node.Size
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+	// the snapshot is saved last so it never references a chunk that was not stored
+	if err := r.saveSnapshot(logger, &snapshot); err != nil {
+		logger.Error("failed to save snapshot", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	report.SnapshotID = snapshot.ID
+	report.NumFiles = snapshot.NumFiles
+	report.NumDirs = snapshot.NumDirs
+	report.TotalSize = snapshot.TotalSize
+	logger.Info("backup complete", slog.Any("report", report))
+	return report, nil
+}
+
+// collectBackupEntries returns the entries of every input path named under the base name of the input path, and sets the snapshot paths.
+func collectBackupEntries(logger *slog.Logger, params BackupParams, snapshot *Snapshot) ([]archivepath.Entry, error) {
+	entries := make([]archivepath.Entry, 0)
+	baseNames := make(map[string]string, len(params.InputPaths))
+	for _, ip := range params.InputPaths {
+		absPath, err := filepath.Abs(ip)
+		if err != nil {
+			return nil, err
+		}
+		baseName := filepath.Base(absPath)
+		if other, ok := baseNames[baseName]; ok {
+			logger.Error("input paths would be stored under the same name", slog.String("path", absPath), slog.String("otherPath", other))
+			return nil, fmt.Errorf("%w: %s and %s", ErrDuplicateInputName, other, absPath)
+		}
+		baseNames[baseName] = absPath
+		snapshot.Paths = append(snapshot.Paths, absPath)
+		info, err := os.Stat(absPath)
+		if err != nil {
+			logger.Error("failed to stat input path", slog.String("path", absPath), slog.String("errorMessage", err.Error()))
+			return nil, err
+		}
+		pathEntries, err := archivepath.CollectEntries(logger, []string{absPath}, params.ExcludeOptions)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			entries = append(entries, pathEntries...)
+			continue
+		}
+		entries = append(entries, archivepath.Entry{Path: absPath, Name: baseName, Info: info})
+		for _, e := range pathEntries {
+			e.Name = filepath.Join(baseName, e.Name)
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// findParentNodes finds the newest snapshot of the same paths and host, sets it as the parent and returns its file nodes by name.
+func (r *repository) findParentNodes(logger *slog.Logger, snapshot *Snapshot) (map[string]Node, error) {
+	nodes := make(map[string]Node)
+	snapshots, err := r.loadSnapshots(logger)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].sameSource(*snapshot) {
+			continue
+		}
+		snapshot.ParentID = snapshots[i].ID
+		for _, n := range snapshots[i].Nodes {
+			if n.Type == NodeTypeFile {
+				nodes[n.Name] = n
+			}
+		}
+		logger.Debug("found parent snapshot", slog.String("parentId", snapshot.ParentID), slog.Int("numFiles", len(nodes)))
+		break
+	}
+	return nodes, nil
+}
+
+// isUnchanged returns true if the file has the same size and modification time as in the parent, and all of the parent chunks are still stored.
+func isUnchanged(parent, node Node, knownChunks map[string]struct{}) bool {
+	if parent.Size != node.Size || !parent.ModTime.Equal(node.ModTime) {
+		return false
+	}
+	for _, id := range parent.Chunks {
+		if _, ok := knownChunks[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// backupFile splits the file into chunks, stores the ones the repository does not have and returns the ids of all of them.
+func (r *repository) backupFile(logger *slog.Logger, path string, knownChunks map[string]struct{}, report *BackupReport) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunker, err := NewChunker(f, r.config.Chunker)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := r.chunkID(chunk)
+		ids = append(ids, id)
+		if _, ok := knownChunks[id]; ok {
+			continue
+		}
+		stored, err := r.saveChunk(logger, id, chunk)
+		if err != nil {
+			return nil, err
+		}
+		knownChunks[id] = struct{}{}
+		report.NumNewChunks++
+		report.NewSize += int64(len(chunk))
+		report.StoredSize += stored
+	}
+	return ids, nil
+}
@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+)
+
+var (
+	ErrCheckFailed = errors.New("repository check found problems")
+)
+
+type CheckParams struct {
+	OpenParams
+	// ReadData if true every chunk is read, decoded and checked against its id instead of only checking it exists.
+	ReadData bool
+}
+
+type CheckReport struct {
+	OK           bool `json:"ok"`
+	NumSnapshots int  `json:"numSnapshots"`
+	NumChunks    int  `json:"numChunks"`
+	// DamagedSnapshots are snapshots that could not be read.
+	DamagedSnapshots []string `json:"damagedSnapshots"`
+	// MissingChunks are referenced by a snapshot but not stored.
+	MissingChunks []string `json:"missingChunks"`
+	// DamagedChunks could not be decoded or do not match their id. Only set when data is read.
+	DamagedChunks []string `json:"damagedChunks"`
+	// NumUnreferencedChunks are stored but not used by any snapshot, prune removes them.
+	NumUnreferencedChunks int `json:"numUnreferencedChunks"`
+}
+
+// Check makes sure every snapshot can be read and every chunk they reference is stored, and with ReadData that every chunk is intact.
+// The report is returned with ErrCheckFailed if a problem is found. Check takes a shared lock, so a prune running between listing the chunks
+// and reading the snapshots is not reported as damage.
+func Check(logger *slog.Logger, params CheckParams) (CheckReport, error) {
+	report := CheckReport{
+		DamagedSnapshots: make([]string, 0),
+		MissingChunks:    make([]string, 0),
+		DamagedChunks:    make([]string, 0),
+	}
+	r, err := open(logger, params.OpenParams)
+	if err != nil {
+		return report, err
+	}
+	unlock, err := r.lock(logger, false)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	chunks, err := r.listChunks()
+	if err != nil {
+		logger.Error("failed to list repository chunks", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	report.NumChunks = len(chunks)
+	snapshotIDs, err := r.backend.List(SnapshotFile)
+	if err != nil {
+		logger.Error("failed to list repository snapshots", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	slices.Sort(snapshotIDs)
+	report.NumSnapshots = len(snapshotIDs)
+	referenced := make(map[string]struct{})
+	missing := make(map[string]struct{})
+	for _, id := range snapshotIDs {
+		snapshot, err := r.loadSnapshot(logger, id)
+		if err != nil {
+			logger.Error("failed to read snapshot", slog.String("snapshotId", id), slog.String("errorMessage", err.Error()))
+			report.DamagedSnapshots = append(report.DamagedSnapshots, id)
+			continue
+		}
+		for _, node := range snapshot.Nodes {
+			for _, chunkID := range node.Chunks {
+				referenced[chunkID] = struct{}{}
+				if _, ok := chunks[chunkID]; ok {
+					continue
+				}
+				if _, ok := missing[chunkID]; !ok {
+					logger.Error("snapshot references missing chunk", slog.String("snapshotId", id), slog.String("chunkId", chunkID))
+					missing[chunkID] = struct{}{}
+					report.MissingChunks = append(report.MissingChunks, chunkID)
+				}
+			}
+		}
+	}
+	chunkIDs := make([]string, 0, len(chunks))
+	for id := range chunks {
+		chunkIDs = append(chunkIDs, id)
+	}
+	slices.Sort(chunkIDs)
+	for _, id := range chunkIDs {
+		if _, ok := referenced[id]; !ok {
+			report.NumUnreferencedChunks++
+		}
+		if !params.ReadData {
+			continue
+		}
+		if _, err := r.loadChunk(logger, id); err != nil {
+			logger.Error("chunk is damaged", slog.String("chunkId", id), slog.String("errorMessage", err.Error()))
+			report.DamagedChunks = append(report.DamagedChunks, id)
+		}
+	}
+	report.OK = len(report.DamagedSnapshots) == 0 && len(report.MissingChunks) == 0 && len(report.DamagedChunks) == 0
+	if !report.OK {
+		return report, ErrCheckFailed
+	}
+	logger.Info("repository check passed", slog.Any("report", report))
+	return report, nil
+}
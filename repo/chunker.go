@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	DefaultMinChunkSize = 512 * 1024
+	DefaultAvgChunkSize = 1024 * 1024
+	DefaultMaxChunkSize = 8 * 1024 * 1024
+)
+
+var (
+	ErrInvalidChunkerParams = errors.New("invalid chunker params, sizes must be 0 < min < avg < max and avg must be a power of 2")
+)
+
+// ChunkerParams are the sizes content defined chunks are cut at. They are saved in the repository config because changing them stops new chunks matching old ones.
+type ChunkerParams struct {
+	MinSize int `json:"minSize"`
+	AvgSize int `json:"avgSize"`
+	MaxSize int `json:"maxSize"`
+}
+
+// DefaultChunkerParams returns chunk sizes of 512KB to 8MB averaging 1MB.
+func DefaultChunkerParams() ChunkerParams {
+	return ChunkerParams{
+		MinSize: DefaultMinChunkSize,
+		AvgSize: DefaultAvgChunkSize,
+		MaxSize: DefaultMaxChunkSize,
+	}
+}
+
+func (p ChunkerParams) validate() error {
+	if p.MinSize <= 0 || p.MinSize >= p.AvgSize || p.AvgSize >= p.MaxSize || bits.OnesCount(uint(p.AvgSize)) != 1 {
+		return ErrInvalidChunkerParams
+	}
+	return nil
+}
+
+// gearTable maps each byte to a random 64 bit value for the gear rolling hash. It is generated from a fixed seed so chunk boundaries never change.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64
+	state := uint64(0x66696c656a697473) // "filejits"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content defined chunks with FastCDC. Since boundaries depend on the content around them,
+// an insert or delete only changes the chunks near it and the rest of the stream still produces the same chunks.
+type Chunker struct {
+	r      io.Reader
+	params ChunkerParams
+	// maskS is harder to match and is used before the average size, maskL is easier and is used after, which keeps chunk sizes close to the average.
+	maskS uint64
+	maskL uint64
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func NewChunker(r io.Reader, params ChunkerParams) (*Chunker, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	avgBits := bits.TrailingZeros(uint(params.AvgSize))
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  highBitsMask(avgBits + 1),
+		maskL:  highBitsMask(avgBits - 1),
+		buf:    make([]byte, 2*params.MaxSize),
+	}, nil
+}
+
+// highBitsMask returns a mask of the n highest bits. The gear hash shifts left, so its high bits depend on the most bytes.
+func highBitsMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted. The chunk is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill moves the unread data to the front of the buffer and reads until at least a max size chunk is buffered or the stream ends.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.params.MaxSize {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := min(len(data), c.params.MaxSize)
+	if n <= c.params.MinSize {
+		return n
+	}
+	normalSize := min(n, c.params.AvgSize)
+	var fp uint64
+	i := c.params.MinSize
+	for ; i < normalSize; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
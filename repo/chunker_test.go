@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func testChunkerParams() ChunkerParams {
+	return ChunkerParams{
+		MinSize: 1024,
+		AvgSize: 4096,
+		MaxSize: 16384,
+	}
+}
+
+func chunkAll(t *testing.T, data []byte, params ChunkerParams) [][]byte {
+	t.Helper()
+	chunker, err := NewChunker(bytes.NewReader(data), params)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := make([][]byte, 0)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("failed to read chunk: %v", err)
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+func TestChunkerSizesAndContent(t *testing.T) {
+	params := testChunkerParams()
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	chunks := chunkAll(t, data, params)
+	if len(chunks) < 2 {
+		t.Fatalf("expected data to be split into several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > params.MaxSize {
+			t.Errorf("chunk %d is larger than the max size: %d", i, len(chunk))
+		}
+		if i < len(chunks)-1 && len(chunk) < params.MinSize {
+			t.Errorf("chunk %d is smaller than the min size: %d", i, len(chunk))
+		}
+	}
+	if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+		t.Errorf("chunks do not join back into the input")
+	}
+}
+
+func TestChunkerBoundariesSurviveInsert(t *testing.T) {
+	params := testChunkerParams()
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	original := chunkAll(t, data, params)
+	modified := make([]byte, 0, len(data)+10)
+	modified = append(modified, data[:1000]...)
+	modified = append(modified, []byte("0123456789")...)
+	modified = append(modified, data[1000:]...)
+	shifted := chunkAll(t, modified, params)
+	known := make(map[string]struct{}, len(original))
+	for _, chunk := range original {
+		known[string(chunk)] = struct{}{}
+	}
+	numShared := 0
+	for _, chunk := range shifted {
+		if _, ok := known[string(chunk)]; ok {
+			numShared++
+		}
+	}
+	// only the chunks around the insert should change
+	if numShared < len(original)-2 {
+		t.Errorf("expected all but the first chunks to be shared after an insert, shared %d of %d", numShared, len(original))
+	}
+}
+
+func TestChunkerInvalidParams(t *testing.T) {
+	testCases := []ChunkerParams{
+		{},
+		{MinSize: 1024, AvgSize: 3000, MaxSize: 16384},
+		{MinSize: 4096, AvgSize: 4096, MaxSize: 16384},
+		{MinSize: 1024, AvgSize: 4096, MaxSize: 4096},
+	}
+	for _, params := range testCases {
+		if _, err := NewChunker(bytes.NewReader(nil), params); !errors.Is(err, ErrInvalidChunkerParams) {
+			t.Errorf("expected ErrInvalidChunkerParams for %+v, got %v", params, err)
+		}
+	}
+}
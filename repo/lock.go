@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRepositoryLocked = errors.New("the repository is locked")
+)
+
+// Lock is stored in the locks directory of a repository while an operation runs. Backup, restore and check take a shared lock, so they can
+// run together, and prune takes an exclusive lock, so it never removes snapshots or chunks another operation is using.
+type Lock struct {
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Time      time.Time `json:"time"`
+}
+
+// lock saves a lock file and returns a func that removes it. It fails with ErrRepositoryLocked if a lock that conflicts with it exists.
+// Locks are checked again after saving, so of two operations that lock at the same time at least one fails.
+func (r *repository) lock(logger *slog.Logger, exclusive bool) (func(), error) {
+	if err := r.checkLocks(logger, exclusive, ""); err != nil {
+		return nil, err
+	}
+	l := Lock{
+		Exclusive: exclusive,
+		PID:       os.Getpid(),
+		Time:      time.Now().UTC(),
+	}
+	var err error
+	if l.Hostname, err = os.Hostname(); err != nil {
+		logger.Warn("failed to get hostname", slog.String("errorMessage", err.Error()))
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	id := uuid.New().String()
+	if err := r.backend.Save(LockFile, id, data); err != nil {
+		logger.Error("failed to save repository lock", slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	unlock := func() {
+		if err := r.backend.Remove(LockFile, id); err != nil {
+			logger.Warn("failed to remove repository lock", slog.String("lockId", id), slog.String("errorMessage", err.Error()))
+		}
+	}
+	if err := r.checkLocks(logger, exclusive, id); err != nil {
+		unlock()
+		return nil, err
+	}
+	logger.Debug("locked repository", slog.String("lockId", id), slog.Bool("exclusive", exclusive))
+	return unlock, nil
+}
+
+// checkLocks returns ErrRepositoryLocked if a lock other than ownID conflicts with taking a lock. Every lock conflicts with an exclusive lock.
+func (r *repository) checkLocks(logger *slog.Logger, exclusive bool, ownID string) error {
+	ids, err := r.backend.List(LockFile)
+	if err != nil {
+		logger.Error("failed to list repository locks", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	for _, id := range ids {
+		if id == ownID {
+			continue
+		}
+		data, err := r.backend.Load(LockFile, id)
+		if errors.Is(err, ErrFileNotFound) {
+			// the lock was removed since it was listed
+			continue
+		}
+		if err != nil {
+			logger.Error("failed to read repository lock", slog.String("lockId", id), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		l := Lock{}
+		if err := json.Unmarshal(data, &l); err != nil {
+			// a lock that can not be read is treated as exclusive, so it is never ignored
+			logger.Warn("failed to parse repository lock", slog.String("lockId", id), slog.String("errorMessage", err.Error()))
+			l.Exclusive = true
+		}
+		if exclusive || l.Exclusive {
+			logger.Error("repository is locked", slog.String("lockId", id), slog.Any("lock", l))
+			return fmt.Errorf("%w: lock %s held by %s pid %d since %s", ErrRepositoryLocked, id, l.Hostname, l.PID, l.Time.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+)
+
+type PruneParams struct {
+	OpenParams
+	// KeepLast if greater than zero only the newest KeepLast snapshots of each host and set of paths are kept.
+	KeepLast int
+	// Forget are ids or unique id prefixes of snapshots to remove.
+	Forget []string
+	// DryRun if true nothing is removed, the report only says what would be.
+	DryRun bool
+}
+
+type PruneReport struct {
+	RemovedSnapshots []string `json:"removedSnapshots"`
+	KeptSnapshots    []string `json:"keptSnapshots"`
+	RemovedChunks    int      `json:"removedChunks"`
+	// RemovedBytes is the stored size of the removed chunks.
+	RemovedBytes int64 `json:"removedBytes"`
+	DryRun       bool  `json:"dryRun"`
+}
+
+// Prune removes the selected snapshots and then every chunk no remaining snapshot references.
+// Snapshots are removed before chunks, so an interrupted prune only leaves unreferenced chunks behind for the next prune.
+// Prune takes an exclusive lock and fails with ErrRepositoryLocked while a backup or another prune runs.
+func Prune(logger *slog.Logger, params PruneParams) (PruneReport, error) {
+	report := PruneReport{
+		RemovedSnapshots: make([]string, 0),
+		KeptSnapshots:    make([]string, 0),
+		DryRun:           params.DryRun,
+	}
+	if params.KeepLast < 0 {
+		errMsg := "keep last must not be negative"
+		logger.Error(errMsg)
+		return report, errors.New(errMsg)
+	}
+	r, err := open(logger, params.OpenParams)
+	if err != nil {
+		return report, err
+	}
+	// a dry run removes nothing, so it does not need to wait for backups
+	if !params.DryRun {
+		unlock, err := r.lock(logger, true)
+		if err != nil {
+			return report, err
+		}
+		defer unlock()
+	}
+	snapshots, err := r.loadSnapshots(logger)
+	if err != nil {
+		return report, err
+	}
+	remove := make(map[string]struct{})
+	for _, id := range params.Forget {
+		snapshot, err := r.findSnapshot(logger, id)
+		if err != nil {
+			logger.Error("failed to find snapshot to forget", slog.String("snapshotId", id), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+		remove[snapshot.ID] = struct{}{}
+	}
+	if params.KeepLast > 0 {
+		// snapshots are oldest first, so counting from the end keeps the newest of each group
+		kept := make([]Snapshot, 0)
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			numKept := 0
+			for _, k := range kept {
+				if k.sameSource(snapshots[i]) {
+					numKept++
+				}
+			}
+			if numKept >= params.KeepLast {
+				remove[snapshots[i].ID] = struct{}{}
+				continue
+			}
+			kept = append(kept, snapshots[i])
+		}
+	}
+	referenced := make(map[string]struct{})
+	for _, snapshot := range snapshots {
+		if _, ok := remove[snapshot.ID]; ok {
+			report.RemovedSnapshots = append(report.RemovedSnapshots, snapshot.ID)
+			continue
+		}
+		report.KeptSnapshots = append(report.KeptSnapshots, snapshot.ID)
+		for _, node := range snapshot.Nodes {
+			for _, id := range node.Chunks {
+				referenced[id] = struct{}{}
+			}
+		}
+	}
+	chunks, err := r.backend.List(ChunkFile)
+	if err != nil {
+		logger.Error("failed to list repository chunks", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	slices.Sort(chunks)
+	unreferenced := make([]string, 0)
+	for _, id := range chunks {
+		if _, ok := referenced[id]; ok {
+			continue
+		}
+		size, err := r.backend.Stat(ChunkFile, id)
+		if err != nil {
+			return report, err
+		}
+		unreferenced = append(unreferenced, id)
+		report.RemovedChunks++
+		report.RemovedBytes += size
+	}
+	if params.DryRun {
+		logger.Info("prune dry run complete", slog.Any("report", report))
+		return report, nil
+	}
+	for _, id := range report.RemovedSnapshots {
+		if err := r.backend.Remove(SnapshotFile, id); err != nil {
+			logger.Error("failed to remove snapshot", slog.String("snapshotId", id), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+	}
+	for _, id := range unreferenced {
+		if err := r.backend.Remove(ChunkFile, id); err != nil {
+			logger.Error("failed to remove chunk", slog.String("chunkId", id), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+	}
+	logger.Info("prune complete", slog.Any("report", report))
+	return report, nil
+}
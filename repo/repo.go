@@ -0,0 +1,278 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/calvine/filejitsu/archive"
+	"github.com/calvine/filejitsu/encrypt"
+	fgzip "github.com/calvine/filejitsu/gzip"
+	"github.com/google/uuid"
+)
+
+const (
+	// RepositoryVersion is the version of the repository layout written by Init.
+	RepositoryVersion = 1
+
+	passphraseCheckMessage = "filejitsu repository passphrase check"
+)
+
+var (
+	ErrRepositoryExists    = errors.New("a repository already exists at the path")
+	ErrNotRepository       = errors.New("no repository config found at the path")
+	ErrUnsupportedVersion  = errors.New("unsupported repository version")
+	ErrUnknownCompression  = errors.New("unknown repository compression")
+	ErrPassphraseRequired  = errors.New("the repository is encrypted and requires a passphrase")
+	ErrWrongPassphrase     = errors.New("wrong passphrase for the repository")
+	ErrChunkHashMismatch   = errors.New("chunk contents do not match its id")
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+	ErrAmbiguousSnapshotID = errors.New("snapshot id prefix matches more than one snapshot")
+)
+
+// Config is stored unencrypted at the root of a repository and holds what is needed to read and write it.
+type Config struct {
+	Version          int                        `json:"version"`
+	ID               string                     `json:"id"`
+	CreatedAt        time.Time                  `json:"createdAt"`
+	Compression      string                     `json:"compression"`
+	CompressionLevel fgzip.GZipCompressionLevel `json:"compressionLevel"`
+	Encrypted        bool                       `json:"encrypted"`
+	// PassphraseCheck is a keyed hash that tells if a passphrase is the one the repository was created with, without storing anything it can be derived from.
+	PassphraseCheck string        `json:"passphraseCheck,omitempty"`
+	Chunker         ChunkerParams `json:"chunker"`
+}
+
+// OpenParams are what every repository operation needs to open the repository.
+type OpenParams struct {
+	Path string
+	// Passphrase is required for encrypted repositories and ignored otherwise.
+	Passphrase []byte
+}
+
+type InitParams struct {
+	OpenParams
+	// Compression is one of archive.CompressionNone, archive.CompressionGzip or archive.CompressionZstd.
+	Compression      string
+	CompressionLevel fgzip.GZipCompressionLevel
+	// Encrypt if true every chunk and snapshot is encrypted with the passphrase.
+	Encrypt bool
+	// Chunker if zero DefaultChunkerParams are used.
+	Chunker ChunkerParams
+}
+
+// repository is an opened repository.
+type repository struct {
+	config     Config
+	backend    Backend
+	passphrase []byte
+	// idKey if set chunk ids are keyed hashes, so an encrypted repository does not reveal if it holds known content.
+	idKey []byte
+}
+
+// Init creates a new empty repository at the path.
+func Init(logger *slog.Logger, params InitParams) (Config, error) {
+	backend := NewLocalBackend(params.Path)
+	if _, err := backend.Stat(ConfigFile, ""); err == nil {
+		logger.Error("refusing to overwrite existing repository", slog.String("path", params.Path))
+		return Config{}, ErrRepositoryExists
+	}
+	switch params.Compression {
+	case archive.CompressionNone, archive.CompressionGzip, archive.CompressionZstd:
+	default:
+		return Config{}, fmt.Errorf("%w: %s", ErrUnknownCompression, params.Compression)
+	}
+	chunker := params.Chunker
+	if chunker == (ChunkerParams{}) {
+		chunker = DefaultChunkerParams()
+	}
+	if err := chunker.validate(); err != nil {
+		return Config{}, err
+	}
+	config := Config{
+		Version:          RepositoryVersion,
+		ID:               uuid.New().String(),
+		CreatedAt:        time.Now().UTC(),
+		Compression:      params.Compression,
+		CompressionLevel: params.CompressionLevel,
+		Encrypted:        params.Encrypt,
+		Chunker:          chunker,
+	}
+	if config.Encrypted {
+		if len(params.Passphrase) == 0 {
+			return Config{}, ErrPassphraseRequired
+		}
+		config.PassphraseCheck = passphraseCheck(deriveIDKey(config.ID, params.Passphrase))
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return Config{}, err
+	}
+	for _, dir := range []FileType{ChunkFile, SnapshotFile, LockFile} {
+		if err := os.MkdirAll(filepath.Join(params.Path, string(dir)), 0755); err != nil {
+			logger.Error("failed to create repository directory", slog.String("dir", string(dir)), slog.String("errorMessage", err.Error()))
+			return Config{}, err
+		}
+	}
+	if err := backend.Save(ConfigFile, "", data); err != nil {
+		logger.Error("failed to save repository config", slog.String("errorMessage", err.Error()))
+		return Config{}, err
+	}
+	logger.Info("initialized repository", slog.String("path", params.Path), slog.String("id", config.ID), slog.Bool("encrypted", config.Encrypted))
+	return config, nil
+}
+
+// open reads the config of the repository and checks the passphrase.
+func open(logger *slog.Logger, params OpenParams) (*repository, error) {
+	backend := NewLocalBackend(params.Path)
+	data, err := backend.Load(ConfigFile, "")
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			logger.Error("no repository found", slog.String("path", params.Path))
+			return nil, fmt.Errorf("%w: %s", ErrNotRepository, params.Path)
+		}
+		logger.Error("failed to read repository config", slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		logger.Error("failed to parse repository config", slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	if config.Version != RepositoryVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, config.Version)
+	}
+	r := &repository{
+		config:  config,
+		backend: backend,
+	}
+	if config.Encrypted {
+		if len(params.Passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		idKey := deriveIDKey(config.ID, params.Passphrase)
+		if !hmac.Equal([]byte(passphraseCheck(idKey)), []byte(config.PassphraseCheck)) {
+			logger.Error("passphrase does not match the repository")
+			return nil, ErrWrongPassphrase
+		}
+		r.passphrase = params.Passphrase
+		r.idKey = idKey
+	}
+	logger.Debug("opened repository", slog.String("path", params.Path), slog.Any("config", config))
+	return r, nil
+}
+
+func deriveIDKey(repositoryID string, passphrase []byte) []byte {
+	mac := hmac.New(sha256.New, passphrase)
+	mac.Write([]byte("filejitsu repository chunk id " + repositoryID))
+	return mac.Sum(nil)
+}
+
+func passphraseCheck(idKey []byte) string {
+	mac := hmac.New(sha256.New, idKey)
+	mac.Write([]byte(passphraseCheckMessage))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chunkID returns the name a chunk with the data is stored under.
+func (r *repository) chunkID(data []byte) string {
+	if r.idKey != nil {
+		mac := hmac.New(sha256.New, r.idKey)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// encode compresses and then encrypts data as the repository is configured to.
+func (r *repository) encode(logger *slog.Logger, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if r.config.Encrypted {
+		cipherStream, err := encrypt.NewAESEncryptionWriter(logger, &buf, r.passphrase)
+		if err != nil {
+			return nil, err
+		}
+		out = cipherStream
+	}
+	compressedOut, err := archive.NewCompressionWriter(logger, out, r.config.Compression, r.config.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := compressedOut.Write(data); err != nil {
+		return nil, err
+	}
+	if err := compressedOut.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reverses encode.
+func (r *repository) decode(logger *slog.Logger, data []byte) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if r.config.Encrypted {
+		cipherStream, err := encrypt.NewAESDecryptionReader(logger, in, r.passphrase)
+		if err != nil {
+			return nil, err
+		}
+		in = cipherStream
+	}
+	decompressedIn, closeDecompression, err := archive.NewDecompressionReader(logger, in, r.config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDecompression()
+	return io.ReadAll(decompressedIn)
+}
+
+// saveChunk stores the chunk and returns the number of bytes stored.
+func (r *repository) saveChunk(logger *slog.Logger, id string, data []byte) (int64, error) {
+	encoded, err := r.encode(logger, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.backend.Save(ChunkFile, id, encoded); err != nil {
+		return 0, err
+	}
+	return int64(len(encoded)), nil
+}
+
+// loadChunk returns the contents of the chunk after checking they match its id.
+func (r *repository) loadChunk(logger *slog.Logger, id string) ([]byte, error) {
+	encoded, err := r.backend.Load(ChunkFile, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.decode(logger, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chunk %s: %w", id, err)
+	}
+	if r.chunkID(data) != id {
+		return nil, fmt.Errorf("%w: %s", ErrChunkHashMismatch, id)
+	}
+	return data, nil
+}
+
+// listChunks returns the set of chunk ids stored in the repository.
+func (r *repository) listChunks() (map[string]struct{}, error) {
+	names, err := r.backend.List(ChunkFile)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		chunks[name] = struct{}{}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/archive"
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	type testCase struct {
+		Name        string
+		Compression string
+		Encrypt     bool
+	}
+	testCases := []testCase{
+		{Name: "uncompressed", Compression: archive.CompressionNone},
+		{Name: "gzip", Compression: archive.CompressionGzip},
+		{Name: "zstd encrypted", Compression: archive.CompressionZstd, Encrypt: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			logger := mock.NewMockLogger()
+			inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+			if err != nil {
+				t.Fatalf("failed to create test dir tree: %v", err)
+			}
+			defer cleanup()
+			openParams := OpenParams{Path: filepath.Join(t.TempDir(), "repo")}
+			if tc.Encrypt {
+				openParams.Passphrase = []byte("test1")
+			}
+			if _, err := Init(logger, InitParams{OpenParams: openParams, Compression: tc.Compression, Encrypt: tc.Encrypt}); err != nil {
+				t.Fatalf("failed to init repository: %v", err)
+			}
+			report, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"})
+			if err != nil {
+				t.Fatalf("failed to back up: %v", err)
+			}
+			if report.NumFiles != len(content) {
+				t.Errorf("expected %d files backed up, got %d", len(content), report.NumFiles)
+			}
+			outputPath := t.TempDir()
+			if _, err := Restore(logger, RestoreParams{OpenParams: openParams, SnapshotID: LatestSnapshotID, OutputPath: outputPath}); err != nil {
+				t.Fatalf("failed to restore: %v", err)
+			}
+			if err := mock.ConfirmContentMapMatches(filepath.Join(outputPath, filepath.Base(inputPath)), content); err != nil {
+				t.Errorf("restored files do not match: %v", err)
+			}
+			if _, err := Check(logger, CheckParams{OpenParams: openParams, ReadData: true}); err != nil {
+				t.Errorf("check failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	logger := mock.NewMockLogger()
+	openParams := OpenParams{Path: t.TempDir(), Passphrase: []byte("test1")}
+	if _, err := Init(logger, InitParams{OpenParams: openParams, Encrypt: true}); err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	if _, err := Init(logger, InitParams{OpenParams: openParams}); !errors.Is(err, ErrRepositoryExists) {
+		t.Errorf("expected ErrRepositoryExists, got %v", err)
+	}
+	if _, err := List(logger, OpenParams{Path: openParams.Path, Passphrase: []byte("test2")}); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	if _, err := List(logger, OpenParams{Path: openParams.Path}); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestBackupDeduplicatesAndPrunes(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath := t.TempDir()
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	if err := os.WriteFile(filepath.Join(inputPath, "a.bin"), data, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	openParams := OpenParams{Path: t.TempDir()}
+	chunker := ChunkerParams{MinSize: 1024, AvgSize: 4096, MaxSize: 16384}
+	if _, err := Init(logger, InitParams{OpenParams: openParams, Compression: archive.CompressionZstd, Chunker: chunker}); err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	first, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"})
+	if err != nil {
+		t.Fatalf("failed first backup: %v", err)
+	}
+	// a copy of the same content is stored without adding chunks
+	if err := os.WriteFile(filepath.Join(inputPath, "b.bin"), data, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	second, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"})
+	if err != nil {
+		t.Fatalf("failed second backup: %v", err)
+	}
+	if second.ParentID != first.SnapshotID {
+		t.Errorf("expected the first snapshot to be the parent, got %s", second.ParentID)
+	}
+	if second.NumNewChunks != 0 {
+		t.Errorf("expected no new chunks for duplicated content, got %d", second.NumNewChunks)
+	}
+	if second.NumUnchangedFiles != 1 {
+		t.Errorf("expected one unchanged file, got %d", second.NumUnchangedFiles)
+	}
+	if err := os.WriteFile(filepath.Join(inputPath, "a.bin"), []byte("replaced"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(inputPath, "b.bin")); err != nil {
+		t.Fatalf("failed to remove test file: %v", err)
+	}
+	if _, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"}); err != nil {
+		t.Fatalf("failed third backup: %v", err)
+	}
+	dryRun, err := Prune(logger, PruneParams{OpenParams: openParams, KeepLast: 1, DryRun: true})
+	if err != nil {
+		t.Fatalf("failed prune dry run: %v", err)
+	}
+	if len(dryRun.RemovedSnapshots) != 2 || dryRun.RemovedChunks != first.NumNewChunks {
+		t.Errorf("expected 2 snapshots and %d chunks to be removed, got %d and %d", first.NumNewChunks, len(dryRun.RemovedSnapshots), dryRun.RemovedChunks)
+	}
+	snapshots, err := List(logger, openParams)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 3 {
+		t.Errorf("expected the dry run to keep all 3 snapshots, got %d", len(snapshots))
+	}
+	if _, err := Prune(logger, PruneParams{OpenParams: openParams, KeepLast: 1}); err != nil {
+		t.Fatalf("failed prune: %v", err)
+	}
+	report, err := Check(logger, CheckParams{OpenParams: openParams, ReadData: true})
+	if err != nil {
+		t.Fatalf("check failed after prune: %v", err)
+	}
+	if report.NumSnapshots != 1 || report.NumUnreferencedChunks != 0 {
+		t.Errorf("expected 1 snapshot and no unreferenced chunks, got %d and %d", report.NumSnapshots, report.NumUnreferencedChunks)
+	}
+}
+
+func TestCheckFindsDamagedChunk(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputPath, "a.txt"), []byte("some content to back up"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	openParams := OpenParams{Path: t.TempDir()}
+	if _, err := Init(logger, InitParams{OpenParams: openParams}); err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	if _, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}}); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	backend := NewLocalBackend(openParams.Path)
+	chunks, err := backend.List(ChunkFile)
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("expected one chunk, got %d: %v", len(chunks), err)
+	}
+	if err := backend.Save(ChunkFile, chunks[0], []byte("damaged")); err != nil {
+		t.Fatalf("failed to damage chunk: %v", err)
+	}
+	if _, err := Check(logger, CheckParams{OpenParams: openParams}); err != nil {
+		t.Errorf("expected check without reading data to pass, got %v", err)
+	}
+	report, err := Check(logger, CheckParams{OpenParams: openParams, ReadData: true})
+	if !errors.Is(err, ErrCheckFailed) {
+		t.Fatalf("expected ErrCheckFailed, got %v", err)
+	}
+	if len(report.DamagedChunks) != 1 || report.DamagedChunks[0] != chunks[0] {
+		t.Errorf("expected the damaged chunk to be reported, got %v", report.DamagedChunks)
+	}
+	if _, err := Restore(logger, RestoreParams{OpenParams: openParams, SnapshotID: LatestSnapshotID, OutputPath: t.TempDir()}); !errors.Is(err, ErrChunkHashMismatch) {
+		t.Errorf("expected restore to fail with ErrChunkHashMismatch, got %v", err)
+	}
+}
+
+func TestPruneAndBackupLocks(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputPath, "a.txt"), []byte("locked"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	openParams := OpenParams{Path: t.TempDir()}
+	if _, err := Init(logger, InitParams{OpenParams: openParams, Compression: archive.CompressionNone, Chunker: DefaultChunkerParams()}); err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	backend := NewLocalBackend(openParams.Path)
+	// a lock left by a running backup
+	if err := backend.Save(LockFile, "backup", []byte(`{"exclusive":false,"hostname":"test","pid":1}`)); err != nil {
+		t.Fatalf("failed to save lock: %v", err)
+	}
+	if _, err := Prune(logger, PruneParams{OpenParams: openParams, KeepLast: 1}); !errors.Is(err, ErrRepositoryLocked) {
+		t.Errorf("expected prune to fail while a backup is running, got %v", err)
+	}
+	if _, err := Prune(logger, PruneParams{OpenParams: openParams, KeepLast: 1, DryRun: true}); err != nil {
+		t.Errorf("expected a prune dry run to ignore locks: %v", err)
+	}
+	if _, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"}); err != nil {
+		t.Errorf("expected backups to run together: %v", err)
+	}
+	if err := backend.Remove(LockFile, "backup"); err != nil {
+		t.Fatalf("failed to remove lock: %v", err)
+	}
+	// a lock left by a running prune
+	if err := backend.Save(LockFile, "prune", []byte(`{"exclusive":true,"hostname":"test","pid":1}`)); err != nil {
+		t.Fatalf("failed to save lock: %v", err)
+	}
+	if _, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"}); !errors.Is(err, ErrRepositoryLocked) {
+		t.Errorf("expected backup to fail while a prune is running, got %v", err)
+	}
+	if err := backend.Remove(LockFile, "prune"); err != nil {
+		t.Fatalf("failed to remove lock: %v", err)
+	}
+	if _, err := Prune(logger, PruneParams{OpenParams: openParams, KeepLast: 1}); err != nil {
+		t.Errorf("failed prune: %v", err)
+	}
+	locks, err := backend.List(LockFile)
+	if err != nil || len(locks) != 0 {
+		t.Errorf("expected every lock to be removed, got %v %v", locks, err)
+	}
+}
+
+func TestRestoreAndCheckLocks(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputPath, "a.txt"), []byte("locked"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	openParams := OpenParams{Path: t.TempDir()}
+	if _, err := Init(logger, InitParams{OpenParams: openParams, Compression: archive.CompressionNone, Chunker: DefaultChunkerParams()}); err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	if _, err := Backup(logger, BackupParams{OpenParams: openParams, InputPaths: []string{inputPath}, Hostname: "test"}); err != nil {
+		t.Fatalf("failed backup: %v", err)
+	}
+	backend := NewLocalBackend(openParams.Path)
+	// a lock left by a running prune
+	if err := backend.Save(LockFile, "prune", []byte(`{"exclusive":true,"hostname":"test","pid":1}`)); err != nil {
+		t.Fatalf("failed to save lock: %v", err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "restore")
+	if _, err := Restore(logger, RestoreParams{OpenParams: openParams, SnapshotID: LatestSnapshotID, OutputPath: outputPath}); !errors.Is(err, ErrRepositoryLocked) {
+		t.Errorf("expected restore to fail while a prune is running, got %v", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be restored while a prune is running: %v", err)
+	}
+	if _, err := Check(logger, CheckParams{OpenParams: openParams}); !errors.Is(err, ErrRepositoryLocked) {
+		t.Errorf("expected check to fail while a prune is running, got %v", err)
+	}
+	if err := backend.Remove(LockFile, "prune"); err != nil {
+		t.Fatalf("failed to remove lock: %v", err)
+	}
+	// a lock left by a running backup
+	if err := backend.Save(LockFile, "backup", []byte(`{"exclusive":false,"hostname":"test","pid":1}`)); err != nil {
+		t.Fatalf("failed to save lock: %v", err)
+	}
+	if _, err := Restore(logger, RestoreParams{OpenParams: openParams, SnapshotID: LatestSnapshotID, OutputPath: outputPath}); err != nil {
+		t.Errorf("expected restore to run during a backup: %v", err)
+	}
+	if _, err := Check(logger, CheckParams{OpenParams: openParams}); err != nil {
+		t.Errorf("expected check to run during a backup: %v", err)
+	}
+	locks, err := backend.List(LockFile)
+	if err != nil || len(locks) != 1 {
+		t.Errorf("expected only the backup lock to be left, got %v %v", locks, err)
+	}
+}
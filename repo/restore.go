@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/calvine/filejitsu/util/archivepath"
+	"github.com/calvine/filejitsu/util/ignore"
+)
+
+type RestoreParams struct {
+	OpenParams
+	// SnapshotID is a snapshot id, a unique prefix of one, or LatestSnapshotID.
+	SnapshotID string
+	OutputPath string
+	// Include if not empty only nodes matching one of these gitignore style patterns, or inside a matching directory, are restored.
+	Include []string
+}
+
+type RestoreReport struct {
+	SnapshotID string `json:"snapshotId"`
+	NumFiles   int    `json:"numFiles"`
+	NumDirs    int    `json:"numDirs"`
+	TotalSize  int64  `json:"totalSize"`
+	NumSkipped int    `json:"numSkipped"`
+	OutputPath string `json:"outputPath"`
+}
+
+// Restore writes the files and directories of a snapshot to the output path. Every chunk is checked against its id before it is written.
+// Restore takes a shared lock, so a prune can not remove the snapshot or its chunks while it is restored.
+func Restore(logger *slog.Logger, params RestoreParams) (RestoreReport, error) {
+	report := RestoreReport{OutputPath: params.OutputPath}
+	r, err := open(logger, params.OpenParams)
+	if err != nil {
+		return report, err
+	}
+	unlock, err := r.lock(logger, false)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	snapshot, err := r.findSnapshot(logger, params.SnapshotID)
+	if err != nil {
+		logger.Error("failed to find snapshot", slog.String("snapshotId", params.SnapshotID), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	report.SnapshotID = snapshot.ID
+	var include *ignore.Matcher
+	if len(params.Include) > 0 {
+		if include, err = ignore.NewMatcher(params.Include); err != nil {
+			logger.Error("failed to parse include patterns", slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+	}
+	if err := os.MkdirAll(params.OutputPath, 0755); err != nil {
+		logger.Error("failed to create output directory", slog.String("outputPath", params.OutputPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	dirs := make([]Node, 0)
+	dirPaths := make([]string, 0)
+	for _, node := range snapshot.Nodes {
+		if include != nil && !include.MatchPathOrParent(node.Name, node.Type == NodeTypeDirectory) {
+			report.NumSkipped++
+			continue
+		}
+		target, err := archivepath.SafeTargetPath(params.OutputPath, filepath.FromSlash(node.Name))
+		if err != nil {
+			logger.Error("snapshot node would be restored outside of the output path", slog.String("name", node.Name), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+		if node.Type == NodeTypeDirectory {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				logger.Error("failed to create directory", slog.String("path", target), slog.String("errorMessage", err.Error()))
+				return report, err
+			}
+			dirs = append(dirs, node)
+			dirPaths = append(dirPaths, target)
+			report.NumDirs++
+			continue
+		}
+		if err := r.restoreFile(logger, node, target); err != nil {
+			logger.Error("failed to restore file", slog.String("path", target), slog.String("errorMessage", err.Error()))
+			return report, err
+		}
+		report.NumFiles++
+		report.TotalSize += node.Size
+	}
+	// directory permissions and times are set last, since writing the files inside them would change the times and could be blocked by the permissions
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirPaths[i], dirs[i].Mode.Perm()); err != nil {
+			logger.Warn("failed to set directory mode", slog.String("path", dirPaths[i]), slog.String("errorMessage", err.Error()))
+		}
+		if err := os.Chtimes(dirPaths[i], dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			logger.Warn("failed to set directory modification time", slog.String("path", dirPaths[i]), slog.String("errorMessage", err.Error()))
+		}
+	}
+	logger.Info("restore complete", slog.Any("report", report))
+	return report, nil
+}
+
+func (r *repository) restoreFile(logger *slog.Logger, node Node, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, node.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	for _, id := range node.Chunks {
+		data, err := r.loadChunk(logger, id)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(target, node.Mode.Perm()); err != nil {
+		logger.Warn("failed to set file mode", slog.String("path", target), slog.String("errorMessage", err.Error()))
+	}
+	if err := os.Chtimes(target, node.ModTime, node.ModTime); err != nil {
+		logger.Warn("failed to set file modification time", slog.String("path", target), slog.String("errorMessage", err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// LatestSnapshotID selects the newest snapshot.
+	LatestSnapshotID = "latest"
+
+	NodeTypeFile      NodeType = "file"
+	NodeTypeDirectory NodeType = "directory"
+)
+
+type NodeType string
+
+// Node is a file or directory in a snapshot.
+type Node struct {
+	// Name is the slash separated path of the node in the snapshot.
+	Name    string      `json:"name"`
+	Type    NodeType    `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Size    int64       `json:"size"`
+	// Chunks are the ids of the chunks that make up the file contents in order.
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// Snapshot is the state of the backed up paths at a point in time.
+type Snapshot struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	// Paths are the absolute paths that were backed up.
+	Paths []string `json:"paths"`
+	Tags  []string `json:"tags,omitempty"`
+	// ParentID is the snapshot unchanged files were taken from, if any.
+	ParentID  string `json:"parentId,omitempty"`
+	NumFiles  int    `json:"numFiles"`
+	NumDirs   int    `json:"numDirs"`
+	TotalSize int64  `json:"totalSize"`
+	// Nodes are left out when snapshots are listed.
+	Nodes []Node `json:"nodes,omitempty"`
+}
+
+// ShortID returns the first 8 characters of the id, which is usually enough to select a snapshot.
+func (s Snapshot) ShortID() string {
+	if len(s.ID) <= 8 {
+		return s.ID
+	}
+	return s.ID[:8]
+}
+
+// sameSource returns true if the snapshots are of the same paths on the same host, which is what a parent snapshot and a retention group are chosen by.
+func (s Snapshot) sameSource(other Snapshot) bool {
+	return s.Hostname == other.Hostname && slices.Equal(s.Paths, other.Paths)
+}
+
+// saveSnapshot sets the snapshot id to the hash of its contents and stores it.
+func (r *repository) saveSnapshot(logger *slog.Logger, snapshot *Snapshot) error {
+	snapshot.ID = ""
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	snapshot.ID = hex.EncodeToString(sum[:])
+	if data, err = json.Marshal(snapshot); err != nil {
+		return err
+	}
+	encoded, err := r.encode(logger, data)
+	if err != nil {
+		return err
+	}
+	return r.backend.Save(SnapshotFile, snapshot.ID, encoded)
+}
+
+func (r *repository) loadSnapshot(logger *slog.Logger, id string) (Snapshot, error) {
+	snapshot := Snapshot{}
+	encoded, err := r.backend.Load(SnapshotFile, id)
+	if err != nil {
+		return snapshot, err
+	}
+	data, err := r.decode(logger, encoded)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to decode snapshot %s: %w", id, err)
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("failed to parse snapshot %s: %w", id, err)
+	}
+	snapshot.ID = id
+	return snapshot, nil
+}
+
+// loadSnapshots returns every snapshot oldest first.
+func (r *repository) loadSnapshots(logger *slog.Logger) ([]Snapshot, error) {
+	ids, err := r.backend.List(SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := r.loadSnapshot(logger, id)
+		if err != nil {
+			logger.Error("failed to load snapshot", slog.String("snapshotId", id), slog.String("errorMessage", err.Error()))
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	return snapshots, nil
+}
+
+// findSnapshot returns the snapshot with the id, a unique prefix of the id, or LatestSnapshotID for the newest snapshot.
+func (r *repository) findSnapshot(logger *slog.Logger, id string) (Snapshot, error) {
+	ids, err := r.backend.List(SnapshotFile)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if id == LatestSnapshotID {
+		snapshots, err := r.loadSnapshots(logger)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if len(snapshots) == 0 {
+			return Snapshot{}, fmt.Errorf("%w: the repository has no snapshots", ErrSnapshotNotFound)
+		}
+		return snapshots[len(snapshots)-1], nil
+	}
+	match := ""
+	for _, candidate := range ids {
+		if !strings.HasPrefix(candidate, id) {
+			continue
+		}
+		if len(match) > 0 {
+			return Snapshot{}, fmt.Errorf("%w: %s", ErrAmbiguousSnapshotID, id)
+		}
+		match = candidate
+	}
+	if len(id) == 0 || len(match) == 0 {
+		return Snapshot{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	return r.loadSnapshot(logger, match)
+}
+
+// List returns every snapshot in the repository oldest first, without their nodes.
+func List(logger *slog.Logger, params OpenParams) ([]Snapshot, error) {
+	r, err := open(logger, params)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := r.loadSnapshots(logger)
+	if err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		snapshots[i].Nodes = nil
+	}
+	return snapshots, nil
+}
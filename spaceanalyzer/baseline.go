@@ -0,0 +1,103 @@
+package spaceanalyzer
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"sync/atomic"
+)
+
+// Baseline is a previous report of the same root path that a scan reuses what has not changed from. Entities are matched by their path
+// relative to the root, so a baseline must come from a scan with the same filters to be reused as it is.
+type Baseline struct {
+	// TrustUnchangedFiles if true the files of unchanged directories are reused without touching them, so a file changed in place is reported
+	// as it was in the baseline. Otherwise each file is still stat'ed and only reused if it matches the baseline. It is ignored when file
+	// hashes are calculated, since a stale hash would be reported as the file's content.
+	TrustUnchangedFiles bool
+
+	entities map[string]FSEntity
+	children map[string][]string
+
+	numDirsReused  atomic.Int64
+	numFilesReused atomic.Int64
+}
+
+// NewBaseline indexes the entities of a report read with ReadReport by their path relative to the report root.
+func NewBaseline(entities []FSEntity) (*Baseline, error) {
+	byPath, _, err := reportPaths(entities)
+	if err != nil {
+		return nil, err
+	}
+	b := &Baseline{
+		entities: make(map[string]FSEntity, len(byPath)),
+		children: make(map[string][]string),
+	}
+	for relPath, e := range byPath {
+		relPath = baselinePath(relPath)
+		e.Children = nil
+		b.entities[relPath] = e
+		if len(relPath) > 0 {
+			parent := baselinePath(path.Dir(relPath))
+			b.children[parent] = append(b.children[parent], relPath)
+		}
+	}
+	for _, children := range b.children {
+		slices.Sort(children)
+	}
+	return b, nil
+}
+
+// baselinePath is the path relative to the root like scanFilter.relPath returns it, where the root is empty.
+func baselinePath(relPath string) string {
+	if relPath == "." {
+		return ""
+	}
+	return relPath
+}
+
+// sameFile reports if the baseline entity scanned without errors and matches the file info.
+func sameFile(e FSEntity, info fs.FileInfo) bool {
+	return len(e.ErrorMessage) == 0 && e.Matches(info)
+}
+
+// unchangedDir returns the baseline entities in the directory if it has the same modification time and identity as in the baseline. A directory's
+// modification time changes when anything is added to, removed from or renamed in it, so its entries are the same as in the baseline.
+func (b *Baseline) unchangedDir(relPath string, info fs.FileInfo) ([]FSEntity, bool) {
+	if b == nil {
+		return nil, false
+	}
+	e, ok := b.entities[relPath]
+	if !ok || !sameFile(e, info) {
+		return nil, false
+	}
+	children := make([]FSEntity, 0, len(b.children[relPath]))
+	for _, childPath := range b.children[relPath] {
+		children = append(children, b.entities[childPath])
+	}
+	b.numDirsReused.Add(1)
+	return children, true
+}
+
+// matchingFile returns the baseline entity of the file if its size, modification time and identity have not changed, so its hash does not
+// need to be calculated again.
+func (b *Baseline) matchingFile(relPath string, info fs.FileInfo) (FSEntity, bool) {
+	if b == nil {
+		return FSEntity{}, false
+	}
+	e, ok := b.entities[relPath]
+	if !ok || e.IsDir || !sameFile(e, info) {
+		return FSEntity{}, false
+	}
+	b.numFilesReused.Add(1)
+	return e, true
+}
+
+// NumDirsReused is how many directories the last scan reused the entries of.
+func (b *Baseline) NumDirsReused() int64 {
+	return b.numDirsReused.Load()
+}
+
+// NumFilesReused is how many files the last scan reused from the baseline.
+func (b *Baseline) NumFilesReused() int64 {
+	return b.numFilesReused.Load()
+}
@@ -0,0 +1,114 @@
+package spaceanalyzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func findEntity(e FSEntity, name string) (FSEntity, bool) {
+	if e.Name == name {
+		return e, true
+	}
+	for _, c := range e.Children {
+		if found, ok := findEntity(c, name); ok {
+			return found, true
+		}
+	}
+	return FSEntity{}, false
+}
+
+func TestScanWithBaseline(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	files := map[string]string{
+		filepath.Join("a", "keep.txt"):     "unchanged",
+		filepath.Join("a", "edited.txt"):   "before",
+		filepath.Join("b", "c", "old.txt"): "old",
+	}
+	for name, content := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	params := ScanParams{
+		RootPath:            rootPath,
+		MaxRecursion:        -1,
+		CalculateFileHashes: true,
+	}
+	first, err := Scan(logger, params)
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	entities := make([]FSEntity, 0)
+	flattenEntity(first, &entities)
+	for i, e := range entities {
+		// a hash that was not calculated from the file shows it was reused instead of calculated again
+		if e.Name == "keep.txt" {
+			entities[i].FileHash = "from-baseline"
+		}
+	}
+
+	// editing a file in place does not change its directory, adding a file deep in the tree only changes the directory it is added to
+	edited, err := os.OpenFile(filepath.Join(rootPath, "a", "edited.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	edited.WriteString(" and after")
+	edited.Close()
+	if err := os.WriteFile(filepath.Join(rootPath, "b", "c", "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	baseline, err := NewBaseline(entities)
+	if err != nil {
+		t.Fatalf("failed to create baseline: %v", err)
+	}
+	params.Baseline = baseline
+	checked, err := Scan(logger, params)
+	if err != nil {
+		t.Fatalf("failed to scan with baseline: %v", err)
+	}
+	edit, _ := findEntity(checked, "edited.txt")
+	original, _ := findEntity(first, "edited.txt")
+	if edit.Size != int64(len("before and after")) || edit.FileHash == original.FileHash {
+		t.Errorf("expected the edited file to be scanned and hashed again: %+v", edit)
+	}
+	if keep, _ := findEntity(checked, "keep.txt"); keep.FileHash != "from-baseline" {
+		t.Errorf("expected the hash of a file that matches the baseline to be reused: %s", keep.FileHash)
+	}
+	if _, ok := findEntity(checked, "new.txt"); !ok {
+		t.Error("expected the file added to a changed directory below an unchanged one to be found")
+	}
+	if checked.Size != first.Size+int64(len(" and after")+len("new")) {
+		t.Errorf("expected the directory sizes to add up the changes: %d", checked.Size)
+	}
+	if baseline.NumDirsReused() == 0 || baseline.NumFilesReused() == 0 {
+		t.Errorf("expected directories and files to be reused: %d %d", baseline.NumDirsReused(), baseline.NumFilesReused())
+	}
+
+	// trusting the baseline is ignored while hashing, since the stale hash would be reported
+	baseline.TrustUnchangedFiles = true
+	hashed, err := Scan(logger, params)
+	if err != nil {
+		t.Fatalf("failed to scan with baseline: %v", err)
+	}
+	if edit, _ := findEntity(hashed, "edited.txt"); edit.Size != int64(len("before and after")) {
+		t.Errorf("expected the edited file to be scanned again while hashing: %d", edit.Size)
+	}
+
+	params.CalculateFileHashes = false
+	trusted, err := Scan(logger, params)
+	if err != nil {
+		t.Fatalf("failed to scan with baseline: %v", err)
+	}
+	if stale, _ := findEntity(trusted, "edited.txt"); stale.Size != int64(len("before")) {
+		t.Errorf("expected a file in an unchanged directory to be reused without checking it: %d", stale.Size)
+	}
+}
@@ -0,0 +1,672 @@
+// Package browse is an interactive terminal browser for space-analyzer results, in the style of ncdu.
+package browse
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/calvine/filejitsu/spaceanalyzer"
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	DefaultWidth  = 80
+	DefaultHeight = 24
+
+	barWidth = 10
+
+	enterScreen   = "\033[?1049h\033[?25l"
+	exitScreen    = "\033[?25h\033[?1049l"
+	clearScreen   = "\033[H\033[2J"
+	reverseVideo  = "\033[7m"
+	resetGraphics = "\033[0m"
+)
+
+type inputMode int
+
+const (
+	modeNormal inputMode = iota
+	modeSearch
+	modeConfirmDelete
+	modeHelp
+)
+
+// ErrChangedSinceScan is returned for an item that is not deleted because its path no longer holds the file that was scanned, like when browsing an old report.
+var ErrChangedSinceScan = errors.New("changed since it was scanned")
+
+var helpLines = []string{
+	"up/k down/j         move the cursor",
+	"pgup pgdn home end  move the cursor a page or to the ends",
+	"right/l/enter       open the directory, or go to a search result",
+	"left/h/backspace    go to the parent directory, or back from search results",
+	"a                   toggle between apparent and disk size",
+	"/                   search names in the whole tree",
+	"space               mark or unmark the item",
+	"u                   unmark everything",
+	"d                   delete the marked items, or the item if none are marked",
+	"q                   quit",
+	"",
+	"press any key to go back",
+}
+
+// node is an entity in the tree being browsed. Sizes and file counts include everything below the node.
+type node struct {
+	entity   spaceanalyzer.FSEntity
+	parent   *node
+	children []*node
+	numFiles int64
+}
+
+func newNode(e spaceanalyzer.FSEntity, parent *node) *node {
+	n := &node{
+		entity:   e,
+		parent:   parent,
+		children: make([]*node, 0, len(e.Children)),
+	}
+	n.entity.Children = nil
+	if !e.IsDir {
+		n.numFiles = 1
+	}
+	for _, c := range e.Children {
+		child := newNode(c, n)
+		n.children = append(n.children, child)
+		n.numFiles += child.numFiles
+	}
+	return n
+}
+
+func (n *node) size(mode spaceanalyzer.SizeMode) int64 {
+	return n.entity.SizeFor(mode)
+}
+
+// isAttached reports if the node is still in the tree under root, and was not deleted along with an ancestor.
+func (n *node) isAttached(root *node) bool {
+	for p := n; p != nil; p = p.parent {
+		if p == root {
+			return true
+		}
+	}
+	return false
+}
+
+type Options struct {
+	// SizeMode is the size items are sorted and shown by at the start. The browser can toggle it.
+	SizeMode spaceanalyzer.SizeMode
+	// Remove deletes an item from disk. Defaults to os.RemoveAll.
+	Remove func(path string) error
+}
+
+// Browser is the state of the browser: the directory shown, the cursor, marks and any prompt. Keys are handled with HandleKey
+// and the screen is drawn with Render, which Run does until the user quits.
+type Browser struct {
+	logger   *slog.Logger
+	root     *node
+	dir      *node
+	sizeMode spaceanalyzer.SizeMode
+	remove   func(path string) error
+	width    int
+	height   int
+
+	cursor int
+	offset int
+	marked map[*node]struct{}
+
+	mode          inputMode
+	input         string
+	query         string
+	results       []*node
+	showResults   bool
+	pendingDelete []*node
+	status        string
+}
+
+// New creates a browser for the tree under root, starting in the root directory.
+func New(logger *slog.Logger, root spaceanalyzer.FSEntity, options Options) *Browser {
+	b := &Browser{
+		logger:   logger,
+		root:     newNode(root, nil),
+		sizeMode: options.SizeMode,
+		remove:   options.Remove,
+		width:    DefaultWidth,
+		height:   DefaultHeight,
+		marked:   make(map[*node]struct{}),
+	}
+	if len(b.sizeMode) == 0 {
+		b.sizeMode = spaceanalyzer.SizeModeApparent
+	}
+	if b.remove == nil {
+		b.remove = os.RemoveAll
+	}
+	b.dir = b.root
+	b.sortTree(b.root)
+	return b
+}
+
+// SetSize sets the size of the screen in characters. Sizes that are too small to draw anything are ignored.
+func (b *Browser) SetSize(width, height int) {
+	if width > 0 {
+		b.width = width
+	}
+	if height > 2 {
+		b.height = height
+	}
+}
+
+// Run draws the browser to out and handles keys until the user quits or keys fail to read. If size is not nil it is called
+// before every draw so the browser follows the terminal being resized. The end of the keys is treated as quitting.
+func (b *Browser) Run(keys *KeyReader, out io.Writer, size func() (width, height int, err error)) (err error) {
+	w := bufio.NewWriter(out)
+	w.WriteString(enterScreen)
+	defer func() {
+		w.WriteString(exitScreen)
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+	for {
+		if size != nil {
+			if width, height, err := size(); err == nil {
+				b.SetSize(width, height)
+			}
+		}
+		if err := b.Render(w); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		key, err := keys.ReadKey()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if b.HandleKey(key) {
+			return nil
+		}
+	}
+}
+
+// HandleKey updates the browser for a key press and reports if the user asked to quit.
+func (b *Browser) HandleKey(key Key) bool {
+	switch b.mode {
+	case modeHelp:
+		b.mode = modeNormal
+		return false
+	case modeSearch:
+		b.handleSearchKey(key)
+		return false
+	case modeConfirmDelete:
+		b.mode = modeNormal
+		if key.Rune == 'y' || key.Rune == 'Y' {
+			b.deletePending()
+		} else {
+			b.status = "delete cancelled"
+		}
+		b.pendingDelete = nil
+		return false
+	}
+	b.status = ""
+	entries := b.entries()
+	switch {
+	case key.Special == KeyCtrlC || key.Rune == 'q':
+		return true
+	case key.Special == KeyUp || key.Rune == 'k':
+		b.cursor--
+	case key.Special == KeyDown || key.Rune == 'j':
+		b.cursor++
+	case key.Special == KeyPageUp:
+		b.cursor -= b.listHeight()
+	case key.Special == KeyPageDown:
+		b.cursor += b.listHeight()
+	case key.Special == KeyHome || key.Rune == 'g':
+		b.cursor = 0
+	case key.Special == KeyEnd || key.Rune == 'G':
+		b.cursor = len(entries) - 1
+	case key.Special == KeyRight || key.Special == KeyEnter || key.Rune == 'l':
+		b.open()
+	case key.Special == KeyLeft || key.Special == KeyBackspace || key.Rune == 'h':
+		b.back()
+	case key.Special == KeyEscape:
+		if b.showResults {
+			b.back()
+		}
+	case key.Rune == 'a':
+		b.toggleSizeMode()
+	case key.Rune == '/':
+		b.mode = modeSearch
+		b.input = ""
+	case key.Rune == ' ':
+		if len(entries) > 0 {
+			n := entries[b.cursor]
+			if _, ok := b.marked[n]; ok {
+				delete(b.marked, n)
+			} else {
+				b.marked[n] = struct{}{}
+			}
+			b.cursor++
+		}
+	case key.Rune == 'u':
+		clear(b.marked)
+	case key.Rune == 'd':
+		b.confirmDelete()
+	case key.Rune == '?':
+		b.mode = modeHelp
+	}
+	b.clampCursor()
+	return false
+}
+
+func (b *Browser) handleSearchKey(key Key) {
+	switch {
+	case key.Special == KeyEnter:
+		b.mode = modeNormal
+		if len(b.input) > 0 {
+			b.search(b.input)
+		}
+	case key.Special == KeyEscape || key.Special == KeyCtrlC:
+		b.mode = modeNormal
+	case key.Special == KeyBackspace:
+		if len(b.input) > 0 {
+			_, size := utf8.DecodeLastRuneInString(b.input)
+			b.input = b.input[:len(b.input)-size]
+		}
+	case key.Rune >= ' ':
+		b.input += string(key.Rune)
+	}
+}
+
+// entries returns the items listed, which are the search results while they are shown and the children of the directory otherwise.
+func (b *Browser) entries() []*node {
+	if b.showResults {
+		return b.results
+	}
+	return b.dir.children
+}
+
+// listParentSize is the size percentages are shown against.
+func (b *Browser) listParentSize() int64 {
+	if b.showResults {
+		return b.root.size(b.sizeMode)
+	}
+	return b.dir.size(b.sizeMode)
+}
+
+func (b *Browser) listHeight() int {
+	return max(1, b.height-2)
+}
+
+func (b *Browser) clampCursor() {
+	numEntries := len(b.entries())
+	b.cursor = max(0, min(b.cursor, numEntries-1))
+	listHeight := b.listHeight()
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	} else if b.cursor >= b.offset+listHeight {
+		b.offset = b.cursor - listHeight + 1
+	}
+	b.offset = max(0, min(b.offset, numEntries-listHeight))
+}
+
+// setCursorTo moves the cursor to the node if it is listed.
+func (b *Browser) setCursorTo(n *node) {
+	b.cursor = max(0, slices.Index(b.entries(), n))
+	b.clampCursor()
+}
+
+func (b *Browser) open() {
+	entries := b.entries()
+	if len(entries) == 0 {
+		return
+	}
+	n := entries[b.cursor]
+	if b.showResults {
+		b.showResults = false
+		b.dir = n.parent
+		b.setCursorTo(n)
+		return
+	}
+	if !n.entity.IsDir {
+		return
+	}
+	b.dir = n
+	b.cursor = 0
+	b.offset = 0
+}
+
+func (b *Browser) back() {
+	if b.showResults {
+		b.showResults = false
+		b.cursor = 0
+		b.offset = 0
+		return
+	}
+	if b.dir.parent == nil {
+		return
+	}
+	from := b.dir
+	b.dir = b.dir.parent
+	b.setCursorTo(from)
+}
+
+func (b *Browser) toggleSizeMode() {
+	var current *node
+	if entries := b.entries(); len(entries) > 0 {
+		current = entries[b.cursor]
+	}
+	if b.sizeMode == spaceanalyzer.SizeModeDisk {
+		b.sizeMode = spaceanalyzer.SizeModeApparent
+	} else {
+		b.sizeMode = spaceanalyzer.SizeModeDisk
+	}
+	b.sortTree(b.root)
+	b.sortNodes(b.results)
+	if current != nil {
+		b.setCursorTo(current)
+	}
+	b.status = fmt.Sprintf("showing %s size", b.sizeMode)
+}
+
+// sortNodes sorts largest first by the current size mode, then by name.
+func (b *Browser) sortNodes(nodes []*node) {
+	slices.SortFunc(nodes, func(x, y *node) int {
+		if c := cmp.Compare(y.size(b.sizeMode), x.size(b.sizeMode)); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.entity.Name, y.entity.Name)
+	})
+}
+
+func (b *Browser) sortTree(n *node) {
+	b.sortNodes(n.children)
+	for _, c := range n.children {
+		b.sortTree(c)
+	}
+}
+
+// search lists every item below the root whose name contains the query, ignoring case.
+func (b *Browser) search(query string) {
+	b.query = query
+	lowerQuery := strings.ToLower(query)
+	results := make([]*node, 0)
+	var walk func(n *node)
+	walk = func(n *node) {
+		for _, c := range n.children {
+			if strings.Contains(strings.ToLower(c.entity.Name), lowerQuery) {
+				results = append(results, c)
+			}
+			walk(c)
+		}
+	}
+	walk(b.root)
+	b.sortNodes(results)
+	b.results = results
+	b.showResults = true
+	b.cursor = 0
+	b.offset = 0
+	b.status = fmt.Sprintf("%d matches for %q", len(results), query)
+}
+
+// deleteTargets returns the marked items, or the item under the cursor if none are marked. Marked items inside another
+// marked directory are left out since deleting the directory deletes them.
+func (b *Browser) deleteTargets() []*node {
+	if len(b.marked) == 0 {
+		entries := b.entries()
+		if len(entries) == 0 {
+			return nil
+		}
+		return []*node{entries[b.cursor]}
+	}
+	targets := make([]*node, 0, len(b.marked))
+	for n := range b.marked {
+		insideMarked := false
+		for p := n.parent; p != nil; p = p.parent {
+			if _, ok := b.marked[p]; ok {
+				insideMarked = true
+				break
+			}
+		}
+		if !insideMarked {
+			targets = append(targets, n)
+		}
+	}
+	slices.SortFunc(targets, func(x, y *node) int {
+		return cmp.Compare(x.entity.FullPath, y.entity.FullPath)
+	})
+	return targets
+}
+
+func (b *Browser) confirmDelete() {
+	targets := b.deleteTargets()
+	if len(targets) == 0 {
+		return
+	}
+	var total int64
+	for _, n := range targets {
+		total += n.size(b.sizeMode)
+	}
+	b.pendingDelete = targets
+	b.mode = modeConfirmDelete
+	if len(targets) == 1 {
+		b.status = fmt.Sprintf("delete %s (%s)? [y/N]", targets[0].entity.FullPath, util.GetPrettyBytesSize(total))
+		return
+	}
+	b.status = fmt.Sprintf("delete %d marked items (%s)? [y/N]", len(targets), util.GetPrettyBytesSize(total))
+}
+
+func (b *Browser) deletePending() {
+	var current *node
+	if entries := b.entries(); len(entries) > 0 {
+		current = entries[b.cursor]
+	}
+	numDeleted := 0
+	var deletedBytes int64
+	var failures []string
+	for _, n := range b.pendingDelete {
+		fullPath := n.entity.FullPath
+		if err := checkUnchanged(n.entity); err != nil {
+			b.logger.Warn("refusing to delete item", slog.String("fullPath", fullPath), slog.String("errorMessage", err.Error()))
+			failures = append(failures, err.Error())
+			continue
+		}
+		if err := b.remove(fullPath); err != nil {
+			b.logger.Warn("failed to delete item", slog.String("fullPath", fullPath), slog.String("errorMessage", err.Error()))
+			failures = append(failures, err.Error())
+			continue
+		}
+		b.logger.Info("deleted item", slog.String("fullPath", fullPath), slog.Int64("size", n.entity.Size))
+		numDeleted++
+		deletedBytes += n.size(b.sizeMode)
+		b.detach(n)
+	}
+	for n := range b.marked {
+		if !n.isAttached(b.root) {
+			delete(b.marked, n)
+		}
+	}
+	b.results = slices.DeleteFunc(b.results, func(n *node) bool {
+		return !n.isAttached(b.root)
+	})
+	for !b.dir.isAttached(b.root) {
+		b.dir = b.dir.parent
+	}
+	if current != nil && current.isAttached(b.root) {
+		b.setCursorTo(current)
+	}
+	b.clampCursor()
+	b.status = fmt.Sprintf("deleted %d items (%s)", numDeleted, util.GetPrettyBytesSize(deletedBytes))
+	if len(failures) > 0 {
+		b.status += fmt.Sprintf(", %d failed: %s", len(failures), failures[0])
+	}
+}
+
+// checkUnchanged returns ErrChangedSinceScan unless the path of the entity still holds the file it was scanned from,
+// so deleting from an old report never removes whatever was put at the path since.
+func checkUnchanged(e spaceanalyzer.FSEntity) error {
+	info, err := os.Lstat(e.FullPath)
+	if err != nil {
+		return err
+	}
+	if !e.Matches(info) {
+		return fmt.Errorf("%w: %s", ErrChangedSinceScan, e.FullPath)
+	}
+	return nil
+}
+
+// detach removes a deleted node from its parent and takes its sizes and file count off every ancestor.
+// The node keeps nothing pointing back into the tree, so anything below it is no longer attached.
+func (b *Browser) detach(n *node) {
+	parent := n.parent
+	if parent == nil {
+		return
+	}
+	parent.children = slices.DeleteFunc(parent.children, func(c *node) bool {
+		return c == n
+	})
+	for p := parent; p != nil; p = p.parent {
+		p.entity.Size = max(0, p.entity.Size-n.entity.Size)
+		p.entity.DiskSize = max(0, p.entity.DiskSize-n.entity.DiskSize)
+		p.numFiles = max(0, p.numFiles-n.numFiles)
+	}
+	n.parent = nil
+}
+
+// Render draws the whole screen: a header with where the browser is, the listed items and a footer with totals or a prompt.
+func (b *Browser) Render(w io.Writer) error {
+	lines := make([]string, 0, b.height)
+	if b.mode == modeHelp {
+		lines = append(lines, b.header("help"))
+		lines = append(lines, helpLines...)
+		return b.writeScreen(w, lines, -1)
+	}
+	if b.showResults {
+		lines = append(lines, b.header(fmt.Sprintf("search results for %q", b.query)))
+	} else {
+		lines = append(lines, b.header(b.dir.entity.FullPath))
+	}
+	entries := b.entries()
+	if len(entries) == 0 {
+		lines = append(lines, "  (empty)")
+	}
+	end := min(len(entries), b.offset+b.listHeight())
+	for _, n := range entries[b.offset:end] {
+		lines = append(lines, b.entryLine(n))
+	}
+	for len(lines) < b.height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, b.footer())
+	cursorLine := -1
+	if len(entries) > 0 {
+		cursorLine = b.cursor - b.offset + 1
+	}
+	return b.writeScreen(w, lines, cursorLine)
+}
+
+func (b *Browser) header(location string) string {
+	return fmt.Sprintf("filejitsu space-analyzer browse - %s  (? for help)", location)
+}
+
+func (b *Browser) footer() string {
+	switch b.mode {
+	case modeSearch:
+		return "search: " + b.input
+	case modeConfirmDelete:
+		return b.status
+	}
+	if len(b.status) > 0 {
+		return b.status
+	}
+	footer := fmt.Sprintf("total %s (%s)  %d files", util.GetPrettyBytesSize(b.listParentSize()), b.sizeMode, b.dir.numFiles)
+	if b.showResults {
+		footer = fmt.Sprintf("%d results  total %s (%s)", len(b.results), util.GetPrettyBytesSize(b.listParentSize()), b.sizeMode)
+	}
+	if len(b.marked) > 0 {
+		var markedSize int64
+		for n := range b.marked {
+			markedSize += n.size(b.sizeMode)
+		}
+		footer += fmt.Sprintf("  %d marked (%s)", len(b.marked), util.GetPrettyBytesSize(markedSize))
+	}
+	return footer
+}
+
+// entryLine is an item with its mark, size, percentage of the listed total with a bar, file count and name.
+// Directories end in / and show how many files they hold, ! marks items that failed to scan and @ marks symlinks.
+func (b *Browser) entryLine(n *node) string {
+	mark := ' '
+	if _, ok := b.marked[n]; ok {
+		mark = '*'
+	}
+	flag := ' '
+	if len(n.entity.ErrorMessage) > 0 {
+		flag = '!'
+	} else if len(n.entity.LinkTarget) > 0 {
+		flag = '@'
+	}
+	size := n.size(b.sizeMode)
+	percent := 0.0
+	if parentSize := b.listParentSize(); parentSize > 0 {
+		percent = min(100, float64(size)/float64(parentSize)*100)
+	}
+	filled := int(percent / 100 * barWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", barWidth-filled)
+	files := ""
+	if n.entity.IsDir {
+		files = fmt.Sprintf("%d files", n.numFiles)
+	}
+	name := n.entity.Name
+	if b.showResults {
+		if rel, err := filepath.Rel(b.root.entity.FullPath, n.entity.FullPath); err == nil {
+			name = rel
+		}
+	}
+	if n.entity.IsDir {
+		name += "/"
+	}
+	return fmt.Sprintf("%c%c%10s %5.1f%% [%s] %12s  %s", mark, flag, util.GetPrettyBytesSize(size), percent, bar, files, name)
+}
+
+// writeScreen clears the screen and writes the lines cut to its width, with the cursor line in reverse video.
+// Lines end in \r\n since a terminal in raw mode does not return to the start of the line on \n.
+func (b *Browser) writeScreen(w io.Writer, lines []string, cursorLine int) error {
+	var sb strings.Builder
+	sb.WriteString(clearScreen)
+	for i, line := range lines {
+		if i >= b.height {
+			break
+		}
+		line = truncate(line, b.width)
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		if i == 0 || i == cursorLine {
+			sb.WriteString(reverseVideo)
+			sb.WriteString(line)
+			sb.WriteString(strings.Repeat(" ", b.width-utf8.RuneCountInString(line)))
+			sb.WriteString(resetGraphics)
+			continue
+		}
+		sb.WriteString(line)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width])
+}
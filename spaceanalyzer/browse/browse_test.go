@@ -0,0 +1,255 @@
+package browse
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/calvine/filejitsu/spaceanalyzer"
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func newTestBrowser(t *testing.T) (*Browser, string) {
+	t.Helper()
+	rootPath := t.TempDir()
+	files := map[string]int{
+		filepath.Join("big", "a.bin"):   4000,
+		filepath.Join("big", "b.bin"):   2000,
+		"top.log":                       3000,
+		filepath.Join("small", "c.txt"): 10,
+	}
+	for name, size := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, make([]byte, size), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	logger := mock.NewMockLogger()
+	root, err := spaceanalyzer.Scan(logger, spaceanalyzer.ScanParams{
+		RootPath:     rootPath,
+		MaxRecursion: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	return New(logger, root, Options{}), rootPath
+}
+
+func pressKeys(b *Browser, keys ...Key) {
+	for _, k := range keys {
+		b.HandleKey(k)
+	}
+}
+
+func typeText(b *Browser, text string) {
+	for _, r := range text {
+		b.HandleKey(Key{Rune: r})
+	}
+}
+
+func entryNames(b *Browser) []string {
+	names := make([]string, 0)
+	for _, n := range b.entries() {
+		names = append(names, n.entity.Name)
+	}
+	return names
+}
+
+func TestBrowserNavigation(t *testing.T) {
+	b, _ := newTestBrowser(t)
+	if names := strings.Join(entryNames(b), ","); names != "big,top.log,small" {
+		t.Fatalf("expected the root sorted by size: %s", names)
+	}
+	if b.root.numFiles != 4 {
+		t.Errorf("expected 4 files under the root: %d", b.root.numFiles)
+	}
+	pressKeys(b, Key{Special: KeyEnter})
+	if b.dir.entity.Name != "big" || strings.Join(entryNames(b), ",") != "a.bin,b.bin" {
+		t.Fatalf("expected to open big: %s %v", b.dir.entity.Name, entryNames(b))
+	}
+	// opening a file does nothing
+	pressKeys(b, Key{Special: KeyDown}, Key{Special: KeyRight})
+	if b.dir.entity.Name != "big" || b.cursor != 1 {
+		t.Errorf("expected to stay in big on b.bin: %s %d", b.dir.entity.Name, b.cursor)
+	}
+	pressKeys(b, Key{Special: KeyLeft})
+	if b.dir != b.root || b.cursor != 0 {
+		t.Errorf("expected to go back to the root with the cursor on big: %d", b.cursor)
+	}
+	pressKeys(b, Key{Special: KeyEnd}, Key{Rune: 'j'})
+	if b.cursor != 2 {
+		t.Errorf("expected the cursor to stop on the last item: %d", b.cursor)
+	}
+	if quit := b.HandleKey(Key{Rune: 'q'}); !quit {
+		t.Error("expected q to quit")
+	}
+}
+
+func TestBrowserRender(t *testing.T) {
+	b, rootPath := newTestBrowser(t)
+	b.SetSize(100, 10)
+	var screen bytes.Buffer
+	if err := b.Render(&screen); err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+	output := screen.String()
+	for _, expected := range []string{rootPath, "66.6% [######    ]", "2 files  big/", "top.log", "total", "4 files"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the screen to contain %q: %q", expected, output)
+		}
+	}
+	if lines := strings.Split(output, "\r\n"); len(lines) != 10 {
+		t.Errorf("expected a line for every row of the screen: %d", len(lines))
+	}
+	pressKeys(b, Key{Rune: 'a'})
+	if b.sizeMode != spaceanalyzer.SizeModeDisk || !strings.Contains(b.footer(), "disk") {
+		t.Errorf("expected a to switch to disk size: %s %s", b.sizeMode, b.footer())
+	}
+}
+
+func TestBrowserSearch(t *testing.T) {
+	b, _ := newTestBrowser(t)
+	pressKeys(b, Key{Rune: '/'})
+	typeText(b, "B.BIN")
+	pressKeys(b, Key{Special: KeyEnter})
+	if !b.showResults || strings.Join(entryNames(b), ",") != "b.bin" {
+		t.Fatalf("expected b.bin to be found ignoring case: %v", entryNames(b))
+	}
+	if line := b.entryLine(b.results[0]); !strings.Contains(line, filepath.Join("big", "b.bin")) {
+		t.Errorf("expected results to show the path from the root: %s", line)
+	}
+	pressKeys(b, Key{Special: KeyEnter})
+	if b.showResults || b.dir.entity.Name != "big" || b.cursor != 1 {
+		t.Errorf("expected to go to b.bin in big: %s %d", b.dir.entity.Name, b.cursor)
+	}
+	pressKeys(b, Key{Rune: '/'}, Key{Rune: 'x'}, Key{Special: KeyEscape})
+	if b.mode != modeNormal || b.showResults {
+		t.Error("expected escape to cancel the search")
+	}
+}
+
+func TestBrowserDelete(t *testing.T) {
+	b, rootPath := newTestBrowser(t)
+	// cancelling leaves everything in place
+	pressKeys(b, Key{Rune: 'd'}, Key{Rune: 'n'})
+	if _, err := os.Stat(filepath.Join(rootPath, "big")); err != nil || b.status != "delete cancelled" {
+		t.Fatalf("expected big to be kept: %v %s", err, b.status)
+	}
+
+	// marking big and a file inside it only deletes big once
+	pressKeys(b, Key{Rune: ' '}, Key{Special: KeyUp}, Key{Special: KeyEnter}, Key{Rune: ' '}, Key{Special: KeyLeft})
+	pressKeys(b, Key{Special: KeyDown}, Key{Rune: ' '})
+	if len(b.marked) != 3 {
+		t.Fatalf("expected 3 marked items: %d", len(b.marked))
+	}
+	pressKeys(b, Key{Rune: 'd'})
+	if b.mode != modeConfirmDelete || len(b.pendingDelete) != 2 || !strings.Contains(b.status, "2 marked items") {
+		t.Fatalf("expected to confirm deleting big and top.log: %s", b.status)
+	}
+	pressKeys(b, Key{Rune: 'y'})
+	for _, name := range []string{"big", "top.log"} {
+		if _, err := os.Stat(filepath.Join(rootPath, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be deleted: %v", name, err)
+		}
+	}
+	if names := strings.Join(entryNames(b), ","); names != "small" || len(b.marked) != 0 {
+		t.Errorf("expected only small left with no marks: %s %d", names, len(b.marked))
+	}
+	if b.root.entity.Size >= 6000 || b.root.numFiles != 1 {
+		t.Errorf("expected the root size and file count to drop: %d %d", b.root.entity.Size, b.root.numFiles)
+	}
+
+	lockedPath := filepath.Join(rootPath, "locked")
+	if err := os.WriteFile(lockedPath, []byte("locked"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	lockedInfo, err := os.Lstat(lockedPath)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	logger := mock.NewMockLogger()
+	failing := New(logger, spaceanalyzer.FSEntity{
+		Name:     "root",
+		FullPath: rootPath,
+		IsDir:    true,
+		Children: []spaceanalyzer.FSEntity{spaceanalyzer.FileInfoToFSEntry(logger, lockedInfo, "", "locked", lockedPath, false, 1)},
+	}, Options{
+		Remove: func(path string) error {
+			return os.ErrPermission
+		},
+	})
+	pressKeys(failing, Key{Rune: 'd'}, Key{Rune: 'y'})
+	if len(failing.entries()) != 1 || !strings.Contains(failing.status, "1 failed") || !strings.Contains(failing.status, "permission") {
+		t.Errorf("expected a failed delete to keep the item: %s", failing.status)
+	}
+}
+
+func TestBrowserDeleteRefusesChangedItems(t *testing.T) {
+	rootPath := t.TempDir()
+	reportedPath := filepath.Join(rootPath, "reported")
+	if err := os.WriteFile(reportedPath, []byte("scanned"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	logger := mock.NewMockLogger()
+	root, err := spaceanalyzer.Scan(logger, spaceanalyzer.ScanParams{
+		RootPath:     rootPath,
+		MaxRecursion: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	// after the report was saved the file was replaced by a directory of something else
+	if err := os.Remove(reportedPath); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(reportedPath, "important"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	b := New(logger, root, Options{})
+	pressKeys(b, Key{Rune: 'd'}, Key{Rune: 'y'})
+	if _, err := os.Stat(filepath.Join(reportedPath, "important")); err != nil {
+		t.Fatalf("expected what is at the path now to be kept: %v", err)
+	}
+	if len(b.entries()) != 1 || !strings.Contains(b.status, "changed since it was scanned") {
+		t.Errorf("expected the delete to be refused: %s", b.status)
+	}
+}
+
+func TestKeyReader(t *testing.T) {
+	keys := NewKeyReader(strings.NewReader("j\x1b[A\x1b[6~\r\x7f\x1bOHq"))
+	expected := []Key{
+		{Rune: 'j'},
+		{Special: KeyUp},
+		{Special: KeyPageDown},
+		{Special: KeyEnter},
+		{Special: KeyBackspace},
+		{Special: KeyHome},
+		{Rune: 'q'},
+	}
+	for _, e := range expected {
+		k, err := keys.ReadKey()
+		if err != nil {
+			t.Fatalf("failed to read key: %v", err)
+		}
+		if k != e {
+			t.Errorf("expected %+v got %+v", e, k)
+		}
+	}
+}
+
+func TestBrowserRun(t *testing.T) {
+	b, _ := newTestBrowser(t)
+	var screen bytes.Buffer
+	if err := b.Run(NewKeyReader(strings.NewReader("\rq")), &screen, nil); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if b.dir.entity.Name != "big" || !strings.HasSuffix(screen.String(), exitScreen) {
+		t.Errorf("expected to quit in big and restore the screen: %s", b.dir.entity.Name)
+	}
+}
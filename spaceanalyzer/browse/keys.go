@@ -0,0 +1,112 @@
+package browse
+
+import (
+	"bufio"
+	"io"
+)
+
+// SpecialKey is a key that does not type a character.
+type SpecialKey int
+
+const (
+	KeyNone SpecialKey = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPageUp
+	KeyPageDown
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeyBackspace
+	KeyEscape
+	KeyCtrlC
+)
+
+// Key is a key press. Either Rune is the character typed or Special is the key pressed.
+type Key struct {
+	Rune    rune
+	Special SpecialKey
+}
+
+// KeyReader decodes key presses from a terminal in raw mode, including the ANSI escape sequences sent for arrow and paging keys.
+type KeyReader struct {
+	r *bufio.Reader
+}
+
+func NewKeyReader(r io.Reader) *KeyReader {
+	return &KeyReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// ReadKey returns the next key press. Escape sequences that are not understood are returned as KeyNone.
+func (k *KeyReader) ReadKey() (Key, error) {
+	r, _, err := k.r.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return Key{Special: KeyEnter}, nil
+	case 0x7f, 0x08:
+		return Key{Special: KeyBackspace}, nil
+	case 0x03:
+		return Key{Special: KeyCtrlC}, nil
+	case 0x1b:
+		return k.readEscapeSequence()
+	}
+	return Key{Rune: r}, nil
+}
+
+// readEscapeSequence reads what follows an escape. A terminal sends a whole sequence at once, so an escape with nothing
+// buffered after it is the escape key itself.
+func (k *KeyReader) readEscapeSequence() (Key, error) {
+	if k.r.Buffered() == 0 {
+		return Key{Special: KeyEscape}, nil
+	}
+	next, err := k.r.Peek(1)
+	if err != nil || (next[0] != '[' && next[0] != 'O') {
+		return Key{Special: KeyEscape}, nil
+	}
+	k.r.ReadByte()
+	params := make([]byte, 0, 4)
+	for {
+		b, err := k.r.ReadByte()
+		if err != nil {
+			return Key{}, err
+		}
+		// parameter bytes are digits and separators, and the sequence ends with its final byte
+		if b < 0x40 || b > 0x7e {
+			params = append(params, b)
+			continue
+		}
+		switch b {
+		case 'A':
+			return Key{Special: KeyUp}, nil
+		case 'B':
+			return Key{Special: KeyDown}, nil
+		case 'C':
+			return Key{Special: KeyRight}, nil
+		case 'D':
+			return Key{Special: KeyLeft}, nil
+		case 'H':
+			return Key{Special: KeyHome}, nil
+		case 'F':
+			return Key{Special: KeyEnd}, nil
+		case '~':
+			switch string(params) {
+			case "1", "7":
+				return Key{Special: KeyHome}, nil
+			case "4", "8":
+				return Key{Special: KeyEnd}, nil
+			case "5":
+				return Key{Special: KeyPageUp}, nil
+			case "6":
+				return Key{Special: KeyPageDown}, nil
+			}
+		}
+		return Key{Special: KeyNone}, nil
+	}
+}
@@ -3,6 +3,7 @@ package spaceanalyzer
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -20,27 +21,54 @@ const (
 )
 
 var (
-	ErrRecursionLimit = errors.New("recursion limit")
-	emptyFSJob        = FSJob{}
+	ErrRecursionLimit  = errors.New("recursion limit")
+	ErrOtherFileSystem = errors.New("directory is on another file system and was not scanned")
+	ErrSymlinkCycle    = errors.New("directory was already scanned through another path and was not scanned again")
+	emptyFSJob         = FSJob{}
 )
 
 type ConcurrentFSScanner interface {
 	Scan(logger *slog.Logger, entityPath, rootID string, calculateFileHashes bool, maxRecursion int) (FSEntity, error)
 }
 
+// WalkOptions control how a scan moves through the file system.
+type WalkOptions struct {
+	// FollowSymlinks if true symlinks are scanned as what they point to. Directories reached more than once, like through a symlink cycle, are only scanned the first time.
+	// Otherwise symlinks are reported as they are with their LinkTarget. The root path is always followed.
+	FollowSymlinks bool
+	// OneFileSystem if true directories on a different device than the root path are reported but not scanned.
+	OneFileSystem bool
+	// Baseline if set is a previous report that unchanged directories and files are reused from instead of being read again.
+	Baseline *Baseline
+}
+
 type concurrentFSScanner struct {
 	concurrencyLimit int
+	filter           ScanFilter
+	walkOptions      WalkOptions
 }
 
-func NewConcurrentFSScanner(concurrencyLimit int) ConcurrentFSScanner {
+func NewConcurrentFSScanner(concurrencyLimit int, filter ScanFilter, walkOptions WalkOptions) ConcurrentFSScanner {
 	if concurrencyLimit <= 0 {
 		concurrencyLimit = runtime.NumCPU()
 	}
 	return &concurrentFSScanner{
 		concurrencyLimit: concurrencyLimit,
+		filter:           filter,
+		walkOptions:      walkOptions,
 	}
 }
 
+// scanWalker holds the state of the enumeration of a scan, which runs on a single goroutine.
+type scanWalker struct {
+	WalkOptions
+	filter      *scanFilter
+	rootDevice  uint64
+	visitedDirs map[fileIdentity]struct{}
+	// trustBaselineFiles is true if the files of unchanged directories are reused from the baseline without a stat.
+	trustBaselineFiles bool
+}
+
 func (cfs *concurrentFSScanner) Scan(logger *slog.Logger, entityPath, rootID string, shouldCalculateFileHashes bool, maxRecursion int) (FSEntity, error) {
 	logger.Info("starting concurrent scan",
 		slog.Int("concurrencyLimit", cfs.concurrencyLimit),
@@ -49,6 +77,11 @@ func (cfs *concurrentFSScanner) Scan(logger *slog.Logger, entityPath, rootID str
 		slog.Bool("shouldCalculateFileHashes", shouldCalculateFileHashes),
 		slog.Int("maxRecursion", maxRecursion),
 	)
+	filter, err := cfs.filter.compile(entityPath)
+	if err != nil {
+		logger.Error("failed to compile scan filter", slog.String("errorMessage", err.Error()))
+		return FSEntity{}, err
+	}
 	files := make(map[string][]FSEntity)
 	dirs := make(map[string][]FSEntity)
 	limiter := make(chan bool, cfs.concurrencyLimit)
@@ -57,7 +90,13 @@ func (cfs *concurrentFSScanner) Scan(logger *slog.Logger, entityPath, rootID str
 		logger.Warn("creating new id because one provided was blank")
 		rootID = defaultRootID
 	}
-	jobsChan := enumerateScanTargets(logger, entityPath, rootParentID, rootID, maxRecursion)
+	walker := &scanWalker{
+		WalkOptions:        cfs.walkOptions,
+		filter:             filter,
+		visitedDirs:        make(map[fileIdentity]struct{}),
+		trustBaselineFiles: cfs.walkOptions.Baseline != nil && cfs.walkOptions.Baseline.TrustUnchangedFiles && !shouldCalculateFileHashes,
+	}
+	jobsChan := enumerateScanTargets(logger, walker, entityPath, rootParentID, rootID, maxRecursion)
 	mutex := sync.Mutex{}
 	wg.Add(1)
 	go func() {
@@ -85,8 +124,9 @@ func (cfs *concurrentFSScanner) Scan(logger *slog.Logger, entityPath, rootID str
 						slog.String("fullPath", j.FullPath),
 					)
 				}
-				entity := FileInfoToFSEntry(logger, j.Info, j.ParentID, j.ID, j.FullPath, shouldCalculateFileHashes, j.Depth)
+				entity := walker.jobEntity(logger, j, shouldCalculateFileHashes)
 				entity.Depth = j.Depth
+				entity.LinkTarget = j.LinkTarget
 				if j.Error != nil {
 					if len(entity.ErrorMessage) > 0 {
 						entity.ErrorMessage = fmt.Sprintf("%s|||||%s", j.Error.Error(), entity.ErrorMessage)
@@ -120,6 +160,39 @@ func (cfs *concurrentFSScanner) Scan(logger *slog.Logger, entityPath, rootID str
 	return entity, nil
 }
 
+// jobEntity returns the entity for a job. Files reused from the baseline keep their hash, and a file that matches the baseline is only hashed
+// if the baseline has no hash for it.
+func (w *scanWalker) jobEntity(logger *slog.Logger, j FSJob, shouldCalculateFileHashes bool) FSEntity {
+	if j.Baseline != nil {
+		entity := *j.Baseline
+		entity.ID = j.ID
+		entity.ParentID = j.ParentID
+		entity.FullPath = j.FullPath
+		if !shouldCalculateFileHashes {
+			entity.FileHash = ""
+		} else if len(entity.FileHash) == 0 && entity.EntityType == FileType {
+			fileHash, err := calculateFileHash(logger, entity.FullPath)
+			if err != nil {
+				logger.Warn("failed to calculate file hash", slog.Any("fullPath", entity.FullPath), slog.String("errorMessage", err.Error()))
+				entity.ErrorMessage = err.Error()
+			}
+			entity.FileHash = fileHash
+		}
+		return entity
+	}
+	var baselineHash string
+	if !j.IsDir && j.Info != nil {
+		if e, ok := w.Baseline.matchingFile(w.filter.relPath(j.FullPath), j.Info); ok {
+			baselineHash = e.FileHash
+		}
+	}
+	entity := FileInfoToFSEntry(logger, j.Info, j.ParentID, j.ID, j.FullPath, shouldCalculateFileHashes && len(baselineHash) == 0, j.Depth)
+	if len(baselineHash) > 0 {
+		entity.FileHash = baselineHash
+	}
+	return entity
+}
+
 func collateEntities(logger *slog.Logger, entity FSEntity, files map[string][]FSEntity, dirs map[string][]FSEntity) FSEntity {
 	// add files
 	entityFiles := files[entity.ID]
@@ -142,23 +215,26 @@ func collateEntities(logger *slog.Logger, entity FSEntity, files map[string][]FS
 	return entity
 }
 
-func enumerateScanTargets(logger *slog.Logger, entityPath, parentID, id string, maxRecursion int) <-chan FSJob {
+func enumerateScanTargets(logger *slog.Logger, walker *scanWalker, entityPath, parentID, id string, maxRecursion int) <-chan FSJob {
 	logger.Info("enumerating targets")
 	jobsChan := make(chan FSJob, jobsChannelBufferSize)
 	go func() {
-		recursiveEnumerateScanTargets(logger, jobsChan, entityPath, parentID, id, maxRecursion, 0)
+		recursiveEnumerateScanTargets(logger, walker, jobsChan, entityPath, parentID, id, maxRecursion, 0)
 		logger.Info("finished enumerating targets")
 		close(jobsChan)
 	}()
 	return jobsChan
 }
 
-func recursiveEnumerateScanTargets(logger *slog.Logger, jobsChan chan<- FSJob, entityPath, parentID, id string, maxRecursion, recursionCount int) {
+func recursiveEnumerateScanTargets(logger *slog.Logger, walker *scanWalker, jobsChan chan<- FSJob, entityPath, parentID, id string, maxRecursion, recursionCount int) {
 	logger = logger.With(slog.String("parentID", parentID), slog.String("entityPath", entityPath))
 	currentPath := entityPath
 	var job FSJob
+	filteredOut := false
 	defer func() {
-		jobsChan <- job
+		if !filteredOut {
+			jobsChan <- job
+		}
 	}()
 	if !filepath.IsAbs(currentPath) {
 		var err error
@@ -175,12 +251,28 @@ func recursiveEnumerateScanTargets(logger *slog.Logger, jobsChan chan<- FSJob, e
 		job.Error = ErrRecursionLimit
 		return
 	}
-	currentStat, err := os.Stat(currentPath)
+	currentStat, err := os.Lstat(currentPath)
 	if err != nil {
 		job.FailedScan = true
 		job.Error = fmt.Errorf("failed to get stat on current path: %w", err)
 		return
 	}
+	if currentStat.Mode()&fs.ModeSymlink != 0 {
+		job.LinkTarget, err = os.Readlink(currentPath)
+		if err != nil {
+			logger.Warn("failed to read symlink target", slog.String("errorMessage", err.Error()))
+		}
+		if recursionCount == 0 || walker.FollowSymlinks {
+			targetStat, err := os.Stat(currentPath)
+			if err != nil {
+				// a broken symlink is reported as the symlink itself
+				logger.Debug("failed to follow symlink", slog.String("errorMessage", err.Error()))
+				job.Error = fmt.Errorf("failed to follow symlink: %w", err)
+			} else {
+				currentStat = targetStat
+			}
+		}
+	}
 	job.ID = id
 	if len(job.ID) == 0 {
 		job.ID = uuid.New().String()
@@ -190,8 +282,43 @@ func recursiveEnumerateScanTargets(logger *slog.Logger, jobsChan chan<- FSJob, e
 	job.FullPath = currentPath
 	job.Depth = recursionCount
 	logger = logger.With("id", id)
+	if !currentStat.IsDir() && !walker.filter.includesFile(currentPath, currentStat) {
+		logger.Debug("skipping file left out by the scan filter")
+		filteredOut = true
+		return
+	}
 	if currentStat.IsDir() {
 		job.IsDir = true
+		identity, _ := getFileIdentity(currentStat)
+		if recursionCount == 0 {
+			walker.rootDevice = identity.device
+		} else if walker.OneFileSystem && identity.device != walker.rootDevice {
+			logger.Debug("skipping directory on another file system", slog.Uint64("device", identity.device))
+			job.Error = ErrOtherFileSystem
+			return
+		}
+		if identity.inode != 0 {
+			if _, visited := walker.visitedDirs[identity]; visited {
+				logger.Warn("skipping directory that was already scanned", slog.Uint64("device", identity.device), slog.Uint64("inode", identity.inode))
+				job.Error = ErrSymlinkCycle
+				return
+			}
+			walker.visitedDirs[identity] = struct{}{}
+		}
+		loaded, err := walker.filter.loadIgnoreFiles(currentPath)
+		if err != nil {
+			job.FailedScan = true
+			job.Error = fmt.Errorf("failed to load ignore files: %w", err)
+			return
+		}
+		if len(loaded) > 0 {
+			logger.Debug("loaded ignore files for directory", slog.Any("ignoreFiles", loaded))
+		}
+		if baselineChildren, ok := walker.Baseline.unchangedDir(walker.filter.relPath(currentPath), currentStat); ok {
+			logger.Debug("reusing unchanged directory from baseline", slog.Int("numChildren", len(baselineChildren)))
+			enumerateBaselineChildren(logger, walker, jobsChan, currentPath, job.ID, baselineChildren, maxRecursion, recursionCount)
+			return
+		}
 		dirContents, err := os.ReadDir(currentPath)
 		if err != nil {
 			job.FailedScan = true
@@ -203,8 +330,43 @@ func recursiveEnumerateScanTargets(logger *slog.Logger, jobsChan chan<- FSJob, e
 		for _, d := range dirContents {
 			childName := d.Name()
 			childPath := filepath.Join(currentPath, childName)
+			// excluded directories are pruned here so they are never read
+			if walker.filter.excluded(childPath, d.IsDir()) {
+				logger.Debug("skipping excluded entity", slog.String("childPath", childPath))
+				continue
+			}
 			childID := uuid.New().String()
-			recursiveEnumerateScanTargets(logger, jobsChan, childPath, job.ID, childID, maxRecursion, recursionCount+1)
+			recursiveEnumerateScanTargets(logger, walker, jobsChan, childPath, job.ID, childID, maxRecursion, recursionCount+1)
+		}
+	}
+}
+
+// enumerateBaselineChildren enumerates the entries of a directory that has not changed since the baseline without reading it again. Directories are
+// still enumerated since a change below them only changes their own modification time. Files are stat'ed like any other file, and only reused
+// from the baseline without a stat if the baseline is trusted and they did not fail in the baseline or would hit the recursion limit.
+func enumerateBaselineChildren(logger *slog.Logger, walker *scanWalker, jobsChan chan<- FSJob, dirPath, parentID string, children []FSEntity, maxRecursion, recursionCount int) {
+	for _, child := range children {
+		childPath := filepath.Join(dirPath, child.Name)
+		if walker.filter.excluded(childPath, child.IsDir) {
+			logger.Debug("skipping excluded entity", slog.String("childPath", childPath))
+			continue
+		}
+		childID := uuid.New().String()
+		if child.IsDir || !walker.trustBaselineFiles || len(child.ErrorMessage) > 0 || (maxRecursion > -1 && maxRecursion >= recursionCount+1) {
+			recursiveEnumerateScanTargets(logger, walker, jobsChan, childPath, parentID, childID, maxRecursion, recursionCount+1)
+			continue
+		}
+		if !walker.filter.includesEntity(childPath, child.EntityType == FileType, child.Size, child.LastModified) {
+			continue
+		}
+		walker.Baseline.numFilesReused.Add(1)
+		jobsChan <- FSJob{
+			ID:         childID,
+			ParentID:   parentID,
+			FullPath:   childPath,
+			LinkTarget: child.LinkTarget,
+			Depth:      recursionCount + 1,
+			Baseline:   &child,
 		}
 	}
 }
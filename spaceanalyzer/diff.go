@@ -0,0 +1,280 @@
+package spaceanalyzer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	DiffStatusAdded   = "added"
+	DiffStatusRemoved = "removed"
+	DiffStatusChanged = "changed"
+
+	// diffRootPath is the path of the report roots, which every other path is relative to.
+	diffRootPath = "."
+)
+
+var ErrGrowthThresholdExceeded = errors.New("directories grew by more than the growth threshold")
+
+type DiffParams struct {
+	// SizeMode is the size that is compared.
+	SizeMode SizeMode
+	// MinDelta leaves out the entries whose size changed by less than this in either direction. Added and removed entries are compared by their size.
+	MinDelta int64
+	// GrowthThreshold if more than 0 flags the directories that grew by more than this, and DiffReports returns ErrGrowthThresholdExceeded if any did.
+	GrowthThreshold int64
+	// Top if more than 0 limits the directories and the files listed to this many with the most growth.
+	Top int
+}
+
+// DiffEntry is a file or directory that was added, removed or changed between two reports.
+type DiffEntry struct {
+	// Path is relative to the report roots with / separators, and the roots are ".".
+	Path       string     `json:"path"`
+	EntityType EntityType `json:"entityType"`
+	Status     string     `json:"status"`
+	OldSize    int64      `json:"oldSize"`
+	NewSize    int64      `json:"newSize"`
+	// Delta is NewSize - OldSize, so it is negative for entries that shrank.
+	Delta       int64  `json:"delta"`
+	PrettyDelta string `json:"prettyDelta"`
+	// NumAdded, NumRemoved and NumChanged count the files below a directory with each status.
+	NumAdded   int `json:"numAdded,omitempty"`
+	NumRemoved int `json:"numRemoved,omitempty"`
+	NumChanged int `json:"numChanged,omitempty"`
+	// ExceedsGrowthThreshold is set on directories that grew by more than DiffParams.GrowthThreshold.
+	ExceedsGrowthThreshold bool `json:"exceedsGrowthThreshold,omitempty"`
+}
+
+type DiffReport struct {
+	OldRootPath      string   `json:"oldRootPath"`
+	NewRootPath      string   `json:"newRootPath"`
+	SizeMode         SizeMode `json:"sizeMode"`
+	OldSize          int64    `json:"oldSize"`
+	NewSize          int64    `json:"newSize"`
+	TotalDelta       int64    `json:"totalDelta"`
+	PrettyTotalDelta string   `json:"prettyTotalDelta"`
+	NumFilesAdded    int      `json:"numFilesAdded"`
+	NumFilesRemoved  int      `json:"numFilesRemoved"`
+	NumFilesChanged  int      `json:"numFilesChanged"`
+	GrowthThreshold  int64    `json:"growthThreshold,omitempty"`
+	// NumExceedingGrowthThreshold counts the directories that grew by more than the GrowthThreshold.
+	NumExceedingGrowthThreshold int `json:"numExceedingGrowthThreshold,omitempty"`
+	// Directories and Files are sorted by Delta, the most growth first.
+	Directories []DiffEntry `json:"directories"`
+	Files       []DiffEntry `json:"files"`
+}
+
+// prettyDelta is the pretty size of a delta with its sign.
+func prettyDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + util.GetPrettyBytesSize(-delta)
+	}
+	return "+" + util.GetPrettyBytesSize(delta)
+}
+
+// reportPaths returns the entities of a report read with ReadReport by their path relative to the report root, and the root.
+func reportPaths(entities []FSEntity) (map[string]FSEntity, FSEntity, error) {
+	var root *FSEntity
+	for i, e := range entities {
+		if len(e.ParentID) == 0 {
+			root = &entities[i]
+			break
+		}
+	}
+	if root == nil {
+		return nil, FSEntity{}, ErrNoReportRoot
+	}
+	byPath := make(map[string]FSEntity, len(entities))
+	for _, e := range entities {
+		relPath, err := filepath.Rel(root.FullPath, e.FullPath)
+		if err != nil {
+			return nil, *root, fmt.Errorf("failed to get path relative to report root: %w", err)
+		}
+		byPath[filepath.ToSlash(relPath)] = e
+	}
+	return byPath, *root, nil
+}
+
+// fileChanged reports if a file is different in the new report, by its size, modification time or hash when both reports have one.
+func fileChanged(oldEntity, newEntity FSEntity, mode SizeMode) bool {
+	if oldEntity.SizeFor(mode) != newEntity.SizeFor(mode) || !oldEntity.LastModified.Equal(newEntity.LastModified) {
+		return true
+	}
+	return len(oldEntity.FileHash) > 0 && len(newEntity.FileHash) > 0 && oldEntity.FileHash != newEntity.FileHash
+}
+
+// DiffReports compares two reports read with ReadReport by the paths of their entities relative to their roots, so scans of a moved or
+// remounted directory still line up. Directory sizes are the totals of everything below them, so a directory's delta is its growth.
+// The report is returned with ErrGrowthThresholdExceeded if any directory grew by more than the GrowthThreshold.
+func DiffReports(logger *slog.Logger, oldEntities, newEntities []FSEntity, params DiffParams) (DiffReport, error) {
+	oldPaths, oldRoot, err := reportPaths(oldEntities)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to read old report: %w", err)
+	}
+	newPaths, newRoot, err := reportPaths(newEntities)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to read new report: %w", err)
+	}
+	mode := params.SizeMode
+	report := DiffReport{
+		OldRootPath:     oldRoot.FullPath,
+		NewRootPath:     newRoot.FullPath,
+		SizeMode:        mode,
+		OldSize:         oldRoot.SizeFor(mode),
+		NewSize:         newRoot.SizeFor(mode),
+		GrowthThreshold: params.GrowthThreshold,
+		Directories:     make([]DiffEntry, 0),
+		Files:           make([]DiffEntry, 0),
+	}
+	report.TotalDelta = report.NewSize - report.OldSize
+	report.PrettyTotalDelta = prettyDelta(report.TotalDelta)
+
+	entries := make(map[string]*DiffEntry)
+	addEntry := func(p string, e FSEntity, status string, oldSize, newSize int64) *DiffEntry {
+		entry := &DiffEntry{
+			Path:       p,
+			EntityType: e.EntityType,
+			Status:     status,
+			OldSize:    oldSize,
+			NewSize:    newSize,
+			Delta:      newSize - oldSize,
+		}
+		if e.IsDir {
+			entry.EntityType = DirectoryType
+		}
+		entries[p] = entry
+		return entry
+	}
+	for p, oldEntity := range oldPaths {
+		newEntity, ok := newPaths[p]
+		switch {
+		case !ok:
+			addEntry(p, oldEntity, DiffStatusRemoved, oldEntity.SizeFor(mode), 0)
+		case oldEntity.IsDir != newEntity.IsDir:
+			// a file replaced by a directory, or the other way around, is changed to its new type
+			addEntry(p, newEntity, DiffStatusChanged, oldEntity.SizeFor(mode), newEntity.SizeFor(mode))
+		case oldEntity.IsDir:
+			if oldEntity.SizeFor(mode) != newEntity.SizeFor(mode) {
+				addEntry(p, newEntity, DiffStatusChanged, oldEntity.SizeFor(mode), newEntity.SizeFor(mode))
+			}
+		case fileChanged(oldEntity, newEntity, mode):
+			addEntry(p, newEntity, DiffStatusChanged, oldEntity.SizeFor(mode), newEntity.SizeFor(mode))
+		}
+	}
+	for p, newEntity := range newPaths {
+		if _, ok := oldPaths[p]; !ok {
+			addEntry(p, newEntity, DiffStatusAdded, 0, newEntity.SizeFor(mode))
+		}
+	}
+
+	// roll the file counts up to every directory above each file, adding directories whose size did not change but whose files did
+	fileEntries := make([]*DiffEntry, 0)
+	for p, entry := range entries {
+		if entry.EntityType != DirectoryType && p != diffRootPath {
+			fileEntries = append(fileEntries, entry)
+		}
+	}
+	for _, entry := range fileEntries {
+		switch entry.Status {
+		case DiffStatusAdded:
+			report.NumFilesAdded++
+		case DiffStatusRemoved:
+			report.NumFilesRemoved++
+		case DiffStatusChanged:
+			report.NumFilesChanged++
+		}
+		for dir := path.Dir(entry.Path); ; dir = path.Dir(dir) {
+			dirEntry, ok := entries[dir]
+			if !ok {
+				e, inNew := newPaths[dir]
+				if !inNew {
+					e = oldPaths[dir]
+				}
+				size := e.SizeFor(mode)
+				dirEntry = addEntry(dir, e, DiffStatusChanged, size, size)
+				dirEntry.EntityType = DirectoryType
+			}
+			switch entry.Status {
+			case DiffStatusAdded:
+				dirEntry.NumAdded++
+			case DiffStatusRemoved:
+				dirEntry.NumRemoved++
+			case DiffStatusChanged:
+				dirEntry.NumChanged++
+			}
+			if dir == diffRootPath {
+				break
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		entry.PrettyDelta = prettyDelta(entry.Delta)
+		if entry.EntityType == DirectoryType && params.GrowthThreshold > 0 && entry.Delta > params.GrowthThreshold {
+			entry.ExceedsGrowthThreshold = true
+			report.NumExceedingGrowthThreshold++
+		}
+		if max(entry.Delta, -entry.Delta) < params.MinDelta {
+			continue
+		}
+		if entry.EntityType == DirectoryType {
+			report.Directories = append(report.Directories, *entry)
+		} else {
+			report.Files = append(report.Files, *entry)
+		}
+	}
+	byGrowth := func(a, b DiffEntry) int {
+		if c := cmp.Compare(b.Delta, a.Delta); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Path, b.Path)
+	}
+	slices.SortFunc(report.Directories, byGrowth)
+	slices.SortFunc(report.Files, byGrowth)
+	if params.Top > 0 {
+		report.Directories = report.Directories[:min(params.Top, len(report.Directories))]
+		report.Files = report.Files[:min(params.Top, len(report.Files))]
+	}
+	logger.Info("compared reports",
+		slog.Int64("totalDelta", report.TotalDelta),
+		slog.Int("numFilesAdded", report.NumFilesAdded),
+		slog.Int("numFilesRemoved", report.NumFilesRemoved),
+		slog.Int("numFilesChanged", report.NumFilesChanged),
+	)
+	if report.NumExceedingGrowthThreshold > 0 {
+		return report, fmt.Errorf("%w: %d directories grew by more than %s", ErrGrowthThresholdExceeded, report.NumExceedingGrowthThreshold, util.GetPrettyBytesSize(params.GrowthThreshold))
+	}
+	return report, nil
+}
+
+// WriteDiffTable writes the directories and then the files of a diff report, each with their delta, old and new size.
+func WriteDiffTable(w io.Writer, report DiffReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s -> %s: %s (%s -> %s)\n", report.OldRootPath, report.NewRootPath, report.PrettyTotalDelta,
+		util.GetPrettyBytesSize(report.OldSize), util.GetPrettyBytesSize(report.NewSize))
+	fmt.Fprintf(tw, "%d files added, %d removed, %d changed\n", report.NumFilesAdded, report.NumFilesRemoved, report.NumFilesChanged)
+	fmt.Fprintln(tw, "\nDELTA\tOLD\tNEW\tSTATUS\tFILES +/-/~\tDIRECTORY")
+	for _, d := range report.Directories {
+		path := d.Path
+		if d.ExceedsGrowthThreshold {
+			path += " (over threshold)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d/%d\t%s\n", d.PrettyDelta, util.GetPrettyBytesSize(d.OldSize), util.GetPrettyBytesSize(d.NewSize),
+			d.Status, d.NumAdded, d.NumRemoved, d.NumChanged, path)
+	}
+	fmt.Fprintln(tw, "\nDELTA\tOLD\tNEW\tSTATUS\t\tFILE")
+	for _, f := range report.Files {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\t%s\n", f.PrettyDelta, util.GetPrettyBytesSize(f.OldSize), util.GetPrettyBytesSize(f.NewSize), f.Status, f.Path)
+	}
+	return tw.Flush()
+}
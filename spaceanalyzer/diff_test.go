@@ -0,0 +1,83 @@
+package spaceanalyzer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestDiffReports(t *testing.T) {
+	logger := mock.NewMockLogger()
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := func(id, parentID, fullPath string, size int64) FSEntity {
+		return FSEntity{ID: id, ParentID: parentID, FullPath: fullPath, Size: size, EntityType: FileType, LastModified: modified}
+	}
+	dir := func(id, parentID, fullPath string, size int64) FSEntity {
+		return FSEntity{ID: id, ParentID: parentID, FullPath: fullPath, Size: size, IsDir: true, EntityType: DirectoryType}
+	}
+	oldEntities := []FSEntity{
+		dir("r", "", "/old/data", 3500),
+		dir("l", "r", "/old/data/logs", 3000),
+		file("a", "l", "/old/data/logs/app.log", 2000),
+		file("b", "l", "/old/data/logs/old.log", 1000),
+		dir("c", "r", "/old/data/cache", 500),
+		file("d", "c", "/old/data/cache/x.bin", 500),
+	}
+	touched := file("d", "c", "/mnt/data/cache/x.bin", 500)
+	touched.LastModified = modified.Add(time.Hour)
+	// the new scan is of the same tree mounted somewhere else
+	newEntities := []FSEntity{
+		dir("r", "", "/mnt/data", 7500),
+		dir("l", "r", "/mnt/data/logs", 5000),
+		file("a", "l", "/mnt/data/logs/app.log", 5000),
+		dir("c", "r", "/mnt/data/cache", 500),
+		touched,
+		dir("m", "r", "/mnt/data/media", 2000),
+		file("e", "m", "/mnt/data/media/new.mp4", 2000),
+	}
+	report, err := DiffReports(logger, oldEntities, newEntities, DiffParams{GrowthThreshold: 2500})
+	if !errors.Is(err, ErrGrowthThresholdExceeded) {
+		t.Fatalf("expected the root to exceed the growth threshold: %v", err)
+	}
+	if report.TotalDelta != 4000 || report.NumFilesAdded != 1 || report.NumFilesRemoved != 1 || report.NumFilesChanged != 2 {
+		t.Errorf("unexpected totals: %+v", report)
+	}
+	dirPaths := make([]string, 0)
+	for _, d := range report.Directories {
+		dirPaths = append(dirPaths, d.Path)
+	}
+	// logs and media grew the same so they are sorted by path, and cache did not change size but a file in it changed so it is still listed
+	if strings.Join(dirPaths, ",") != ".,logs,media,cache" {
+		t.Fatalf("expected directories sorted by growth: %v", dirPaths)
+	}
+	root := report.Directories[0]
+	if !root.ExceedsGrowthThreshold || root.NumAdded != 1 || root.NumRemoved != 1 || root.NumChanged != 2 || report.NumExceedingGrowthThreshold != 1 {
+		t.Errorf("expected the root to roll up every file and exceed the threshold: %+v", root)
+	}
+	if logs := report.Directories[1]; logs.Delta != 2000 || logs.ExceedsGrowthThreshold || logs.PrettyDelta != "+1.95 KB" {
+		t.Errorf("expected logs to grow by 2000 bytes: %+v", logs)
+	}
+	if report.Files[0].Path != "logs/app.log" || report.Files[len(report.Files)-1].Status != DiffStatusRemoved {
+		t.Errorf("expected files sorted by growth with the removed file last: %+v", report.Files)
+	}
+
+	filtered, err := DiffReports(logger, oldEntities, newEntities, DiffParams{MinDelta: 2500, Top: 1})
+	if err != nil {
+		t.Fatalf("failed to diff reports: %v", err)
+	}
+	if len(filtered.Directories) != 1 || filtered.Directories[0].Path != "." || len(filtered.Files) != 1 || filtered.Files[0].Path != "logs/app.log" {
+		t.Errorf("expected only the largest changes: %+v %+v", filtered.Directories, filtered.Files)
+	}
+
+	var table bytes.Buffer
+	if err := WriteDiffTable(&table, report); err != nil {
+		t.Fatalf("failed to write diff table: %v", err)
+	}
+	if !strings.Contains(table.String(), "1 files added, 1 removed, 2 changed") || !strings.Contains(table.String(), ". (over threshold)") {
+		t.Errorf("unexpected diff table: %s", table.String())
+	}
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package spaceanalyzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestScanDiskSize(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	sparse, err := os.Create(filepath.Join(rootPath, "sparse.img"))
+	if err != nil {
+		t.Fatalf("failed to create sparse file: %v", err)
+	}
+	if err := sparse.Truncate(64 * 1024 * 1024); err != nil {
+		t.Fatalf("failed to size sparse file: %v", err)
+	}
+	sparse.Close()
+	if err := os.WriteFile(filepath.Join(rootPath, "dense.bin"), make([]byte, 1024*1024), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	scan := func(mode SizeMode) FSEntity {
+		root, err := Scan(logger, ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+			SizeMode:     mode,
+		})
+		if err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		return root
+	}
+	root := scan(SizeModeApparent)
+	if root.Children[0].Name != "sparse.img" {
+		t.Errorf("expected the sparse file to be first by apparent size: %s", root.Children[0].Name)
+	}
+	sparseEntity := root.Children[0]
+	if sparseEntity.DiskSize >= sparseEntity.Size {
+		t.Skipf("file system does not support sparse files: disk size %d", sparseEntity.DiskSize)
+	}
+	dense := root.Children[1]
+	if dense.DiskSize < dense.Size {
+		t.Errorf("expected the dense file to be fully allocated: %+v", dense)
+	}
+	if root.DiskSize < sparseEntity.DiskSize+dense.DiskSize || root.Size != sparseEntity.Size+dense.Size {
+		t.Errorf("expected the root to add up its children: %d %d", root.Size, root.DiskSize)
+	}
+	root = scan(SizeModeDisk)
+	if root.Children[0].Name != "dense.bin" {
+		t.Errorf("expected the dense file to be first by disk size: %s", root.Children[0].Name)
+	}
+}
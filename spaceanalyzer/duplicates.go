@@ -0,0 +1,301 @@
+package spaceanalyzer
+
+import (
+	"cmp"
+	"crypto/sha512"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	// OutputFormatCSV is one row per duplicate file with the set it belongs to.
+	OutputFormatCSV = "csv"
+	// OutputFormatTable is a human readable table of duplicate sets.
+	OutputFormatTable = "table"
+
+	// DefaultPartialHashSize is how much of the start and end of a file is hashed to rule out files that only share a size.
+	DefaultPartialHashSize = 4 * 1024
+)
+
+type DuplicatesParams struct {
+	// ScanParams select the files that are checked. Empty files are always left out.
+	ScanParams
+	// PartialHashSize is how many bytes of the start and the end of each file are hashed before files are fully hashed.
+	PartialHashSize int64
+}
+
+// DuplicateSet is a group of files with identical content.
+type DuplicateSet struct {
+	// FileHash is the SHA512 hash of the content, like FSEntity.FileHash.
+	FileHash string `json:"fileHash"`
+	Size     int64  `json:"size"`
+	// NumCopies is how many independent copies of the content there are. Hard links to the same file are one copy.
+	NumCopies int `json:"numCopies"`
+	// WastedBytes is the space that would be reclaimed by keeping one copy.
+	WastedBytes       int64  `json:"wastedBytes"`
+	PrettyWastedBytes string `json:"prettyWastedBytes"`
+	// Files are every path to the content, including each hard link.
+	Files []FSEntity `json:"files"`
+}
+
+type DuplicatesReport struct {
+	RootPath               string `json:"rootPath"`
+	NumFilesScanned        int    `json:"numFilesScanned"`
+	NumPartialHashed       int    `json:"numPartialHashed"`
+	NumFullHashed          int    `json:"numFullHashed"`
+	NumFilesFailed         int    `json:"numFilesFailed"`
+	TotalWastedBytes       int64  `json:"totalWastedBytes"`
+	PrettyTotalWastedBytes string `json:"prettyTotalWastedBytes"`
+	// Sets are sorted by WastedBytes, largest first.
+	Sets []DuplicateSet `json:"sets"`
+}
+
+// FindDuplicates scans the root path and groups the regular files with identical content.
+func FindDuplicates(logger *slog.Logger, params DuplicatesParams) (DuplicatesReport, error) {
+	if params.Baseline != nil && params.Baseline.TrustUnchangedFiles {
+		// duplicates are found by size and content, so a file changed in place must not keep its baseline size and hash
+		logger.Warn("checking every file against the baseline to find duplicates")
+		params.Baseline.TrustUnchangedFiles = false
+	}
+	root, err := Scan(logger, params.ScanParams)
+	if err != nil {
+		return DuplicatesReport{}, err
+	}
+	files := make([]FSEntity, 0)
+	flattenEntity(root, &files)
+	report := GroupDuplicates(logger, files, params)
+	report.RootPath = root.FullPath
+	return report, nil
+}
+
+// GroupDuplicates groups the regular files in the entities with identical content. Files are first grouped by size, then by a hash of
+// their first and last PartialHashSize bytes, and only files still sharing a group are fully hashed. A FileHash already on an entity is reused.
+// Hard links to the same file are one copy, so only one of them is hashed and content that is only hard linked is not a duplicate.
+func GroupDuplicates(logger *slog.Logger, entities []FSEntity, params DuplicatesParams) DuplicatesReport {
+	report := DuplicatesReport{
+		Sets: make([]DuplicateSet, 0),
+	}
+	partialHashSize := params.PartialHashSize
+	if partialHashSize <= 0 {
+		partialHashSize = DefaultPartialHashSize
+	}
+	bySize := make(map[int64][]FSEntity)
+	links := make(map[fileIdentity][]FSEntity)
+	for _, e := range entities {
+		if e.EntityType != FileType || e.Size == 0 {
+			continue
+		}
+		report.NumFilesScanned++
+		if identity, ok := e.identity(); ok {
+			links[identity] = append(links[identity], e)
+			if len(links[identity]) > 1 {
+				// the first link stands for the file, the others join its set once it is found
+				continue
+			}
+		}
+		bySize[e.Size] = append(bySize[e.Size], e)
+	}
+	candidates := make([]FSEntity, 0)
+	for _, group := range bySize {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+	logger.Info("grouped files by size", slog.Int("numFiles", report.NumFilesScanned), slog.Int("numCandidates", len(candidates)))
+
+	partialHashes := hashFiles(logger, candidates, params.ConcurrencyLimit, func(e FSEntity) (string, error) {
+		return partialFileHash(e.FullPath, e.Size, partialHashSize)
+	})
+	report.NumPartialHashed += countUnhashed(candidates)
+	byPartialHash := make(map[string][]FSEntity)
+	for i, e := range candidates {
+		if len(partialHashes[i]) == 0 {
+			report.NumFilesFailed++
+			continue
+		}
+		// files no bigger than two partial reads are hashed whole by the partial hash, so their partial hash is their file hash
+		if e.Size <= 2*partialHashSize {
+			e.FileHash = partialHashes[i]
+		}
+		key := fmt.Sprintf("%d:%s", e.Size, partialHashes[i])
+		byPartialHash[key] = append(byPartialHash[key], e)
+	}
+	candidates = candidates[:0]
+	for _, group := range byPartialHash {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+	logger.Info("grouped files by partial hash", slog.Int("numCandidates", len(candidates)))
+
+	fullHashes := hashFiles(logger, candidates, params.ConcurrencyLimit, func(e FSEntity) (string, error) {
+		return calculateFileHash(logger, e.FullPath)
+	})
+	report.NumFullHashed += countUnhashed(candidates)
+	byHash := make(map[string][]FSEntity)
+	for i, e := range candidates {
+		if len(fullHashes[i]) == 0 {
+			report.NumFilesFailed++
+			continue
+		}
+		e.FileHash = fullHashes[i]
+		byHash[e.FileHash] = append(byHash[e.FileHash], e)
+	}
+	for hash, group := range byHash {
+		if len(group) < 2 {
+			continue
+		}
+		files := make([]FSEntity, 0, len(group))
+		for _, e := range group {
+			identity, ok := e.identity()
+			if !ok {
+				files = append(files, e)
+				continue
+			}
+			for _, link := range links[identity] {
+				link.FileHash = hash
+				files = append(files, link)
+			}
+		}
+		slices.SortFunc(files, func(a, b FSEntity) int {
+			return cmp.Compare(a.FullPath, b.FullPath)
+		})
+		wasted := group[0].Size * int64(len(group)-1)
+		report.Sets = append(report.Sets, DuplicateSet{
+			FileHash:          hash,
+			Size:              group[0].Size,
+			NumCopies:         len(group),
+			WastedBytes:       wasted,
+			PrettyWastedBytes: util.GetPrettyBytesSize(wasted),
+			Files:             files,
+		})
+		report.TotalWastedBytes += wasted
+	}
+	slices.SortFunc(report.Sets, func(a, b DuplicateSet) int {
+		if c := cmp.Compare(b.WastedBytes, a.WastedBytes); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Files[0].FullPath, b.Files[0].FullPath)
+	})
+	report.PrettyTotalWastedBytes = util.GetPrettyBytesSize(report.TotalWastedBytes)
+	logger.Info("found duplicates", slog.Int("numSets", len(report.Sets)), slog.Int64("totalWastedBytes", report.TotalWastedBytes))
+	return report
+}
+
+// hashFiles runs the hash function on every file without a FileHash, with at most concurrencyLimit running at a time.
+// Files that already have a FileHash get it back, and the hash of a file that failed is empty.
+func hashFiles(logger *slog.Logger, files []FSEntity, concurrencyLimit int, hash func(FSEntity) (string, error)) []string {
+	if concurrencyLimit <= 0 {
+		concurrencyLimit = runtime.NumCPU()
+	}
+	hashes := make([]string, len(files))
+	limiter := make(chan bool, concurrencyLimit)
+	wg := sync.WaitGroup{}
+	for i, f := range files {
+		if len(f.FileHash) > 0 {
+			hashes[i] = f.FileHash
+			continue
+		}
+		limiter <- true
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			h, err := hash(f)
+			if err != nil {
+				logger.Warn("failed to hash file", slog.String("fullPath", f.FullPath), slog.String("errorMessage", err.Error()))
+				return
+			}
+			hashes[i] = h
+		}()
+	}
+	wg.Wait()
+	return hashes
+}
+
+func countUnhashed(files []FSEntity) int {
+	count := 0
+	for _, f := range files {
+		if len(f.FileHash) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// partialFileHash returns the SHA512 hash of the first and last partialHashSize bytes of the file, or of the whole file if it is no bigger than both.
+func partialFileHash(fullPath string, size, partialHashSize int64) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for hashing: %w", err)
+	}
+	defer f.Close()
+	hasher := sha512.New()
+	if size <= 2*partialHashSize {
+		if _, err := io.Copy(hasher, f); err != nil {
+			return "", fmt.Errorf("failed to hash file: %w", err)
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+	if _, err := io.Copy(hasher, io.NewSectionReader(f, 0, partialHashSize)); err != nil {
+		return "", fmt.Errorf("failed to hash start of file: %w", err)
+	}
+	if _, err := io.Copy(hasher, io.NewSectionReader(f, size-partialHashSize, partialHashSize)); err != nil {
+		return "", fmt.Errorf("failed to hash end of file: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// WriteDuplicatesCSV writes one row for every file in a duplicate set, in the order of the sets.
+func WriteDuplicatesCSV(w io.Writer, report DuplicatesReport) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write([]string{"set", "fileHash", "size", "wastedBytes", "fullPath"}); err != nil {
+		return err
+	}
+	for i, set := range report.Sets {
+		for _, f := range set.Files {
+			record := []string{
+				strconv.Itoa(i + 1),
+				set.FileHash,
+				strconv.FormatInt(set.Size, 10),
+				strconv.FormatInt(set.WastedBytes, 10),
+				f.FullPath,
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteDuplicatesTable writes each duplicate set with its files indented below it, followed by the total reclaimable space.
+func WriteDuplicatesTable(w io.Writer, report DuplicatesReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SET\tCOPIES\tSIZE\tWASTED\tPATH")
+	for i, set := range report.Sets {
+		for j, f := range set.Files {
+			if j == 0 {
+				fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", i+1, set.NumCopies, util.GetPrettyBytesSize(set.Size), set.PrettyWastedBytes, f.FullPath)
+				continue
+			}
+			fmt.Fprintf(tw, "\t\t\t\t%s\n", f.FullPath)
+		}
+	}
+	fmt.Fprintf(tw, "\n%d duplicate sets, %s reclaimable\n", len(report.Sets), report.PrettyTotalWastedBytes)
+	return tw.Flush()
+}
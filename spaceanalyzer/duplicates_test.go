@@ -0,0 +1,144 @@
+package spaceanalyzer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	// same size, start and end as big, so only a full hash tells them apart
+	bigChangedMiddle := bytes.Clone(big)
+	bigChangedMiddle[len(big)/2] = 'x'
+	files := map[string][]byte{
+		"big1.bin":                               big,
+		filepath.Join("nested", "big2.bin"):      big,
+		filepath.Join("nested", "big3.bin"):      big,
+		"big-changed.bin":                        bigChangedMiddle,
+		"small1.txt":                             []byte("duplicate"),
+		filepath.Join("nested", "deep", "s.txt"): []byte("duplicate"),
+		"same-size.txt":                          []byte("different"),
+		"empty1.txt":                             {},
+		"empty2.txt":                             {},
+	}
+	for name, content := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, content, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	report, err := FindDuplicates(logger, DuplicatesParams{
+		ScanParams: ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(report.Sets) != 2 {
+		t.Fatalf("expected 2 duplicate sets: %+v", report.Sets)
+	}
+	bigSet := report.Sets[0]
+	if len(bigSet.Files) != 3 || bigSet.WastedBytes != int64(2*len(big)) || !strings.HasSuffix(bigSet.Files[0].FullPath, "big1.bin") {
+		t.Errorf("expected the set of big files to be first with 2 copies wasted: %+v", bigSet)
+	}
+	smallSet := report.Sets[1]
+	if len(smallSet.Files) != 2 || smallSet.WastedBytes != int64(len("duplicate")) {
+		t.Errorf("expected the set of small files second: %+v", smallSet)
+	}
+	if report.TotalWastedBytes != bigSet.WastedBytes+smallSet.WastedBytes {
+		t.Errorf("expected total wasted bytes to add up: %d", report.TotalWastedBytes)
+	}
+	// only the four big files share a partial hash, and only the big ones are too large for the partial hash to cover
+	if report.NumPartialHashed != 7 || report.NumFullHashed != 4 {
+		t.Errorf("expected 7 partial hashes and 4 full hashes, got %d and %d", report.NumPartialHashed, report.NumFullHashed)
+	}
+
+	minSizeReport, err := FindDuplicates(logger, DuplicatesParams{
+		ScanParams: ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+			Filter: ScanFilter{
+				MinSize: 1024,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(minSizeReport.Sets) != 1 || minSizeReport.Sets[0].Size != int64(len(big)) {
+		t.Errorf("expected only the big files with a min size: %+v", minSizeReport.Sets)
+	}
+
+	var csvOutput bytes.Buffer
+	if err := WriteDuplicatesCSV(&csvOutput, report); err != nil {
+		t.Fatalf("failed to write csv: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(csvOutput.String()), "\n"); len(lines) != 6 {
+		t.Errorf("expected a header and a row per duplicate file: %s", csvOutput.String())
+	}
+}
+
+// makeLinkedDuplicates writes a.txt and b.txt with the same content and c.txt with content of its own, and hard links each of them.
+func makeLinkedDuplicates(t *testing.T) (string, DuplicatesReport) {
+	t.Helper()
+	rootPath := t.TempDir()
+	files := map[string]string{
+		"a.txt": "same content",
+		"b.txt": "same content",
+		"c.txt": "only linked",
+	}
+	for name, content := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := os.Link(fullPath, fullPath+".link"); err != nil {
+			t.Skipf("hard links are not supported: %v", err)
+		}
+	}
+	report, err := FindDuplicates(mock.NewMockLogger(), DuplicatesParams{
+		ScanParams: ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(report.Sets) > 0 && report.Sets[0].Files[0].Inode == 0 {
+		t.Skip("the platform does not provide inodes")
+	}
+	return rootPath, report
+}
+
+func TestFindDuplicatesHardLinks(t *testing.T) {
+	_, report := makeLinkedDuplicates(t)
+	// c.txt is only hard linked so it takes no extra space, and the links of a.txt and b.txt are one copy each
+	if len(report.Sets) != 1 {
+		t.Fatalf("expected only a and b to be duplicates: %+v", report.Sets)
+	}
+	set := report.Sets[0]
+	if set.NumCopies != 2 || len(set.Files) != 4 || set.WastedBytes != int64(len("same content")) || report.TotalWastedBytes != set.WastedBytes {
+		t.Errorf("expected 2 copies with every link listed: %+v", set)
+	}
+	for _, f := range set.Files {
+		if f.FileHash != set.FileHash {
+			t.Errorf("expected every link to have the hash of the set: %s", f.FullPath)
+		}
+	}
+	if report.NumFullHashed+report.NumPartialHashed != 2 {
+		t.Errorf("expected one hash per copy: %d %d", report.NumPartialHashed, report.NumFullHashed)
+	}
+}
@@ -0,0 +1,133 @@
+package spaceanalyzer
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/calvine/filejitsu/util/ignore"
+)
+
+// ScanFilter selects what a scan descends into and which files it reports. The zero value selects everything.
+// Paths are matched relative to the scan root with / separators, and excluded directories are never read.
+type ScanFilter struct {
+	// Excludes are gitignore style patterns for files and directories to leave out.
+	Excludes []string
+	// ExcludeRegexes leave out the files and directories whose relative path they match.
+	ExcludeRegexes []*regexp.Regexp
+	// Includes if set only files matching one of these gitignore style patterns, or inside a matching directory, are reported.
+	Includes []string
+	// IncludeRegexes if set only files whose relative path one of them matches are reported.
+	IncludeRegexes []*regexp.Regexp
+	// RespectIgnoreFiles if true .gitignore and .filejitsuignore files found while scanning are honored.
+	RespectIgnoreFiles bool
+	// MinSize files smaller than this are left out.
+	MinSize int64
+	// MaxSize if more than 0 files larger than this are left out.
+	MaxSize int64
+	// NewerThan if set files last modified before this are left out.
+	NewerThan time.Time
+	// OlderThan if set files last modified after this are left out.
+	OlderThan time.Time
+}
+
+// scanFilter is a ScanFilter with its patterns compiled for a scan root.
+type scanFilter struct {
+	ScanFilter
+	rootPath       string
+	excludeMatcher *ignore.Matcher
+	includeMatcher *ignore.Matcher
+}
+
+func (f ScanFilter) compile(rootPath string) (*scanFilter, error) {
+	absRootPath, err := filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	excludeMatcher, err := ignore.NewMatcher(f.Excludes)
+	if err != nil {
+		return nil, err
+	}
+	includeMatcher, err := ignore.NewMatcher(f.Includes)
+	if err != nil {
+		return nil, err
+	}
+	return &scanFilter{
+		ScanFilter:     f,
+		rootPath:       absRootPath,
+		excludeMatcher: excludeMatcher,
+		includeMatcher: includeMatcher,
+	}, nil
+}
+
+// relPath returns the slash separated path relative to the scan root, which is empty for the root itself.
+func (f *scanFilter) relPath(fullPath string) string {
+	rel, err := filepath.Rel(f.rootPath, fullPath)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// excluded reports if the entity should not be scanned at all. The scan root is never excluded.
+func (f *scanFilter) excluded(fullPath string, isDir bool) bool {
+	rel := f.relPath(fullPath)
+	if len(rel) == 0 {
+		return false
+	}
+	if f.excludeMatcher.Match(rel, isDir) {
+		return true
+	}
+	for _, r := range f.ExcludeRegexes {
+		if r.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadIgnoreFiles adds the rules of any ignore files in the directory if RespectIgnoreFiles is set. Returns the names of the files loaded.
+func (f *scanFilter) loadIgnoreFiles(dirPath string) ([]string, error) {
+	if !f.RespectIgnoreFiles {
+		return nil, nil
+	}
+	return f.excludeMatcher.AddIgnoreFilesInDir(f.relPath(dirPath), dirPath)
+}
+
+// includesFile reports if a file that was not excluded passes the include, size and modification time filters.
+func (f *scanFilter) includesFile(fullPath string, info fs.FileInfo) bool {
+	return f.includesEntity(fullPath, info.Mode().IsRegular(), info.Size(), info.ModTime())
+}
+
+// includesEntity is includesFile for a file known by its size and modification time, like one reused from a baseline.
+func (f *scanFilter) includesEntity(fullPath string, isRegular bool, size int64, modTime time.Time) bool {
+	rel := f.relPath(fullPath)
+	if len(rel) == 0 {
+		return true
+	}
+	if len(f.Includes) > 0 || len(f.IncludeRegexes) > 0 {
+		included := len(f.Includes) > 0 && f.includeMatcher.MatchPathOrParent(rel, false)
+		for _, r := range f.IncludeRegexes {
+			if included {
+				break
+			}
+			included = r.MatchString(rel)
+		}
+		if !included {
+			return false
+		}
+	}
+	if isRegular {
+		if size < f.MinSize || (f.MaxSize > 0 && size > f.MaxSize) {
+			return false
+		}
+	}
+	if !f.NewerThan.IsZero() && modTime.Before(f.NewerThan) {
+		return false
+	}
+	if !f.OlderThan.IsZero() && modTime.After(f.OlderThan) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package spaceanalyzer
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestScanFilter(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]int{
+		filepath.Join(".git", "HEAD"):                   10,
+		filepath.Join("src", "main.go"):                 100,
+		filepath.Join("src", "node_modules", "dep.js"):  100,
+		filepath.Join("src", "build.log"):               2000,
+		filepath.Join("docs", "readme.txt"):             500,
+		filepath.Join("docs", "old.txt"):                500,
+		filepath.Join("docs", "cache", "thumbnail.txt"): 500,
+		".gitignore": 0,
+	}
+	for name, size := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, make([]byte, size), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rootPath, "src", ".gitignore"), []byte("node_modules/\n"), 0644); err != nil {
+		t.Fatalf("failed to write ignore file: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(rootPath, "docs", "old.txt"), old, old); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	scanNames := func(filter ScanFilter) []string {
+		root, err := Scan(logger, ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+			Filter:       filter,
+		})
+		if err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		entities := make([]FSEntity, 0)
+		flattenEntity(root, &entities)
+		names := make([]string, 0)
+		for _, e := range entities {
+			rel, _ := filepath.Rel(rootPath, e.FullPath)
+			if e.EntityType == FileType {
+				names = append(names, filepath.ToSlash(rel))
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+	testCases := []struct {
+		name     string
+		filter   ScanFilter
+		expected []string
+	}{
+		{
+			name: "exclude patterns and ignore files",
+			filter: ScanFilter{
+				Excludes:           []string{".git/", "*.log"},
+				ExcludeRegexes:     []*regexp.Regexp{regexp.MustCompile(`(^|/)cache$`)},
+				RespectIgnoreFiles: true,
+			},
+			expected: []string{".gitignore", "docs/old.txt", "docs/readme.txt", "src/.gitignore", "src/main.go"},
+		},
+		{
+			name: "include patterns",
+			filter: ScanFilter{
+				Includes:       []string{"docs/cache/"},
+				IncludeRegexes: []*regexp.Regexp{regexp.MustCompile(`\.go$`)},
+			},
+			expected: []string{"docs/cache/thumbnail.txt", "src/main.go"},
+		},
+		{
+			name: "size",
+			filter: ScanFilter{
+				MinSize: 100,
+				MaxSize: 500,
+			},
+			expected: []string{"docs/cache/thumbnail.txt", "docs/old.txt", "docs/readme.txt", "src/main.go", "src/node_modules/dep.js"},
+		},
+		{
+			name: "modification time",
+			filter: ScanFilter{
+				Includes:  []string{"docs/"},
+				OlderThan: time.Now().Add(-24 * time.Hour),
+			},
+			expected: []string{"docs/old.txt"},
+		},
+		{
+			name: "newer than",
+			filter: ScanFilter{
+				Includes:  []string{"*.txt"},
+				NewerThan: time.Now().Add(-24 * time.Hour),
+			},
+			expected: []string{"docs/cache/thumbnail.txt", "docs/readme.txt"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			names := scanNames(tc.filter)
+			if !slices.Equal(names, tc.expected) {
+				t.Errorf("expected %v got %v", tc.expected, names)
+			}
+		})
+	}
+}
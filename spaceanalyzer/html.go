@@ -0,0 +1,258 @@
+package spaceanalyzer
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	// OutputFormatHTML is a single self contained HTML page with a treemap, the largest files, an extension breakdown and the duplicates.
+	OutputFormatHTML = "html"
+
+	// DefaultHTMLTopFiles is how many of the largest files and duplicate sets an HTML report lists.
+	DefaultHTMLTopFiles = 100
+
+	// htmlTreeMinFraction is the fraction of the total size below which the items in a directory are grouped into one treemap node,
+	// so the report of a scan with millions of files stays small enough for a browser.
+	htmlTreeMinFraction = 0.00001
+	// htmlMaxExtensions is how many extensions the breakdown lists before the rest are added up as other.
+	htmlMaxExtensions = 20
+)
+
+//go:embed html_report.tmpl
+var htmlReportTemplateText string
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(htmlReportTemplateText))
+
+type HTMLReportParams struct {
+	// SizeMode is the size the treemap, the tables and the charts use.
+	SizeMode SizeMode
+	// TopFiles is how many of the largest files and duplicate sets are listed. Defaults to DefaultHTMLTopFiles.
+	TopFiles int
+	// FindDuplicates if true files are hashed to find duplicates. Otherwise duplicates are only found among the files that already have a
+	// FileHash, like from a scan with CalculateFileHashes, so writing the report never reads the files.
+	FindDuplicates bool
+	// ConcurrencyLimit limits how many files are hashed at a time while finding duplicates.
+	ConcurrencyLimit int
+}
+
+// ExtensionStats is the number and total size of the files with an extension. Files without an extension have an empty Extension.
+type ExtensionStats struct {
+	Extension  string `json:"extension"`
+	NumFiles   int64  `json:"numFiles"`
+	Size       int64  `json:"size"`
+	PrettySize string `json:"prettySize"`
+}
+
+// htmlTreeNode is an entity in the treemap with short JSON names since every node is inlined in the page.
+type htmlTreeNode struct {
+	Name     string         `json:"n"`
+	Size     int64          `json:"s"`
+	NumFiles int64          `json:"f"`
+	IsDir    bool           `json:"d,omitempty"`
+	Children []htmlTreeNode `json:"c,omitempty"`
+	// Grouped is how many small items the node stands for, or 0 for an entity.
+	Grouped int `json:"g,omitempty"`
+}
+
+type htmlFile struct {
+	Path         string
+	Size         int64
+	PrettySize   string
+	Extension    string
+	LastModified time.Time
+}
+
+type htmlExtension struct {
+	ExtensionStats
+	SizePercent  float64
+	CountPercent float64
+}
+
+type htmlReport struct {
+	RootPath         string
+	GeneratedAt      time.Time
+	SizeMode         SizeMode
+	TotalSize        int64
+	PrettyTotalSize  string
+	NumFiles         int64
+	NumDirs          int64
+	Tree             htmlTreeNode
+	TopFiles         []htmlFile
+	Extensions       []htmlExtension
+	Duplicates       DuplicatesReport
+	NumDuplicateSets int
+	// SearchedDuplicates is false if no file was hashed or had a hash, so duplicates were not looked for.
+	SearchedDuplicates bool
+}
+
+// WriteHTMLReport writes a single HTML page for the scanned root that works offline, with everything it needs inlined.
+// The page has a zoomable treemap, a sortable table of the largest files, charts of the space and files by extension and the largest sets of duplicates.
+func WriteHTMLReport(logger *slog.Logger, w io.Writer, root FSEntity, params HTMLReportParams) error {
+	topFiles := params.TopFiles
+	if topFiles <= 0 {
+		topFiles = DefaultHTMLTopFiles
+	}
+	entities := make([]FSEntity, 0)
+	flattenEntity(root, &entities)
+	report := htmlReport{
+		RootPath:        root.FullPath,
+		GeneratedAt:     time.Now().UTC(),
+		SizeMode:        params.SizeMode,
+		TotalSize:       root.SizeFor(params.SizeMode),
+		PrettyTotalSize: util.GetPrettyBytesSize(root.SizeFor(params.SizeMode)),
+	}
+	files := make([]FSEntity, 0, len(entities))
+	for _, e := range entities {
+		if e.IsDir {
+			report.NumDirs++
+			continue
+		}
+		report.NumFiles++
+		if e.EntityType == FileType {
+			files = append(files, e)
+		}
+	}
+	report.Tree = newHTMLTreeNode(root, params.SizeMode, int64(float64(report.TotalSize)*htmlTreeMinFraction))
+
+	slices.SortFunc(files, func(a, b FSEntity) int {
+		if c := cmp.Compare(b.SizeFor(params.SizeMode), a.SizeFor(params.SizeMode)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.FullPath, b.FullPath)
+	})
+	for _, f := range files[:min(topFiles, len(files))] {
+		relPath, err := filepath.Rel(root.FullPath, f.FullPath)
+		if err != nil {
+			relPath = f.FullPath
+		}
+		size := f.SizeFor(params.SizeMode)
+		report.TopFiles = append(report.TopFiles, htmlFile{
+			Path:         filepath.ToSlash(relPath),
+			Size:         size,
+			PrettySize:   util.GetPrettyBytesSize(size),
+			Extension:    f.Extension,
+			LastModified: f.LastModified,
+		})
+	}
+
+	extensions := SummarizeExtensions(files, params.SizeMode)
+	if len(extensions) > htmlMaxExtensions {
+		other := ExtensionStats{Extension: "(other)"}
+		for _, e := range extensions[htmlMaxExtensions-1:] {
+			other.NumFiles += e.NumFiles
+			other.Size += e.Size
+		}
+		other.PrettySize = util.GetPrettyBytesSize(other.Size)
+		extensions = append(extensions[:htmlMaxExtensions-1], other)
+	}
+	var maxExtensionSize, maxExtensionCount int64
+	for _, e := range extensions {
+		maxExtensionSize = max(maxExtensionSize, e.Size)
+		maxExtensionCount = max(maxExtensionCount, e.NumFiles)
+	}
+	for _, e := range extensions {
+		extension := htmlExtension{ExtensionStats: e}
+		if maxExtensionSize > 0 {
+			extension.SizePercent = float64(e.Size) / float64(maxExtensionSize) * 100
+		}
+		if maxExtensionCount > 0 {
+			extension.CountPercent = float64(e.NumFiles) / float64(maxExtensionCount) * 100
+		}
+		report.Extensions = append(report.Extensions, extension)
+	}
+
+	hashed := files
+	if !params.FindDuplicates {
+		hashed = make([]FSEntity, 0)
+		for _, f := range files {
+			if len(f.FileHash) > 0 {
+				hashed = append(hashed, f)
+			}
+		}
+	}
+	report.SearchedDuplicates = len(hashed) > 0
+	report.Duplicates = GroupDuplicates(logger, hashed, DuplicatesParams{
+		ScanParams: ScanParams{
+			ConcurrencyLimit: params.ConcurrencyLimit,
+		},
+	})
+	report.NumDuplicateSets = len(report.Duplicates.Sets)
+	report.Duplicates.Sets = report.Duplicates.Sets[:min(topFiles, len(report.Duplicates.Sets))]
+
+	logger.Debug("writing html report", slog.Int64("numFiles", report.NumFiles), slog.Int("numExtensions", len(report.Extensions)), slog.Int("numDuplicateSets", report.NumDuplicateSets))
+	if err := htmlReportTemplate.Execute(w, report); err != nil {
+		return fmt.Errorf("failed to write html report: %w", err)
+	}
+	return nil
+}
+
+// newHTMLTreeNode converts the entity and everything below it. Children smaller than minSize are grouped into one node.
+func newHTMLTreeNode(e FSEntity, mode SizeMode, minSize int64) htmlTreeNode {
+	n := htmlTreeNode{
+		Name:  e.Name,
+		Size:  e.SizeFor(mode),
+		IsDir: e.IsDir,
+	}
+	if !e.IsDir {
+		n.NumFiles = 1
+		return n
+	}
+	grouped := htmlTreeNode{}
+	for _, c := range e.Children {
+		child := newHTMLTreeNode(c, mode, minSize)
+		n.NumFiles += child.NumFiles
+		if child.Size >= max(minSize, 1) {
+			n.Children = append(n.Children, child)
+			continue
+		}
+		grouped.Size += child.Size
+		grouped.NumFiles += child.NumFiles
+		grouped.Grouped++
+	}
+	if grouped.Grouped > 0 {
+		grouped.Name = fmt.Sprintf("(%d smaller items)", grouped.Grouped)
+		n.Children = append(n.Children, grouped)
+	}
+	return n
+}
+
+// SummarizeExtensions adds up the number and size of the regular files by lower case extension, largest first.
+func SummarizeExtensions(entities []FSEntity, mode SizeMode) []ExtensionStats {
+	byExtension := make(map[string]*ExtensionStats)
+	for _, e := range entities {
+		if e.EntityType != FileType {
+			continue
+		}
+		extension := strings.ToLower(e.Extension)
+		stats, ok := byExtension[extension]
+		if !ok {
+			stats = &ExtensionStats{Extension: extension}
+			byExtension[extension] = stats
+		}
+		stats.NumFiles++
+		stats.Size += e.SizeFor(mode)
+	}
+	summary := make([]ExtensionStats, 0, len(byExtension))
+	for _, stats := range byExtension {
+		stats.PrettySize = util.GetPrettyBytesSize(stats.Size)
+		summary = append(summary, *stats)
+	}
+	slices.SortFunc(summary, func(a, b ExtensionStats) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Extension, b.Extension)
+	})
+	return summary
+}
@@ -0,0 +1,94 @@
+package spaceanalyzer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	files := map[string][]byte{
+		filepath.Join("videos", "movie.MP4"):  bytes.Repeat([]byte("m"), 50000),
+		filepath.Join("videos", "copy.mp4"):   bytes.Repeat([]byte("m"), 50000),
+		filepath.Join("docs", "<script>.txt"): []byte("escaped"),
+		"README":                              []byte("no extension"),
+	}
+	for name, content := range files {
+		fullPath := filepath.Join(rootPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, content, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	root, err := Scan(logger, ScanParams{
+		RootPath:     rootPath,
+		MaxRecursion: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	var output bytes.Buffer
+	if err := WriteHTMLReport(logger, &output, root, HTMLReportParams{TopFiles: 3, FindDuplicates: true}); err != nil {
+		t.Fatalf("failed to write html report: %v", err)
+	}
+	page := output.String()
+	for _, expected := range []string{"<!DOCTYPE html>", "videos/movie.MP4", "48.83 KB", ".mp4", "(none)", "1 sets of files with identical content", "function squarify"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected the report to contain %q", expected)
+		}
+	}
+	// the page must work offline and file names must not be able to inject markup
+	for _, unexpected := range []string{"http://", "https://", "<script>.txt", "ZgotmplZ"} {
+		if strings.Contains(page, unexpected) {
+			t.Errorf("expected the report not to contain %q", unexpected)
+		}
+	}
+	if strings.Count(page, `<td class="path"`) != 3 {
+		t.Errorf("expected the top files table to be limited to 3 rows")
+	}
+
+	// without FindDuplicates only hashes already in the report are compared, so files without one are never read
+	output.Reset()
+	if err := WriteHTMLReport(logger, &output, root, HTMLReportParams{TopFiles: 3}); err != nil {
+		t.Fatalf("failed to write html report: %v", err)
+	}
+	if !strings.Contains(output.String(), "duplicates were not looked for") {
+		t.Errorf("expected duplicates not to be looked for without file hashes")
+	}
+	videos, _ := findEntity(root, "videos")
+	for i := range videos.Children {
+		videos.Children[i].FileHash = "recorded"
+	}
+	output.Reset()
+	if err := WriteHTMLReport(logger, &output, root, HTMLReportParams{TopFiles: 3}); err != nil {
+		t.Fatalf("failed to write html report: %v", err)
+	}
+	if !strings.Contains(output.String(), "1 sets of files with identical content") {
+		t.Errorf("expected duplicates to be found from the hashes in the report")
+	}
+}
+
+func TestNewHTMLTreeNodeGroupsSmallItems(t *testing.T) {
+	root := FSEntity{Name: "root", IsDir: true, Size: 1000}
+	root.Children = append(root.Children, FSEntity{Name: "big", Size: 970})
+	for i := 0; i < 3; i++ {
+		root.Children = append(root.Children, FSEntity{Name: fmt.Sprintf("small%d", i), Size: 10})
+	}
+	node := newHTMLTreeNode(root, SizeModeApparent, 100)
+	if len(node.Children) != 2 || node.NumFiles != 4 {
+		t.Fatalf("expected big and a group of the small files: %+v", node)
+	}
+	grouped := node.Children[1]
+	if grouped.Grouped != 3 || grouped.Size != 30 || grouped.Name != "(3 smaller items)" {
+		t.Errorf("expected the small files to be grouped: %+v", grouped)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !windows
+// +build !windows
+
+package spaceanalyzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestScanLinks(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	dataPath := filepath.Join(rootPath, "data")
+	if err := os.MkdirAll(filepath.Join(dataPath, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create dirs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataPath, "file.bin"), make([]byte, 1000), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Link(filepath.Join(dataPath, "file.bin"), filepath.Join(dataPath, "sub", "hardlink.bin")); err != nil {
+		t.Fatalf("failed to create hard link: %v", err)
+	}
+	if err := os.Symlink("data", filepath.Join(rootPath, "data-link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	// a cycle that would never end if it were followed without cycle detection
+	if err := os.Symlink("..", filepath.Join(dataPath, "sub", "up")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	scan := func(followSymlinks bool) (FSEntity, map[string]FSEntity) {
+		root, err := Scan(logger, ScanParams{
+			RootPath:       rootPath,
+			MaxRecursion:   -1,
+			FollowSymlinks: followSymlinks,
+		})
+		if err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		entities := make([]FSEntity, 0)
+		flattenEntity(root, &entities)
+		byPath := make(map[string]FSEntity)
+		for _, e := range entities {
+			rel, _ := filepath.Rel(rootPath, e.FullPath)
+			byPath[filepath.ToSlash(rel)] = e
+		}
+		return root, byPath
+	}
+
+	root, byPath := scan(false)
+	if root.Size != 1000 {
+		t.Errorf("expected the hard linked file to be counted once, got %d", root.Size)
+	}
+	file := byPath["data/file.bin"]
+	hardlink := byPath["data/sub/hardlink.bin"]
+	if file.NLink != 2 || file.Inode == 0 || file.Inode != hardlink.Inode || file.Device != hardlink.Device {
+		t.Errorf("expected both hard links to have the same inode and a link count of 2: %+v %+v", file, hardlink)
+	}
+	link := byPath["data-link"]
+	if link.LinkTarget != "data" || link.EntityType != OtherType || link.Size != 0 {
+		t.Errorf("expected the symlink to be reported without being followed: %+v", link)
+	}
+	if _, ok := byPath["data-link/file.bin"]; ok {
+		t.Errorf("expected the symlink to not be followed")
+	}
+
+	root, byPath = scan(true)
+	if root.Size != 1000 {
+		t.Errorf("expected every inode to be counted once when following symlinks, got %d", root.Size)
+	}
+	link = byPath["data-link"]
+	if link.LinkTarget != "data" || !link.IsDir {
+		t.Errorf("expected the symlink to be followed to the directory: %+v", link)
+	}
+	cycles := 0
+	for _, e := range byPath {
+		if e.ErrorMessage == ErrSymlinkCycle.Error() {
+			cycles++
+		}
+	}
+	if cycles != 2 {
+		t.Errorf("expected the directories reached twice to not be scanned again, got %d", cycles)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package spaceanalyzer
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// getFileIdentity returns the device and inode of the file along with its number of hard links if the platform provides them.
+func getFileIdentity(info fs.FileInfo) (fileIdentity, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileIdentity{}, 0
+	}
+	return fileIdentity{
+		device: uint64(stat.Dev),
+		inode:  uint64(stat.Ino),
+	}, uint64(stat.Nlink)
+}
+
+// getDiskSize returns the space allocated for the file from its number of 512 byte blocks, if the platform provides it.
+func getDiskSize(info fs.FileInfo) (int64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(stat.Blocks) * 512, true
+}
+
+// getOwner returns the user and group that own the file, if the platform provides them.
+func getOwner(info fs.FileInfo) (uint32, uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
@@ -5,4 +5,13 @@ type ScanParams struct {
 	MaxRecursion        int
 	CalculateFileHashes bool
 	ConcurrencyLimit    int
+	Filter              ScanFilter
+	// FollowSymlinks if true symlinks are scanned as what they point to. See WalkOptions.
+	FollowSymlinks bool
+	// OneFileSystem if true directories on a different device than the root path are not scanned.
+	OneFileSystem bool
+	// SizeMode is the size children are sorted by, largest first.
+	SizeMode SizeMode
+	// Baseline if set is a previous report of the root path that unchanged directories and files are reused from.
+	Baseline *Baseline
 }
@@ -0,0 +1,36 @@
+//go:build linux
+
+package spaceanalyzer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request, which the syscall package does not define.
+const ficlone = 0x40049409
+
+// reflinkFile creates a new file at dst that shares the data blocks of src, on file systems like btrfs and XFS that support it.
+func reflinkFile(src, dst string, perm fs.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dstFile.Fd(), ficlone, srcFile.Fd())
+	closeErr := dstFile.Close()
+	if errno != 0 {
+		if errors.Is(errno, syscall.EOPNOTSUPP) || errors.Is(errno, syscall.ENOTTY) || errors.Is(errno, syscall.EXDEV) || errors.Is(errno, syscall.EINVAL) {
+			return fmt.Errorf("%w: %w", ErrReflinkUnsupported, errno)
+		}
+		return errno
+	}
+	return closeErr
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package spaceanalyzer
+
+import "io/fs"
+
+// reflinkFile is only supported on Linux.
+func reflinkFile(src, dst string, perm fs.FileMode) error {
+	return ErrReflinkUnsupported
+}
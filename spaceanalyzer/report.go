@@ -0,0 +1,146 @@
+package spaceanalyzer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/calvine/filejitsu/util/ignore"
+	"github.com/calvine/filejitsu/util/streamingjson"
+)
+
+const (
+	// OutputFormatJSON is a single JSON object of the root entity with its children nested in it.
+	OutputFormatJSON = "json"
+	// OutputFormatStreamingJSON is a length prefixed stream of every entity with children written before their parent.
+	OutputFormatStreamingJSON = "sjson"
+)
+
+var (
+	ErrUnknownReportFormat = errors.New("unknown space analyzer report format")
+	ErrNoReportRoot        = errors.New("space analyzer report does not contain a root entity")
+)
+
+// ReportFilter selects entities from a report. The zero value selects every entity except the root.
+type ReportFilter struct {
+	// EntityType if set only entities of this type are selected.
+	EntityType EntityType
+	// MinSize only entities at least this many bytes are selected.
+	MinSize int64
+	// Include if set only entities matching one of these gitignore style patterns are selected. Patterns are relative to the report root.
+	Include []string
+}
+
+// ReadReport reads a report written by the space-analyzer command in the given format and returns every entity in it with their children removed.
+func ReadReport(ctx context.Context, r io.Reader, format string) ([]FSEntity, error) {
+	switch format {
+	case OutputFormatJSON:
+		root := FSEntity{}
+		if err := json.NewDecoder(r).Decode(&root); err != nil {
+			return nil, fmt.Errorf("failed to read space analyzer report: %w", err)
+		}
+		entities := make([]FSEntity, 0)
+		flattenEntity(root, &entities)
+		return entities, nil
+	case OutputFormatStreamingJSON:
+		streamer := streamingjson.NewLengthPrefixStreamJSONHandler[FSEntity]()
+		_, entities, err := streamer.ReadAll(ctx, bufio.NewReader(r))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read space analyzer report: %w", err)
+		}
+		return entities, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownReportFormat, format)
+}
+
+// ReadReportTree reads a report like ReadReport and returns its root entity with every entity nested under its parent again.
+func ReadReportTree(ctx context.Context, r io.Reader, format string) (FSEntity, error) {
+	entities, err := ReadReport(ctx, r, format)
+	if err != nil {
+		return FSEntity{}, err
+	}
+	return BuildTree(entities)
+}
+
+// BuildTree nests the entities under their parents by ParentID and returns the root, the first entity without a ParentID.
+// Children keep the order they have in entities, and entities whose parent is not in entities are left out.
+func BuildTree(entities []FSEntity) (FSEntity, error) {
+	rootIndex := -1
+	childIndexes := make(map[string][]int)
+	for i, e := range entities {
+		if len(e.ParentID) == 0 {
+			if rootIndex < 0 {
+				rootIndex = i
+			}
+			continue
+		}
+		childIndexes[e.ParentID] = append(childIndexes[e.ParentID], i)
+	}
+	if rootIndex < 0 {
+		return FSEntity{}, ErrNoReportRoot
+	}
+	var build func(i int) FSEntity
+	build = func(i int) FSEntity {
+		e := entities[i]
+		e.Children = nil
+		for _, c := range childIndexes[e.ID] {
+			e.Children = append(e.Children, build(c))
+		}
+		return e
+	}
+	return build(rootIndex), nil
+}
+
+func flattenEntity(e FSEntity, entities *[]FSEntity) {
+	children := e.Children
+	e.Children = nil
+	*entities = append(*entities, e)
+	for _, c := range children {
+		flattenEntity(c, entities)
+	}
+}
+
+// FilterReportPaths returns the full paths of the entities selected by the filter in report order.
+func FilterReportPaths(entities []FSEntity, filter ReportFilter) ([]string, error) {
+	var root *FSEntity
+	for i, e := range entities {
+		if len(e.ParentID) == 0 {
+			root = &entities[i]
+			break
+		}
+	}
+	if root == nil {
+		return nil, ErrNoReportRoot
+	}
+	matcher, err := ignore.NewMatcher(filter.Include)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, e := range entities {
+		if e.ID == root.ID {
+			continue
+		}
+		if len(filter.EntityType) > 0 && e.EntityType != filter.EntityType {
+			continue
+		}
+		if e.Size < filter.MinSize {
+			continue
+		}
+		if len(filter.Include) > 0 {
+			relPath, err := filepath.Rel(root.FullPath, e.FullPath)
+			if err != nil {
+				return nil, err
+			}
+			if !matcher.MatchPathOrParent(filepath.ToSlash(relPath), e.IsDir) {
+				continue
+			}
+		}
+		paths = append(paths, e.FullPath)
+	}
+	return paths, nil
+}
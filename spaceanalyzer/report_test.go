@@ -0,0 +1,30 @@
+package spaceanalyzer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBuildTree(t *testing.T) {
+	// streaming reports list children before their parent
+	entities := []FSEntity{
+		{ID: "c", ParentID: "b", Name: "c.txt"},
+		{ID: "b", ParentID: "a", Name: "nested", IsDir: true},
+		{ID: "d", ParentID: "a", Name: "d.txt"},
+		{ID: "a", Name: "root", IsDir: true},
+		{ID: "e", ParentID: "missing", Name: "orphan.txt"},
+	}
+	root, err := BuildTree(entities)
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	if root.Name != "root" || len(root.Children) != 2 {
+		t.Fatalf("expected the root with 2 children: %+v", root)
+	}
+	if root.Children[0].Name != "nested" || len(root.Children[0].Children) != 1 || root.Children[0].Children[0].Name != "c.txt" {
+		t.Errorf("expected c.txt nested under nested: %+v", root.Children[0])
+	}
+	if _, err := BuildTree(entities[:3]); !errors.Is(err, ErrNoReportRoot) {
+		t.Errorf("expected ErrNoReportRoot without a root: %v", err)
+	}
+}
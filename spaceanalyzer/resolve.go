@@ -0,0 +1,529 @@
+package spaceanalyzer
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/calvine/filejitsu/util"
+	"github.com/google/uuid"
+)
+
+const (
+	ActionDelete   = "delete"
+	ActionHardlink = "hardlink"
+	ActionSymlink  = "symlink"
+	ActionReflink  = "reflink"
+
+	KeepOldest       = "oldest"
+	KeepNewest       = "newest"
+	KeepShortestPath = "shortest-path"
+	KeepPrefix       = "prefix"
+
+	ResolveStatusPlanned  = "planned"
+	ResolveStatusResolved = "resolved"
+	ResolveStatusSkipped  = "skipped"
+	ResolveStatusFailed   = "failed"
+
+	// UndoLogVersion is the current version of the undo log format.
+	UndoLogVersion = 1
+
+	compareBufferSize = 64 * 1024
+
+	// sameFileSkipReason is why a hard link to the kept copy is skipped, there is nothing to reclaim from it.
+	sameFileSkipReason = "already the same file as the kept copy"
+)
+
+var (
+	ErrUnknownAction        = errors.New("unknown duplicate action")
+	ErrUnknownKeepRule      = errors.New("unknown keep rule")
+	ErrUndoLogRequired      = errors.New("an undo log path is required to act on duplicates")
+	ErrReflinkUnsupported   = errors.New("reflinks are not supported on this platform or file system")
+	ErrContentMismatch      = errors.New("file content does not match the kept copy")
+	ErrDuplicatesUnresolved = errors.New("some duplicates could not be resolved")
+	ErrDuplicatesUnrestored = errors.New("some duplicates could not be restored")
+	ErrUnsupportedUndoLog   = errors.New("unsupported undo log version")
+)
+
+type ResolveParams struct {
+	Action string
+	Keep   string
+	// KeepPrefixes are the path prefixes used by KeepPrefix in priority order. The file matching the earliest prefix is kept.
+	KeepPrefixes []string
+	// Execute if false only the plan is returned and nothing is changed.
+	Execute     bool
+	UndoLogPath string
+}
+
+type ResolvedFile struct {
+	FullPath     string `json:"fullPath"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+type ResolvedSet struct {
+	FileHash   string         `json:"fileHash"`
+	Size       int64          `json:"size"`
+	Keep       string         `json:"keep"`
+	Duplicates []ResolvedFile `json:"duplicates"`
+}
+
+// ResolveReport is the plan for a dry run, or what was done to every duplicate.
+type ResolveReport struct {
+	Action      string `json:"action"`
+	Keep        string `json:"keep"`
+	DryRun      bool   `json:"dryRun"`
+	UndoLogPath string `json:"undoLogPath,omitempty"`
+	NumPlanned  int    `json:"numPlanned"`
+	NumResolved int    `json:"numResolved"`
+	NumSkipped  int    `json:"numSkipped"`
+	NumFailed   int    `json:"numFailed"`
+	// ReclaimableBytes is the space the plan would reclaim. Links and reflinks to the kept copy reclaim the same as deleting.
+	// A file with several hard links is only reclaimed once, and only once every link to it is resolved.
+	ReclaimableBytes       int64         `json:"reclaimableBytes"`
+	PrettyReclaimableBytes string        `json:"prettyReclaimableBytes"`
+	ReclaimedBytes         int64         `json:"reclaimedBytes"`
+	PrettyReclaimedBytes   string        `json:"prettyReclaimedBytes"`
+	Sets                   []ResolvedSet `json:"sets"`
+}
+
+// UndoLog records every duplicate an action was planned for along with the metadata needed to put an independent copy back.
+type UndoLog struct {
+	Version   int         `json:"version"`
+	Action    string      `json:"action"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Entries   []UndoEntry `json:"entries"`
+}
+
+type UndoEntry struct {
+	KeptPath     string      `json:"keptPath"`
+	FullPath     string      `json:"fullPath"`
+	Size         int64       `json:"size"`
+	FileHash     string      `json:"fileHash"`
+	Permissions  fs.FileMode `json:"permissions"`
+	LastModified time.Time   `json:"lastModified"`
+	// Done is false if the action was not finished, like when the run was interrupted.
+	Done bool `json:"done"`
+}
+
+// ValidateResolveParams checks the action and keep rule are known.
+func ValidateResolveParams(params ResolveParams) error {
+	switch params.Action {
+	case ActionDelete, ActionHardlink, ActionSymlink, ActionReflink:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownAction, params.Action)
+	}
+	switch params.Keep {
+	case KeepOldest, KeepNewest, KeepShortestPath:
+	case KeepPrefix:
+		if len(params.KeepPrefixes) == 0 {
+			return fmt.Errorf("%w: %s requires at least one prefix", ErrUnknownKeepRule, params.Keep)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownKeepRule, params.Keep)
+	}
+	if params.Execute && len(params.UndoLogPath) == 0 {
+		return ErrUndoLogRequired
+	}
+	return nil
+}
+
+// chooseKeep returns the index of the file the keep rule keeps. Ties are broken by the shortest and then the lowest path.
+func chooseKeep(files []FSEntity, params ResolveParams) int {
+	prefixRank := func(e FSEntity) int {
+		for i, prefix := range params.KeepPrefixes {
+			if strings.HasPrefix(e.FullPath, prefix) {
+				return i
+			}
+		}
+		return len(params.KeepPrefixes)
+	}
+	byPath := func(a, b FSEntity) int {
+		if c := cmp.Compare(len(a.FullPath), len(b.FullPath)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.FullPath, b.FullPath)
+	}
+	compare := func(a, b FSEntity) int {
+		var c int
+		switch params.Keep {
+		case KeepOldest:
+			c = a.LastModified.Compare(b.LastModified)
+		case KeepNewest:
+			c = b.LastModified.Compare(a.LastModified)
+		case KeepPrefix:
+			c = cmp.Compare(prefixRank(a), prefixRank(b))
+		}
+		if c != 0 {
+			return c
+		}
+		return byPath(a, b)
+	}
+	keep := 0
+	for i := range files {
+		if compare(files[i], files[keep]) < 0 {
+			keep = i
+		}
+	}
+	return keep
+}
+
+// ResolveDuplicates plans which file of every duplicate set to keep and what to do with the others. Unless Execute is set only the plan is returned.
+// When executing, the undo log is written before anything is changed, and each duplicate is compared byte for byte with the kept copy right before it is acted on.
+// The report is returned with ErrDuplicatesUnresolved if any duplicate failed.
+func ResolveDuplicates(logger *slog.Logger, duplicates DuplicatesReport, params ResolveParams) (ResolveReport, error) {
+	report := ResolveReport{
+		Action:      params.Action,
+		Keep:        params.Keep,
+		DryRun:      !params.Execute,
+		UndoLogPath: params.UndoLogPath,
+		Sets:        make([]ResolvedSet, 0, len(duplicates.Sets)),
+	}
+	if err := ValidateResolveParams(params); err != nil {
+		logger.Error("invalid resolve params", slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	undoLog := UndoLog{
+		Version:   UndoLogVersion,
+		Action:    params.Action,
+		CreatedAt: time.Now().UTC(),
+		Entries:   make([]UndoEntry, 0),
+	}
+	kept := make([]FSEntity, 0, len(duplicates.Sets))
+	// remainingLinks counts the planned links to each file, so its space is only counted as reclaimed once the last one is resolved
+	remainingLinks := make(map[fileIdentity]int)
+	entryIdentities := make([]fileIdentity, 0)
+	for _, set := range duplicates.Sets {
+		keep := chooseKeep(set.Files, params)
+		keepIdentity, keepHasIdentity := set.Files[keep].identity()
+		resolved := ResolvedSet{
+			FileHash:   set.FileHash,
+			Size:       set.Size,
+			Keep:       set.Files[keep].FullPath,
+			Duplicates: make([]ResolvedFile, 0, len(set.Files)-1),
+		}
+		for i, f := range set.Files {
+			if i == keep {
+				continue
+			}
+			identity, hasIdentity := f.identity()
+			if keepHasIdentity && hasIdentity && identity == keepIdentity {
+				resolved.Duplicates = append(resolved.Duplicates, ResolvedFile{
+					FullPath:     f.FullPath,
+					Status:       ResolveStatusSkipped,
+					ErrorMessage: sameFileSkipReason,
+				})
+				report.NumSkipped++
+				continue
+			}
+			resolved.Duplicates = append(resolved.Duplicates, ResolvedFile{
+				FullPath: f.FullPath,
+				Status:   ResolveStatusPlanned,
+			})
+			undoLog.Entries = append(undoLog.Entries, UndoEntry{
+				KeptPath:     resolved.Keep,
+				FullPath:     f.FullPath,
+				Size:         f.Size,
+				FileHash:     set.FileHash,
+				Permissions:  fs.FileMode(f.Permissions),
+				LastModified: f.LastModified,
+			})
+			entryIdentities = append(entryIdentities, identity)
+			report.NumPlanned++
+			if hasIdentity {
+				remainingLinks[identity]++
+				if remainingLinks[identity] > 1 {
+					continue
+				}
+			}
+			report.ReclaimableBytes += set.Size
+		}
+		report.Sets = append(report.Sets, resolved)
+		kept = append(kept, set.Files[keep])
+	}
+	report.PrettyReclaimableBytes = util.GetPrettyBytesSize(report.ReclaimableBytes)
+	if !params.Execute {
+		logger.Info("dry run, nothing changed", slog.Int("numPlanned", report.NumPlanned), slog.Int64("reclaimableBytes", report.ReclaimableBytes))
+		report.PrettyReclaimedBytes = util.GetPrettyBytesSize(0)
+		return report, nil
+	}
+	if err := writeUndoLog(params.UndoLogPath, undoLog); err != nil {
+		logger.Error("failed to write undo log, nothing changed", slog.String("undoLogPath", params.UndoLogPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	entry := 0
+	for s := range report.Sets {
+		for d := range report.Sets[s].Duplicates {
+			resolved := &report.Sets[s].Duplicates[d]
+			if resolved.Status == ResolveStatusSkipped {
+				// hard links to the kept copy were skipped when planning and have no undo log entry
+				continue
+			}
+			skipReason, err := resolveDuplicate(kept[s], undoLog.Entries[entry], params.Action)
+			switch {
+			case err != nil:
+				logger.Error("failed to resolve duplicate", slog.String("fullPath", resolved.FullPath), slog.String("errorMessage", err.Error()))
+				resolved.Status = ResolveStatusFailed
+				resolved.ErrorMessage = err.Error()
+				report.NumFailed++
+			case len(skipReason) > 0:
+				logger.Warn("skipping duplicate", slog.String("fullPath", resolved.FullPath), slog.String("reason", skipReason))
+				resolved.Status = ResolveStatusSkipped
+				resolved.ErrorMessage = skipReason
+				report.NumSkipped++
+			default:
+				logger.Debug("resolved duplicate", slog.String("fullPath", resolved.FullPath), slog.String("action", params.Action))
+				resolved.Status = ResolveStatusResolved
+				undoLog.Entries[entry].Done = true
+				report.NumResolved++
+				if identity := entryIdentities[entry]; identity.inode != 0 {
+					remainingLinks[identity]--
+					if remainingLinks[identity] > 0 {
+						break
+					}
+				}
+				report.ReclaimedBytes += report.Sets[s].Size
+			}
+			entry++
+		}
+	}
+	report.PrettyReclaimedBytes = util.GetPrettyBytesSize(report.ReclaimedBytes)
+	if err := writeUndoLog(params.UndoLogPath, undoLog); err != nil {
+		logger.Error("failed to update undo log", slog.String("undoLogPath", params.UndoLogPath), slog.String("errorMessage", err.Error()))
+		return report, err
+	}
+	logger.Info("resolved duplicates", slog.Int("numResolved", report.NumResolved), slog.Int("numSkipped", report.NumSkipped), slog.Int("numFailed", report.NumFailed), slog.Int64("reclaimedBytes", report.ReclaimedBytes))
+	if report.NumFailed > 0 {
+		return report, ErrDuplicatesUnresolved
+	}
+	return report, nil
+}
+
+// resolveDuplicate verifies the duplicate still matches the kept copy and acts on it. A duplicate that is already a link to the kept copy is skipped with a reason.
+func resolveDuplicate(keep FSEntity, entry UndoEntry, action string) (string, error) {
+	keepInfo, err := os.Stat(keep.FullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat kept copy: %w", err)
+	}
+	info, err := os.Lstat(entry.FullPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "no longer a regular file", nil
+	}
+	if os.SameFile(keepInfo, info) {
+		return sameFileSkipReason, nil
+	}
+	same, err := sameContent(keep.FullPath, entry.FullPath)
+	if err != nil {
+		return "", err
+	}
+	if !same {
+		return "", ErrContentMismatch
+	}
+	switch action {
+	case ActionDelete:
+		return "", os.Remove(entry.FullPath)
+	case ActionHardlink:
+		return "", replaceFile(entry.FullPath, func(tmpPath string) error {
+			return os.Link(keep.FullPath, tmpPath)
+		})
+	case ActionSymlink:
+		return "", replaceFile(entry.FullPath, func(tmpPath string) error {
+			return os.Symlink(keep.FullPath, tmpPath)
+		})
+	case ActionReflink:
+		return "", replaceFile(entry.FullPath, func(tmpPath string) error {
+			if err := reflinkFile(keep.FullPath, tmpPath, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+		})
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownAction, action)
+}
+
+// replaceFile creates a new file next to the path with the create function and renames it over the path, so the path is never missing.
+func replaceFile(path string, create func(tmpPath string) error) error {
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%s.tmp", filepath.Base(path), uuid.New().String()))
+	if err := create(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// sameContent compares two files byte for byte.
+func sameContent(pathA, pathB string) (bool, error) {
+	a, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	b, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+	bufA := make([]byte, compareBufferSize)
+	bufB := make([]byte, compareBufferSize)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		doneA := errA == io.EOF || errors.Is(errA, io.ErrUnexpectedEOF)
+		doneB := errB == io.EOF || errors.Is(errB, io.ErrUnexpectedEOF)
+		if errA != nil && !doneA {
+			return false, errA
+		}
+		if errB != nil && !doneB {
+			return false, errB
+		}
+		if doneA || doneB {
+			return doneA == doneB, nil
+		}
+	}
+}
+
+func writeUndoLog(path string, undoLog UndoLog) error {
+	data, err := json.MarshalIndent(undoLog, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, func(tmpPath string) error {
+		return os.WriteFile(tmpPath, data, 0644)
+	})
+}
+
+// ReadUndoLog reads an undo log written by ResolveDuplicates.
+func ReadUndoLog(path string) (UndoLog, error) {
+	undoLog := UndoLog{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return undoLog, err
+	}
+	if err := json.Unmarshal(data, &undoLog); err != nil {
+		return undoLog, fmt.Errorf("failed to read undo log: %w", err)
+	}
+	if undoLog.Version != UndoLogVersion {
+		return undoLog, fmt.Errorf("%w: %d", ErrUnsupportedUndoLog, undoLog.Version)
+	}
+	return undoLog, nil
+}
+
+type RestoredFile struct {
+	FullPath     string `json:"fullPath"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+type RestoreReport struct {
+	Action      string         `json:"action"`
+	NumRestored int            `json:"numRestored"`
+	NumSkipped  int            `json:"numSkipped"`
+	NumFailed   int            `json:"numFailed"`
+	Files       []RestoredFile `json:"files"`
+}
+
+// RestoreDuplicates undoes an undo log by putting an independent copy of the kept file back at every duplicate path that was deleted
+// or replaced by a link. Entries that were not finished are checked as well, and files that are already independent are skipped.
+// Each copy is checked against the recorded hash, and the recorded permissions and modification time are restored.
+func RestoreDuplicates(logger *slog.Logger, undoLog UndoLog) (RestoreReport, error) {
+	report := RestoreReport{
+		Action: undoLog.Action,
+		Files:  make([]RestoredFile, 0, len(undoLog.Entries)),
+	}
+	for _, entry := range undoLog.Entries {
+		restored := RestoredFile{
+			FullPath: entry.FullPath,
+		}
+		skipReason, err := restoreDuplicate(entry)
+		switch {
+		case err != nil:
+			logger.Error("failed to restore duplicate", slog.String("fullPath", entry.FullPath), slog.String("errorMessage", err.Error()))
+			restored.Status = ResolveStatusFailed
+			restored.ErrorMessage = err.Error()
+			report.NumFailed++
+		case len(skipReason) > 0:
+			logger.Debug("skipping restore", slog.String("fullPath", entry.FullPath), slog.String("reason", skipReason))
+			restored.Status = ResolveStatusSkipped
+			restored.ErrorMessage = skipReason
+			report.NumSkipped++
+		default:
+			restored.Status = ResolveStatusResolved
+			report.NumRestored++
+		}
+		report.Files = append(report.Files, restored)
+	}
+	logger.Info("restored duplicates", slog.Int("numRestored", report.NumRestored), slog.Int("numSkipped", report.NumSkipped), slog.Int("numFailed", report.NumFailed))
+	if report.NumFailed > 0 {
+		return report, ErrDuplicatesUnrestored
+	}
+	return report, nil
+}
+
+func restoreDuplicate(entry UndoEntry) (string, error) {
+	info, err := os.Lstat(entry.FullPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err == nil && info.Mode().IsRegular() {
+		keepInfo, err := os.Stat(entry.KeptPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat kept copy: %w", err)
+		}
+		if !os.SameFile(keepInfo, info) {
+			return "already an independent file", nil
+		}
+	} else if err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		return "", fmt.Errorf("path is not a file or symlink: %s", entry.FullPath)
+	}
+	return "", replaceFile(entry.FullPath, func(tmpPath string) error {
+		return copyVerified(entry, tmpPath)
+	})
+}
+
+// copyVerified copies the kept file to the path and checks the copy has the recorded hash, in case the kept copy changed since it was logged.
+func copyVerified(entry UndoEntry, path string) error {
+	src, err := os.Open(entry.KeptPath)
+	if err != nil {
+		return fmt.Errorf("failed to open kept copy: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.Permissions.Perm())
+	if err != nil {
+		return err
+	}
+	// the hash is taken of what was copied, so a change to the kept copy during the copy is caught too
+	hasher := sha512.New()
+	_, copyErr := io.Copy(io.MultiWriter(dst, hasher), src)
+	closeErr := dst.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != entry.FileHash {
+		return ErrContentMismatch
+	}
+	if err := os.Chmod(path, entry.Permissions.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, entry.LastModified, entry.LastModified)
+}
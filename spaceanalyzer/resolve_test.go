@@ -0,0 +1,211 @@
+package spaceanalyzer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestChooseKeep(t *testing.T) {
+	now := time.Now()
+	files := []FSEntity{
+		{FullPath: "/data/backup/old/report.pdf", LastModified: now.Add(-2 * time.Hour)},
+		{FullPath: "/data/report.pdf", LastModified: now},
+		{FullPath: "/data/projects/report.pdf", LastModified: now.Add(-time.Hour)},
+	}
+	testCases := []struct {
+		name     string
+		params   ResolveParams
+		expected int
+	}{
+		{name: "oldest", params: ResolveParams{Keep: KeepOldest}, expected: 0},
+		{name: "newest", params: ResolveParams{Keep: KeepNewest}, expected: 1},
+		{name: "shortest path", params: ResolveParams{Keep: KeepShortestPath}, expected: 1},
+		{name: "prefix", params: ResolveParams{Keep: KeepPrefix, KeepPrefixes: []string{"/data/projects/", "/data/backup/"}}, expected: 2},
+		{name: "no prefix matches", params: ResolveParams{Keep: KeepPrefix, KeepPrefixes: []string{"/other/"}}, expected: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if keep := chooseKeep(files, tc.params); keep != tc.expected {
+				t.Errorf("expected to keep %s but kept %s", files[tc.expected].FullPath, files[keep].FullPath)
+			}
+		})
+	}
+}
+
+func makeDuplicateFiles(t *testing.T, content map[string]string) (string, DuplicatesReport) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	for name, data := range content {
+		if err := os.WriteFile(filepath.Join(rootPath, name), []byte(data), 0640); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	report, err := FindDuplicates(logger, DuplicatesParams{
+		ScanParams: ScanParams{
+			RootPath:     rootPath,
+			MaxRecursion: -1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	return rootPath, report
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	logger := mock.NewMockLogger()
+	content := map[string]string{
+		"a.txt":   "same content",
+		"bb.txt":  "same content",
+		"ccc.txt": "same content",
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		rootPath, duplicates := makeDuplicateFiles(t, content)
+		report, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action: ActionDelete,
+			Keep:   KeepShortestPath,
+		})
+		if err != nil {
+			t.Fatalf("failed to plan: %v", err)
+		}
+		if !report.DryRun || report.NumPlanned != 2 || report.Sets[0].Keep != filepath.Join(rootPath, "a.txt") {
+			t.Errorf("expected a plan keeping a.txt: %+v", report)
+		}
+		for name := range content {
+			if _, err := os.Stat(filepath.Join(rootPath, name)); err != nil {
+				t.Errorf("expected dry run to leave %s: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("hardlink", func(t *testing.T) {
+		rootPath, duplicates := makeDuplicateFiles(t, content)
+		undoLogPath := filepath.Join(t.TempDir(), "undo.json")
+		report, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action:      ActionHardlink,
+			Keep:        KeepShortestPath,
+			Execute:     true,
+			UndoLogPath: undoLogPath,
+		})
+		if err != nil || report.NumResolved != 2 {
+			t.Fatalf("failed to hardlink duplicates: %v %+v", err, report)
+		}
+		keepInfo, _ := os.Stat(filepath.Join(rootPath, "a.txt"))
+		for _, name := range []string{"bb.txt", "ccc.txt"} {
+			info, err := os.Stat(filepath.Join(rootPath, name))
+			if err != nil || !os.SameFile(keepInfo, info) {
+				t.Errorf("expected %s to be a hard link to the kept copy: %v", name, err)
+			}
+		}
+		undoLog, err := ReadUndoLog(undoLogPath)
+		if err != nil {
+			t.Fatalf("failed to read undo log: %v", err)
+		}
+		if _, err := RestoreDuplicates(logger, undoLog); err != nil {
+			t.Fatalf("failed to restore duplicates: %v", err)
+		}
+		for _, name := range []string{"bb.txt", "ccc.txt"} {
+			info, err := os.Stat(filepath.Join(rootPath, name))
+			if err != nil || os.SameFile(keepInfo, info) || info.Mode().Perm() != 0640 {
+				t.Errorf("expected %s to be an independent copy again: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		rootPath, duplicates := makeDuplicateFiles(t, content)
+		undoLogPath := filepath.Join(t.TempDir(), "undo.json")
+		_, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action:       ActionDelete,
+			Keep:         KeepPrefix,
+			KeepPrefixes: []string{filepath.Join(rootPath, "ccc")},
+			Execute:      true,
+			UndoLogPath:  undoLogPath,
+		})
+		if err != nil {
+			t.Fatalf("failed to delete duplicates: %v", err)
+		}
+		for _, name := range []string{"a.txt", "bb.txt"} {
+			if _, err := os.Stat(filepath.Join(rootPath, name)); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be deleted: %v", name, err)
+			}
+		}
+		undoLog, err := ReadUndoLog(undoLogPath)
+		if err != nil {
+			t.Fatalf("failed to read undo log: %v", err)
+		}
+		report, err := RestoreDuplicates(logger, undoLog)
+		if err != nil || report.NumRestored != 2 {
+			t.Fatalf("failed to restore duplicates: %v %+v", err, report)
+		}
+		for name, expected := range content {
+			data, err := os.ReadFile(filepath.Join(rootPath, name))
+			if err != nil || string(data) != expected {
+				t.Errorf("expected %s to be restored: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("changed after scan", func(t *testing.T) {
+		rootPath, duplicates := makeDuplicateFiles(t, content)
+		if err := os.WriteFile(filepath.Join(rootPath, "ccc.txt"), []byte("SAME CONTENT"), 0640); err != nil {
+			t.Fatalf("failed to change file: %v", err)
+		}
+		report, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action:      ActionDelete,
+			Keep:        KeepShortestPath,
+			Execute:     true,
+			UndoLogPath: filepath.Join(t.TempDir(), "undo.json"),
+		})
+		if !errors.Is(err, ErrDuplicatesUnresolved) || report.NumResolved != 1 || report.NumFailed != 1 {
+			t.Errorf("expected the changed file to fail verification: %v %+v", err, report)
+		}
+		if _, err := os.Stat(filepath.Join(rootPath, "ccc.txt")); err != nil {
+			t.Errorf("expected the changed file to be left: %v", err)
+		}
+	})
+
+	t.Run("hard links", func(t *testing.T) {
+		rootPath, duplicates := makeLinkedDuplicates(t)
+		report, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action:      ActionDelete,
+			Keep:        KeepShortestPath,
+			Execute:     true,
+			UndoLogPath: filepath.Join(t.TempDir(), "undo.json"),
+		})
+		if err != nil {
+			t.Fatalf("failed to delete duplicates: %v", err)
+		}
+		// the link to the kept a.txt is left, and b.txt is only reclaimed once with both of its links deleted
+		size := int64(len("same content"))
+		if report.NumPlanned != 2 || report.NumSkipped != 1 || report.ReclaimableBytes != size || report.ReclaimedBytes != size {
+			t.Errorf("expected both links of b.txt to be deleted for one copy: %+v", report)
+		}
+		if _, err := os.Stat(filepath.Join(rootPath, "a.txt.link")); err != nil {
+			t.Errorf("expected the link to the kept copy to be left: %v", err)
+		}
+		for _, name := range []string{"b.txt", "b.txt.link"} {
+			if _, err := os.Stat(filepath.Join(rootPath, name)); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be deleted: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("execute requires undo log", func(t *testing.T) {
+		_, duplicates := makeDuplicateFiles(t, content)
+		_, err := ResolveDuplicates(logger, duplicates, ResolveParams{
+			Action:  ActionDelete,
+			Keep:    KeepShortestPath,
+			Execute: true,
+		})
+		if !errors.Is(err, ErrUndoLogRequired) {
+			t.Errorf("expected ErrUndoLogRequired, got %v", err)
+		}
+	})
+}
@@ -1,40 +1,96 @@
 package spaceanalyzer
 
 import (
+	"cmp"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"slices"
 
 	"log/slog"
 
 	"github.com/calvine/filejitsu/util"
 )
 
-func populateExtraSizeInfo(item *FSEntity) {
-	if len(item.Children) > 0 {
-		var size int64
-		for index, childItem := range item.Children {
-			populateExtraSizeInfo(&childItem)
-			item.Children[index] = childItem
-			size += childItem.Size
+// fileIdentity is the device and inode of a file, which is the same for every hard link to it.
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+// identity returns the device and inode of the entity, and false if the platform did not provide them.
+func (e FSEntity) identity() (fileIdentity, bool) {
+	return fileIdentity{device: e.Device, inode: e.Inode}, e.Inode != 0
+}
+
+// populateExtraSizeInfo sets the apparent and disk size of directories to the total of their children, plus the blocks of the directory itself for the disk size,
+// and returns the sizes the item adds to its parent. An inode that was already counted, like a second hard link, adds nothing of its own so every inode is only counted once.
+func populateExtraSizeInfo(item *FSEntity, countedInodes map[fileIdentity]struct{}) (int64, int64) {
+	countOwn := true
+	if identity, ok := item.identity(); ok {
+		_, counted := countedInodes[identity]
+		countOwn = !counted
+		countedInodes[identity] = struct{}{}
+	}
+	var ownDiskSize int64
+	if countOwn {
+		ownDiskSize = item.DiskSize
+	}
+	if !item.IsDir {
+		item.PrettySize = util.GetPrettyBytesSize(item.Size)
+		item.PrettyDiskSize = util.GetPrettyBytesSize(item.DiskSize)
+		if !countOwn {
+			return 0, 0
+		}
+		return item.Size, item.DiskSize
+	}
+	var size, diskSize int64
+	for index, childItem := range item.Children {
+		childSize, childDiskSize := populateExtraSizeInfo(&childItem, countedInodes)
+		item.Children[index] = childItem
+		size += childSize
+		diskSize += childDiskSize
+	}
+	item.Size = size
+	item.DiskSize += diskSize
+	item.PrettySize = util.GetPrettyBytesSize(item.Size)
+	item.PrettyDiskSize = util.GetPrettyBytesSize(item.DiskSize)
+	return size, ownDiskSize + diskSize
+}
+
+// sortChildren orders the children of every directory by the size for the mode, largest first, and then by name.
+func sortChildren(item *FSEntity, mode SizeMode) {
+	slices.SortFunc(item.Children, func(a, b FSEntity) int {
+		if c := cmp.Compare(b.SizeFor(mode), a.SizeFor(mode)); c != 0 {
+			return c
 		}
-		item.Size = size
+		return cmp.Compare(a.Name, b.Name)
+	})
+	for i := range item.Children {
+		sortChildren(&item.Children[i], mode)
 	}
-	item.PrettySize = util.GetPrettyBytesSize(int64(item.Size))
 }
 
 func Scan(logger *slog.Logger, params ScanParams) (FSEntity, error) {
 	// ncs := NewNonConcurrentFSScanner()
-	cfs := NewConcurrentFSScanner(params.ConcurrencyLimit)
+	cfs := NewConcurrentFSScanner(params.ConcurrencyLimit, params.Filter, WalkOptions{
+		FollowSymlinks: params.FollowSymlinks,
+		OneFileSystem:  params.OneFileSystem,
+		Baseline:       params.Baseline,
+	})
 	info, err := cfs.Scan(logger, params.RootPath, "base", params.CalculateFileHashes, params.MaxRecursion)
 	logger.Info("finished scan")
 	if err != nil {
 		logger.Error("failed to get dir content details", slog.String("errorMessage", err.Error()), slog.String("rootPath", params.RootPath))
 		return FSEntity{}, err
 	}
+	if params.Baseline != nil {
+		logger.Info("reused entities from baseline", slog.Int64("numDirsReused", params.Baseline.NumDirsReused()), slog.Int64("numFilesReused", params.Baseline.NumFilesReused()))
+	}
 	logger.Info("populating extra size info")
-	populateExtraSizeInfo(&info)
+	populateExtraSizeInfo(&info, make(map[fileIdentity]struct{}))
+	sortChildren(&info, params.SizeMode)
 	logger.Info("finished populating extra size info")
 	return info, nil
 }
@@ -62,10 +118,16 @@ func FileInfoToFSEntry(logger *slog.Logger, fi fs.FileInfo, parentID, id, fullPa
 	}
 	permissions := fi.Mode().Perm()
 	entityType := getEntityType(isDir, isRegular)
+	identity, nLink := getFileIdentity(fi)
+	diskSize, ok := getDiskSize(fi)
+	if !ok {
+		diskSize = size
+	}
 
 	e := FSEntity{
 		Name:         name,
 		Size:         size,
+		DiskSize:     diskSize,
 		FullPath:     fullPath,
 		IsDir:        isDir,
 		EntityType:   entityType,
@@ -78,6 +140,13 @@ func FileInfoToFSEntry(logger *slog.Logger, fi fs.FileInfo, parentID, id, fullPa
 		ParentID:     parentID,
 		ID:           id,
 		Depth:        depth,
+		Inode:        identity.inode,
+		Device:       identity.device,
+		NLink:        nLink,
+	}
+	if uid, gid, ok := getOwner(fi); ok {
+		e.UID = &uid
+		e.GID = &gid
 	}
 	if hashError != nil {
 		e.ErrorMessage = hashError.Error()
@@ -0,0 +1,386 @@
+package spaceanalyzer
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	// DefaultSummaryTop is how many of the largest files and directories a summary lists.
+	DefaultSummaryTop = 10
+
+	// summaryUnknownOwner is the key of the files whose owner is not in the report, like scans made on Windows.
+	summaryUnknownOwner = "unknown"
+
+	summaryDay = 24 * time.Hour
+)
+
+// summaryAgeBuckets are the modification age ranges files are grouped by, each up to its maxAge. The last bucket has no limit.
+var summaryAgeBuckets = []struct {
+	name   string
+	maxAge time.Duration
+}{
+	{name: "under 30 days", maxAge: 30 * summaryDay},
+	{name: "30-180 days", maxAge: 180 * summaryDay},
+	{name: "180-365 days", maxAge: 365 * summaryDay},
+	{name: "over 365 days"},
+}
+
+type SummaryParams struct {
+	// SizeMode is the size that is added up and that the largest files and directories are chosen by.
+	SizeMode SizeMode
+	// Top is how many of the largest files and directories are listed. Defaults to DefaultSummaryTop.
+	Top int
+	// Now is the time file ages are calculated from. Defaults to the current time.
+	Now time.Time
+}
+
+// SummaryGroup is the number and total size of the files that share a key, like an owner, an age bucket or a depth.
+type SummaryGroup struct {
+	Key string `json:"key"`
+	// Name is the user or group name of an owner if it could be looked up on this system.
+	Name       string `json:"name,omitempty"`
+	NumFiles   int64  `json:"numFiles"`
+	Size       int64  `json:"size"`
+	PrettySize string `json:"prettySize"`
+}
+
+// SummaryEntry is one of the largest files or directories.
+type SummaryEntry struct {
+	// Path is relative to the root with / separators.
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	PrettySize string `json:"prettySize"`
+	// NumFiles is how many files are below a directory.
+	NumFiles     int64     `json:"numFiles,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Summary breaks down the regular files of a scan by extension, owner, age and depth. Hard linked files are counted once, by the first link
+// found, like the directory sizes are, so the breakdowns add up to the root's size.
+type Summary struct {
+	RootPath        string           `json:"rootPath"`
+	SizeMode        SizeMode         `json:"sizeMode"`
+	TotalSize       int64            `json:"totalSize"`
+	PrettyTotalSize string           `json:"prettyTotalSize"`
+	NumFiles        int64            `json:"numFiles"`
+	NumDirs         int64            `json:"numDirs"`
+	Extensions      []ExtensionStats `json:"extensions"`
+	// Users and Groups are keyed by uid and gid, largest first.
+	Users  []SummaryGroup `json:"users"`
+	Groups []SummaryGroup `json:"groups"`
+	// Ages are the modification age buckets from the newest to the oldest.
+	Ages []SummaryGroup `json:"ages"`
+	// Depths are keyed by how many directories below the root the files are, so the files directly in the root have a depth of 1.
+	Depths             []SummaryGroup `json:"depths"`
+	LargestFiles       []SummaryEntry `json:"largestFiles"`
+	LargestDirectories []SummaryEntry `json:"largestDirectories"`
+}
+
+// summaryGroups adds files up by key and returns the groups in the order their keys were first seen.
+type summaryGroups struct {
+	keys   []string
+	groups map[string]*SummaryGroup
+}
+
+func newSummaryGroups(keys ...string) *summaryGroups {
+	g := &summaryGroups{groups: make(map[string]*SummaryGroup)}
+	for _, key := range keys {
+		g.group(key)
+	}
+	return g
+}
+
+func (g *summaryGroups) group(key string) *SummaryGroup {
+	group, ok := g.groups[key]
+	if !ok {
+		group = &SummaryGroup{Key: key}
+		g.groups[key] = group
+		g.keys = append(g.keys, key)
+	}
+	return group
+}
+
+func (g *summaryGroups) add(key string, size int64) {
+	group := g.group(key)
+	group.NumFiles++
+	group.Size += size
+}
+
+func (g *summaryGroups) list() []SummaryGroup {
+	list := make([]SummaryGroup, 0, len(g.keys))
+	for _, key := range g.keys {
+		group := g.groups[key]
+		group.PrettySize = util.GetPrettyBytesSize(group.Size)
+		list = append(list, *group)
+	}
+	return list
+}
+
+// bySize sorts groups largest first.
+func bySize(a, b SummaryGroup) int {
+	if c := cmp.Compare(b.Size, a.Size); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Key, b.Key)
+}
+
+// ownerKey is the uid or gid as a string, or summaryUnknownOwner if the report does not have it.
+func ownerKey(id *uint32) string {
+	if id == nil {
+		return summaryUnknownOwner
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// Summarize breaks down the scanned root by extension, owning user and group, modification age and depth, and lists the largest files and directories.
+func Summarize(logger *slog.Logger, root FSEntity, params SummaryParams) Summary {
+	top := params.Top
+	if top <= 0 {
+		top = DefaultSummaryTop
+	}
+	now := params.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	mode := params.SizeMode
+	entities := make([]FSEntity, 0)
+	flattenEntity(root, &entities)
+	summary := Summary{
+		RootPath:        root.FullPath,
+		SizeMode:        mode,
+		TotalSize:       root.SizeFor(mode),
+		PrettyTotalSize: util.GetPrettyBytesSize(root.SizeFor(mode)),
+	}
+
+	ageKeys := make([]string, 0, len(summaryAgeBuckets))
+	for _, bucket := range summaryAgeBuckets {
+		ageKeys = append(ageKeys, bucket.name)
+	}
+	users, groups, ages, depths := newSummaryGroups(), newSummaryGroups(), newSummaryGroups(ageKeys...), newSummaryGroups()
+	files := make([]FSEntity, 0, len(entities))
+	dirs := make([]FSEntity, 0)
+	seen := make(map[fileIdentity]struct{})
+	for _, e := range entities {
+		if e.IsDir {
+			summary.NumDirs++
+			if e.ID != root.ID {
+				dirs = append(dirs, e)
+			}
+			continue
+		}
+		if e.EntityType != FileType {
+			continue
+		}
+		if identity, ok := e.identity(); ok {
+			if _, ok := seen[identity]; ok {
+				continue
+			}
+			seen[identity] = struct{}{}
+		}
+		summary.NumFiles++
+		files = append(files, e)
+		size := e.SizeFor(mode)
+		users.add(ownerKey(e.UID), size)
+		groups.add(ownerKey(e.GID), size)
+		age := now.Sub(e.LastModified)
+		for _, bucket := range summaryAgeBuckets {
+			if bucket.maxAge == 0 || age < bucket.maxAge {
+				ages.add(bucket.name, size)
+				break
+			}
+		}
+		depths.add(strconv.Itoa(e.Depth-root.Depth), size)
+	}
+
+	summary.Extensions = SummarizeExtensions(files, mode)
+	summary.Users = users.list()
+	slices.SortFunc(summary.Users, bySize)
+	summary.Groups = groups.list()
+	slices.SortFunc(summary.Groups, bySize)
+	for i, u := range summary.Users {
+		summary.Users[i].Name = lookupOwnerName(u.Key, func(id string) (string, error) {
+			u, err := user.LookupId(id)
+			if err != nil {
+				return "", err
+			}
+			return u.Username, nil
+		})
+	}
+	for i, g := range summary.Groups {
+		summary.Groups[i].Name = lookupOwnerName(g.Key, func(id string) (string, error) {
+			g, err := user.LookupGroupId(id)
+			if err != nil {
+				return "", err
+			}
+			return g.Name, nil
+		})
+	}
+	summary.Ages = ages.list()
+	summary.Depths = depths.list()
+	slices.SortFunc(summary.Depths, func(a, b SummaryGroup) int {
+		depthA, _ := strconv.Atoi(a.Key)
+		depthB, _ := strconv.Atoi(b.Key)
+		return cmp.Compare(depthA, depthB)
+	})
+
+	dirFiles := make(map[string]int64, len(dirs)+1)
+	countSummaryFiles(root, dirFiles)
+	summary.LargestFiles = largestSummaryEntries(root, files, dirFiles, mode, top)
+	summary.LargestDirectories = largestSummaryEntries(root, dirs, dirFiles, mode, top)
+	logger.Debug("summarized scan",
+		slog.Int64("numFiles", summary.NumFiles),
+		slog.Int64("numDirs", summary.NumDirs),
+		slog.Int("numExtensions", len(summary.Extensions)),
+		slog.Int("numUsers", len(summary.Users)),
+	)
+	return summary
+}
+
+// lookupOwnerName returns the name of the uid or gid on this system, or an empty string if it does not have one.
+func lookupOwnerName(id string, lookup func(id string) (string, error)) string {
+	if id == summaryUnknownOwner {
+		return ""
+	}
+	name, err := lookup(id)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// countSummaryFiles counts the regular files below the entity, and adds the count of every directory to dirFiles by its full path.
+func countSummaryFiles(e FSEntity, dirFiles map[string]int64) int64 {
+	if !e.IsDir {
+		if e.EntityType == FileType {
+			return 1
+		}
+		return 0
+	}
+	var numFiles int64
+	for _, c := range e.Children {
+		numFiles += countSummaryFiles(c, dirFiles)
+	}
+	dirFiles[e.FullPath] = numFiles
+	return numFiles
+}
+
+// largestSummaryEntries returns up to top of the entities, largest first. dirFiles has the number of files below each directory.
+func largestSummaryEntries(root FSEntity, entities []FSEntity, dirFiles map[string]int64, mode SizeMode, top int) []SummaryEntry {
+	slices.SortFunc(entities, func(a, b FSEntity) int {
+		if c := cmp.Compare(b.SizeFor(mode), a.SizeFor(mode)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.FullPath, b.FullPath)
+	})
+	largest := make([]SummaryEntry, 0, min(top, len(entities)))
+	for _, e := range entities[:min(top, len(entities))] {
+		relPath, err := filepath.Rel(root.FullPath, e.FullPath)
+		if err != nil {
+			relPath = e.FullPath
+		}
+		entry := SummaryEntry{
+			Path:         filepath.ToSlash(relPath),
+			Size:         e.SizeFor(mode),
+			PrettySize:   util.GetPrettyBytesSize(e.SizeFor(mode)),
+			LastModified: e.LastModified,
+		}
+		if e.IsDir {
+			entry.NumFiles = dirFiles[e.FullPath]
+		}
+		largest = append(largest, entry)
+	}
+	return largest
+}
+
+// WriteSummaryTable writes each breakdown of a summary as its own table.
+func WriteSummaryTable(w io.Writer, summary Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s: %s in %d files and %d directories\n", summary.RootPath, summary.PrettyTotalSize, summary.NumFiles, summary.NumDirs)
+	fmt.Fprintln(tw, "\nSIZE\tFILES\tEXTENSION")
+	for _, e := range summary.Extensions {
+		extension := e.Extension
+		if len(extension) == 0 {
+			extension = "(none)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", e.PrettySize, e.NumFiles, extension)
+	}
+	writeGroups := func(heading string, groups []SummaryGroup) {
+		fmt.Fprintf(tw, "\nSIZE\tFILES\t%s\n", heading)
+		for _, g := range groups {
+			key := g.Key
+			if len(g.Name) > 0 {
+				key = fmt.Sprintf("%s (%s)", g.Name, g.Key)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", g.PrettySize, g.NumFiles, key)
+		}
+	}
+	writeGroups("USER", summary.Users)
+	writeGroups("GROUP", summary.Groups)
+	writeGroups("AGE", summary.Ages)
+	writeGroups("DEPTH", summary.Depths)
+	fmt.Fprintln(tw, "\nSIZE\tMODIFIED\tFILE")
+	for _, f := range summary.LargestFiles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.PrettySize, f.LastModified.Format(time.DateOnly), f.Path)
+	}
+	fmt.Fprintln(tw, "\nSIZE\tFILES\tDIRECTORY")
+	for _, d := range summary.LargestDirectories {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", d.PrettySize, d.NumFiles, d.Path)
+	}
+	return tw.Flush()
+}
+
+// WriteSummaryCSV writes one row per group and largest entry, with the breakdown it belongs to in the first column.
+func WriteSummaryCSV(w io.Writer, summary Summary) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write([]string{"section", "key", "name", "numFiles", "size"}); err != nil {
+		return err
+	}
+	write := func(section, key, name string, numFiles, size int64) error {
+		return csvWriter.Write([]string{section, key, name, strconv.FormatInt(numFiles, 10), strconv.FormatInt(size, 10)})
+	}
+	for _, e := range summary.Extensions {
+		if err := write("extension", e.Extension, "", e.NumFiles, e.Size); err != nil {
+			return err
+		}
+	}
+	sections := []struct {
+		name   string
+		groups []SummaryGroup
+	}{
+		{name: "user", groups: summary.Users},
+		{name: "group", groups: summary.Groups},
+		{name: "age", groups: summary.Ages},
+		{name: "depth", groups: summary.Depths},
+	}
+	for _, section := range sections {
+		for _, g := range section.groups {
+			if err := write(section.name, g.Key, g.Name, g.NumFiles, g.Size); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range summary.LargestFiles {
+		if err := write("largestFile", f.Path, "", 1, f.Size); err != nil {
+			return err
+		}
+	}
+	for _, d := range summary.LargestDirectories {
+		if err := write("largestDirectory", d.Path, "", d.NumFiles, d.Size); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,140 @@
+package spaceanalyzer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestSummarize(t *testing.T) {
+	logger := mock.NewMockLogger()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	owner := func(id uint32) *uint32 {
+		return &id
+	}
+	file := func(id, parentID, fullPath string, depth int, size int64, age time.Duration, uid *uint32) FSEntity {
+		return FSEntity{ID: id, ParentID: parentID, FullPath: fullPath, Extension: filepath.Ext(fullPath), Size: size, Depth: depth,
+			EntityType: FileType, LastModified: now.Add(-age), UID: uid, GID: uid}
+	}
+	dir := func(id, parentID, fullPath string, depth int, size int64) FSEntity {
+		return FSEntity{ID: id, ParentID: parentID, FullPath: fullPath, Size: size, Depth: depth, IsDir: true, EntityType: DirectoryType}
+	}
+	root, err := BuildTree([]FSEntity{
+		dir("r", "", "/data", 0, 3600),
+		file("a", "r", "/data/notes.TXT", 1, 100, time.Hour, owner(1000)),
+		dir("m", "r", "/data/media", 1, 3500),
+		file("b", "m", "/data/media/old.mp4", 2, 2000, 400*summaryDay, owner(0)),
+		file("c", "m", "/data/media/new.mp4", 2, 1000, 60*summaryDay, owner(1000)),
+		file("d", "m", "/data/media/mid.txt", 2, 500, 200*summaryDay, nil),
+	})
+	if err != nil {
+		t.Fatalf("failed to build tree: %v", err)
+	}
+	summary := Summarize(logger, root, SummaryParams{Top: 2, Now: now})
+	if summary.NumFiles != 4 || summary.NumDirs != 2 || summary.TotalSize != 3600 {
+		t.Errorf("unexpected totals: %+v", summary)
+	}
+	if len(summary.Extensions) != 2 || summary.Extensions[0].Extension != ".mp4" || summary.Extensions[1].Extension != ".txt" || summary.Extensions[1].NumFiles != 2 {
+		t.Errorf("expected extensions by lower case extension, largest first: %+v", summary.Extensions)
+	}
+	if len(summary.Users) != 3 || summary.Users[0].Key != "0" || summary.Users[1].Key != "1000" || summary.Users[1].Size != 1100 || summary.Users[2].Key != summaryUnknownOwner {
+		t.Errorf("expected users by uid, largest first: %+v", summary.Users)
+	}
+	expectedAges := []int64{100, 1000, 500, 2000}
+	for i, age := range summary.Ages {
+		if age.Size != expectedAges[i] || age.Key != summaryAgeBuckets[i].name {
+			t.Errorf("unexpected age bucket %d: %+v", i, age)
+		}
+	}
+	if len(summary.Depths) != 2 || summary.Depths[0].Key != "1" || summary.Depths[1].NumFiles != 3 {
+		t.Errorf("expected files by depth: %+v", summary.Depths)
+	}
+	if len(summary.LargestFiles) != 2 || summary.LargestFiles[0].Path != "media/old.mp4" || summary.LargestFiles[1].Path != "media/new.mp4" {
+		t.Errorf("expected the 2 largest files: %+v", summary.LargestFiles)
+	}
+	if len(summary.LargestDirectories) != 1 || summary.LargestDirectories[0].Path != "media" || summary.LargestDirectories[0].NumFiles != 3 {
+		t.Errorf("expected the directories below the root: %+v", summary.LargestDirectories)
+	}
+
+	var table bytes.Buffer
+	if err := WriteSummaryTable(&table, summary); err != nil {
+		t.Fatalf("failed to write summary table: %v", err)
+	}
+	for _, expected := range []string{"/data: 3.52 KB in 4 files and 2 directories", "over 365 days", "media/old.mp4"} {
+		if !strings.Contains(table.String(), expected) {
+			t.Errorf("expected the table to contain %q: %s", expected, table.String())
+		}
+	}
+	var output bytes.Buffer
+	if err := WriteSummaryCSV(&output, summary); err != nil {
+		t.Fatalf("failed to write summary csv: %v", err)
+	}
+	records, err := csv.NewReader(&output).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read summary csv: %v", err)
+	}
+	// the header, 2 extensions, 3 users, 3 groups, 4 ages, 2 depths, 2 files and 1 directory
+	if len(records) != 18 || records[len(records)-1][0] != "largestDirectory" {
+		t.Errorf("unexpected csv records: %v", records)
+	}
+}
+
+func TestScanRecordsOwner(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootPath, "owned.txt"), []byte("owned"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	root, err := Scan(logger, ScanParams{
+		RootPath:     rootPath,
+		MaxRecursion: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(rootPath, "owned.txt"))
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	uid, gid, ok := getOwner(info)
+	if !ok {
+		t.Skip("the platform does not provide file owners")
+	}
+	owned, _ := findEntity(root, "owned.txt")
+	if owned.UID == nil || *owned.UID != uid || owned.GID == nil || *owned.GID != gid {
+		t.Errorf("expected the owner to be recorded: %v %v", owned.UID, owned.GID)
+	}
+}
+
+func TestSummarizeHardLinks(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootPath := t.TempDir()
+	fullPath := filepath.Join(rootPath, "a.txt")
+	if err := os.WriteFile(fullPath, []byte("linked content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Link(fullPath, filepath.Join(rootPath, "b.txt")); err != nil {
+		t.Skipf("hard links are not supported: %v", err)
+	}
+	root, err := Scan(logger, ScanParams{
+		RootPath:     rootPath,
+		MaxRecursion: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if linked, _ := findEntity(root, "a.txt"); linked.Inode == 0 {
+		t.Skip("the platform does not provide inodes")
+	}
+	summary := Summarize(logger, root, SummaryParams{})
+	// the links are one file, so the breakdowns add up to the root's size
+	if summary.NumFiles != 1 || len(summary.Extensions) != 1 || summary.Extensions[0].Size != summary.TotalSize || len(summary.LargestFiles) != 1 {
+		t.Errorf("expected the hard links to be counted once: %+v", summary)
+	}
+}
@@ -1,6 +1,8 @@
 package spaceanalyzer
 
 import (
+	"errors"
+	"fmt"
 	"io/fs"
 	"time"
 )
@@ -13,31 +15,91 @@ const (
 	OtherType     EntityType = "other"
 )
 
+// SizeMode selects which size of an entity sorting and summaries use.
+type SizeMode string
+
+const (
+	// SizeModeApparent uses Size, the number of bytes in the files.
+	SizeModeApparent SizeMode = "apparent"
+	// SizeModeDisk uses DiskSize, the space allocated for the files on disk like du reports.
+	SizeModeDisk SizeMode = "disk"
+)
+
+var ErrUnknownSizeMode = errors.New("unknown size mode")
+
+// ParseSizeMode returns the size mode with the name. An empty name is SizeModeApparent.
+func ParseSizeMode(name string) (SizeMode, error) {
+	switch SizeMode(name) {
+	case "", SizeModeApparent:
+		return SizeModeApparent, nil
+	case SizeModeDisk:
+		return SizeModeDisk, nil
+	}
+	return SizeModeApparent, fmt.Errorf("%w: %s", ErrUnknownSizeMode, name)
+}
+
 type FSEntity struct {
-	ID           string     `json:"id,omitempty"`
-	ParentID     string     `json:"parentID,omitempty"`
-	Name         string     `json:"name,omitempty"`
-	Extension    string     `json:"extension,omitempty"`
-	FullPath     string     `json:"fullPath,omitempty"`
-	Size         int64      `json:"size"`
-	PrettySize   string     `json:"prettySize"`
-	FileHash     string     `json:"fileHash,omitempty"`
-	IsDir        bool       `json:"isDir"` // TODO: remove and have client calculate based on entityType?
-	Depth        int        `json:"depth"`
-	EntityType   EntityType `json:"entityType"`
-	Mode         uint32     `json:"mode,omitempty"`
-	Type         uint32     `json:"type"`
-	Permissions  uint32     `json:"permissions"`
-	LastModified time.Time  `json:"lastModified"`
-	Children     []FSEntity `json:"children,omitempty"`
-	ErrorMessage string     `json:"errorMessage,omitempty"`
+	ID         string `json:"id,omitempty"`
+	ParentID   string `json:"parentID,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Extension  string `json:"extension,omitempty"`
+	FullPath   string `json:"fullPath,omitempty"`
+	Size       int64  `json:"size"`
+	PrettySize string `json:"prettySize"`
+	// DiskSize is the space allocated on disk, which is less than Size for sparse files and more for small files that fill part of a block.
+	// Directories include their own blocks. Platforms without block counts use Size.
+	DiskSize       int64      `json:"diskSize"`
+	PrettyDiskSize string     `json:"prettyDiskSize"`
+	FileHash       string     `json:"fileHash,omitempty"`
+	IsDir          bool       `json:"isDir"` // TODO: remove and have client calculate based on entityType?
+	Depth          int        `json:"depth"`
+	EntityType     EntityType `json:"entityType"`
+	Mode           uint32     `json:"mode,omitempty"`
+	Type           uint32     `json:"type"`
+	Permissions    uint32     `json:"permissions"`
+	LastModified   time.Time  `json:"lastModified"`
+	Children       []FSEntity `json:"children,omitempty"`
+	ErrorMessage   string     `json:"errorMessage,omitempty"`
+	// LinkTarget is where the entity points if it is a symlink.
+	LinkTarget string `json:"linkTarget,omitempty"`
+	// Inode, Device and NLink identify the file and count its hard links on platforms that provide them.
+	Inode  uint64 `json:"inode,omitempty"`
+	Device uint64 `json:"device,omitempty"`
+	NLink  uint64 `json:"nLink,omitempty"`
+	// UID and GID are the owning user and group on platforms that provide them, and nil otherwise.
+	UID *uint32 `json:"uid,omitempty"`
+	GID *uint32 `json:"gid,omitempty"`
+}
+
+// SizeFor returns Size or DiskSize depending on the mode.
+func (e FSEntity) SizeFor(mode SizeMode) int64 {
+	if mode == SizeModeDisk {
+		return e.DiskSize
+	}
+	return e.Size
+}
+
+// Matches reports if the file info is of the file the entity was scanned from: the same type, modification time, inode and device, and for
+// regular files size. Use it to check that a path from a saved report still holds the same file before acting on it.
+func (e FSEntity) Matches(info fs.FileInfo) bool {
+	if fs.FileMode(e.Type) != info.Mode().Type() || !e.LastModified.Equal(info.ModTime()) {
+		return false
+	}
+	if info.Mode().IsRegular() && e.Size != info.Size() {
+		return false
+	}
+	identity, _ := getFileIdentity(info)
+	return e.Inode == identity.inode && e.Device == identity.device
 }
 
 type FSJob struct {
 	ID         string
 	ParentID   string
 	FullPath   string
+	LinkTarget string
 	Info       fs.FileInfo
+	// Baseline is the entity reused from a baseline for a file in an unchanged directory, which has no Info since it was not read again.
+	Baseline   *FSEntity
 	IsDir      bool
 	Depth      int
 	FailedScan bool
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package spaceanalyzer
+
+import "io/fs"
+
+// getFileIdentity returns the device and inode of the file along with its number of hard links if the platform provides them.
+// Windows does not expose them through fs.FileInfo.
+func getFileIdentity(info fs.FileInfo) (fileIdentity, uint64) {
+	return fileIdentity{}, 0
+}
+
+// getDiskSize returns the space allocated for the file if the platform provides it. Windows does not expose it through fs.FileInfo.
+func getDiskSize(info fs.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+// getOwner returns the user and group that own the file if the platform provides them. Windows does not expose them through fs.FileInfo.
+func getOwner(info fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,421 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+const (
+	blockSize = 512
+	// endOfArchiveSize is the size of the two zero blocks that mark the end of a tar archive.
+	endOfArchiveSize = 2 * blockSize
+)
+
+var (
+	ErrMissingEndOfArchive = errors.New("archive does not end with two zero blocks")
+	ErrAppendIncremental   = errors.New("incremental archives can not be appended to")
+)
+
+type TarAppendParams struct {
+	// TarPackageParams are the options used to package the new entries. Output is ignored, the archive at ArchivePath is modified instead.
+	// UseGzip and UseEncryption describe the existing archive as well as the new one.
+	TarPackageParams
+	// ArchivePath is the path of the existing archive.
+	ArchivePath string
+	// Update if true only entities that are not in the archive, or are newer than the archived copy, are added.
+	Update bool
+}
+
+// archivedState is what is known about an existing archive after reading through it.
+type archivedState struct {
+	// modTimes is the modification time of the last member with each name.
+	modTimes map[string]time.Time
+	// manifest is the last content manifest in the archive, or nil if it does not have one.
+	manifest *ContentManifest
+	// indexed is true if the archive has an archive index.
+	indexed bool
+}
+
+func newArchivedState() *archivedState {
+	return &archivedState{
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+// observe records the header, and reads the content manifest if the header is one.
+func (s *archivedState) observe(header *tar.Header, content io.Reader) error {
+	if header.Name == ContentManifestName && header.Typeflag == tar.TypeReg {
+		m, err := readContentManifest(content)
+		if err != nil {
+			return err
+		}
+		s.manifest = &m
+		return nil
+	}
+	if header.Name == ArchiveIndexName && header.Typeflag == tar.TypeReg {
+		s.indexed = true
+		return nil
+	}
+	if isMetadataMember(header) {
+		return nil
+	}
+	s.modTimes[filepath.ToSlash(header.Name)] = header.ModTime
+	return nil
+}
+
+// shouldAppend reports if the entry should be added to the archive.
+func (s *archivedState) shouldAppend(entry archivepath.Entry, update bool) bool {
+	if !update {
+		return true
+	}
+	archived, ok := s.modTimes[filepath.ToSlash(entry.Name)]
+	if !ok {
+		return true
+	}
+	return entry.Info.ModTime().Truncate(time.Second).After(archived.Truncate(time.Second))
+}
+
+// TarAppend adds the input paths to an existing archive. Plain archives are appended to in place by seeking over the end of archive blocks.
+// Compressed or encrypted archives are rewritten through a temporary file next to the archive that replaces it once complete.
+// If the archive has a content manifest, or one is requested, an updated manifest covering the whole archive is written after the new entries.
+// If Indexed is set the archive index is rebuilt for the rewritten archive.
+func TarAppend(logger *slog.Logger, params TarAppendParams) error {
+	logger.Debug("attempting to append to tar archive", slog.Any("params", params))
+	if params.Incremental {
+		logger.Error("incremental packaging can not be used when appending")
+		return ErrAppendIncremental
+	}
+	if len(params.InputPaths) == 0 && len(params.FilesFrom.Paths) == 0 {
+		errMsg := "no input paths provided to append to tar archive"
+		logger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	entries, err := archivepath.CollectInputEntries(logger, params.InputPaths, params.FilesFrom, params.ExcludeOptions)
+	if err != nil {
+		logger.Error("failed to collect entities to append", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if params.Transform.enabled() {
+		entries, err = transformEntries(logger, params.Transform, entries)
+		if err != nil {
+			return err
+		}
+	}
+	if params.Reproducible {
+		sortPackageEntries(entries)
+	}
+	setProgressTotals(params.Progress, entries)
+	if params.UseGzip || params.UseEncryption {
+		return rewriteAndAppend(logger, params, entries)
+	}
+	return appendInPlace(logger, params, entries)
+}
+
+// appendInPlace finds the end of the last member of a plain archive and writes the new entries over the end of archive blocks.
+func appendInPlace(logger *slog.Logger, params TarAppendParams, entries []archivepath.Entry) error {
+	f, err := os.OpenFile(params.ArchivePath, os.O_RDWR, 0)
+	if err != nil {
+		logger.Error("failed to open archive for appending", slog.String("archivePath", params.ArchivePath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logger.Warn("failed to close archive", slog.String("archivePath", params.ArchivePath), slog.String("errorMessage", err.Error()))
+		}
+	}()
+	state := newArchivedState()
+	end, err := findEndOfMembers(logger, f, state)
+	if err != nil {
+		return err
+	}
+	logger.Debug("found end of archive members", slog.Int64("offset", end))
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		logger.Error("failed to seek to end of archive members", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	tarWriter := tar.NewWriter(f)
+	if err := appendEntries(logger, tarWriter, f, params, entries, state, nil); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		logger.Error("failed to close tar writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	// drop anything that was after the old end of archive blocks, like record padding
+	newEnd, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(newEnd); err != nil {
+		logger.Error("failed to truncate archive", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// findEndOfMembers reads through the archive and returns the offset where its end of archive blocks start.
+func findEndOfMembers(logger *slog.Logger, f *os.File, state *archivedState) (int64, error) {
+	counter := &offsetReadSeeker{r: f}
+	tarReader := tar.NewReader(counter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("failed to read next from tar package", slog.String("errorMessage", err.Error()))
+			return 0, err
+		}
+		if err := state.observe(header, tarReader); err != nil {
+			logger.Error("failed to read archive member", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return 0, err
+		}
+	}
+	if counter.offset == 0 {
+		logger.Debug("archive is empty, appending to it will create it")
+		return 0, nil
+	}
+	// the reader returns io.EOF after reading both zero blocks, so they are right before the current offset
+	end := counter.offset - endOfArchiveSize
+	if end < 0 {
+		logger.Error("archive is too small to contain the end of archive blocks", slog.Int64("size", counter.offset))
+		return 0, ErrMissingEndOfArchive
+	}
+	trailer := make([]byte, endOfArchiveSize)
+	if _, err := f.ReadAt(trailer, end); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(trailer, make([]byte, endOfArchiveSize)) {
+		logger.Error("archive does not end with the end of archive blocks")
+		return 0, ErrMissingEndOfArchive
+	}
+	return end, nil
+}
+
+// rewriteAndAppend copies every member of a compressed or encrypted archive into a temporary archive, adds the new entries and moves it over the original.
+func rewriteAndAppend(logger *slog.Logger, params TarAppendParams, entries []archivepath.Entry) error {
+	src, err := os.Open(params.ArchivePath)
+	if err != nil {
+		logger.Error("failed to open archive for appending", slog.String("archivePath", params.ArchivePath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(params.ArchivePath), filepath.Base(params.ArchivePath)+".*.tmp")
+	if err != nil {
+		logger.Error("failed to create temporary archive", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	tmpPath := tmp.Name()
+	logger.Debug("rewriting archive through temporary file", slog.String("tmpPath", tmpPath))
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			tmp.Close()
+			if err := os.Remove(tmpPath); err != nil {
+				logger.Warn("failed to remove temporary archive", slog.String("tmpPath", tmpPath), slog.String("errorMessage", err.Error()))
+			}
+		}
+	}()
+
+	out, closeArchiveWriter, err := newArchiveWriter(logger, tmp, params.TarPackageParams)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(out)
+	var index *archiveIndexBuilder
+	if indexedOut, ok := out.(*indexedGZIPWriter); ok {
+		index = newArchiveIndexBuilder(indexedOut)
+	}
+	state := newArchivedState()
+	if srcInfo.Size() == 0 {
+		logger.Debug("archive is empty, appending to it will create it")
+	} else if err := copyArchiveMembers(logger, src, tarWriter, params, state, index); err != nil {
+		return err
+	}
+	if err := appendEntries(logger, tarWriter, out, params, entries, state, index); err != nil {
+		return err
+	}
+	if index != nil {
+		modTime := time.Now()
+		if params.Reproducible {
+			modTime = params.ReproducibleOptions.clampTime()
+		}
+		if err := index.writeIndex(tarWriter, modTime); err != nil {
+			logger.Error("failed to write archive index", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		logger.Debug("archive index rebuilt", slog.Int("numMembers", len(index.index.Members)))
+	} else if state.indexed {
+		logger.Warn("archive index was dropped because its offsets are only valid for the original archive, append with indexed to rebuild it")
+	}
+	if err := tarWriter.Close(); err != nil {
+		logger.Error("failed to close tar writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := closeArchiveWriter(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		logger.Error("failed to close temporary archive", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	// close the original before replacing it, windows does not allow renaming over an open file
+	src.Close()
+	if err := os.Rename(tmpPath, params.ArchivePath); err != nil {
+		logger.Error("failed to move temporary archive into place", slog.String("archivePath", params.ArchivePath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
+// copyArchiveMembers copies every member of the existing archive except the content manifest and archive index to the tar writer.
+// If index is not nil the copied members are recorded in it.
+func copyArchiveMembers(logger *slog.Logger, src io.Reader, tarWriter *tar.Writer, params TarAppendParams, state *archivedState, index *archiveIndexBuilder) error {
+	in, closeArchiveReader, err := newArchiveReader(logger, src, params.UseGzip, params.UseEncryption, params.EncryptionOptions)
+	if err != nil {
+		return err
+	}
+	defer closeArchiveReader()
+	tarReader := tar.NewReader(in)
+	numCopied := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("failed to read next from tar package", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if err := state.observe(header, tarReader); err != nil {
+			logger.Error("failed to read archive member", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if header.Name == ContentManifestName && header.Typeflag == tar.TypeReg {
+			// the manifest is rewritten after the new entries
+			continue
+		}
+		if header.Name == ArchiveIndexName && header.Typeflag == tar.TypeReg {
+			// the offsets in the index are only valid for the archive it was written to
+			continue
+		}
+		var blockOffset, offset int64
+		if index != nil {
+			if blockOffset, offset, err = index.nextPosition(tarWriter); err != nil {
+				logger.Error("failed to start indexed block", slog.String("errorMessage", err.Error()))
+				return err
+			}
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			logger.Error("failed to copy tar header", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			logger.Error("failed to copy tar member", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if index != nil {
+			index.record(header, blockOffset, offset)
+		}
+		numCopied++
+	}
+	logger.Debug("copied existing members to temporary archive", slog.Int("numCopied", numCopied))
+	return nil
+}
+
+// appendEntries writes the entries that should be appended followed by an updated content manifest if needed.
+// If index is not nil the appended entries are recorded in it.
+func appendEntries(logger *slog.Logger, tarWriter *tar.Writer, raw io.Writer, params TarAppendParams, entries []archivepath.Entry, state *archivedState, index *archiveIndexBuilder) error {
+	var manifest *contentManifestBuilder
+	if params.IncludeManifest || state.manifest != nil {
+		manifest = newContentManifestBuilder()
+	}
+	appended := make(map[string]bool)
+	numSkipped := 0
+	for _, entry := range entries {
+		if !state.shouldAppend(entry, params.Update) {
+			logger.Debug("skipping entity that is not newer than the archived copy", slog.String("path", entry.Path))
+			params.Progress.SkipEntry(regularFileSize(entry))
+			numSkipped++
+			continue
+		}
+		var blockOffset, offset int64
+		if index != nil {
+			var err error
+			if blockOffset, offset, err = index.nextPosition(tarWriter); err != nil {
+				logger.Error("failed to start indexed block", slog.String("errorMessage", err.Error()))
+				return err
+			}
+		}
+		header, hash, err := writePackageEntry(logger, tarWriter, raw, entry, params.TarPackageParams, manifest != nil)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			index.record(header, blockOffset, offset)
+		}
+		if manifest != nil {
+			manifest.record(header, hash)
+			appended[filepath.ToSlash(header.Name)] = true
+		}
+	}
+	logger.Info("appended entities to archive", slog.Int("numAppended", len(entries)-numSkipped), slog.Int("numSkipped", numSkipped))
+	if manifest == nil {
+		return nil
+	}
+	if state.manifest == nil {
+		logger.Warn("archive did not have a content manifest, the new manifest only covers the appended entities")
+	} else {
+		// keep the entries for files that were not replaced, the new entries win like they do on extraction
+		newFiles := manifest.manifest.Files
+		manifest.manifest.Files = make([]ContentManifestEntry, 0, len(state.manifest.Files)+len(newFiles))
+		for _, f := range state.manifest.Files {
+			if !appended[f.Name] {
+				manifest.manifest.Files = append(manifest.manifest.Files, f)
+			}
+		}
+		manifest.manifest.Files = append(manifest.manifest.Files, newFiles...)
+	}
+	modTime := time.Now()
+	if params.Reproducible {
+		modTime = params.ReproducibleOptions.clampTime()
+	}
+	if err := manifest.writeManifest(tarWriter, modTime); err != nil {
+		logger.Error("failed to write content manifest", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// offsetReadSeeker tracks the offset of the underlying reader so the position the tar reader stopped at is known.
+type offsetReadSeeker struct {
+	r      io.ReadSeeker
+	offset int64
+}
+
+func (o *offsetReadSeeker) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.offset = pos
+	}
+	return pos, err
+}
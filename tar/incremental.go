@@ -0,0 +1,270 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+const (
+	// IncrementalMarkerName is the name of the member written to incremental archives that records the deletions since the previous level.
+	IncrementalMarkerName = ".filejitsu-incremental.json"
+	// SnapshotVersion is the current version of the snapshot file format.
+	SnapshotVersion = 1
+)
+
+var (
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+)
+
+// IncrementalOptions controls incremental and differential packaging.
+type IncrementalOptions struct {
+	// SnapshotPath is the path of the snapshot file that records the state of the previous backup. It is created if it does not exist.
+	SnapshotPath string
+	// Differential if true the snapshot is not updated after packaging, so every run contains all changes since the snapshot was created.
+	Differential bool
+}
+
+// Snapshot records the state of every entity packaged by a listed incremental backup.
+type Snapshot struct {
+	Version int `json:"version"`
+	// Level is the number of archives created from this snapshot. 0 is the full backup.
+	Level     int                      `json:"level"`
+	CreatedAt time.Time                `json:"createdAt"`
+	UpdatedAt time.Time                `json:"updatedAt"`
+	Entries   map[string]SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is the state of a single entity in a snapshot.
+type SnapshotEntry struct {
+	IsDir   bool      `json:"isDir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Device  uint64    `json:"device,omitempty"`
+	Inode   uint64    `json:"inode,omitempty"`
+	// Hash is the hex encoded SHA-256 of the file content.
+	Hash string `json:"hash,omitempty"`
+}
+
+// IncrementalMarker is the content of the IncrementalMarkerName member.
+type IncrementalMarker struct {
+	Level int `json:"level"`
+	// Deleted are the names of entities in the previous level that no longer exist.
+	Deleted []string `json:"deleted"`
+}
+
+// NewSnapshot creates an empty level 0 snapshot.
+func NewSnapshot() Snapshot {
+	now := time.Now().UTC()
+	return Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Entries:   make(map[string]SnapshotEntry),
+	}
+}
+
+// LoadSnapshot reads a snapshot file. The bool is false if the file does not exist, in which case an empty snapshot is returned.
+func LoadSnapshot(logger *slog.Logger, snapshotPath string) (Snapshot, bool, error) {
+	data, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Info("snapshot file does not exist, performing a full backup", slog.String("snapshotPath", snapshotPath))
+			return NewSnapshot(), false, nil
+		}
+		logger.Error("failed to read snapshot file", slog.String("snapshotPath", snapshotPath), slog.String("errorMessage", err.Error()))
+		return Snapshot{}, false, err
+	}
+	snapshot := Snapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		logger.Error("failed to parse snapshot file", slog.String("snapshotPath", snapshotPath), slog.String("errorMessage", err.Error()))
+		return Snapshot{}, false, fmt.Errorf("failed to parse snapshot file: %w", err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		logger.Error("unsupported snapshot version", slog.Int("version", snapshot.Version))
+		return Snapshot{}, false, ErrUnsupportedSnapshotVersion
+	}
+	if snapshot.Entries == nil {
+		snapshot.Entries = make(map[string]SnapshotEntry)
+	}
+	return snapshot, true, nil
+}
+
+// SaveSnapshot writes the snapshot to a temporary file and renames it over snapshotPath so a failed write never corrupts the previous snapshot.
+func SaveSnapshot(logger *slog.Logger, snapshotPath string, snapshot Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		logger.Error("failed to marshal snapshot", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	tmpPath := snapshotPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		logger.Error("failed to write snapshot file", slog.String("snapshotPath", tmpPath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := os.Rename(tmpPath, snapshotPath); err != nil {
+		logger.Error("failed to move snapshot file into place", slog.String("snapshotPath", snapshotPath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// incrementalState tracks the snapshot being built while an incremental archive is written.
+type incrementalState struct {
+	previous Snapshot
+	current  Snapshot
+}
+
+func newIncrementalState(previous Snapshot) *incrementalState {
+	current := NewSnapshot()
+	current.CreatedAt = previous.CreatedAt
+	current.Level = previous.Level
+	if len(previous.Entries) > 0 {
+		current.Level++
+	}
+	return &incrementalState{
+		previous: previous,
+		current:  current,
+	}
+}
+
+// shouldPackage reports if the entry changed since the previous snapshot. Entries that did not change are recorded in the current snapshot as is.
+func (s *incrementalState) shouldPackage(logger *slog.Logger, entry archivepath.Entry) (bool, error) {
+	info := entry.Info
+	device, inode := getFileIdentity(info)
+	state := SnapshotEntry{
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime().UTC(),
+		Device:  device,
+		Inode:   inode,
+	}
+	name := filepath.ToSlash(entry.Name)
+	if state.IsDir {
+		// directories are always packaged so the tree can be recreated from any level
+		s.current.Entries[name] = state
+		return true, nil
+	}
+	state.Size = info.Size()
+	previous, ok := s.previous.Entries[name]
+	if !ok || previous.IsDir || previous.Size != state.Size {
+		return true, nil
+	}
+	sameIdentity := previous.Device == state.Device && previous.Inode == state.Inode
+	if sameIdentity && previous.ModTime.Equal(state.ModTime) {
+		state.Hash = previous.Hash
+		s.current.Entries[name] = state
+		return false, nil
+	}
+	// the size matches but the file was touched or replaced, so compare the content before deciding
+	hash, err := hashFile(entry.Path)
+	if err != nil {
+		logger.Error("failed to hash file for incremental comparison", slog.String("path", entry.Path), slog.String("errorMessage", err.Error()))
+		return false, err
+	}
+	if hash == previous.Hash {
+		state.Hash = hash
+		s.current.Entries[name] = state
+		return false, nil
+	}
+	return true, nil
+}
+
+// recordPackaged records a file written to the archive in the current snapshot.
+func (s *incrementalState) recordPackaged(entry archivepath.Entry, hash string) {
+	if entry.Info.IsDir() {
+		return
+	}
+	device, inode := getFileIdentity(entry.Info)
+	s.current.Entries[filepath.ToSlash(entry.Name)] = SnapshotEntry{
+		Size:    entry.Info.Size(),
+		ModTime: entry.Info.ModTime().UTC(),
+		Device:  device,
+		Inode:   inode,
+		Hash:    hash,
+	}
+}
+
+// deleted returns the sorted names in the previous snapshot that are not in the current one.
+func (s *incrementalState) deleted() []string {
+	deleted := make([]string, 0)
+	for name := range s.previous.Entries {
+		if _, ok := s.current.Entries[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	slices.Sort(deleted)
+	return deleted
+}
+
+// writeMarker writes the IncrementalMarkerName member to the tar writer.
+func (s *incrementalState) writeMarker(tarWriter *tar.Writer, modTime time.Time) (IncrementalMarker, error) {
+	marker := IncrementalMarker{
+		Level:   s.current.Level,
+		Deleted: s.deleted(),
+	}
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return marker, err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     IncrementalMarkerName,
+		Mode:     ReproducibleFilePermission,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return marker, err
+	}
+	if _, err := io.Copy(tarWriter, bytes.NewReader(data)); err != nil {
+		return marker, err
+	}
+	return marker, nil
+}
+
+// applyIncrementalMarker removes the entities listed in the marker from the output path.
+func applyIncrementalMarker(logger *slog.Logger, outputPath string, marker IncrementalMarker) error {
+	logger.Debug("applying incremental deletions", slog.Int("level", marker.Level), slog.Int("numDeleted", len(marker.Deleted)))
+	for _, name := range marker.Deleted {
+		target, err := archivepath.SafeTargetPath(outputPath, name)
+		if err != nil {
+			logger.Error("refusing to delete path outside of output path", slog.String("name", name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if target == filepath.Clean(outputPath) {
+			logger.Warn("refusing to delete the output path itself", slog.String("name", name))
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			logger.Error("failed to remove deleted entity", slog.String("target", target), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		logger.Debug("removed deleted entity", slog.String("target", target))
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
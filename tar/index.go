@@ -0,0 +1,400 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// ArchiveIndexName is the name of the member written to the end of an indexed archive that lists where every member starts.
+	ArchiveIndexName = ".filejitsu-index.json"
+	// ArchiveIndexVersion is the current version of the archive index format.
+	ArchiveIndexVersion = 1
+	// IndexBlockSize is how much uncompressed data a gzip member of an indexed archive holds before a new one is started at the next member boundary.
+	IndexBlockSize = 1024 * 1024
+
+	// indexTrailerSize is the size of the empty gzip member that ends an indexed archive. Its extra field holds the offset of the index.
+	indexTrailerSize = 34
+)
+
+var (
+	ErrNoArchiveIndex                 = errors.New("archive does not contain an index")
+	ErrUnsupportedArchiveIndexVersion = errors.New("unsupported archive index version")
+	ErrIndexedRequiresGzip            = errors.New("indexed archives must be gzip compressed and can not be encrypted")
+	ErrMemberNotFound                 = errors.New("member not found in archive")
+
+	// indexTrailerHeader is the start of the trailer member: the gzip magic, deflate, the FEXTRA flag, a zero modification time, no extra flags,
+	// an unknown OS, an extra field length of 12 and the "FJ" subfield with a length of 8.
+	indexTrailerHeader = []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 255, 12, 0, 'F', 'J', 8, 0}
+	// indexTrailerFooter is an empty final deflate block followed by the CRC-32 and size of no data.
+	indexTrailerFooter = []byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+)
+
+// ArchiveIndex is the content of the ArchiveIndexName member.
+type ArchiveIndex struct {
+	Version int                 `json:"version"`
+	Members []ArchiveIndexEntry `json:"members"`
+}
+
+// ArchiveIndexEntry tells where a member starts in an indexed archive.
+type ArchiveIndexEntry struct {
+	Name     string `json:"name"`
+	Typeflag byte   `json:"typeflag"`
+	Size     int64  `json:"size"`
+	// BlockOffset is the offset in the compressed archive of the gzip member the tar header of the member is in.
+	BlockOffset int64 `json:"blockOffset"`
+	// Offset is where the tar header of the member starts in the uncompressed data of the gzip member.
+	Offset int64 `json:"offset"`
+}
+
+// indexedGZIPWriter writes a gzip stream made of many gzip members, like BGZF. A new gzip member is only started at a tar member boundary,
+// so a member can be read by decompressing from the start of its gzip member. Concatenated gzip members are still read as a single stream by gzip readers.
+type indexedGZIPWriter struct {
+	out *countingWriter
+	gz  *gzip.Writer
+	// header is written at the start of every gzip member, since resetting the gzip writer clears it.
+	header      gzip.Header
+	open        bool
+	blockOffset int64
+	blockSize   int64
+	// indexOffset is the offset of the gzip member holding the index, or -1 if it has not been written.
+	indexOffset int64
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newIndexedGZIPWriter(output io.Writer, gz *gzip.Writer) *indexedGZIPWriter {
+	return &indexedGZIPWriter{
+		out:         &countingWriter{w: output},
+		gz:          gz,
+		header:      gz.Header,
+		indexOffset: -1,
+	}
+}
+
+func (w *indexedGZIPWriter) Write(p []byte) (int, error) {
+	if !w.open {
+		w.gz.Reset(w.out)
+		w.gz.Header = w.header
+		w.open = true
+		w.blockOffset = w.out.n
+		w.blockSize = 0
+	}
+	n, err := w.gz.Write(p)
+	w.blockSize += int64(n)
+	return n, err
+}
+
+// endBlock finishes the current gzip member so the next write starts a new one.
+func (w *indexedGZIPWriter) endBlock() error {
+	if !w.open {
+		return nil
+	}
+	w.open = false
+	return w.gz.Close()
+}
+
+// position returns the gzip member offset and the offset in its uncompressed data that the next write goes to.
+// If the current gzip member is full it is ended first, so the next member starts a new one.
+func (w *indexedGZIPWriter) position() (int64, int64, error) {
+	if w.open && w.blockSize >= IndexBlockSize {
+		if err := w.endBlock(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !w.open {
+		return w.out.n, 0, nil
+	}
+	return w.blockOffset, w.blockSize, nil
+}
+
+// startIndex ends the current gzip member so the index is written to a member of its own, whose offset is written to the trailer on close.
+func (w *indexedGZIPWriter) startIndex() error {
+	if err := w.endBlock(); err != nil {
+		return err
+	}
+	w.indexOffset = w.out.n
+	return nil
+}
+
+// Close ends the current gzip member and writes the trailer if the index was written.
+func (w *indexedGZIPWriter) Close() error {
+	if err := w.endBlock(); err != nil {
+		return err
+	}
+	if w.indexOffset < 0 {
+		return nil
+	}
+	trailer := make([]byte, 0, indexTrailerSize)
+	trailer = append(trailer, indexTrailerHeader...)
+	trailer = binary.LittleEndian.AppendUint64(trailer, uint64(w.indexOffset))
+	trailer = append(trailer, indexTrailerFooter...)
+	_, err := w.out.Write(trailer)
+	return err
+}
+
+// archiveIndexBuilder collects the index entries while an indexed archive is written.
+type archiveIndexBuilder struct {
+	writer *indexedGZIPWriter
+	index  ArchiveIndex
+}
+
+func newArchiveIndexBuilder(writer *indexedGZIPWriter) *archiveIndexBuilder {
+	return &archiveIndexBuilder{
+		writer: writer,
+		index: ArchiveIndex{
+			Version: ArchiveIndexVersion,
+			Members: make([]ArchiveIndexEntry, 0),
+		},
+	}
+}
+
+// nextPosition flushes the padding of the previous member and returns where the next member will start.
+func (b *archiveIndexBuilder) nextPosition(tarWriter *tar.Writer) (int64, int64, error) {
+	if err := tarWriter.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return b.writer.position()
+}
+
+func (b *archiveIndexBuilder) record(header *tar.Header, blockOffset, offset int64) {
+	b.index.Members = append(b.index.Members, ArchiveIndexEntry{
+		Name:        header.Name,
+		Typeflag:    header.Typeflag,
+		Size:        header.Size,
+		BlockOffset: blockOffset,
+		Offset:      offset,
+	})
+}
+
+// writeIndex writes the ArchiveIndexName member to a gzip member of its own.
+func (b *archiveIndexBuilder) writeIndex(tarWriter *tar.Writer, modTime time.Time) error {
+	if err := tarWriter.Flush(); err != nil {
+		return err
+	}
+	if err := b.writer.startIndex(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(b.index)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ArchiveIndexName,
+		Mode:     ReproducibleFilePermission,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, bytes.NewReader(data))
+	return err
+}
+
+// ReadArchiveIndex reads the index of an indexed archive of the size without reading the rest of the archive.
+func ReadArchiveIndex(r io.ReaderAt, size int64) (ArchiveIndex, error) {
+	index := ArchiveIndex{}
+	if size < indexTrailerSize {
+		return index, ErrNoArchiveIndex
+	}
+	trailer := make([]byte, indexTrailerSize)
+	if _, err := r.ReadAt(trailer, size-indexTrailerSize); err != nil {
+		return index, err
+	}
+	if !bytes.HasPrefix(trailer, indexTrailerHeader) || !bytes.HasSuffix(trailer, indexTrailerFooter) {
+		return index, ErrNoArchiveIndex
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer[len(indexTrailerHeader):]))
+	if indexOffset < 0 || indexOffset >= size-indexTrailerSize {
+		return index, fmt.Errorf("%w: invalid index offset %d", ErrNoArchiveIndex, indexOffset)
+	}
+	tarReader, closeReader, err := readMemberAt(r, size, indexOffset, 0)
+	if err != nil {
+		return index, err
+	}
+	defer closeReader()
+	header, err := tarReader.Next()
+	if err != nil {
+		return index, err
+	}
+	if header.Name != ArchiveIndexName {
+		return index, fmt.Errorf("%w: unexpected member %s at index offset", ErrNoArchiveIndex, header.Name)
+	}
+	if err := json.NewDecoder(tarReader).Decode(&index); err != nil {
+		return index, err
+	}
+	if index.Version != ArchiveIndexVersion {
+		return index, fmt.Errorf("%w: %d", ErrUnsupportedArchiveIndexVersion, index.Version)
+	}
+	return index, nil
+}
+
+// readMemberAt returns a tar reader positioned at the member starting offset bytes into the gzip member at blockOffset.
+func readMemberAt(r io.ReaderAt, size, blockOffset, offset int64) (*tar.Reader, func(), error) {
+	gzipReader, err := gzip.NewReader(io.NewSectionReader(r, blockOffset, size-blockOffset))
+	if err != nil {
+		return nil, func() {}, err
+	}
+	if _, err := io.CopyN(io.Discard, gzipReader, offset); err != nil {
+		gzipReader.Close()
+		return nil, func() {}, err
+	}
+	return tar.NewReader(gzipReader), func() { gzipReader.Close() }, nil
+}
+
+type TarExtractParams struct {
+	Input io.Reader
+	// Names are the members to extract. A directory name extracts everything in it.
+	Names             []string
+	OutputPath        string
+	UseGzip           bool
+	UseEncryption     bool
+	EncryptionOptions EncryptionOptions
+}
+
+// matchesExtractName reports if the member name is one of the names or is inside one of them.
+func matchesExtractName(memberName string, names []string) (string, bool) {
+	memberName = strings.TrimSuffix(memberName, "/")
+	for _, name := range names {
+		name = strings.TrimSuffix(name, "/")
+		if memberName == name || strings.HasPrefix(memberName, name+"/") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// TarExtractMembers unpackages only the named members. If the input is a seekable indexed archive each member is read by seeking straight to it,
+// otherwise the archive is read from the start and the other members are skipped. Fails with ErrMemberNotFound if a name matches no member.
+func TarExtractMembers(logger *slog.Logger, params TarExtractParams) error {
+	if len(params.Names) == 0 {
+		errMsg := "no member names provided to extract"
+		logger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	found := make(map[string]struct{}, len(params.Names))
+	var err error
+	if ra, size, ok := seekableInput(params.Input); ok && params.UseGzip && !params.UseEncryption {
+		var index ArchiveIndex
+		index, err = ReadArchiveIndex(ra, size)
+		if err == nil {
+			logger.Debug("extracting members with archive index", slog.Int("numIndexed", len(index.Members)))
+			err = extractIndexedMembers(logger, ra, size, index, params, found)
+		} else if errors.Is(err, ErrNoArchiveIndex) {
+			logger.Info("archive has no index, reading the whole archive")
+			err = extractStreamedMembers(logger, io.NewSectionReader(ra, 0, size), params, found)
+		}
+	} else {
+		logger.Info("input is not a seekable indexed archive, reading the whole archive")
+		err = extractStreamedMembers(logger, params.Input, params, found)
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range params.Names {
+		if _, ok := found[strings.TrimSuffix(name, "/")]; !ok {
+			logger.Error("member not found in archive", slog.String("name", name))
+			return fmt.Errorf("%w: %s", ErrMemberNotFound, name)
+		}
+	}
+	return nil
+}
+
+// seekableInput returns the input as an io.ReaderAt along with its size if it can seek, which a pipe can not.
+func seekableInput(input io.Reader) (io.ReaderAt, int64, bool) {
+	ra, ok := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil, 0, false
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, false
+	}
+	if _, err := ra.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, false
+	}
+	return ra, size, true
+}
+
+func extractIndexedMembers(logger *slog.Logger, ra io.ReaderAt, size int64, index ArchiveIndex, params TarExtractParams, found map[string]struct{}) error {
+	for _, entry := range index.Members {
+		name, ok := matchesExtractName(entry.Name, params.Names)
+		if !ok {
+			continue
+		}
+		found[name] = struct{}{}
+		logger.Debug("seeking to member", slog.String("name", entry.Name), slog.Int64("blockOffset", entry.BlockOffset), slog.Int64("offset", entry.Offset))
+		tarReader, closeReader, err := readMemberAt(ra, size, entry.BlockOffset, entry.Offset)
+		if err != nil {
+			logger.Error("failed to seek to member", slog.String("name", entry.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		header, err := tarReader.Next()
+		if err == nil && header.Name != entry.Name {
+			err = fmt.Errorf("expected member %s at indexed offset but found %s", entry.Name, header.Name)
+		}
+		if err == nil {
+			err = unpackageMember(logger, header, tarReader, params.OutputPath)
+		}
+		closeReader()
+		if err != nil {
+			logger.Error("failed to extract member", slog.String("name", entry.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func extractStreamedMembers(logger *slog.Logger, input io.Reader, params TarExtractParams, found map[string]struct{}) error {
+	in, closeArchiveReader, err := newArchiveReader(logger, input, params.UseGzip, params.UseEncryption, params.EncryptionOptions)
+	if err != nil {
+		return err
+	}
+	defer closeArchiveReader()
+	tarReader := tar.NewReader(in)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Error("failed to read next from tar package", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if isMetadataMember(header) {
+			continue
+		}
+		name, ok := matchesExtractName(header.Name, params.Names)
+		if !ok {
+			continue
+		}
+		found[name] = struct{}{}
+		if err := unpackageMember(logger, header, tarReader, params.OutputPath); err != nil {
+			logger.Error("failed to extract member", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+	}
+}
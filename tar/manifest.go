@@ -0,0 +1,342 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// ContentManifestName is the name of the member written to the end of an archive that lists the content of every file in it.
+	ContentManifestName = ".filejitsu-manifest.json"
+	// ContentManifestVersion is the current version of the content manifest format.
+	ContentManifestVersion = 1
+)
+
+var (
+	ErrNoContentManifest                 = errors.New("archive does not contain a content manifest")
+	ErrUnsupportedContentManifestVersion = errors.New("unsupported content manifest version")
+	ErrVerificationFailed                = errors.New("verification failed")
+)
+
+// ContentManifest is the content of the ContentManifestName member.
+type ContentManifest struct {
+	Version int                    `json:"version"`
+	Files   []ContentManifestEntry `json:"files"`
+}
+
+// ContentManifestEntry describes a single file in an archive.
+type ContentManifestEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Mode int64  `json:"mode"`
+	// SHA256 is the hex encoded SHA-256 of the file content.
+	SHA256 string `json:"sha256"`
+}
+
+// VerifyReport is the result of verifying an archive against its manifest or the files on disk.
+type VerifyReport struct {
+	OK bool `json:"ok"`
+	// Against is the directory the archive was verified against. Empty if the archive members were verified.
+	Against    string           `json:"against,omitempty"`
+	NumChecked int              `json:"numChecked"`
+	Mismatches []VerifyMismatch `json:"mismatches"`
+	// ModeMismatches are files with matching content but different permissions. They do not fail verification because extraction is subject to the umask.
+	ModeMismatches []VerifyMismatch `json:"modeMismatches"`
+	// Missing are files that are expected but were not found.
+	Missing []string `json:"missing"`
+	// Extras are files that were found but are not expected.
+	Extras []string `json:"extras"`
+}
+
+// VerifyMismatch describes a file whose size, content or mode does not match what was expected.
+type VerifyMismatch struct {
+	Name           string `json:"name"`
+	ExpectedSize   int64  `json:"expectedSize"`
+	ActualSize     int64  `json:"actualSize"`
+	ExpectedSHA256 string `json:"expectedSha256"`
+	ActualSHA256   string `json:"actualSha256"`
+	ExpectedMode   int64  `json:"expectedMode"`
+	ActualMode     int64  `json:"actualMode"`
+}
+
+type TarVerifyParams struct {
+	Input             io.Reader
+	UseGzip           bool
+	UseEncryption     bool
+	EncryptionOptions EncryptionOptions
+	// AgainstPath if set the files in this directory are compared to the archive instead of the archive members to the manifest.
+	AgainstPath string
+}
+
+// isMetadataMember reports if the header is one of the members filejitsu writes to describe the archive rather than packaged content.
+func isMetadataMember(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg && (header.Name == ContentManifestName || header.Name == IncrementalMarkerName || header.Name == ArchiveIndexName)
+}
+
+// contentManifestBuilder collects the manifest entries while an archive is written.
+type contentManifestBuilder struct {
+	manifest ContentManifest
+}
+
+func newContentManifestBuilder() *contentManifestBuilder {
+	return &contentManifestBuilder{
+		manifest: ContentManifest{
+			Version: ContentManifestVersion,
+			Files:   make([]ContentManifestEntry, 0),
+		},
+	}
+}
+
+// record adds a regular file written to the archive to the manifest.
+func (b *contentManifestBuilder) record(header *tar.Header, hash string) {
+	if header.Typeflag != tar.TypeReg {
+		return
+	}
+	b.manifest.Files = append(b.manifest.Files, ContentManifestEntry{
+		Name:   filepath.ToSlash(header.Name),
+		Size:   header.Size,
+		Mode:   header.Mode,
+		SHA256: hash,
+	})
+}
+
+// writeManifest writes the ContentManifestName member to the tar writer.
+func (b *contentManifestBuilder) writeManifest(tarWriter *tar.Writer, modTime time.Time) error {
+	data, err := json.Marshal(b.manifest)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ContentManifestName,
+		Mode:     ReproducibleFilePermission,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tarWriter, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readContentManifest decodes the content of a ContentManifestName member.
+func readContentManifest(r io.Reader) (ContentManifest, error) {
+	m := ContentManifest{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, fmt.Errorf("failed to read content manifest: %w", err)
+	}
+	if m.Version != ContentManifestVersion {
+		return m, fmt.Errorf("%w: %d", ErrUnsupportedContentManifestVersion, m.Version)
+	}
+	return m, nil
+}
+
+// TarVerify reads the archive and verifies either its members against the embedded content manifest, or the files in params.AgainstPath against the archive.
+// When verifying against a directory and the archive has no manifest the archive members are used as the expected content.
+// An error is only returned if the archive can not be read, a failed verification is reported with VerifyReport.OK set to false.
+func TarVerify(logger *slog.Logger, params TarVerifyParams) (VerifyReport, error) {
+	report := VerifyReport{
+		Against:        params.AgainstPath,
+		Mismatches:     make([]VerifyMismatch, 0),
+		ModeMismatches: make([]VerifyMismatch, 0),
+		Missing:        make([]string, 0),
+		Extras:         make([]string, 0),
+	}
+	in, closeArchiveReader, err := newArchiveReader(logger, params.Input, params.UseGzip, params.UseEncryption, params.EncryptionOptions)
+	if err != nil {
+		return report, err
+	}
+	defer closeArchiveReader()
+
+	members, manifest, err := readArchiveContent(logger, tar.NewReader(in))
+	if err != nil {
+		return report, err
+	}
+	if len(params.AgainstPath) == 0 {
+		if manifest == nil {
+			logger.Error("cannot verify archive members without a content manifest")
+			return report, ErrNoContentManifest
+		}
+		compareContent(logger, &report, manifestEntries(*manifest), members, true)
+	} else {
+		expected := members
+		if manifest != nil {
+			expected = manifestEntries(*manifest)
+		} else {
+			logger.Warn("archive does not contain a content manifest, verifying against the archive members")
+		}
+		actual, err := readDirContent(logger, params.AgainstPath)
+		if err != nil {
+			return report, err
+		}
+		compareContent(logger, &report, expected, actual, false)
+	}
+	report.OK = len(report.Mismatches) == 0 && len(report.Missing) == 0 && len(report.Extras) == 0
+	logger.Info("finished verifying archive",
+		slog.Bool("ok", report.OK),
+		slog.Int("numChecked", report.NumChecked),
+		slog.Int("numMismatches", len(report.Mismatches)),
+		slog.Int("numMissing", len(report.Missing)),
+		slog.Int("numExtras", len(report.Extras)),
+	)
+	return report, nil
+}
+
+// readArchiveContent hashes every regular file member in the archive and returns them with the content manifest if the archive has one.
+// If a name appears more than once the last member wins, like it would on extraction.
+func readArchiveContent(logger *slog.Logger, tarReader *tar.Reader) (map[string]ContentManifestEntry, *ContentManifest, error) {
+	members := make(map[string]ContentManifestEntry)
+	var manifest *ContentManifest
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return members, manifest, nil
+		}
+		if err != nil {
+			logger.Error("failed to read next from tar package", slog.String("errorMessage", err.Error()))
+			return nil, nil, err
+		}
+		if header.Name == ContentManifestName && header.Typeflag == tar.TypeReg {
+			m, err := readContentManifest(tarReader)
+			if err != nil {
+				logger.Error("failed to read content manifest", slog.String("errorMessage", err.Error()))
+				return nil, nil, err
+			}
+			manifest = &m
+			continue
+		}
+		if isMetadataMember(header) || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, tarReader)
+		if err != nil {
+			logger.Error("failed to read tar member", slog.String("name", header.Name), slog.String("errorMessage", err.Error()))
+			return nil, nil, err
+		}
+		name := filepath.ToSlash(header.Name)
+		members[name] = ContentManifestEntry{
+			Name:   name,
+			Size:   size,
+			Mode:   header.Mode,
+			SHA256: hex.EncodeToString(hasher.Sum(nil)),
+		}
+	}
+}
+
+// readDirContent hashes every regular file under dirPath keyed by its slash separated path relative to dirPath.
+func readDirContent(logger *slog.Logger, dirPath string) (map[string]ContentManifestEntry, error) {
+	files := make(map[string]ContentManifestEntry)
+	walkErr := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Error("failed to walk entity", slog.String("path", path), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			logger.Error("failed to hash file", slog.String("path", path), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		files[name] = ContentManifestEntry{
+			Name:   name,
+			Size:   info.Size(),
+			Mode:   int64(info.Mode().Perm()),
+			SHA256: hash,
+		}
+		return nil
+	})
+	if walkErr != nil {
+		if errors.Is(walkErr, os.ErrNotExist) {
+			logger.Error("verify directory does not exist", slog.String("path", dirPath))
+		}
+		return nil, walkErr
+	}
+	return files, nil
+}
+
+func manifestEntries(manifest ContentManifest) map[string]ContentManifestEntry {
+	entries := make(map[string]ContentManifestEntry, len(manifest.Files))
+	for _, f := range manifest.Files {
+		entries[f.Name] = f
+	}
+	return entries
+}
+
+// compareContent fills the report with the differences between the expected and actual files. If exactMode is false only the permission bits are compared.
+func compareContent(logger *slog.Logger, report *VerifyReport, expected, actual map[string]ContentManifestEntry, exactMode bool) {
+	for name, e := range expected {
+		report.NumChecked++
+		a, ok := actual[name]
+		if !ok {
+			logger.Debug("expected file is missing", slog.String("name", name))
+			report.Missing = append(report.Missing, name)
+			continue
+		}
+		mismatch := VerifyMismatch{
+			Name:           name,
+			ExpectedSize:   e.Size,
+			ActualSize:     a.Size,
+			ExpectedSHA256: e.SHA256,
+			ActualSHA256:   a.SHA256,
+			ExpectedMode:   e.Mode,
+			ActualMode:     a.Mode,
+		}
+		if e.Size != a.Size || e.SHA256 != a.SHA256 {
+			logger.Debug("file content does not match", slog.String("name", name))
+			report.Mismatches = append(report.Mismatches, mismatch)
+			continue
+		}
+		expectedMode, actualMode := e.Mode, a.Mode
+		if !exactMode {
+			expectedMode = int64(fs.FileMode(expectedMode).Perm())
+			actualMode = int64(fs.FileMode(actualMode).Perm())
+		}
+		if expectedMode != actualMode {
+			if exactMode {
+				report.Mismatches = append(report.Mismatches, mismatch)
+			} else {
+				report.ModeMismatches = append(report.ModeMismatches, mismatch)
+			}
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			logger.Debug("found file that is not expected", slog.String("name", name))
+			report.Extras = append(report.Extras, name)
+		}
+	}
+	sortMismatches := func(a, b VerifyMismatch) int {
+		return compareEntryNames(a.Name, b.Name)
+	}
+	slices.SortFunc(report.Mismatches, sortMismatches)
+	slices.SortFunc(report.ModeMismatches, sortMismatches)
+	slices.SortFunc(report.Missing, compareEntryNames)
+	slices.SortFunc(report.Extras, compareEntryNames)
+}
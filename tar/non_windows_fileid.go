@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package tar
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// getFileIdentity returns the device and inode of the file if the platform provides them.
+func getFileIdentity(info fs.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
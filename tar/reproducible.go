@@ -0,0 +1,85 @@
+package tar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+const (
+	// ReproducibleDirPermission is the permission given to directories in a reproducible archive.
+	ReproducibleDirPermission = 0755
+	// ReproducibleFilePermission is the permission given to non executable files in a reproducible archive.
+	ReproducibleFilePermission = 0644
+	// ReproducibleExecutablePermission is the permission given to files with any executable bit set in a reproducible archive.
+	ReproducibleExecutablePermission = 0755
+	// ReproducibleGZIPOS is the OS written to the gzip header of a reproducible archive. 255 is "unknown" per RFC 1952.
+	ReproducibleGZIPOS byte = 255
+)
+
+// ReproducibleOptions controls how entries are normalized when creating a reproducible archive.
+type ReproducibleOptions struct {
+	// ModTime is the latest modification time allowed in the archive. Entries modified after it are clamped to it.
+	// The zero value clamps every entry to the unix epoch.
+	ModTime time.Time
+}
+
+// clampTime returns the time all modification times are clamped to.
+func (o ReproducibleOptions) clampTime() time.Time {
+	if o.ModTime.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+	return o.ModTime.UTC().Truncate(time.Second)
+}
+
+// sortPackageEntries sorts entries by their archive name. Names are compared segment by segment so a directory is always followed by its contents.
+func sortPackageEntries(entries []archivepath.Entry) {
+	slices.SortStableFunc(entries, func(a, b archivepath.Entry) int {
+		return compareEntryNames(a.Name, b.Name)
+	})
+}
+
+func compareEntryNames(a, b string) int {
+	aParts := strings.Split(strings.ReplaceAll(a, "\\", "/"), "/")
+	bParts := strings.Split(strings.ReplaceAll(b, "\\", "/"), "/")
+	return slices.Compare(aParts, bParts)
+}
+
+// normalizeHeader strips the machine specific data from a tar header so identical inputs produce identical headers.
+func normalizeHeader(header *tar.Header, options ReproducibleOptions) {
+	clamp := options.clampTime()
+	modTime := header.ModTime.UTC().Truncate(time.Second)
+	if modTime.After(clamp) {
+		modTime = clamp
+	}
+	header.ModTime = modTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+	header.PAXRecords = nil
+	header.Name = strings.ReplaceAll(header.Name, "\\", "/")
+	switch header.Typeflag {
+	case tar.TypeDir:
+		header.Mode = ReproducibleDirPermission
+	default:
+		if header.Mode&0111 != 0 {
+			header.Mode = ReproducibleExecutablePermission
+		} else {
+			header.Mode = ReproducibleFilePermission
+		}
+	}
+}
+
+// reproducibleGZIPHeader returns a copy of the header with a fixed modification time and OS.
+func reproducibleGZIPHeader(header gzip.Header, options ReproducibleOptions) gzip.Header {
+	header.ModTime = options.clampTime()
+	header.OS = ReproducibleGZIPOS
+	return header
+}
@@ -0,0 +1,280 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/calvine/filejitsu/util/progress"
+)
+
+// PAX records of the GNU sparse 1.0 format. The member data starts with a sparse map of the data regions padded to a block, followed by the data regions.
+const (
+	paxGNUSparseMajor    = "GNU.sparse.major"
+	paxGNUSparseMinor    = "GNU.sparse.minor"
+	paxGNUSparseName     = "GNU.sparse.name"
+	paxGNUSparseRealSize = "GNU.sparse.realsize"
+
+	// sparseFileDirName is the directory GNU tar puts sparse members in, so tools that do not understand the format extract them out of the way.
+	sparseFileDirName  = "GNUSparseFile.0"
+	paxHeaderDirName   = "PaxHeaders.0"
+	paxGNUSparsePrefix = "GNU.sparse."
+
+	// holeBlockSize is the size of the zero blocks that are left as holes when unpackaging a sparse member, the block size of most file systems.
+	holeBlockSize = 4096
+)
+
+// sparseRegion is a run of data in a sparse file. Everything between regions reads as zeros.
+type sparseRegion struct {
+	Offset int64
+	Length int64
+}
+
+func sparseDataSize(regions []sparseRegion) int64 {
+	var size int64
+	for _, r := range regions {
+		size += r.Length
+	}
+	return size
+}
+
+// hasHoles returns true if the regions leave any part of a file of the given size as a hole.
+func hasHoles(regions []sparseRegion, size int64) bool {
+	return sparseDataSize(regions) < size
+}
+
+// writeSparseEntry writes a regular file with holes as a GNU sparse 1.0 member holding only the data regions.
+// The tar writer drops GNU.sparse PAX records, so the member is written to raw, the stream the tar writer writes to, after flushing the tar writer.
+// If hashContent is true the hex encoded SHA-256 of the full file contents, with zeros for the holes, is returned.
+func writeSparseEntry(tarWriter *tar.Writer, raw io.Writer, header *tar.Header, f *os.File, regions []sparseRegion, hashContent bool, tracker *progress.Tracker) (string, error) {
+	sparseMap := encodeSparseMap(regions, header.Size)
+	headerBlocks, err := encodeSparseHeader(header, int64(len(sparseMap))+sparseDataSize(regions))
+	if err != nil {
+		return "", err
+	}
+	if err := tarWriter.Flush(); err != nil {
+		return "", err
+	}
+	if _, err := raw.Write(headerBlocks); err != nil {
+		return "", err
+	}
+	if _, err := raw.Write(sparseMap); err != nil {
+		return "", err
+	}
+	var hasher hash.Hash
+	if hashContent {
+		hasher = sha256.New()
+	}
+	var offset int64
+	for _, r := range regions {
+		if err := hashHole(hasher, tracker, r.Offset-offset); err != nil {
+			return "", err
+		}
+		if _, err := f.Seek(r.Offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		dst := raw
+		if hasher != nil {
+			dst = io.MultiWriter(raw, hasher)
+		}
+		if _, err := io.CopyN(dst, tracker.Reader(f), r.Length); err != nil {
+			return "", err
+		}
+		offset = r.Offset + r.Length
+	}
+	if err := hashHole(hasher, tracker, header.Size-offset); err != nil {
+		return "", err
+	}
+	if _, err := raw.Write(blockPadding(sparseDataSize(regions))); err != nil {
+		return "", err
+	}
+	if hasher == nil {
+		return "", nil
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashHole gives the hasher the zeros of a hole and counts them as processed, since they are part of the file even though they are not read.
+func hashHole(hasher hash.Hash, tracker *progress.Tracker, length int64) error {
+	if length <= 0 {
+		return nil
+	}
+	tracker.AddBytes(length)
+	if hasher == nil {
+		return nil
+	}
+	_, err := io.CopyN(hasher, zeroReader{}, length)
+	return err
+}
+
+// encodeSparseMap returns the GNU sparse 1.0 map of the regions padded to a block. If the file ends in a hole an empty region is added at the end like GNU tar does.
+func encodeSparseMap(regions []sparseRegion, size int64) []byte {
+	if len(regions) == 0 || regions[len(regions)-1].Offset+regions[len(regions)-1].Length < size {
+		regions = append(regions, sparseRegion{Offset: size})
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(regions)) + "\n")
+	for _, r := range regions {
+		fmt.Fprintf(&sb, "%d\n%d\n", r.Offset, r.Length)
+	}
+	sb.Write(blockPadding(int64(sb.Len())))
+	return []byte(sb.String())
+}
+
+// encodeSparseHeader returns the PAX extended header and the header block of a GNU sparse 1.0 member that stores storedSize bytes for the file of the header.
+// The header block is encoded by the tar writer, and any PAX records it needs are merged with the sparse records into one extended header.
+func encodeSparseHeader(header *tar.Header, storedSize int64) ([]byte, error) {
+	dir, file := path.Split(header.Name)
+	sparseHeader := *header
+	sparseHeader.Name = path.Join(dir, sparseFileDirName, file)
+	sparseHeader.Size = storedSize
+	sparseHeader.Format = tar.FormatPAX
+	sparseHeader.PAXRecords = nil
+	var encoded bytes.Buffer
+	if err := tar.NewWriter(&encoded).WriteHeader(&sparseHeader); err != nil {
+		return nil, err
+	}
+	records := map[string]string{
+		paxGNUSparseMajor:    "1",
+		paxGNUSparseMinor:    "0",
+		paxGNUSparseName:     header.Name,
+		paxGNUSparseRealSize: strconv.FormatInt(header.Size, 10),
+	}
+	if encoded.Len() > blockSize {
+		// the tar writer wrote an extended header of its own, read it back to keep its records
+		written, err := tar.NewReader(bytes.NewReader(encoded.Bytes())).Next()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range written.PAXRecords {
+			if _, ok := records[k]; !ok {
+				records[k] = v
+			}
+		}
+	}
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var paxData strings.Builder
+	for _, k := range keys {
+		paxData.WriteString(formatPAXRecord(k, records[k]))
+	}
+	blocks := make([]byte, 0, 3*blockSize+paxData.Len())
+	blocks = append(blocks, paxHeaderBlock(path.Join(dir, paxHeaderDirName, file), int64(paxData.Len()))...)
+	blocks = append(blocks, paxData.String()...)
+	blocks = append(blocks, blockPadding(int64(paxData.Len()))...)
+	blocks = append(blocks, encoded.Bytes()[encoded.Len()-blockSize:]...)
+	return blocks, nil
+}
+
+// formatPAXRecord formats a record as "<length> <key>=<value>\n" where the length counts itself.
+func formatPAXRecord(k, v string) string {
+	size := len(k) + len(v) + len(" =\n")
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		// adding the length made the length one digit longer
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// paxHeaderBlock returns the USTAR header block of a PAX extended header holding size bytes of records.
+func paxHeaderBlock(name string, size int64) []byte {
+	block := make([]byte, blockSize)
+	if len(name) > 99 {
+		name = name[:99]
+	}
+	copy(block[0:100], name)
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", size))
+	copy(block[136:148], "00000000000\x00")
+	block[156] = tar.TypeXHeader
+	copy(block[257:265], "ustar\x0000")
+	// the checksum is the sum of the block bytes with the checksum field counted as spaces
+	copy(block[148:156], "        ")
+	checksum := 0
+	for _, b := range block {
+		checksum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+	return block
+}
+
+// blockPadding returns the zeros that pad size bytes to a whole block.
+func blockPadding(size int64) []byte {
+	return make([]byte, (blockSize-size%blockSize)%blockSize)
+}
+
+// isSparseHeader returns true if the member was stored as a GNU sparse file in any of the PAX formats.
+func isSparseHeader(header *tar.Header) bool {
+	for k := range header.PAXRecords {
+		if strings.HasPrefix(k, paxGNUSparsePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSparseFile copies the contents of a sparse member to f seeking over zero blocks so they are left as holes, and sets the size of f to cover a trailing hole.
+// The tar reader returns the holes of a sparse member as zeros and does not expose the sparse map, so zero blocks are treated as holes.
+func writeSparseFile(f *os.File, r io.Reader) (int64, error) {
+	buf := make([]byte, 16*holeBlockSize)
+	var written int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		for start := 0; start < n; {
+			zero := isZeroBlock(buf[start:min(start+holeBlockSize, n)])
+			end := start
+			for end < n && isZeroBlock(buf[end:min(end+holeBlockSize, n)]) == zero {
+				end = min(end+holeBlockSize, n)
+			}
+			if zero {
+				if _, err := f.Seek(int64(end-start), io.SeekCurrent); err != nil {
+					return written, err
+				}
+			} else if _, err := f.Write(buf[start:end]); err != nil {
+				return written, err
+			}
+			written += int64(end - start)
+			start = end
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+	return written, f.Truncate(written)
+}
+
+func isZeroBlock(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
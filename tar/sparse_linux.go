@@ -0,0 +1,53 @@
+//go:build linux
+
+package tar
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lseek whence values for finding data and holes, which the syscall package does not define.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// findDataRegions returns the data regions of the file using SEEK_DATA and SEEK_HOLE. File systems without hole support report the whole file as one region.
+// The file offset is reset to the start of the file.
+func findDataRegions(f *os.File, size int64) ([]sparseRegion, error) {
+	regions := make([]sparseRegion, 0)
+	var offset int64
+	for offset < size {
+		dataStart, err := f.Seek(offset, seekData)
+		if err != nil {
+			if errors.Is(err, syscall.ENXIO) {
+				// there is no data past offset so the rest of the file is a hole
+				break
+			}
+			if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+				return []sparseRegion{{Offset: 0, Length: size}}, resetOffset(f)
+			}
+			return nil, err
+		}
+		if dataStart >= size {
+			break
+		}
+		holeStart, err := f.Seek(dataStart, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		// the file may have grown since it was stat'ed, only the stat'ed size is packaged
+		holeStart = min(holeStart, size)
+		regions = append(regions, sparseRegion{Offset: dataStart, Length: holeStart - dataStart})
+		offset = holeStart
+	}
+	return regions, resetOffset(f)
+}
+
+func resetOffset(f *os.File) error {
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
@@ -0,0 +1,127 @@
+//go:build linux
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestTarSparseRoundTrip(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath := t.TempDir()
+	sparsePath := filepath.Join(inputPath, "disk.img")
+	const size = 8 << 20
+	f, err := os.Create(sparsePath)
+	if err != nil {
+		t.Errorf("failed to create sparse file: %v", err)
+		return
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Errorf("failed to size sparse file: %v", err)
+		return
+	}
+	for _, offset := range []int64{1 << 20, 5 << 20} {
+		if _, err := f.WriteAt([]byte("some data"), offset); err != nil {
+			t.Errorf("failed to write data to sparse file: %v", err)
+			return
+		}
+	}
+	regions, err := findDataRegions(f, size)
+	f.Close()
+	if err != nil {
+		t.Errorf("failed to find data regions: %v", err)
+		return
+	}
+	if !hasHoles(regions, size) {
+		t.Skip("temp directory file system does not support holes")
+	}
+	if len(regions) != 2 {
+		t.Errorf("expected 2 data regions but got %+v", regions)
+	}
+	if err := os.WriteFile(filepath.Join(inputPath, "dense.txt"), []byte("dense file"), 0644); err != nil {
+		t.Errorf("failed to write dense file: %v", err)
+		return
+	}
+
+	var archive bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths:      []string{inputPath},
+		Output:          &archive,
+		Sparse:          true,
+		IncludeManifest: true,
+	})
+	if err != nil {
+		t.Errorf("failed to package tar: %v", err)
+		return
+	}
+	if archive.Len() >= 1<<20 {
+		t.Errorf("expected the holes to be left out of the archive but it is %d bytes", archive.Len())
+	}
+	tarReader := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	foundSparse := false
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		if header.Name == "disk.img" {
+			foundSparse = true
+			if header.Size != size || header.PAXRecords[paxGNUSparseMajor] != "1" {
+				t.Errorf("expected a GNU sparse 1.0 member of the full size: %+v", header)
+			}
+		}
+	}
+	if !foundSparse {
+		t.Error("expected the sparse file to be read back with its real name")
+	}
+	report, err := TarVerify(logger, TarVerifyParams{
+		Input: bytes.NewReader(archive.Bytes()),
+	})
+	if err != nil || !report.OK {
+		t.Errorf("expected manifest hashes to cover the holes: %v %+v", err, report)
+		return
+	}
+
+	outputPath := t.TempDir()
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage tar: %v", err)
+		return
+	}
+	original, err := os.ReadFile(sparsePath)
+	if err != nil {
+		t.Errorf("failed to read sparse file: %v", err)
+		return
+	}
+	unpackagedPath := filepath.Join(outputPath, "disk.img")
+	unpackaged, err := os.ReadFile(unpackagedPath)
+	if err != nil {
+		t.Errorf("failed to read unpackaged sparse file: %v", err)
+		return
+	}
+	if !bytes.Equal(original, unpackaged) {
+		t.Error("unpackaged sparse file does not match the original")
+	}
+	info, err := os.Stat(unpackagedPath)
+	if err != nil {
+		t.Errorf("failed to stat unpackaged sparse file: %v", err)
+		return
+	}
+	if allocated := info.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= size {
+		t.Errorf("expected the holes to be recreated but %d bytes are allocated", allocated)
+	}
+	dense, err := os.ReadFile(filepath.Join(outputPath, "dense.txt"))
+	if err != nil || string(dense) != "dense file" {
+		t.Errorf("expected dense file to round trip: %v", err)
+	}
+}
@@ -3,17 +3,23 @@ package tar
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
-	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/calvine/filejitsu/encrypt"
 	fgzip "github.com/calvine/filejitsu/gzip"
 	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/archivepath"
+	"github.com/calvine/filejitsu/util/progress"
 )
 
 const (
@@ -30,12 +36,33 @@ type EncryptionOptions struct {
 }
 
 type TarPackageParams struct {
-	InputPaths        []string
-	Output            io.Writer
-	UseGzip           bool
-	GZIPOptions       GZIPOptions
-	UseEncryption     bool
-	EncryptionOptions EncryptionOptions
+	InputPaths     []string
+	ExcludeOptions archivepath.ExcludeOptions
+	// FilesFrom are listed paths packaged after the input paths.
+	FilesFrom           archivepath.FilesFrom
+	Output              io.Writer
+	UseGzip             bool
+	GZIPOptions         GZIPOptions
+	UseEncryption       bool
+	EncryptionOptions   EncryptionOptions
+	Reproducible        bool
+	ReproducibleOptions ReproducibleOptions
+	Incremental         bool
+	IncrementalOptions  IncrementalOptions
+	// IncludeManifest if true a ContentManifestName member listing the size, mode and SHA-256 of every file is written to the end of the archive.
+	IncludeManifest bool
+	// Transform if set rewrites the entity names before they are packaged.
+	Transform TransformOptions
+	// Progress if set counts the packaged entities and file bytes. Its totals are set from the collected entities.
+	Progress *progress.Tracker
+	// Sparse if true regular files with holes are packaged as GNU sparse 1.0 members holding only their data. Holes are only detected on Linux.
+	Sparse bool
+	// Indexed if true the gzip stream is restarted at member boundaries and an ArchiveIndexName member with the offset of every member is written,
+	// so TarExtractMembers can seek straight to a member. Requires gzip and can not be used with encryption.
+	Indexed bool
+	// FinishOutput if set is called once the archive is closed to finish writing Output, like flushing a buffer or closing volumes.
+	// The incremental snapshot is only saved if the archive and FinishOutput succeed, so a failed archive never advances it.
+	FinishOutput func() error
 }
 
 type TarUnpackageParams struct {
@@ -44,59 +71,45 @@ type TarUnpackageParams struct {
 	UseGzip           bool
 	UseEncryption     bool
 	EncryptionOptions EncryptionOptions
+	// Incremental if true the deletions recorded in listed incremental archives are applied to the output path.
+	Incremental bool
+	// Transform if set rewrites the member names, and the names of recorded deletions, before they are unpackaged.
+	Transform TransformOptions
+	// Progress if set counts the members and the archive bytes read from the input. The caller sets the total bytes if the input size is known.
+	Progress *progress.Tracker
 }
 
 func TarPackage(logger *slog.Logger, params TarPackageParams) error {
 	logger.Debug("attempting to tar package the target path", slog.Any("params", params))
-	out := params.Output
-	// if use encryption then make encrypted writer
-	if params.UseEncryption {
-		logger.Debug("encryption enabled")
-		encryptedOut, err := encrypt.NewAESEncryptionWriter(logger, out, params.EncryptionOptions.Passphrase)
-		if err != nil {
-			logger.Error("failed to create encrypted stream writer", slog.String("errorMessage", err.Error()))
-			return err
-		}
-		out = encryptedOut
-		defer func() {
-			logger.Debug("closing encryption writer")
-			if err := encryptedOut.Close(); err != nil {
-				logger.Warn("encryption writer failed to close", slog.String("errorMessage", err.Error()))
-			}
-		}()
-	}
-	// if use gzip then make gzip writer
-	if params.UseGzip {
-		logger.Debug("gzip compression enabled", slog.Any("gzipOptions", params.GZIPOptions))
-		compressionLevel, err := fgzip.GZipCompressionLevelToLevel(params.GZIPOptions.CompressionLevel)
-		if err != nil {
-			logger.Error("invalid gzip compression level provided", slog.String("gzipCompressionLevel", string(params.GZIPOptions.CompressionLevel)))
-			return err
-		}
-		gzipOut, err := fgzip.NewGZIPWriter(logger, out, compressionLevel, gzip.Header{})
-		if err != nil {
-			logger.Error("failed to construct gzip writer", slog.String("errorMessage", err.Error()))
-			return err
-		}
-		out = gzipOut
-		defer func() {
-			logger.Debug("closing gzip writer")
-			// no need to flush, close flushes...
-			// if err := gzipOut.Flush(); err != nil {
-			// 	logger.Warn("gzip writer failed to flush", slog.String("errorMessage", err.Error()))
-			// }
-			if err := gzipOut.Close(); err != nil {
-				logger.Warn("gzip writer failed to close", slog.String("errorMessage", err.Error()))
-			}
-		}()
+	out, closeArchiveWriter, err := newArchiveWriter(logger, params.Output, params)
+	if err != nil {
+		return err
 	}
+	defer closeArchiveWriter()
 
-	if len(params.InputPaths) == 0 {
+	if len(params.InputPaths) == 0 && len(params.FilesFrom.Paths) == 0 {
 		errMsg := "no input paths provided for tar archive"
 		logger.Error(errMsg)
 		return errors.New(errMsg)
 	}
 
+	entries, err := archivepath.CollectInputEntries(logger, params.InputPaths, params.FilesFrom, params.ExcludeOptions)
+	if err != nil {
+		logger.Error("failed to collect entities to package", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if params.Transform.enabled() {
+		entries, err = transformEntries(logger, params.Transform, entries)
+		if err != nil {
+			return err
+		}
+	}
+	if params.Reproducible {
+		logger.Debug("sorting entities for reproducible archive", slog.Int("numEntries", len(entries)))
+		sortPackageEntries(entries)
+	}
+	setProgressTotals(params.Progress, entries)
+
 	// make the item to contain the tar data
 	tarWriter := tar.NewWriter(out)
 	defer func() {
@@ -109,114 +122,343 @@ func TarPackage(logger *slog.Logger, params TarPackageParams) error {
 			logger.Warn("tar writer failed to close", slog.String("errorMessage", err.Error()))
 		}
 	}()
-	for _, ip := range params.InputPaths {
-		logger.Info("processing input path", slog.String("path", ip))
-		filepath.Walk(ip, func(path string, info fs.FileInfo, err error) (returnErr error) {
-			walkLogger := logger.With(slog.String("path", path))
+	var incremental *incrementalState
+	snapshotExisted := false
+	if params.Incremental {
+		logger.Debug("incremental packaging enabled", slog.Any("incrementalOptions", params.IncrementalOptions))
+		var previous Snapshot
+		previous, snapshotExisted, err = LoadSnapshot(logger, params.IncrementalOptions.SnapshotPath)
+		if err != nil {
+			return err
+		}
+		incremental = newIncrementalState(previous)
+	}
+	var manifest *contentManifestBuilder
+	if params.IncludeManifest {
+		manifest = newContentManifestBuilder()
+	}
+	var index *archiveIndexBuilder
+	if indexedOut, ok := out.(*indexedGZIPWriter); ok {
+		index = newArchiveIndexBuilder(indexedOut)
+	}
+	numSkipped := 0
+	for _, entry := range entries {
+		if incremental != nil {
+			shouldPackage, err := incremental.shouldPackage(logger, entry)
 			if err != nil {
-				walkLogger.Error("failed to walk entity",
-					slog.String("errorMessage", err.Error()),
-				)
 				return err
 			}
-			fMode := info.Mode()
-			isRegular := fMode.IsRegular()
-			isDir := fMode.IsDir()
-			name := info.Name()
-			if !isRegular && !isDir {
-				walkLogger.Debug("skipping entity because its not a regular file or directory")
-				return nil
+			if !shouldPackage {
+				logger.Debug("skipping unchanged entity", slog.String("path", entry.Path))
+				params.Progress.SkipEntry(regularFileSize(entry))
+				numSkipped++
+				continue
 			}
-			tarHeader, returnErr := tar.FileInfoHeader(info, name)
-			if returnErr != nil {
-				walkLogger.Error("failed to create tar header for file",
+		}
+		var blockOffset, offset int64
+		if index != nil {
+			if blockOffset, offset, err = index.nextPosition(tarWriter); err != nil {
+				logger.Error("failed to start indexed block", slog.String("errorMessage", err.Error()))
+				return err
+			}
+		}
+		header, hash, err := writePackageEntry(logger, tarWriter, out, entry, params, incremental != nil || manifest != nil)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			index.record(header, blockOffset, offset)
+		}
+		if incremental != nil {
+			incremental.recordPackaged(entry, hash)
+		}
+		if manifest != nil {
+			manifest.record(header, hash)
+		}
+	}
+	metadataModTime := time.Now()
+	if params.Reproducible {
+		metadataModTime = params.ReproducibleOptions.clampTime()
+	}
+	if incremental != nil {
+		marker, err := incremental.writeMarker(tarWriter, metadataModTime)
+		if err != nil {
+			logger.Error("failed to write incremental marker", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		logger.Info("incremental archive written",
+			slog.Int("level", marker.Level),
+			slog.Int("numSkipped", numSkipped),
+			slog.Int("numDeleted", len(marker.Deleted)),
+		)
+	}
+	if manifest != nil {
+		if err := manifest.writeManifest(tarWriter, metadataModTime); err != nil {
+			logger.Error("failed to write content manifest", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		logger.Debug("content manifest written", slog.Int("numFiles", len(manifest.manifest.Files)))
+	}
+	if index != nil {
+		if err := index.writeIndex(tarWriter, metadataModTime); err != nil {
+			logger.Error("failed to write archive index", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		logger.Debug("archive index written", slog.Int("numMembers", len(index.index.Members)))
+	}
+	if err := tarWriter.Close(); err != nil {
+		logger.Error("failed to close tar writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := closeArchiveWriter(); err != nil {
+		logger.Error("failed to close archive writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if params.FinishOutput != nil {
+		if err := params.FinishOutput(); err != nil {
+			logger.Error("failed to finish writing archive output", slog.String("errorMessage", err.Error()))
+			return err
+		}
+	}
+	if incremental != nil {
+		if params.IncrementalOptions.Differential && snapshotExisted {
+			logger.Debug("differential backup, leaving snapshot unchanged")
+		} else if err := SaveSnapshot(logger, params.IncrementalOptions.SnapshotPath, incremental.current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePackageEntry writes the header for the entry to the tar writer, followed by the file contents if the entry is a regular file.
+// If params.Sparse is set regular files with holes are written as GNU sparse 1.0 members directly to raw, the stream the tar writer writes to.
+// The header of the file as it is unpackaged is returned, and if hashContent is true the hex encoded SHA-256 of the file contents computed while copying.
+func writePackageEntry(logger *slog.Logger, tarWriter *tar.Writer, raw io.Writer, entry archivepath.Entry, params TarPackageParams, hashContent bool) (*tar.Header, string, error) {
+	entryLogger := logger.With(slog.String("path", entry.Path))
+	params.Progress.StartEntry(entry.Name)
+	tarHeader, err := tar.FileInfoHeader(entry.Info, entry.Info.Name())
+	if err != nil {
+		entryLogger.Error("failed to create tar header for file",
+			slog.String("errorMessage", err.Error()),
+		)
+		return nil, "", err
+	}
+
+	tarHeader.Name = entry.Name
+	if params.Reproducible {
+		normalizeHeader(tarHeader, params.ReproducibleOptions)
+	}
+
+	var f *os.File
+	if entry.Info.Mode().IsRegular() {
+		f, err = os.Open(entry.Path)
+		if err != nil {
+			entryLogger.Error("failed to open file",
+				slog.String("errorMessage", err.Error()),
+			)
+			return nil, "", err
+		}
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				entryLogger.Error("failed to close file",
 					slog.String("errorMessage", err.Error()),
 				)
-				return returnErr
 			}
+		}()
+	}
 
-			tarHeader.Name = strings.TrimPrefix(strings.Replace(path, ip, "", -1), string(filepath.Separator))
+	var regions []sparseRegion
+	if f != nil && params.Sparse {
+		regions, err = findDataRegions(f, tarHeader.Size)
+		if err != nil {
+			entryLogger.Error("failed to find data regions of file", slog.String("errorMessage", err.Error()))
+			return nil, "", err
+		}
+		if !hasHoles(regions, tarHeader.Size) {
+			regions = nil
+		}
+	}
 
-			if tarHeader.Name == "" {
-				if isRegular {
-					walkLogger.Debug("got input path that is a file and not a directory, changing the header name to compensate")
-					tarHeader.Name = filepath.Base(path)
-				} else {
-					return nil
-				}
-			}
+	if regions != nil {
+		entryLogger.Debug("packaging file as sparse", slog.Int("numRegions", len(regions)), slog.Int64("dataSize", sparseDataSize(regions)), slog.Int64("size", tarHeader.Size))
+		hash, err := writeSparseEntry(tarWriter, raw, tarHeader, f, regions, hashContent, params.Progress)
+		if err != nil {
+			entryLogger.Error("failed to write sparse file to tar writer", slog.String("errorMessage", err.Error()))
+			return nil, "", err
+		}
+		return tarHeader, hash, nil
+	}
 
-			returnErr = tarWriter.WriteHeader(tarHeader)
-			if returnErr != nil {
-				walkLogger.Error("failed to write tar header for file", slog.String("errorMessage", err.Error()))
-				return returnErr
-			}
+	if err := tarWriter.WriteHeader(tarHeader); err != nil {
+		entryLogger.Error("failed to write tar header for file", slog.String("errorMessage", err.Error()))
+		return nil, "", err
+	}
 
-			if fMode.IsRegular() {
-				logger.Debug("item is regular file, so writing file to tar package")
-				f, returnErr := os.Open(path)
-				if returnErr != nil {
-					walkLogger.Error("failed to open file",
-						slog.String("errorMessage", returnErr.Error()),
-					)
-					return returnErr
-				}
+	if f != nil {
+		logger.Debug("item is regular file, so writing file to tar package")
+		var hasher hash.Hash
+		var dst io.Writer = tarWriter
+		if hashContent {
+			hasher = sha256.New()
+			dst = io.MultiWriter(tarWriter, hasher)
+		}
+		bytesWritten, err := io.Copy(dst, params.Progress.Reader(f))
+		logger.Debug("bytes written to tar writer", slog.Int64("bytesWritten", bytesWritten))
+		if err != nil {
+			entryLogger.Error("failed to copy file to tar writer",
+				slog.String("errorMessage", err.Error()),
+			)
+			return nil, "", err
+		}
+		if hasher != nil {
+			return tarHeader, hex.EncodeToString(hasher.Sum(nil)), nil
+		}
+	}
+
+	return tarHeader, "", nil
+}
 
-				defer func() {
-					returnErr = f.Close()
-					if returnErr != nil {
-						walkLogger.Error("failed to close file",
-							slog.String("errorMessage", returnErr.Error()),
-						)
-					}
-				}()
+// setProgressTotals sets the totals of the tracker to the number of entries and the size of the regular files in them.
+func setProgressTotals(tracker *progress.Tracker, entries []archivepath.Entry) {
+	var totalBytes int64
+	for _, entry := range entries {
+		totalBytes += regularFileSize(entry)
+	}
+	tracker.SetTotalEntries(int64(len(entries)))
+	tracker.SetTotalBytes(totalBytes)
+}
 
-				bytesWritten, returnErr := io.Copy(tarWriter, f)
-				logger.Debug("bytes written to tar writer", slog.Int64("bytesWritten", bytesWritten))
-				if returnErr != nil {
-					walkLogger.Error("failed to copy file to tar writer",
-						slog.String("errorMessage", err.Error()),
-					)
-					return returnErr
-				}
-			}
+func regularFileSize(entry archivepath.Entry) int64 {
+	if !entry.Info.Mode().IsRegular() {
+		return 0
+	}
+	return entry.Info.Size()
+}
 
+// newArchiveWriter wraps the output with encryption and gzip compression as needed. The returned func closes the wrappers and must be called when done writing.
+func newArchiveWriter(logger *slog.Logger, output io.Writer, params TarPackageParams) (io.Writer, func() error, error) {
+	out := output
+	closers := make([]func() error, 0, 2)
+	closeFunc := func() error {
+		var closeErr error
+		// close in the reverse order the writers were created so each flushes into the next
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				closeErr = errors.Join(closeErr, err)
+			}
+		}
+		closers = nil
+		return closeErr
+	}
+	if params.Indexed && (!params.UseGzip || params.UseEncryption) {
+		logger.Error("indexed archives require gzip without encryption")
+		return nil, closeFunc, ErrIndexedRequiresGzip
+	}
+	// if use encryption then make encrypted writer
+	if params.UseEncryption {
+		logger.Debug("encryption enabled")
+		if params.Reproducible {
+			logger.Warn("encrypted output uses a random iv so it will not be byte for byte reproducible")
+		}
+		encryptedOut, err := encrypt.NewAESEncryptionWriter(logger, out, params.EncryptionOptions.Passphrase)
+		if err != nil {
+			logger.Error("failed to create encrypted stream writer", slog.String("errorMessage", err.Error()))
+			return nil, closeFunc, err
+		}
+		out = encryptedOut
+		closers = append(closers, func() error {
+			logger.Debug("closing encryption writer")
+			if err := encryptedOut.Close(); err != nil {
+				logger.Warn("encryption writer failed to close", slog.String("errorMessage", err.Error()))
+				return err
+			}
 			return nil
 		})
 	}
-	if err := tarWriter.Close(); err != nil {
-		logger.Warn("failed to close tar writer", slog.String("errorMessage", err.Error()))
+	// if use gzip then make gzip writer
+	if params.UseGzip {
+		logger.Debug("gzip compression enabled", slog.Any("gzipOptions", params.GZIPOptions))
+		compressionLevel, err := fgzip.GZipCompressionLevelToLevel(params.GZIPOptions.CompressionLevel)
+		if err != nil {
+			logger.Error("invalid gzip compression level provided", slog.String("gzipCompressionLevel", string(params.GZIPOptions.CompressionLevel)))
+			closeFunc()
+			return nil, closeFunc, err
+		}
+		gzipHeader := params.GZIPOptions.Header
+		if params.Reproducible {
+			gzipHeader = reproducibleGZIPHeader(gzipHeader, params.ReproducibleOptions)
+		}
+		gzipOut, err := fgzip.NewGZIPWriter(logger, out, compressionLevel, gzipHeader)
+		if err != nil {
+			logger.Error("failed to construct gzip writer", slog.String("errorMessage", err.Error()))
+			closeFunc()
+			return nil, closeFunc, err
+		}
+		if params.Indexed {
+			indexedOut := newIndexedGZIPWriter(out, gzipOut)
+			out = indexedOut
+			closers = append(closers, func() error {
+				logger.Debug("closing indexed gzip writer")
+				if err := indexedOut.Close(); err != nil {
+					logger.Warn("indexed gzip writer failed to close", slog.String("errorMessage", err.Error()))
+					return err
+				}
+				return nil
+			})
+			return out, closeFunc, nil
+		}
+		out = gzipOut
+		closers = append(closers, func() error {
+			logger.Debug("closing gzip writer")
+			// no need to flush, close flushes...
+			if err := gzipOut.Close(); err != nil {
+				logger.Warn("gzip writer failed to close", slog.String("errorMessage", err.Error()))
+				return err
+			}
+			return nil
+		})
 	}
-	return nil
+	return out, closeFunc, nil
 }
 
-func TarUnpackage(logger *slog.Logger, params TarUnpackageParams) error {
-	in := params.Input
-
-	if params.UseEncryption {
+// newArchiveReader wraps the input with decryption and gzip decompression as needed. The returned func must be called when done reading.
+func newArchiveReader(logger *slog.Logger, input io.Reader, useGzip, useEncryption bool, encryptionOptions EncryptionOptions) (io.Reader, func(), error) {
+	in := input
+	closeFunc := func() {}
+	if useEncryption {
 		logger.Debug("using decryption for tar unpack")
-		decryptionReader, err := encrypt.NewAESDecryptionReader(logger, in, params.EncryptionOptions.Passphrase)
+		decryptionReader, err := encrypt.NewAESDecryptionReader(logger, in, encryptionOptions.Passphrase)
 		if err != nil {
 			logger.Error("failed to create decryption reader", slog.String("errorMessage", err.Error()))
-			return err
+			return nil, closeFunc, err
 		}
 		in = decryptionReader
 	}
-	if params.UseGzip {
+	if useGzip {
 		logger.Debug("using gzip for tar unpack")
 		gzipReader, _, err := fgzip.NewGZIPReader(logger, in)
 		if err != nil {
 			logger.Error("failed to create gzip reader", slog.String("errorMessage", err.Error()))
-			return err
+			return nil, closeFunc, err
 		}
 		in = gzipReader
-		defer func() {
+		closeFunc = func() {
 			logger.Debug("closing gzip reader")
 			if err := gzipReader.Close(); err != nil {
 				logger.Warn("gzip reader failed to close", slog.String("errorMessage", err.Error()))
 			}
-		}()
+		}
+	}
+	return in, closeFunc, nil
+}
+
+func TarUnpackage(logger *slog.Logger, params TarUnpackageParams) error {
+	in, closeArchiveReader, err := newArchiveReader(logger, params.Progress.Reader(params.Input), params.UseGzip, params.UseEncryption, params.EncryptionOptions)
+	if err != nil {
+		return err
 	}
+	defer closeArchiveReader()
 
 	tarReader := tar.NewReader(in)
 	if err := util.MakeAllDirIfNotExists(logger, params.OutputPath, DefaultPermission); err != nil {
@@ -255,45 +497,122 @@ func TarUnpackage(logger *slog.Logger, params TarUnpackageParams) error {
 			continue
 		}
 		numFiles++
-		target := filepath.Join(params.OutputPath, nextHeader.Name)
-		logger.Debug("starting to unpackage item", slog.String("target", target))
-		switch nextHeader.Typeflag {
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			logger.Debug("got directory from tar", slog.String("target", target))
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, DefaultPermission); err != nil {
-					logger.Error("failed to make target directory", slog.String("target", target))
+		params.Progress.StartEntry(nextHeader.Name)
+		if nextHeader.Name == ContentManifestName && nextHeader.Typeflag == tar.TypeReg {
+			logger.Debug("skipping content manifest")
+			continue
+		}
+		if nextHeader.Name == ArchiveIndexName && nextHeader.Typeflag == tar.TypeReg {
+			logger.Debug("skipping archive index")
+			continue
+		}
+		if nextHeader.Name == IncrementalMarkerName && nextHeader.Typeflag == tar.TypeReg {
+			if !params.Incremental {
+				logger.Debug("skipping incremental marker because incremental restore is not enabled")
+				continue
+			}
+			marker := IncrementalMarker{}
+			if err := json.NewDecoder(tarReader).Decode(&marker); err != nil {
+				logger.Error("failed to read incremental marker", slog.String("errorMessage", err.Error()))
+				return err
+			}
+			if params.Transform.enabled() {
+				if marker.Deleted, err = transformDeleted(logger, params.Transform, marker.Deleted); err != nil {
 					return err
 				}
 			}
-
-		// if it's a file create it
-		case tar.TypeReg:
-			logger.Debug("got regular file from tar", slog.String("target", target))
-			pathToFile := filepath.Dir(target)
-			if err := util.MakeAllDirIfNotExists(logger, pathToFile, DefaultPermission); err != nil {
-				logger.Error("failed to create directory ")
+			if err := applyIncrementalMarker(logger, params.OutputPath, marker); err != nil {
+				return err
 			}
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(nextHeader.Mode))
+			continue
+		}
+		if params.Transform.enabled() {
+			nextHeader.Name, err = transformName(logger, params.Transform, nextHeader.Name)
 			if err != nil {
-				logger.Error("failed to open target file for unpackaging", slog.String("target", target))
 				return err
 			}
+			if len(nextHeader.Name) == 0 {
+				logger.Debug("skipping member whose name was transformed to nothing")
+				continue
+			}
+		}
+		if err := unpackageMember(logger, nextHeader, tarReader, params.OutputPath); err != nil {
+			return err
+		}
+	}
+}
 
-			// copy over contents
-			bytesWritten, err := io.Copy(f, tarReader)
-			logger.Debug("bytes written to output file", slog.String("target", target), slog.Int64("bytesWritten", bytesWritten))
-			if err != nil {
-				logger.Error("failed to write tar data to output file", slog.String("target", target), slog.String("errorMessage", err.Error()))
+// unpackageMember writes the member read from r to its path under the output path. Members other than directories and regular files are skipped.
+func unpackageMember(logger *slog.Logger, nextHeader *tar.Header, r io.Reader, outputPath string) error {
+	target, err := archivepath.SafeTargetPath(outputPath, nextHeader.Name)
+	if err != nil {
+		logger.Error("refusing to unpackage item outside of output path", slog.String("name", nextHeader.Name), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	logger.Debug("starting to unpackage item", slog.String("target", target))
+	switch nextHeader.Typeflag {
+	// if its a dir and it doesn't exist create it
+	case tar.TypeDir:
+		logger.Debug("got directory from tar", slog.String("target", target))
+		if _, err := os.Stat(target); err != nil {
+			if err := os.MkdirAll(target, DefaultPermission); err != nil {
+				logger.Error("failed to make target directory", slog.String("target", target))
 				return err
 			}
+		}
 
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			if err := f.Close(); err != nil {
-				logger.Warn("failed to close target file", slog.String("target", target), slog.String("errorMessage", err.Error()))
-			}
+	// if it's a file create it
+	case tar.TypeReg:
+		logger.Debug("got regular file from tar", slog.String("target", target))
+		pathToFile := filepath.Dir(target)
+		if err := util.MakeAllDirIfNotExists(logger, pathToFile, DefaultPermission); err != nil {
+			logger.Error("failed to create directory ")
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(nextHeader.Mode))
+		if err != nil {
+			logger.Error("failed to open target file for unpackaging", slog.String("target", target))
+			return err
+		}
+
+		// copy over contents
+		var bytesWritten int64
+		if isSparseHeader(nextHeader) {
+			logger.Debug("item is sparse, recreating holes", slog.String("target", target))
+			bytesWritten, err = writeSparseFile(f, r)
+		} else {
+			bytesWritten, err = io.Copy(f, r)
+		}
+		logger.Debug("bytes written to output file", slog.String("target", target), slog.Int64("bytesWritten", bytesWritten))
+		if err != nil {
+			logger.Error("failed to write tar data to output file", slog.String("target", target), slog.String("errorMessage", err.Error()))
+			return err
+		}
+
+		// manually close here after each file operation; defering would cause each file close
+		// to wait until all operations have completed.
+		if err := f.Close(); err != nil {
+			logger.Warn("failed to close target file", slog.String("target", target), slog.String("errorMessage", err.Error()))
 		}
 	}
+	return nil
+}
+
+// TarUnpackageIncrementalChain unpackages a base archive from params.Input and then each increment in order, applying the deletions recorded in each level.
+func TarUnpackageIncrementalChain(logger *slog.Logger, params TarUnpackageParams, increments []io.Reader) error {
+	params.Incremental = true
+	logger.Debug("unpackaging base archive of incremental chain", slog.Int("numIncrements", len(increments)))
+	if err := TarUnpackage(logger, params); err != nil {
+		logger.Error("failed to unpackage base archive", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	for i, increment := range increments {
+		logger.Debug("unpackaging increment", slog.Int("increment", i+1))
+		incrementParams := params
+		incrementParams.Input = increment
+		if err := TarUnpackage(logger, incrementParams); err != nil {
+			logger.Error("failed to unpackage increment", slog.Int("increment", i+1), slog.String("errorMessage", err.Error()))
+			return fmt.Errorf("failed to unpackage increment %d: %w", i+1, err)
+		}
+	}
+	return nil
 }
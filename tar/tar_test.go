@@ -1,14 +1,23 @@
 package tar
 
 import (
+	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/calvine/filejitsu/bulkrename"
 	"github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/util/archivepath"
 	"github.com/calvine/filejitsu/util/mock"
 )
 
@@ -105,3 +114,761 @@ func TestTarPackageRoundTrip(t *testing.T) {
 	}
 	inputTar.Close()
 }
+
+func TestTarPackageExclude(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootDir := mock.GetRandomTmpDirName()
+	incompleteContent := []mock.IncompleteMockFile{
+		{RelativePath: filepath.Join("main.go"), Content: "package main"},
+		{RelativePath: filepath.Join(".gitignore"), Content: "*.log\n/build/\n"},
+		{RelativePath: filepath.Join("app.log"), Content: "log data"},
+		{RelativePath: filepath.Join("build", "app"), Content: "binary"},
+		{RelativePath: filepath.Join("node_modules", "pkg", "index.js"), Content: "js"},
+		{RelativePath: filepath.Join("src", ".filejitsuignore"), Content: "!keep.log\n"},
+		{RelativePath: filepath.Join("src", "keep.log"), Content: "kept log"},
+		{RelativePath: filepath.Join("src", "drop.log"), Content: "dropped log"},
+		{RelativePath: filepath.Join("src", "build", "out.txt"), Content: "not anchored at root"},
+	}
+	content, err := mock.MakeMockDirContentMap(rootDir, incompleteContent)
+	if err != nil {
+		t.Errorf("failed to make content map: %v", err)
+		return
+	}
+	inputPath, content, cleanup, err := mock.CreateCustomMockDirTree(rootDir, content)
+	if err != nil {
+		t.Errorf("failed to create mock dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	var output bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths: []string{inputPath},
+		ExcludeOptions: archivepath.ExcludeOptions{
+			Patterns:           []string{"node_modules/"},
+			RespectIgnoreFiles: true,
+		},
+		Output: &output,
+	})
+	if err != nil {
+		t.Errorf("failed to write tar output: %v", err)
+		return
+	}
+	outputPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(outputPath)
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      &output,
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage tar: %v", err)
+		return
+	}
+	for _, excluded := range []string{
+		"app.log",
+		filepath.Join("build", "app"),
+		filepath.Join("node_modules", "pkg", "index.js"),
+		filepath.Join("src", "drop.log"),
+	} {
+		delete(content, excluded)
+	}
+	if err := mock.ConfirmContentMapMatches(outputPath, content); err != nil {
+		t.Errorf("unpackaged content did not match expected content: %v", err)
+	}
+}
+
+func TestTarPackageReproducible(t *testing.T) {
+	logger := mock.NewMockLogger()
+	clamp := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	packageTree := func(modTime time.Time, perm os.FileMode) ([]byte, error) {
+		inputPath, _, cleanup, err := mock.MakeGenericMockDirTree()
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		err = filepath.Walk(inputPath, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if err := os.Chmod(path, perm); err != nil {
+					return err
+				}
+			}
+			return os.Chtimes(path, modTime, modTime)
+		})
+		if err != nil {
+			return nil, err
+		}
+		var output bytes.Buffer
+		err = TarPackage(logger, TarPackageParams{
+			InputPaths: []string{inputPath},
+			UseGzip:    true,
+			GZIPOptions: GZIPOptions{
+				CompressionLevel: gzip.DefaultCompression,
+			},
+			Reproducible: true,
+			ReproducibleOptions: ReproducibleOptions{
+				ModTime: clamp,
+			},
+			Output: &output,
+		})
+		return output.Bytes(), err
+	}
+	first, err := packageTree(time.Now(), 0600)
+	if err != nil {
+		t.Errorf("failed to package first tree: %v", err)
+		return
+	}
+	second, err := packageTree(time.Now().Add(time.Hour), 0640)
+	if err != nil {
+		t.Errorf("failed to package second tree: %v", err)
+		return
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("reproducible archives of identical content were not identical")
+		return
+	}
+	gzipReader, _, err := gzip.NewGZIPReader(logger, bytes.NewReader(first))
+	if err != nil {
+		t.Errorf("failed to read gzip stream: %v", err)
+		return
+	}
+	tarReader := tar.NewReader(gzipReader)
+	previousName := ""
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("failed to read tar header: %v", err)
+			return
+		}
+		if !header.ModTime.Equal(clamp) {
+			t.Errorf("%s mod time was %v expected %v", header.Name, header.ModTime, clamp)
+		}
+		if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" {
+			t.Errorf("%s owner was not zeroed", header.Name)
+		}
+		if header.Typeflag == tar.TypeReg && header.Mode != ReproducibleFilePermission {
+			t.Errorf("%s mode was %o expected %o", header.Name, header.Mode, ReproducibleFilePermission)
+		}
+		if compareEntryNames(previousName, header.Name) > 0 {
+			t.Errorf("entries out of order: %s before %s", previousName, header.Name)
+		}
+		previousName = header.Name
+	}
+}
+
+func TestTarPackageIncrementalFailedOutputKeepsSnapshot(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, _, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create mock dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	snapshotPath := filepath.Join(t.TempDir(), "backup.snar")
+	finishErr := errors.New("disk full")
+	var output bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths:  []string{inputPath},
+		Incremental: true,
+		IncrementalOptions: IncrementalOptions{
+			SnapshotPath: snapshotPath,
+		},
+		Output: &output,
+		FinishOutput: func() error {
+			return finishErr
+		},
+	})
+	if !errors.Is(err, finishErr) {
+		t.Errorf("expected the output error to be returned: %v", err)
+	}
+	if _, err := os.Stat(snapshotPath); !os.IsNotExist(err) {
+		t.Errorf("expected no snapshot to be saved for an archive that was not completely written: %v", err)
+	}
+}
+
+func TestTarPackageIncrementalRoundTrip(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create mock dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	snapshotPath := filepath.Join(t.TempDir(), "backup.snar")
+	packageIncrement := func() (*bytes.Buffer, error) {
+		var output bytes.Buffer
+		err := TarPackage(logger, TarPackageParams{
+			InputPaths:  []string{inputPath},
+			Incremental: true,
+			IncrementalOptions: IncrementalOptions{
+				SnapshotPath: snapshotPath,
+			},
+			Output: &output,
+		})
+		return &output, err
+	}
+	base, err := packageIncrement()
+	if err != nil {
+		t.Errorf("failed to package base archive: %v", err)
+		return
+	}
+
+	// change one file, delete one, add one and touch one without changing its content
+	changedPath := filepath.Join(inputPath, "file1.txt")
+	if err := os.WriteFile(changedPath, []byte("changed content!!!!!!!!!!!!!!!!"), 0644); err != nil {
+		t.Errorf("failed to change file: %v", err)
+		return
+	}
+	if err := os.Remove(filepath.Join(inputPath, "file2.txt")); err != nil {
+		t.Errorf("failed to delete file: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(inputPath, "nested", "new.txt"), []byte("new file"), 0644); err != nil {
+		t.Errorf("failed to add file: %v", err)
+		return
+	}
+	touched := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(inputPath, "nested", "bigfile.txt"), touched, touched); err != nil {
+		t.Errorf("failed to touch file: %v", err)
+		return
+	}
+
+	increment, err := packageIncrement()
+	if err != nil {
+		t.Errorf("failed to package increment: %v", err)
+		return
+	}
+	incrementFiles := make([]string, 0)
+	tarReader := tar.NewReader(bytes.NewReader(increment.Bytes()))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("failed to read increment: %v", err)
+			return
+		}
+		if header.Typeflag == tar.TypeReg {
+			incrementFiles = append(incrementFiles, filepath.ToSlash(header.Name))
+		}
+	}
+	slices.Sort(incrementFiles)
+	expectedFiles := []string{IncrementalMarkerName, "file1.txt", "nested/new.txt"}
+	if !slices.Equal(incrementFiles, expectedFiles) {
+		t.Errorf("increment contained %v expected %v", incrementFiles, expectedFiles)
+		return
+	}
+
+	outputPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(outputPath)
+	err = TarUnpackageIncrementalChain(logger, TarUnpackageParams{
+		Input:      base,
+		OutputPath: outputPath,
+	}, []io.Reader{increment})
+	if err != nil {
+		t.Errorf("failed to unpackage incremental chain: %v", err)
+		return
+	}
+	expectedContent, err := mock.MakeMockDirContentMap(inputPath, []mock.IncompleteMockFile{
+		{RelativePath: "file1.txt", Content: "changed content!!!!!!!!!!!!!!!!"},
+		{RelativePath: filepath.Join("nested", "new.txt"), Content: "new file"},
+		{RelativePath: filepath.Join("nested", "bigfile.txt"), Content: content[filepath.Join("nested", "bigfile.txt")].Content},
+		{RelativePath: filepath.Join("nested", "nexted2", "file.txt"), Content: content[filepath.Join("nested", "nexted2", "file.txt")].Content},
+	})
+	if err != nil {
+		t.Errorf("failed to make expected content map: %v", err)
+		return
+	}
+	if err := mock.ConfirmContentMapMatches(outputPath, expectedContent); err != nil {
+		t.Errorf("restored content did not match: %v", err)
+	}
+}
+
+func TestTarVerify(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	var archive bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths:      []string{inputPath},
+		Output:          &archive,
+		IncludeManifest: true,
+	})
+	if err != nil {
+		t.Errorf("failed to package tar: %v", err)
+		return
+	}
+	report, err := TarVerify(logger, TarVerifyParams{
+		Input: bytes.NewReader(archive.Bytes()),
+	})
+	if err != nil {
+		t.Errorf("failed to verify archive: %v", err)
+		return
+	}
+	if !report.OK || report.NumChecked != len(content) {
+		t.Errorf("expected archive to verify with %d files checked: %+v", len(content), report)
+		return
+	}
+
+	outputPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(outputPath)
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage tar: %v", err)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, ContentManifestName)); !os.IsNotExist(err) {
+		t.Errorf("expected content manifest to not be extracted: %v", err)
+		return
+	}
+	report, err = TarVerify(logger, TarVerifyParams{
+		Input:       bytes.NewReader(archive.Bytes()),
+		AgainstPath: outputPath,
+	})
+	if err != nil {
+		t.Errorf("failed to verify against extracted files: %v", err)
+		return
+	}
+	if !report.OK {
+		t.Errorf("expected extracted files to verify: %+v", report)
+		return
+	}
+
+	if err := os.WriteFile(filepath.Join(outputPath, "file1.txt"), []byte("tampered"), 0644); err != nil {
+		t.Errorf("failed to modify extracted file: %v", err)
+		return
+	}
+	if err := os.Remove(filepath.Join(outputPath, "file2.txt")); err != nil {
+		t.Errorf("failed to remove extracted file: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(outputPath, "nested", "extra.txt"), []byte("extra"), 0644); err != nil {
+		t.Errorf("failed to add extra file: %v", err)
+		return
+	}
+	report, err = TarVerify(logger, TarVerifyParams{
+		Input:       bytes.NewReader(archive.Bytes()),
+		AgainstPath: outputPath,
+	})
+	if err != nil {
+		t.Errorf("failed to verify against modified files: %v", err)
+		return
+	}
+	if report.OK {
+		t.Error("expected modified files to fail verification")
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Name != "file1.txt" {
+		t.Errorf("expected file1.txt to be a mismatch: %+v", report.Mismatches)
+	}
+	if !slices.Equal(report.Missing, []string{"file2.txt"}) {
+		t.Errorf("expected file2.txt to be missing: %v", report.Missing)
+	}
+	if !slices.Equal(report.Extras, []string{"nested/extra.txt"}) {
+		t.Errorf("expected nested/extra.txt to be an extra: %v", report.Extras)
+	}
+}
+
+func TestTarVerifyNoManifest(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, _, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	var archive bytes.Buffer
+	if err := TarPackage(logger, TarPackageParams{InputPaths: []string{inputPath}, Output: &archive}); err != nil {
+		t.Errorf("failed to package tar: %v", err)
+		return
+	}
+	_, err = TarVerify(logger, TarVerifyParams{Input: &archive})
+	if !errors.Is(err, ErrNoContentManifest) {
+		t.Errorf("expected ErrNoContentManifest got %v", err)
+	}
+}
+
+func TestTarAppend(t *testing.T) {
+	type testCase struct {
+		Name    string
+		UseGzip bool
+		Indexed bool
+	}
+	testCases := []testCase{
+		{Name: "plain archive appended in place"},
+		{Name: "gzipped archive rewritten", UseGzip: true},
+		{Name: "indexed archive rewritten with a new index", UseGzip: true, Indexed: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			logger := mock.NewMockLogger()
+			inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+			if err != nil {
+				t.Errorf("failed to create test dir tree: %v", err)
+				return
+			}
+			defer cleanup()
+			archivePath := filepath.Join(t.TempDir(), "archive.tar")
+			archive, err := os.Create(archivePath)
+			if err != nil {
+				t.Errorf("failed to create archive file: %v", err)
+				return
+			}
+			packageParams := TarPackageParams{
+				InputPaths:      []string{inputPath},
+				Output:          archive,
+				UseGzip:         tc.UseGzip,
+				GZIPOptions:     GZIPOptions{CompressionLevel: gzip.DefaultCompression},
+				IncludeManifest: true,
+				Indexed:         tc.Indexed,
+			}
+			err = TarPackage(logger, packageParams)
+			archive.Close()
+			if err != nil {
+				t.Errorf("failed to package tar: %v", err)
+				return
+			}
+
+			// add a new file and make an existing file newer, update should only add those two
+			newFilePath := filepath.Join(inputPath, "nested", "appended.txt")
+			if err := os.WriteFile(newFilePath, []byte("appended content"), 0644); err != nil {
+				t.Errorf("failed to write new file: %v", err)
+				return
+			}
+			changedPath := filepath.Join(inputPath, "file1.txt")
+			if err := os.WriteFile(changedPath, []byte("updated content"), 0644); err != nil {
+				t.Errorf("failed to update file: %v", err)
+				return
+			}
+			future := time.Now().Add(time.Hour)
+			if err := os.Chtimes(changedPath, future, future); err != nil {
+				t.Errorf("failed to change file mod time: %v", err)
+				return
+			}
+			packageParams.Output = nil
+			err = TarAppend(logger, TarAppendParams{
+				TarPackageParams: packageParams,
+				ArchivePath:      archivePath,
+				Update:           true,
+			})
+			if err != nil {
+				t.Errorf("failed to update tar: %v", err)
+				return
+			}
+
+			archiveData, err := os.ReadFile(archivePath)
+			if err != nil {
+				t.Errorf("failed to read archive: %v", err)
+				return
+			}
+			var tarStream io.Reader = bytes.NewReader(archiveData)
+			if tc.UseGzip {
+				tarStream, _, err = gzip.NewGZIPReader(logger, tarStream)
+				if err != nil {
+					t.Errorf("failed to read gzip stream: %v", err)
+					return
+				}
+			}
+			names := make([]string, 0)
+			tarReader := tar.NewReader(tarStream)
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("failed to read tar header: %v", err)
+					return
+				}
+				if header.Typeflag == tar.TypeReg && !isMetadataMember(header) {
+					names = append(names, filepath.ToSlash(header.Name))
+				}
+			}
+			// every original file once, plus the updated and new file
+			if len(names) != len(content)+2 {
+				t.Errorf("expected %d file members got %d: %v", len(content)+2, len(names), names)
+			}
+			if !slices.Equal(names[len(names)-2:], []string{"file1.txt", "nested/appended.txt"}) {
+				t.Errorf("expected updated and new files at the end of the archive: %v", names)
+			}
+
+			report, err := TarVerify(logger, TarVerifyParams{
+				Input:       bytes.NewReader(archiveData),
+				UseGzip:     tc.UseGzip,
+				AgainstPath: inputPath,
+			})
+			if err != nil {
+				t.Errorf("failed to verify updated archive: %v", err)
+				return
+			}
+			if !report.OK {
+				t.Errorf("expected updated archive manifest to match input: %+v", report)
+			}
+			if !tc.Indexed {
+				return
+			}
+			index, err := ReadArchiveIndex(bytes.NewReader(archiveData), int64(len(archiveData)))
+			if err != nil {
+				t.Errorf("expected the rewritten archive to have an index: %v", err)
+				return
+			}
+			if !slices.ContainsFunc(index.Members, func(e ArchiveIndexEntry) bool { return e.Name == "nested/appended.txt" }) {
+				t.Errorf("expected the index to list the appended file: %+v", index)
+			}
+			outputPath := mock.GetRandomTmpDirName()
+			defer os.RemoveAll(outputPath)
+			err = TarExtractMembers(logger, TarExtractParams{
+				Input:      bytes.NewReader(archiveData),
+				Names:      []string{"nested/appended.txt", "file2.txt"},
+				OutputPath: outputPath,
+				UseGzip:    true,
+			})
+			if err != nil {
+				t.Errorf("failed to extract members with the rebuilt index: %v", err)
+				return
+			}
+			if data, err := os.ReadFile(filepath.Join(outputPath, "nested", "appended.txt")); err != nil || string(data) != "appended content" {
+				t.Errorf("expected the appended file to be extracted: %v", err)
+			}
+			if data, err := os.ReadFile(filepath.Join(outputPath, "file2.txt")); err != nil || string(data) != content["file2.txt"].Content {
+				t.Errorf("expected the copied file to be extracted: %v", err)
+			}
+		})
+	}
+}
+
+func TestTarTransform(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	newTransform := func(targetRegex, destinationTemplate string) TransformOptions {
+		template, err := bulkrename.NewDestinationTemplate(destinationTemplate)
+		if err != nil {
+			t.Fatalf("failed to parse template: %v", err)
+		}
+		return TransformOptions{
+			TargetRegex:         regexp.MustCompile(targetRegex),
+			DestinationTemplate: template,
+		}
+	}
+	var archive bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths:      []string{inputPath},
+		Output:          &archive,
+		IncludeManifest: true,
+		Transform:       newTransform(`^(?P<name>file)(?P<num>\d)\.txt$`, `{{padLeft .num "0" 3}}-{{.name}}.txt`),
+	})
+	if err != nil {
+		t.Errorf("failed to package tar: %v", err)
+		return
+	}
+	report, err := TarVerify(logger, TarVerifyParams{
+		Input: bytes.NewReader(archive.Bytes()),
+	})
+	if err != nil || !report.OK {
+		t.Errorf("expected manifest to use the transformed names: %v %+v", err, report)
+		return
+	}
+	outputPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(outputPath)
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		OutputPath: outputPath,
+		Transform:  newTransform(`^nested(?P<rest>/.*)?$`, `deep{{.rest}}`),
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage tar: %v", err)
+		return
+	}
+	expected := map[string]string{
+		"001-file.txt":                               "file1.txt",
+		"002-file.txt":                               "file2.txt",
+		filepath.Join("deep", "bigfile.txt"):         filepath.Join("nested", "bigfile.txt"),
+		filepath.Join("deep", "nexted2", "file.txt"): filepath.Join("nested", "nexted2", "file.txt"),
+	}
+	for newName, originalName := range expected {
+		data, err := os.ReadFile(filepath.Join(outputPath, newName))
+		if err != nil {
+			t.Errorf("expected transformed file %s: %v", newName, err)
+			continue
+		}
+		if string(data) != content[originalName].Content {
+			t.Errorf("transformed file %s does not have the content of %s", newName, originalName)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "nested")); !os.IsNotExist(err) {
+		t.Errorf("expected the untransformed directory to not be created: %v", err)
+	}
+}
+
+func TestTarIndexedExtract(t *testing.T) {
+	logger := mock.NewMockLogger()
+	inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+	if err != nil {
+		t.Errorf("failed to create test dir tree: %v", err)
+		return
+	}
+	defer cleanup()
+	var archive bytes.Buffer
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths: []string{inputPath},
+		Output:     &archive,
+		UseGzip:    true,
+		GZIPOptions: GZIPOptions{
+			CompressionLevel: gzip.DefaultCompression,
+		},
+		Indexed: true,
+	})
+	if err != nil {
+		t.Errorf("failed to package indexed tar: %v", err)
+		return
+	}
+	index, err := ReadArchiveIndex(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Errorf("failed to read archive index: %v", err)
+		return
+	}
+	if index.Version != ArchiveIndexVersion || !slices.ContainsFunc(index.Members, func(e ArchiveIndexEntry) bool { return e.Name == "nested/bigfile.txt" }) {
+		t.Errorf("expected index to list nested/bigfile.txt: %+v", index)
+	}
+
+	// a normal unpackage reads the concatenated gzip members as one stream and leaves the index out
+	outputPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(outputPath)
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		OutputPath: outputPath,
+		UseGzip:    true,
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage indexed tar: %v", err)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, ArchiveIndexName)); !os.IsNotExist(err) {
+		t.Errorf("expected the index to not be unpackaged: %v", err)
+	}
+	// the index is left out by its name in the archive, not the name a transform gives it
+	template, err := bulkrename.NewDestinationTemplate(`restored/{{.all}}`)
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	transformedPath := mock.GetRandomTmpDirName()
+	defer os.RemoveAll(transformedPath)
+	err = TarUnpackage(logger, TarUnpackageParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		OutputPath: transformedPath,
+		UseGzip:    true,
+		Transform: TransformOptions{
+			TargetRegex:         regexp.MustCompile(`^(?P<all>.+)$`),
+			DestinationTemplate: template,
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to unpackage indexed tar with a transform: %v", err)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(transformedPath, "restored", ArchiveIndexName)); !os.IsNotExist(err) {
+		t.Errorf("expected the index to not be unpackaged when names are transformed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(transformedPath, "restored", "file1.txt")); err != nil {
+		t.Errorf("expected the files to be transformed: %v", err)
+	}
+	for name, file := range content {
+		data, err := os.ReadFile(filepath.Join(outputPath, name))
+		if err != nil || string(data) != file.Content {
+			t.Errorf("unpackaged file %s does not match: %v", name, err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		input    io.Reader
+		names    []string
+		expected []string
+		missing  []string
+	}{
+		{
+			name:     "seek to a single file",
+			input:    bytes.NewReader(archive.Bytes()),
+			names:    []string{"file2.txt"},
+			expected: []string{"file2.txt"},
+			missing:  []string{"file1.txt", "nested"},
+		},
+		{
+			name:     "seek to a directory",
+			input:    bytes.NewReader(archive.Bytes()),
+			names:    []string{"nested/"},
+			expected: []string{filepath.Join("nested", "bigfile.txt"), filepath.Join("nested", "nexted2", "file.txt")},
+			missing:  []string{"file1.txt", "file2.txt"},
+		},
+		{
+			// a buffer can not seek so the whole archive is read
+			name:     "streamed without seeking",
+			input:    bytes.NewBuffer(archive.Bytes()),
+			names:    []string{"nested/nexted2/file.txt"},
+			expected: []string{filepath.Join("nested", "nexted2", "file.txt")},
+			missing:  []string{"file1.txt", filepath.Join("nested", "bigfile.txt")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			outputPath := mock.GetRandomTmpDirName()
+			defer os.RemoveAll(outputPath)
+			err := TarExtractMembers(logger, TarExtractParams{
+				Input:      tc.input,
+				Names:      tc.names,
+				OutputPath: outputPath,
+				UseGzip:    true,
+			})
+			if err != nil {
+				t.Errorf("failed to extract members: %v", err)
+				return
+			}
+			for _, name := range tc.expected {
+				data, err := os.ReadFile(filepath.Join(outputPath, name))
+				if err != nil || string(data) != content[name].Content {
+					t.Errorf("extracted file %s does not match: %v", name, err)
+				}
+			}
+			for _, name := range tc.missing {
+				if _, err := os.Stat(filepath.Join(outputPath, name)); !os.IsNotExist(err) {
+					t.Errorf("expected %s to not be extracted: %v", name, err)
+				}
+			}
+		})
+	}
+
+	err = TarExtractMembers(logger, TarExtractParams{
+		Input:      bytes.NewReader(archive.Bytes()),
+		Names:      []string{"does-not-exist.txt"},
+		OutputPath: mock.GetRandomTmpDirName(),
+		UseGzip:    true,
+	})
+	if !errors.Is(err, ErrMemberNotFound) {
+		t.Errorf("expected ErrMemberNotFound, got %v", err)
+	}
+
+	err = TarPackage(logger, TarPackageParams{
+		InputPaths: []string{inputPath},
+		Output:     io.Discard,
+		Indexed:    true,
+	})
+	if !errors.Is(err, ErrIndexedRequiresGzip) {
+		t.Errorf("expected ErrIndexedRequiresGzip without gzip, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package tar
+
+import (
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/calvine/filejitsu/bulkrename"
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+// TransformOptions rewrite member names with the bulk-rename engine. The target regex is matched against the whole slash separated member name,
+// and the part it matches is replaced by the destination template executed with the named capture groups. Names that do not match are left as is.
+type TransformOptions struct {
+	TargetRegex         *regexp.Regexp
+	DestinationTemplate *template.Template
+}
+
+func (t TransformOptions) enabled() bool {
+	return t.TargetRegex != nil && t.DestinationTemplate != nil
+}
+
+// transformName returns the transformed member name. The trailing slash of a directory name is not passed to the regex, and is kept.
+// An empty result means the member should be left out.
+func transformName(logger *slog.Logger, options TransformOptions, name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, "/")
+	newName, didChange, err := bulkrename.RenameName(logger, trimmed, options.TargetRegex, options.DestinationTemplate)
+	if err != nil {
+		logger.Error("failed to transform member name", slog.String("name", name), slog.String("errorMessage", err.Error()))
+		return "", err
+	}
+	if !didChange {
+		return name, nil
+	}
+	newName = strings.Trim(newName, "/")
+	if len(newName) > 0 && len(trimmed) != len(name) {
+		newName += "/"
+	}
+	logger.Debug("transformed member name", slog.String("name", name), slog.String("newName", newName))
+	return newName, nil
+}
+
+// transformEntries rewrites the names of the entries in place, dropping any whose name is transformed to nothing.
+func transformEntries(logger *slog.Logger, options TransformOptions, entries []archivepath.Entry) ([]archivepath.Entry, error) {
+	transformed := entries[:0]
+	for _, entry := range entries {
+		name, err := transformName(logger, options, filepath.ToSlash(entry.Name))
+		if err != nil {
+			return nil, err
+		}
+		if len(name) == 0 {
+			logger.Warn("leaving out entity whose name was transformed to nothing", slog.String("path", entry.Path))
+			continue
+		}
+		entry.Name = filepath.FromSlash(name)
+		transformed = append(transformed, entry)
+	}
+	return transformed, nil
+}
+
+// transformDeleted rewrites the names of the deletions recorded in an incremental marker, dropping any transformed to nothing.
+func transformDeleted(logger *slog.Logger, options TransformOptions, deleted []string) ([]string, error) {
+	transformed := make([]string, 0, len(deleted))
+	for _, name := range deleted {
+		newName, err := transformName(logger, options, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(newName) > 0 {
+			transformed = append(transformed, newName)
+		}
+	}
+	return transformed, nil
+}
@@ -0,0 +1,128 @@
+package archivepath
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/calvine/filejitsu/util/ignore"
+)
+
+var (
+	ErrPathTraversal = errors.New("archive member path escapes the output directory")
+)
+
+// ExcludeOptions controls which entities are left out of an archive while packaging.
+type ExcludeOptions struct {
+	// Patterns are gitignore style patterns evaluated relative to each input path.
+	Patterns []string
+	// RespectIgnoreFiles if true .gitignore and .filejitsuignore files found while walking are honored.
+	RespectIgnoreFiles bool
+}
+
+// Entry is an entity found in the input paths that will be written to an archive.
+type Entry struct {
+	// Path is the path to the entity on disk.
+	Path string
+	// Name is the name the entity will have in the archive.
+	Name string
+	Info fs.FileInfo
+}
+
+// CollectEntries walks the input paths and returns the regular files and directories that should be packaged in walk order.
+// Names are relative to the input path they were found in. An input path that is a file gets its base name.
+func CollectEntries(logger *slog.Logger, inputPaths []string, excludeOptions ExcludeOptions) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	excludeMatcher, err := ignore.NewMatcher(excludeOptions.Patterns)
+	if err != nil {
+		logger.Error("failed to parse exclude patterns", slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	for _, ip := range inputPaths {
+		logger.Info("processing input path", slog.String("path", ip))
+		// each input path gets its own copy so ignore files found under one input path do not leak into the others
+		if err := walkInputPath(logger, ip, "", excludeMatcher.Clone(), excludeOptions, &entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// walkInputPath walks ip and appends the entries found to entries. Names are relative to ip and prefixed with namePrefix.
+func walkInputPath(logger *slog.Logger, ip, namePrefix string, matcher *ignore.Matcher, excludeOptions ExcludeOptions, entries *[]Entry) error {
+	walkErr := filepath.Walk(ip, func(path string, info fs.FileInfo, err error) error {
+		walkLogger := logger.With(slog.String("path", path))
+		if err != nil {
+			walkLogger.Error("failed to walk entity",
+				slog.String("errorMessage", err.Error()),
+			)
+			return err
+		}
+		fMode := info.Mode()
+		isRegular := fMode.IsRegular()
+		isDir := fMode.IsDir()
+		if !isRegular && !isDir {
+			walkLogger.Debug("skipping entity because its not a regular file or directory")
+			return nil
+		}
+
+		name := strings.TrimPrefix(strings.Replace(path, ip, "", -1), string(filepath.Separator))
+		if len(namePrefix) > 0 {
+			name = filepath.Join(namePrefix, name)
+		}
+		matchName := filepath.ToSlash(name)
+		if len(matchName) > 0 && matcher.Match(matchName, isDir) {
+			if isDir {
+				walkLogger.Debug("skipping excluded directory and its contents")
+				return filepath.SkipDir
+			}
+			walkLogger.Debug("skipping excluded file")
+			return nil
+		}
+		if isDir && excludeOptions.RespectIgnoreFiles {
+			loaded, err := matcher.AddIgnoreFilesInDir(matchName, path)
+			if err != nil {
+				walkLogger.Error("failed to load ignore files", slog.String("errorMessage", err.Error()))
+				return err
+			}
+			if len(loaded) > 0 {
+				walkLogger.Debug("loaded ignore files for directory", slog.Any("ignoreFiles", loaded))
+			}
+		}
+
+		if name == "" {
+			if isRegular {
+				walkLogger.Debug("got input path that is a file and not a directory, changing the entry name to compensate")
+				name = filepath.Base(path)
+			} else {
+				return nil
+			}
+		}
+		*entries = append(*entries, Entry{
+			Path: path,
+			Name: name,
+			Info: info,
+		})
+		return nil
+	})
+	if walkErr != nil {
+		logger.Error("failed to walk input path", slog.String("path", ip), slog.String("errorMessage", walkErr.Error()))
+		return walkErr
+	}
+	return nil
+}
+
+// SafeTargetPath joins the archive member name to the output path and makes sure the result does not escape the output path.
+func SafeTargetPath(outputPath, name string) (string, error) {
+	target := filepath.Join(outputPath, filepath.FromSlash(name))
+	rel, err := filepath.Rel(outputPath, target)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrPathTraversal
+	}
+	return target, nil
+}
@@ -0,0 +1,56 @@
+package archivepath
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeTargetPath(t *testing.T) {
+	type testCase struct {
+		Name        string
+		MemberName  string
+		Expected    string
+		ExpectedErr error
+	}
+	outputPath := filepath.Join("out", "dir")
+	testCases := []testCase{
+		{
+			Name:       "nested member",
+			MemberName: "a/b.txt",
+			Expected:   filepath.Join(outputPath, "a", "b.txt"),
+		},
+		{
+			Name:       "leading slash stays inside",
+			MemberName: "/etc/passwd",
+			Expected:   filepath.Join(outputPath, "etc", "passwd"),
+		},
+		{
+			Name:       "dot dot inside output",
+			MemberName: "a/../b.txt",
+			Expected:   filepath.Join(outputPath, "b.txt"),
+		},
+		{
+			Name:        "dot dot escapes output",
+			MemberName:  "../../evil.txt",
+			ExpectedErr: ErrPathTraversal,
+		},
+		{
+			Name:        "parent of output",
+			MemberName:  "..",
+			ExpectedErr: ErrPathTraversal,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			target, err := SafeTargetPath(outputPath, tc.MemberName)
+			if !errors.Is(err, tc.ExpectedErr) {
+				t.Errorf("expected error %v got %v", tc.ExpectedErr, err)
+				return
+			}
+			if target != tc.Expected {
+				t.Errorf("target was %s expected %s", target, tc.Expected)
+			}
+		})
+	}
+}
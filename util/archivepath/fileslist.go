@@ -0,0 +1,133 @@
+package archivepath
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/calvine/filejitsu/util/ignore"
+)
+
+// FilesFrom is a list of paths to package, like the output of find, that is used in addition to walking the input paths.
+type FilesFrom struct {
+	Paths []string
+	// Recursive if true listed directories are walked like input paths. Otherwise only the directory itself is packaged.
+	Recursive bool
+}
+
+// ReadPathList reads a list of paths separated by newlines, or by NUL characters if the list contains any, like find -print0 writes.
+// Empty lines are skipped. Newline separated lists may use \r\n line endings.
+func ReadPathList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	separator := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		separator = "\x00"
+	}
+	paths := make([]string, 0)
+	for _, p := range strings.Split(string(data), separator) {
+		if separator == "\n" {
+			p = strings.TrimSuffix(p, "\r")
+		}
+		if len(p) == 0 {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// CollectInputEntries returns the entries found walking the input paths followed by the entries for the listed paths.
+// A name that was already collected is not added twice, so overlapping input paths and lists are packaged once.
+func CollectInputEntries(logger *slog.Logger, inputPaths []string, filesFrom FilesFrom, excludeOptions ExcludeOptions) ([]Entry, error) {
+	entries, err := CollectEntries(logger, inputPaths, excludeOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(filesFrom.Paths) == 0 {
+		return entries, nil
+	}
+	listedEntries, err := collectListedEntries(logger, filesFrom, excludeOptions)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		seen[e.Name] = struct{}{}
+	}
+	for _, e := range listedEntries {
+		if _, ok := seen[e.Name]; ok {
+			logger.Debug("skipping listed path that was already collected", slog.String("name", e.Name))
+			continue
+		}
+		seen[e.Name] = struct{}{}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// collectListedEntries returns the regular files and directories in the list in list order.
+// Names are the listed paths cleaned of any leading / or ../ so they can not escape the directory an archive is unpacked in.
+// Exclude patterns are evaluated against the names.
+func collectListedEntries(logger *slog.Logger, filesFrom FilesFrom, excludeOptions ExcludeOptions) ([]Entry, error) {
+	entries := make([]Entry, 0, len(filesFrom.Paths))
+	matcher, err := ignore.NewMatcher(excludeOptions.Patterns)
+	if err != nil {
+		logger.Error("failed to parse exclude patterns", slog.String("errorMessage", err.Error()))
+		return nil, err
+	}
+	for _, p := range filesFrom.Paths {
+		pathLogger := logger.With(slog.String("path", p))
+		info, err := os.Lstat(p)
+		if err != nil {
+			pathLogger.Error("failed to stat listed path", slog.String("errorMessage", err.Error()))
+			return nil, err
+		}
+		isDir := info.IsDir()
+		if !info.Mode().IsRegular() && !isDir {
+			pathLogger.Debug("skipping listed path because its not a regular file or directory")
+			continue
+		}
+		name := listedName(p)
+		if filesFrom.Recursive && isDir {
+			// ignore files are added with the directory they were found in as their base, so sharing the matcher does not leak them to other listed paths
+			if err := walkInputPath(logger, p, name, matcher, excludeOptions, &entries); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(name) == 0 {
+			pathLogger.Debug("skipping listed path because it has no name in the archive")
+			continue
+		}
+		if matcher.MatchPathOrParent(filepath.ToSlash(name), isDir) {
+			pathLogger.Debug("skipping excluded listed path")
+			continue
+		}
+		entries = append(entries, Entry{
+			Path: p,
+			Name: name,
+			Info: info,
+		})
+	}
+	return entries, nil
+}
+
+// listedName returns the name a listed path has in an archive.
+func listedName(p string) string {
+	name := filepath.Clean(strings.TrimPrefix(p, filepath.VolumeName(p)))
+	name = strings.TrimLeft(name, string(filepath.Separator))
+	parentPrefix := ".." + string(filepath.Separator)
+	for strings.HasPrefix(name, parentPrefix) {
+		name = strings.TrimPrefix(name, parentPrefix)
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
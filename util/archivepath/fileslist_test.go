@@ -0,0 +1,116 @@
+package archivepath
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestReadPathList(t *testing.T) {
+	type testCase struct {
+		Name     string
+		Input    string
+		Expected []string
+	}
+	testCases := []testCase{
+		{Name: "newline separated", Input: "a.txt\ndir/b.txt\n\n", Expected: []string{"a.txt", "dir/b.txt"}},
+		{Name: "windows line endings", Input: "a.txt\r\nb.txt\r\n", Expected: []string{"a.txt", "b.txt"}},
+		{Name: "nul separated", Input: "a b.txt\x00line\nbreak.txt\x00", Expected: []string{"a b.txt", "line\nbreak.txt"}},
+		{Name: "empty", Input: "", Expected: []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			paths, err := ReadPathList(strings.NewReader(tc.Input))
+			if err != nil {
+				t.Errorf("failed to read path list: %v", err)
+				return
+			}
+			if !slices.Equal(paths, tc.Expected) {
+				t.Errorf("expected %q got %q", tc.Expected, paths)
+			}
+		})
+	}
+}
+
+func TestListedName(t *testing.T) {
+	testCases := map[string]string{
+		"dir/a.txt":         filepath.Join("dir", "a.txt"),
+		"./dir/../b.txt":    "b.txt",
+		"/abs/path/c.txt":   filepath.Join("abs", "path", "c.txt"),
+		"../../outside.txt": "outside.txt",
+		".":                 "",
+		"/":                 "",
+	}
+	for input, expected := range testCases {
+		if name := listedName(filepath.FromSlash(input)); name != expected {
+			t.Errorf("expected %q for %q got %q", expected, input, name)
+		}
+	}
+}
+
+func TestCollectInputEntriesFilesFrom(t *testing.T) {
+	logger := mock.NewMockLogger()
+	rootDir := t.TempDir()
+	for _, p := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/skip.log"} {
+		fullPath := filepath.Join(rootDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(p), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working dir: %v", err)
+	}
+	if err := os.Chdir(rootDir); err != nil {
+		t.Fatalf("failed to change working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	type testCase struct {
+		Name       string
+		InputPaths []string
+		FilesFrom  FilesFrom
+		Expected   []string
+	}
+	testCases := []testCase{
+		{
+			Name:      "not recursive",
+			FilesFrom: FilesFrom{Paths: []string{"a.txt", "dir", "dir/sub/c.txt", "dir/skip.log"}},
+			Expected:  []string{"a.txt", "dir", "dir/sub/c.txt"},
+		},
+		{
+			Name:      "recursive",
+			FilesFrom: FilesFrom{Paths: []string{"dir"}, Recursive: true},
+			Expected:  []string{"dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt"},
+		},
+		{
+			Name:       "listed paths already in input paths are skipped",
+			InputPaths: []string{"a.txt"},
+			FilesFrom:  FilesFrom{Paths: []string{"./a.txt", "dir/b.txt"}},
+			Expected:   []string{"a.txt", "dir/b.txt"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			entries, err := CollectInputEntries(logger, tc.InputPaths, tc.FilesFrom, ExcludeOptions{Patterns: []string{"*.log"}})
+			if err != nil {
+				t.Errorf("failed to collect entries: %v", err)
+				return
+			}
+			names := make([]string, 0, len(entries))
+			for _, e := range entries {
+				names = append(names, filepath.ToSlash(e.Name))
+			}
+			if !slices.Equal(names, tc.Expected) {
+				t.Errorf("expected %q got %q", tc.Expected, names)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ func MakeAllDirIfNotExists(logger *slog.Logger, path string, perm fs.FileMode) e
 				logger.Error("failed to make dir all", slog.String("path", path), slog.String("errorMessage", err.Error()))
 				return err
 			}
+			return nil
 		}
 		logger.Error("failed to perform stat on path", slog.String("path", path), slog.String("errorMessage", err.Error()))
 		return err
@@ -0,0 +1,267 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// GitIgnoreFileName is the name of the git ignore file honored when ignore files are respected.
+	GitIgnoreFileName = ".gitignore"
+	// FilejitsuIgnoreFileName is the name of the filejitsu specific ignore file honored when ignore files are respected.
+	FilejitsuIgnoreFileName = ".filejitsuignore"
+)
+
+var (
+	// IgnoreFileNames are the per directory ignore files that are loaded when ignore files are respected. Files later in the list take precedence.
+	IgnoreFileNames = []string{GitIgnoreFileName, FilejitsuIgnoreFileName}
+
+	ErrEmptyPattern = errors.New("pattern is empty")
+)
+
+// rule is a single compiled gitignore style pattern.
+type rule struct {
+	pattern string
+	// base is the slash separated directory (relative to the matcher root) the rule was declared in. Empty means the root.
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// Matcher evaluates paths against an ordered list of gitignore style rules. Like git the last rule that matches a path decides if it is excluded.
+type Matcher struct {
+	rules []rule
+}
+
+// NewMatcher creates a Matcher from patterns that apply from the root of a walk.
+func NewMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	if err := m.AddPatterns("", patterns); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Clone returns a copy of the matcher, so rules added to the copy do not affect the original.
+func (m *Matcher) Clone() *Matcher {
+	rules := make([]rule, len(m.rules))
+	copy(rules, m.rules)
+	return &Matcher{rules: rules}
+}
+
+// Len returns the number of rules in the matcher.
+func (m *Matcher) Len() int {
+	return len(m.rules)
+}
+
+// AddPatterns adds patterns declared in the base directory. base is slash separated and relative to the root of the walk.
+func (m *Matcher) AddPatterns(base string, patterns []string) error {
+	base = strings.Trim(base, "/")
+	for _, p := range patterns {
+		r, ok, err := compileRule(base, p)
+		if err != nil {
+			return fmt.Errorf("failed to compile pattern %q: %w", p, err)
+		}
+		if !ok {
+			continue
+		}
+		m.rules = append(m.rules, r)
+	}
+	return nil
+}
+
+// AddIgnoreFile reads an ignore file and adds its patterns as declared in the base directory. A missing file is not an error.
+func (m *Matcher) AddIgnoreFile(base, filePath string) (bool, error) {
+	patterns, err := ReadPatternFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, m.AddPatterns(base, patterns)
+}
+
+// AddIgnoreFilesInDir loads any IgnoreFileNames present in dirPath as rules declared in base. Returns the names of the files that were loaded.
+func (m *Matcher) AddIgnoreFilesInDir(base, dirPath string) ([]string, error) {
+	loaded := make([]string, 0)
+	for _, name := range IgnoreFileNames {
+		found, err := m.AddIgnoreFile(base, filepath.Join(dirPath, name))
+		if err != nil {
+			return loaded, err
+		}
+		if found {
+			loaded = append(loaded, name)
+		}
+	}
+	return loaded, nil
+}
+
+// Match reports if the slash separated relPath (relative to the root of the walk) is excluded. isDir must be true for directories so directory only rules can apply.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	relPath = strings.Trim(relPath, "/")
+	if len(relPath) == 0 {
+		return false
+	}
+	excluded := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		target := relPath
+		if len(r.base) > 0 {
+			if !strings.HasPrefix(relPath, r.base+"/") {
+				continue
+			}
+			target = relPath[len(r.base)+1:]
+		}
+		if r.re.MatchString(target) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// MatchPathOrParent reports if relPath or any of its parent directories are excluded. This is useful when paths are not discovered by a walk that prunes excluded directories.
+func (m *Matcher) MatchPathOrParent(relPath string, isDir bool) bool {
+	relPath = strings.Trim(relPath, "/")
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.Match(relPath, isDir)
+}
+
+// ReadPatternFile reads the patterns in a gitignore style file.
+func ReadPatternFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPatterns(f)
+}
+
+// ReadPatterns reads newline separated patterns from r. Comments and blank lines are returned as is and skipped when rules are compiled.
+func ReadPatterns(r io.Reader) ([]string, error) {
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		patterns = append(patterns, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+func compileRule(base, pattern string) (rule, bool, error) {
+	r := rule{
+		pattern: pattern,
+		base:    base,
+	}
+	p := trimTrailingSpaces(pattern)
+	if len(p) == 0 || strings.HasPrefix(p, "#") {
+		return r, false, nil
+	}
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if len(p) == 0 {
+		return r, false, ErrEmptyPattern
+	}
+	// a slash anywhere but the end anchors the pattern to the directory it was declared in
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if !anchored {
+		p = "**/" + p
+	}
+	expr, err := globToRegex(p)
+	if err != nil {
+		return r, false, err
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return r, false, err
+	}
+	r.re = re
+	return r, true, nil
+}
+
+func trimTrailingSpaces(p string) string {
+	for strings.HasSuffix(p, " ") && !strings.HasSuffix(p, `\ `) {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// globToRegex converts a gitignore style glob into an anchored regular expression.
+func globToRegex(glob string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				atEnd := i+2 == len(glob)
+				followedBySlash := i+2 < len(glob) && glob[i+2] == '/'
+				switch {
+				case atStart && followedBySlash:
+					// "**/" matches zero or more directories
+					sb.WriteString("(?:.*/)?")
+					i += 2
+				case atStart && atEnd:
+					// "/**" matches everything inside
+					sb.WriteString(".*")
+					i++
+				default:
+					// any other "**" is treated like a regular "*"
+					sb.WriteString("[^/]*")
+					i++
+				}
+				continue
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class in %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String(), nil
+}
@@ -0,0 +1,205 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	type testCase struct {
+		Name     string
+		Patterns []string
+		Path     string
+		IsDir    bool
+		Expected bool
+	}
+	testCases := []testCase{
+		{
+			Name:     "basename matches at any depth",
+			Patterns: []string{"node_modules"},
+			Path:     "web/app/node_modules",
+			IsDir:    true,
+			Expected: true,
+		},
+		{
+			Name:     "wildcard extension",
+			Patterns: []string{"*.log"},
+			Path:     "logs/today.log",
+			Expected: true,
+		},
+		{
+			Name:     "wildcard does not cross directories",
+			Patterns: []string{"logs*"},
+			Path:     "logs/today.txt",
+			Expected: false,
+		},
+		{
+			Name:     "leading slash anchors to root",
+			Patterns: []string{"/build"},
+			Path:     "src/build",
+			IsDir:    true,
+			Expected: false,
+		},
+		{
+			Name:     "leading slash matches at root",
+			Patterns: []string{"/build"},
+			Path:     "build",
+			IsDir:    true,
+			Expected: true,
+		},
+		{
+			Name:     "middle slash anchors to root",
+			Patterns: []string{"docs/*.md"},
+			Path:     "sub/docs/readme.md",
+			Expected: false,
+		},
+		{
+			Name:     "trailing slash only matches directories",
+			Patterns: []string{"out/"},
+			Path:     "out",
+			IsDir:    false,
+			Expected: false,
+		},
+		{
+			Name:     "trailing slash matches directory",
+			Patterns: []string{"out/"},
+			Path:     "a/out",
+			IsDir:    true,
+			Expected: true,
+		},
+		{
+			Name:     "negation re-includes",
+			Patterns: []string{"*.log", "!keep.log"},
+			Path:     "keep.log",
+			Expected: false,
+		},
+		{
+			Name:     "last match wins",
+			Patterns: []string{"!keep.log", "*.log"},
+			Path:     "keep.log",
+			Expected: true,
+		},
+		{
+			Name:     "double star in the middle",
+			Patterns: []string{"a/**/z"},
+			Path:     "a/b/c/z",
+			Expected: true,
+		},
+		{
+			Name:     "double star in the middle matches zero directories",
+			Patterns: []string{"a/**/z"},
+			Path:     "a/z",
+			Expected: true,
+		},
+		{
+			Name:     "trailing double star matches contents",
+			Patterns: []string{"vendor/**"},
+			Path:     "vendor/pkg/file.go",
+			Expected: true,
+		},
+		{
+			Name:     "comments and blank lines are ignored",
+			Patterns: []string{"# *.go", "", "   "},
+			Path:     "main.go",
+			Expected: false,
+		},
+		{
+			Name:     "escaped hash",
+			Patterns: []string{`\#notes`},
+			Path:     "#notes",
+			Expected: true,
+		},
+		{
+			Name:     "character class",
+			Patterns: []string{"file[0-9].txt"},
+			Path:     "file7.txt",
+			Expected: true,
+		},
+		{
+			Name:     "negated character class",
+			Patterns: []string{"file[!0-9].txt"},
+			Path:     "file7.txt",
+			Expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			m, err := NewMatcher(tc.Patterns)
+			if err != nil {
+				t.Errorf("failed to create matcher: %v", err)
+				return
+			}
+			matched := m.Match(tc.Path, tc.IsDir)
+			if matched != tc.Expected {
+				t.Errorf("match for %s was %t expected %t", tc.Path, matched, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestMatchWithBase(t *testing.T) {
+	m, err := NewMatcher([]string{"*.tmp"})
+	if err != nil {
+		t.Errorf("failed to create matcher: %v", err)
+		return
+	}
+	if err := m.AddPatterns("sub", []string{"/local", "!important.tmp"}); err != nil {
+		t.Errorf("failed to add patterns: %v", err)
+		return
+	}
+	if !m.Match("sub/local", true) {
+		t.Error("expected anchored pattern in sub directory to match")
+	}
+	if m.Match("local", true) {
+		t.Error("expected anchored pattern in sub directory to not match at root")
+	}
+	if m.Match("sub/important.tmp", false) {
+		t.Error("expected negation in sub directory to re-include file")
+	}
+	if !m.Match("important.tmp", false) {
+		t.Error("expected negation in sub directory to not apply at root")
+	}
+}
+
+func TestMatchPathOrParent(t *testing.T) {
+	m, err := NewMatcher([]string{"node_modules/"})
+	if err != nil {
+		t.Errorf("failed to create matcher: %v", err)
+		return
+	}
+	if !m.MatchPathOrParent("web/node_modules/pkg/index.js", false) {
+		t.Error("expected file under excluded directory to match")
+	}
+	if m.MatchPathOrParent("web/src/index.js", false) {
+		t.Error("expected file outside excluded directory to not match")
+	}
+}
+
+func TestAddIgnoreFilesInDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, GitIgnoreFileName), []byte("*.o\n"), 0644); err != nil {
+		t.Errorf("failed to write ignore file: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, FilejitsuIgnoreFileName), []byte("!main.o\n"), 0644); err != nil {
+		t.Errorf("failed to write ignore file: %v", err)
+		return
+	}
+	m, _ := NewMatcher(nil)
+	loaded, err := m.AddIgnoreFilesInDir("", dir)
+	if err != nil {
+		t.Errorf("failed to load ignore files: %v", err)
+		return
+	}
+	if len(loaded) != 2 {
+		t.Errorf("expected 2 ignore files to be loaded got %d", len(loaded))
+		return
+	}
+	if !m.Match("util.o", false) {
+		t.Error("expected .gitignore rule to match")
+	}
+	if m.Match("main.o", false) {
+		t.Error("expected .filejitsuignore rule to take precedence")
+	}
+}
@@ -1,6 +1,11 @@
 package util
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 const (
 	B  float64 = 1
@@ -45,3 +50,39 @@ func GetPrettyBytesSize(sizeInBytes int64) string {
 	prettySize := fmt.Sprintf(formatString, divSize, unit)
 	return prettySize
 }
+
+var (
+	ErrInvalidBytesSize = errors.New("invalid bytes size")
+)
+
+// ParseBytesSize parses a size like 512, 10K, 1.5MB or 2G into a number of bytes. Units are powers of 1024 to match GetPrettyBytesSize.
+func ParseBytesSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(s, "IB")
+	s = strings.TrimSuffix(s, "B")
+	unitSize := B
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'K':
+			unitSize = KB
+		case 'M':
+			unitSize = MB
+		case 'G':
+			unitSize = GB
+		case 'T':
+			unitSize = TB
+		case 'P':
+			unitSize = PB
+		case 'E':
+			unitSize = EB
+		}
+		if unitSize != B {
+			s = s[:len(s)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidBytesSize, size)
+	}
+	return int64(value * unitSize), nil
+}
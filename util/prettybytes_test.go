@@ -38,3 +38,62 @@ func TestGetPrettyBytesSize(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBytesSize(t *testing.T) {
+	type testCase struct {
+		Name          string
+		Size          string
+		ExpectedValue int64
+		ExpectError   bool
+	}
+	testCases := []testCase{
+		{
+			Name:          "plain bytes",
+			Size:          "512",
+			ExpectedValue: 512,
+		},
+		{
+			Name:          "KB test",
+			Size:          "10K",
+			ExpectedValue: 10240,
+		},
+		{
+			Name:          "MB with B suffix",
+			Size:          "1.5MB",
+			ExpectedValue: 1572864,
+		},
+		{
+			Name:          "GB test",
+			Size:          "2G",
+			ExpectedValue: 2147483648,
+		},
+		{
+			Name:          "lower case with iB suffix",
+			Size:          "1gib",
+			ExpectedValue: 1073741824,
+		},
+		{
+			Name:        "invalid",
+			Size:        "big",
+			ExpectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			val, err := ParseBytesSize(tc.Size)
+			if tc.ExpectError {
+				if err == nil {
+					t.Errorf("%s expected an error but got %d", tc.Size, val)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s returned error: %v", tc.Size, err)
+				return
+			}
+			if val != tc.ExpectedValue {
+				t.Errorf("%s returned %d but expected %d", tc.Size, val, tc.ExpectedValue)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package progress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrInvalidMode = errors.New("invalid progress mode")
+)
+
+// Mode is how progress is reported.
+type Mode string
+
+const (
+	// ModeNone reports no progress.
+	ModeNone Mode = "none"
+	// ModeAuto reports with a bar if the output is a terminal, and with JSON lines otherwise.
+	ModeAuto Mode = "auto"
+	// ModeBar redraws a single progress line, meant for a terminal.
+	ModeBar Mode = "bar"
+	// ModeJSON writes a JSON snapshot per line, meant for CI logs.
+	ModeJSON Mode = "json"
+)
+
+// ParseMode returns the mode for the string, or ErrInvalidMode.
+func ParseMode(mode string) (Mode, error) {
+	switch m := Mode(mode); m {
+	case ModeNone, ModeAuto, ModeBar, ModeJSON:
+		return m, nil
+	}
+	return ModeNone, fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+}
+
+// Tracker counts the bytes and entries a job has processed. It is safe for concurrent use.
+// A nil Tracker ignores every call, so code can report progress without checking if it is enabled.
+type Tracker struct {
+	start        time.Time
+	bytes        atomic.Int64
+	entries      atomic.Int64
+	totalBytes   atomic.Int64
+	totalEntries atomic.Int64
+
+	mu          sync.Mutex
+	currentFile string
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		start: time.Now(),
+	}
+}
+
+// SetTotalBytes sets the number of bytes the job is expected to process. A total of 0 means it is unknown.
+func (t *Tracker) SetTotalBytes(n int64) {
+	if t == nil {
+		return
+	}
+	t.totalBytes.Store(n)
+}
+
+// SetTotalEntries sets the number of entries the job is expected to process. A total of 0 means it is unknown.
+func (t *Tracker) SetTotalEntries(n int64) {
+	if t == nil {
+		return
+	}
+	t.totalEntries.Store(n)
+}
+
+// AddBytes records n more bytes processed.
+func (t *Tracker) AddBytes(n int64) {
+	if t == nil {
+		return
+	}
+	t.bytes.Add(n)
+}
+
+// StartEntry records another entry processed and makes it the current file.
+func (t *Tracker) StartEntry(name string) {
+	if t == nil {
+		return
+	}
+	t.entries.Add(1)
+	t.mu.Lock()
+	t.currentFile = name
+	t.mu.Unlock()
+}
+
+// SkipEntry removes an entry of size bytes from the totals, for entries a job decided not to process after the totals were set.
+func (t *Tracker) SkipEntry(size int64) {
+	if t == nil {
+		return
+	}
+	if t.totalEntries.Load() > 0 {
+		t.totalEntries.Add(-1)
+	}
+	if t.totalBytes.Load() > 0 {
+		t.totalBytes.Add(-size)
+	}
+}
+
+// Reader returns r wrapped so every byte read from it is counted. If the tracker is nil r is returned as is.
+func (t *Tracker) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &countingReader{r: r, t: t}
+}
+
+// Writer returns w wrapped so every byte written to it is counted. If the tracker is nil w is returned as is.
+func (t *Tracker) Writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &countingWriter{w: w, t: t}
+}
+
+// Snapshot returns the progress so far.
+func (t *Tracker) Snapshot() Snapshot {
+	if t == nil {
+		return Snapshot{}
+	}
+	return t.snapshotAt(time.Now())
+}
+
+func (t *Tracker) snapshotAt(now time.Time) Snapshot {
+	t.mu.Lock()
+	currentFile := t.currentFile
+	t.mu.Unlock()
+	elapsed := now.Sub(t.start)
+	s := Snapshot{
+		ElapsedSeconds:   elapsed.Seconds(),
+		BytesProcessed:   t.bytes.Load(),
+		TotalBytes:       t.totalBytes.Load(),
+		EntriesProcessed: t.entries.Load(),
+		TotalEntries:     t.totalEntries.Load(),
+		CurrentFile:      currentFile,
+	}
+	if s.ElapsedSeconds > 0 {
+		s.BytesPerSecond = float64(s.BytesProcessed) / s.ElapsedSeconds
+	}
+	if s.TotalBytes > 0 {
+		s.Percent = min(100, float64(s.BytesProcessed)/float64(s.TotalBytes)*100)
+		if s.BytesPerSecond > 0 && s.BytesProcessed < s.TotalBytes {
+			s.ETASeconds = float64(s.TotalBytes-s.BytesProcessed) / s.BytesPerSecond
+		}
+	}
+	return s
+}
+
+// Snapshot is the progress of a job at a point in time. Totals, percent and ETA are 0 when the total is unknown.
+type Snapshot struct {
+	ElapsedSeconds   float64 `json:"elapsedSeconds"`
+	BytesProcessed   int64   `json:"bytesProcessed"`
+	TotalBytes       int64   `json:"totalBytes,omitempty"`
+	EntriesProcessed int64   `json:"entriesProcessed"`
+	TotalEntries     int64   `json:"totalEntries,omitempty"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	Percent          float64 `json:"percent,omitempty"`
+	ETASeconds       float64 `json:"etaSeconds,omitempty"`
+	CurrentFile      string  `json:"currentFile,omitempty"`
+}
+
+type countingReader struct {
+	r io.Reader
+	t *Tracker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.t.AddBytes(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	t *Tracker
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.t.AddBytes(int64(n))
+	return n, err
+}
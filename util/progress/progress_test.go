@@ -0,0 +1,167 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackerCountsReadsAndWrites(t *testing.T) {
+	tracker := NewTracker()
+	tracker.SetTotalBytes(20)
+	tracker.SetTotalEntries(2)
+	tracker.StartEntry("a.txt")
+	if _, err := io.Copy(io.Discard, tracker.Reader(strings.NewReader("0123456789"))); err != nil {
+		t.Errorf("failed to read through tracker: %s", err.Error())
+		return
+	}
+	snapshot := tracker.Snapshot()
+	if snapshot.BytesProcessed != 10 || snapshot.EntriesProcessed != 1 || snapshot.CurrentFile != "a.txt" {
+		t.Errorf("unexpected snapshot after first entry: %+v", snapshot)
+	}
+	if snapshot.Percent != 50 {
+		t.Errorf("expected percent to be 50 but got %f", snapshot.Percent)
+	}
+	tracker.StartEntry("b.txt")
+	if _, err := tracker.Writer(io.Discard).Write([]byte("0123456789")); err != nil {
+		t.Errorf("failed to write through tracker: %s", err.Error())
+		return
+	}
+	snapshot = tracker.Snapshot()
+	if snapshot.BytesProcessed != 20 || snapshot.EntriesProcessed != 2 || snapshot.CurrentFile != "b.txt" {
+		t.Errorf("unexpected snapshot after second entry: %+v", snapshot)
+	}
+	if snapshot.ETASeconds != 0 {
+		t.Errorf("expected no ETA once done but got %f", snapshot.ETASeconds)
+	}
+}
+
+func TestTrackerETA(t *testing.T) {
+	tracker := NewTracker()
+	tracker.SetTotalBytes(300)
+	tracker.AddBytes(100)
+	snapshot := tracker.snapshotAt(tracker.start.Add(10 * time.Second))
+	if snapshot.BytesPerSecond != 10 {
+		t.Errorf("expected 10 bytes per second but got %f", snapshot.BytesPerSecond)
+	}
+	if snapshot.ETASeconds != 20 {
+		t.Errorf("expected ETA of 20 seconds but got %f", snapshot.ETASeconds)
+	}
+	tracker.SkipEntry(100)
+	snapshot = tracker.snapshotAt(tracker.start.Add(10 * time.Second))
+	if snapshot.TotalBytes != 200 || snapshot.ETASeconds != 10 {
+		t.Errorf("unexpected snapshot after skipping an entry: %+v", snapshot)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	tracker.SetTotalBytes(10)
+	tracker.StartEntry("a.txt")
+	tracker.SkipEntry(10)
+	r := strings.NewReader("data")
+	if tracker.Reader(r) != r {
+		t.Error("expected nil tracker to return the reader as is")
+	}
+	if tracker.Snapshot() != (Snapshot{}) {
+		t.Error("expected nil tracker to return an empty snapshot")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []string{"none", "auto", "bar", "json"} {
+		if _, err := ParseMode(mode); err != nil {
+			t.Errorf("expected %s to be a valid mode: %s", mode, err.Error())
+		}
+	}
+	if _, err := ParseMode("fancy"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestResolveMode(t *testing.T) {
+	if mode := ResolveMode(ModeAuto, &bytes.Buffer{}); mode != ModeJSON {
+		t.Errorf("expected auto to resolve to json for a buffer but got %s", mode)
+	}
+	if mode := ResolveMode(ModeBar, &bytes.Buffer{}); mode != ModeBar {
+		t.Errorf("expected bar to stay bar but got %s", mode)
+	}
+}
+
+func TestFormatBar(t *testing.T) {
+	type testCase struct {
+		Name     string
+		Snapshot Snapshot
+		Expected string
+	}
+	testCases := []testCase{
+		{
+			Name: "known total",
+			Snapshot: Snapshot{
+				BytesProcessed:   512,
+				TotalBytes:       1024,
+				EntriesProcessed: 1,
+				TotalEntries:     4,
+				BytesPerSecond:   256,
+				Percent:          50,
+				ETASeconds:       2,
+				CurrentFile:      "dir/a.txt",
+			},
+			Expected: "[###############...............]  50.0% 512 B/1.00 KB 256 B/s ETA 2s 1/4 entries dir/a.txt",
+		},
+		{
+			Name: "unknown total",
+			Snapshot: Snapshot{
+				BytesProcessed: 2048,
+				BytesPerSecond: 1024,
+			},
+			Expected: "2.00 KB 1.00 KB/s",
+		},
+		{
+			Name: "long file name",
+			Snapshot: Snapshot{
+				EntriesProcessed: 3,
+				CurrentFile:      "some/really/long/path/that/keeps/going/to/the/file.txt",
+			},
+			Expected: "0 B 0 B/s 3 entries ...path/that/keeps/going/to/the/file.txt",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			bar := FormatBar(tc.Snapshot)
+			if bar != tc.Expected {
+				t.Errorf("got bar %q but expected %q", bar, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestReporterJSON(t *testing.T) {
+	tracker := NewTracker()
+	tracker.SetTotalBytes(4)
+	out := &bytes.Buffer{}
+	reporter := NewReporter(tracker, out, ModeAuto, time.Hour)
+	reporter.Start()
+	tracker.StartEntry("a.txt")
+	tracker.AddBytes(4)
+	summary := reporter.Stop()
+	if summary.BytesProcessed != 4 || summary.EntriesProcessed != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Errorf("expected only the final line to be written but got %d lines", len(lines))
+		return
+	}
+	written := Snapshot{}
+	if err := json.Unmarshal([]byte(lines[0]), &written); err != nil {
+		t.Errorf("failed to parse progress line: %s", err.Error())
+		return
+	}
+	if written.BytesProcessed != 4 || written.Percent != 100 || written.CurrentFile != "a.txt" {
+		t.Errorf("unexpected progress line: %s", lines[0])
+	}
+}
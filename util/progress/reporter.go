@@ -0,0 +1,156 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calvine/filejitsu/util"
+)
+
+const (
+	DefaultInterval = time.Second
+
+	barWidth           = 30
+	maxCurrentFileSize = 40
+	// clearToEndOfLine is the ANSI escape that erases what is left of a longer previous bar line.
+	clearToEndOfLine = "\033[K"
+)
+
+// Reporter periodically writes the progress of a tracker to an output until it is stopped.
+type Reporter struct {
+	tracker  *Tracker
+	out      io.Writer
+	mode     Mode
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewReporter creates a reporter for the tracker. ModeAuto is resolved against the output with ResolveMode.
+func NewReporter(tracker *Tracker, out io.Writer, mode Mode, interval time.Duration) *Reporter {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Reporter{
+		tracker:  tracker,
+		out:      out,
+		mode:     ResolveMode(mode, out),
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// ResolveMode returns ModeBar for ModeAuto if the output is a terminal and ModeJSON if it is not. Other modes are returned as is.
+func ResolveMode(mode Mode, out io.Writer) Mode {
+	if mode != ModeAuto {
+		return mode
+	}
+	if isTerminal(out) {
+		return ModeBar
+	}
+	return ModeJSON
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins writing progress every interval in a separate goroutine.
+func (r *Reporter) Start() {
+	if r.mode == ModeNone {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.write(r.tracker.Snapshot(), false)
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic writes, writes the final progress and returns it. Stop must be called once.
+func (r *Reporter) Stop() Snapshot {
+	close(r.stop)
+	r.wg.Wait()
+	final := r.tracker.Snapshot()
+	if r.mode != ModeNone {
+		r.write(final, true)
+	}
+	return final
+}
+
+func (r *Reporter) write(s Snapshot, final bool) {
+	switch r.mode {
+	case ModeBar:
+		line := "\r" + FormatBar(s) + clearToEndOfLine
+		if final {
+			line += util.NewLine
+		}
+		fmt.Fprint(r.out, line)
+	case ModeJSON:
+		data, err := json.Marshal(s)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(r.out, string(data))
+	}
+}
+
+// FormatBar renders a snapshot as a single line progress bar. Without a known total the bar is left out.
+func FormatBar(s Snapshot) string {
+	parts := make([]string, 0, 6)
+	if s.TotalBytes > 0 {
+		filled := int(s.Percent / 100 * barWidth)
+		parts = append(parts,
+			"["+strings.Repeat("#", filled)+strings.Repeat(".", barWidth-filled)+"]",
+			fmt.Sprintf("%5.1f%%", s.Percent),
+			util.GetPrettyBytesSize(s.BytesProcessed)+"/"+util.GetPrettyBytesSize(s.TotalBytes),
+		)
+	} else {
+		parts = append(parts, util.GetPrettyBytesSize(s.BytesProcessed))
+	}
+	parts = append(parts, util.GetPrettyBytesSize(int64(s.BytesPerSecond))+"/s")
+	if eta := time.Duration(s.ETASeconds * float64(time.Second)).Round(time.Second); eta > 0 {
+		parts = append(parts, "ETA "+eta.String())
+	}
+	if s.TotalEntries > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d entries", s.EntriesProcessed, s.TotalEntries))
+	} else if s.EntriesProcessed > 0 {
+		parts = append(parts, fmt.Sprintf("%d entries", s.EntriesProcessed))
+	}
+	if len(s.CurrentFile) > 0 {
+		parts = append(parts, shortenFileName(s.CurrentFile))
+	}
+	return strings.Join(parts, " ")
+}
+
+// shortenFileName keeps the end of long names, which is the part that changes from file to file.
+func shortenFileName(name string) string {
+	runes := []rune(name)
+	if len(runes) <= maxCurrentFileSize {
+		return name
+	}
+	return "..." + string(runes[len(runes)-maxCurrentFileSize+3:])
+}
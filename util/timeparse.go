@@ -0,0 +1,47 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidTimeOrAge = errors.New("invalid time or age")
+
+	// timeLayouts are the absolute time formats ParseTimeOrAge accepts. Times without a zone are UTC.
+	timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+)
+
+// ParseTimeOrAge parses an absolute time like 2006-01-02, 2006-01-02 15:04:05 or an RFC3339 time, or an age like 90d, 2w or 36h which is subtracted from now.
+// Ages use the units of time.ParseDuration along with d for days and w for weeks.
+func ParseTimeOrAge(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if len(value) > 1 {
+		unit := time.Duration(0)
+		switch value[len(value)-1] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		}
+		if unit != 0 {
+			count, err := strconv.ParseFloat(value[:len(value)-1], 64)
+			if err == nil && count >= 0 {
+				return now.Add(-time.Duration(count * float64(unit))), nil
+			}
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTimeOrAge, value)
+	}
+	return now.Add(-age), nil
+}
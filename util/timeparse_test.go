@@ -0,0 +1,40 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTimeOrAge(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value    string
+		expected time.Time
+	}{
+		{value: "2026-01-02", expected: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{value: "2026-01-02 15:04:05", expected: time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{value: "2026-01-02T15:04:05+02:00", expected: time.Date(2026, 1, 2, 13, 4, 5, 0, time.UTC)},
+		{value: "90d", expected: now.Add(-90 * 24 * time.Hour)},
+		{value: "2w", expected: now.Add(-14 * 24 * time.Hour)},
+		{value: "36h", expected: now.Add(-36 * time.Hour)},
+		{value: "1.5d", expected: now.Add(-36 * time.Hour)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			parsed, err := ParseTimeOrAge(tc.value, now)
+			if err != nil {
+				t.Errorf("failed to parse: %v", err)
+				return
+			}
+			if !parsed.Equal(tc.expected) {
+				t.Errorf("expected %s got %s", tc.expected, parsed)
+			}
+		})
+	}
+	for _, value := range []string{"", "yesterday", "-3d", "10x"} {
+		if _, err := ParseTimeOrAge(value, now); !errors.Is(err, ErrInvalidTimeOrAge) {
+			t.Errorf("expected %q to be invalid, got %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package volume
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// BlockSize is the boundary volume sizes must align to. It matches the tar block size so volumes of an uncompressed tar archive always start on a block.
+	BlockSize = 512
+	// ManifestSuffix is appended to the base path to get the path of the volume manifest.
+	ManifestSuffix = ".manifest.json"
+	// ManifestVersion is the current version of the manifest format.
+	ManifestVersion = 1
+
+	firstVolumeSuffix = ".001"
+)
+
+var (
+	ErrInvalidVolumeSize = fmt.Errorf("volume size must be a positive multiple of %d bytes", BlockSize)
+	ErrNoVolumesFound    = errors.New("no volumes found")
+	ErrVolumeMismatch    = errors.New("volumes do not match manifest")
+	ErrMissingVolume     = errors.New("volumes are missing")
+
+	volumeSuffixRegex = regexp.MustCompile(`\.(\d{3,})$`)
+)
+
+// Manifest describes a set of volumes written by a Writer.
+type Manifest struct {
+	Version    int    `json:"version"`
+	BaseName   string `json:"baseName"`
+	VolumeSize int64  `json:"volumeSize"`
+	TotalSize  int64  `json:"totalSize"`
+	Volumes    []Info `json:"volumes"`
+}
+
+// Info describes a single volume.
+type Info struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded SHA-256 of the volume content.
+	SHA256 string `json:"sha256"`
+}
+
+// Path returns the path of the volume with the 1 based index for the base path. For example name.tar.gz.001.
+func Path(basePath string, index int) string {
+	return fmt.Sprintf("%s.%03d", basePath, index)
+}
+
+// ManifestPath returns the path of the manifest for the base path.
+func ManifestPath(basePath string) string {
+	return basePath + ManifestSuffix
+}
+
+// BasePath returns the base path for a volume path, and false if the path does not have a volume suffix.
+func BasePath(volumePath string) (string, bool) {
+	loc := volumeSuffixRegex.FindStringIndex(volumePath)
+	if loc == nil {
+		return volumePath, false
+	}
+	return volumePath[:loc[0]], true
+}
+
+// IsFirstVolume reports if the path looks like the first volume of a set.
+func IsFirstVolume(volumePath string) bool {
+	return strings.HasSuffix(volumePath, firstVolumeSuffix)
+}
+
+// Writer splits everything written to it into volumes of at most volumeSize bytes. The manifest is written when it is closed.
+type Writer struct {
+	logger     *slog.Logger
+	basePath   string
+	volumeSize int64
+	current    *os.File
+	hasher     hash.Hash
+	written    int64
+	manifest   Manifest
+}
+
+// NewWriter creates a Writer that writes volumes next to basePath. Volumes are only created as data is written.
+func NewWriter(logger *slog.Logger, basePath string, volumeSize int64) (*Writer, error) {
+	if volumeSize <= 0 || volumeSize%BlockSize != 0 {
+		logger.Error("invalid volume size", slog.Int64("volumeSize", volumeSize))
+		return nil, ErrInvalidVolumeSize
+	}
+	return &Writer{
+		logger:     logger,
+		basePath:   basePath,
+		volumeSize: volumeSize,
+		manifest: Manifest{
+			Version:    ManifestVersion,
+			BaseName:   filepath.Base(basePath),
+			VolumeSize: volumeSize,
+			Volumes:    make([]Info, 0),
+		},
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	totalWritten := 0
+	for len(p) > 0 {
+		if w.current == nil || w.written == w.volumeSize {
+			if err := w.nextVolume(); err != nil {
+				return totalWritten, err
+			}
+		}
+		chunk := p
+		remaining := w.volumeSize - w.written
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.current.Write(chunk)
+		w.hasher.Write(chunk[:n])
+		w.written += int64(n)
+		totalWritten += n
+		if err != nil {
+			w.logger.Error("failed to write to volume", slog.String("volume", w.current.Name()), slog.String("errorMessage", err.Error()))
+			return totalWritten, err
+		}
+		p = p[n:]
+	}
+	return totalWritten, nil
+}
+
+func (w *Writer) nextVolume() error {
+	if err := w.closeVolume(); err != nil {
+		return err
+	}
+	volumePath := Path(w.basePath, len(w.manifest.Volumes)+1)
+	w.logger.Debug("starting new volume", slog.String("volume", volumePath))
+	f, err := os.OpenFile(volumePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		w.logger.Error("failed to create volume", slog.String("volume", volumePath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	w.current = f
+	w.hasher = sha256.New()
+	w.written = 0
+	return nil
+}
+
+func (w *Writer) closeVolume() error {
+	if w.current == nil {
+		return nil
+	}
+	info := Info{
+		Name:   filepath.Base(w.current.Name()),
+		Size:   w.written,
+		SHA256: hex.EncodeToString(w.hasher.Sum(nil)),
+	}
+	if err := w.current.Close(); err != nil {
+		w.logger.Error("failed to close volume", slog.String("volume", w.current.Name()), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	w.current = nil
+	w.manifest.Volumes = append(w.manifest.Volumes, info)
+	w.manifest.TotalSize += info.Size
+	return nil
+}
+
+// Close closes the last volume and writes the manifest.
+func (w *Writer) Close() error {
+	if len(w.manifest.Volumes) == 0 && w.current == nil {
+		// always produce at least one volume so the set can be read back
+		if err := w.nextVolume(); err != nil {
+			return err
+		}
+	}
+	if err := w.closeVolume(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath := ManifestPath(w.basePath)
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		w.logger.Error("failed to write volume manifest", slog.String("manifestPath", manifestPath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	w.logger.Info("finished writing volumes", slog.Int("numVolumes", len(w.manifest.Volumes)), slog.Int64("totalSize", w.manifest.TotalSize))
+	return nil
+}
+
+// Manifest returns the manifest of the volumes written so far.
+func (w *Writer) Manifest() Manifest {
+	return w.manifest
+}
+
+// ResolveVolumes returns the ordered volume paths for either the first volume of a set or a glob that matches the volumes.
+// The volumes must be numbered from 001 without gaps. If a manifest is found next to the volumes the volumes are checked against it, which also catches a missing last volume.
+func ResolveVolumes(logger *slog.Logger, firstVolumeOrGlob string) ([]string, error) {
+	var paths []string
+	if strings.ContainsAny(firstVolumeOrGlob, "*?[") {
+		matches, err := filepath.Glob(firstVolumeOrGlob)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if _, ok := BasePath(m); ok {
+				paths = append(paths, m)
+			}
+		}
+		slices.SortFunc(paths, func(a, b string) int {
+			return volumeIndex(a) - volumeIndex(b)
+		})
+	} else {
+		basePath, ok := BasePath(firstVolumeOrGlob)
+		if !ok {
+			logger.Error("path is not a volume", slog.String("path", firstVolumeOrGlob))
+			return nil, fmt.Errorf("%w: %s is not a volume", ErrNoVolumesFound, firstVolumeOrGlob)
+		}
+		for i := volumeIndex(firstVolumeOrGlob); ; i++ {
+			volumePath := Path(basePath, i)
+			if _, err := os.Stat(volumePath); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					break
+				}
+				return nil, err
+			}
+			paths = append(paths, volumePath)
+		}
+	}
+	if len(paths) == 0 {
+		logger.Error("no volumes found", slog.String("pattern", firstVolumeOrGlob))
+		return nil, ErrNoVolumesFound
+	}
+	basePath, _ := BasePath(paths[0])
+	if err := checkContiguous(logger, basePath, paths); err != nil {
+		return nil, err
+	}
+	if err := checkManifest(logger, basePath, paths); err != nil {
+		return nil, err
+	}
+	logger.Debug("resolved volumes", slog.Any("volumes", paths))
+	return paths, nil
+}
+
+// checkContiguous makes sure the volumes belong to one set and are numbered from 001 without gaps.
+func checkContiguous(logger *slog.Logger, basePath string, paths []string) error {
+	for i, p := range paths {
+		volumeBasePath, _ := BasePath(p)
+		if volumeBasePath != basePath {
+			logger.Error("volumes belong to different archives", slog.String("volume", p), slog.String("basePath", basePath))
+			return fmt.Errorf("%w: %s is not a volume of %s", ErrVolumeMismatch, p, basePath)
+		}
+		if expected := Path(basePath, i+1); p != expected {
+			logger.Error("volume is missing", slog.String("expected", expected), slog.String("found", p))
+			return fmt.Errorf("%w: expected %s found %s", ErrMissingVolume, expected, p)
+		}
+	}
+	return nil
+}
+
+// readManifest reads the manifest for the base path, and returns false if there is none.
+func readManifest(logger *slog.Logger, basePath string) (Manifest, bool, error) {
+	manifest := Manifest{}
+	manifestPath := ManifestPath(basePath)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Debug("no volume manifest found", slog.String("manifestPath", manifestPath))
+			return manifest, false, nil
+		}
+		return manifest, false, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, false, fmt.Errorf("failed to parse volume manifest: %w", err)
+	}
+	return manifest, true, nil
+}
+
+// checkManifest compares the volume names and sizes to the manifest if there is one.
+func checkManifest(logger *slog.Logger, basePath string, paths []string) error {
+	manifest, ok, err := readManifest(logger, basePath)
+	if err != nil || !ok {
+		return err
+	}
+	if len(manifest.Volumes) != len(paths) {
+		logger.Error("volume count does not match manifest", slog.Int("numVolumes", len(paths)), slog.Int("manifestVolumes", len(manifest.Volumes)))
+		return fmt.Errorf("%w: found %d volumes expected %d", ErrVolumeMismatch, len(paths), len(manifest.Volumes))
+	}
+	for i, p := range paths {
+		expected := manifest.Volumes[i]
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if filepath.Base(p) != expected.Name || info.Size() != expected.Size {
+			logger.Error("volume does not match manifest", slog.String("volume", p), slog.Int64("size", info.Size()), slog.Any("expected", expected))
+			return fmt.Errorf("%w: %s", ErrVolumeMismatch, p)
+		}
+	}
+	return nil
+}
+
+// Reader reads a set of volumes in order as one stream.
+type Reader struct {
+	files  []*os.File
+	reader io.Reader
+}
+
+// OpenReader opens the volumes and returns a reader over their concatenated content.
+// If a manifest is found next to the volumes each volume is hashed as it is read, and a read fails once a volume does not match its SHA-256.
+func OpenReader(logger *slog.Logger, paths []string) (*Reader, error) {
+	r := &Reader{
+		files: make([]*os.File, 0, len(paths)),
+	}
+	var manifest Manifest
+	hasManifest := false
+	if len(paths) > 0 {
+		basePath, _ := BasePath(paths[0])
+		var err error
+		if manifest, hasManifest, err = readManifest(logger, basePath); err != nil {
+			logger.Error("failed to read volume manifest", slog.String("basePath", basePath), slog.String("errorMessage", err.Error()))
+			return nil, err
+		}
+		if hasManifest && len(manifest.Volumes) != len(paths) {
+			logger.Error("volume count does not match manifest", slog.Int("numVolumes", len(paths)), slog.Int("manifestVolumes", len(manifest.Volumes)))
+			return nil, fmt.Errorf("%w: found %d volumes expected %d", ErrVolumeMismatch, len(paths), len(manifest.Volumes))
+		}
+	}
+	readers := make([]io.Reader, 0, len(paths))
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			logger.Error("failed to open volume", slog.String("volume", p), slog.String("errorMessage", err.Error()))
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, f)
+		if !hasManifest {
+			readers = append(readers, f)
+			continue
+		}
+		readers = append(readers, &verifyingReader{
+			logger:   logger,
+			file:     f,
+			hasher:   sha256.New(),
+			expected: manifest.Volumes[i],
+		})
+	}
+	r.reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// Close closes every volume.
+func (r *Reader) Close() error {
+	var closeErr error
+	for _, f := range r.files {
+		if err := f.Close(); err != nil {
+			closeErr = errors.Join(closeErr, err)
+		}
+	}
+	return closeErr
+}
+
+// verifyingReader hashes a volume as it is read and compares the hash to the manifest once the whole volume has been read.
+type verifyingReader struct {
+	logger   *slog.Logger
+	file     *os.File
+	hasher   hash.Hash
+	expected Info
+	read     int64
+	checked  bool
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.file.Read(p)
+	v.hasher.Write(p[:n])
+	v.read += int64(n)
+	// check as soon as the last byte is read, since readers like tar stop before they see the end of the last volume
+	if !v.checked && (v.read >= v.expected.Size || errors.Is(err, io.EOF)) {
+		v.checked = true
+		sum := hex.EncodeToString(v.hasher.Sum(nil))
+		if v.read != v.expected.Size || sum != v.expected.SHA256 {
+			v.logger.Error("volume does not match manifest", slog.String("volume", v.file.Name()), slog.Int64("size", v.read), slog.String("sha256", sum), slog.Any("expected", v.expected))
+			return n, fmt.Errorf("%w: %s checksum does not match", ErrVolumeMismatch, v.file.Name())
+		}
+	}
+	return n, err
+}
+
+func volumeIndex(volumePath string) int {
+	match := volumeSuffixRegex.FindStringSubmatch(volumePath)
+	if match == nil {
+		return 0
+	}
+	index, _ := strconv.Atoi(match[1])
+	return index
+}
@@ -0,0 +1,201 @@
+package volume
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestVolumeRoundTrip(t *testing.T) {
+	logger := mock.NewMockLogger()
+	type testCase struct {
+		Name            string
+		DataSize        int
+		VolumeSize      int64
+		ExpectedVolumes int
+		UseGlob         bool
+	}
+	testCases := []testCase{
+		{
+			Name:            "exact multiple of volume size",
+			DataSize:        4096,
+			VolumeSize:      1024,
+			ExpectedVolumes: 4,
+		},
+		{
+			Name:            "partial last volume",
+			DataSize:        4000,
+			VolumeSize:      1024,
+			ExpectedVolumes: 4,
+		},
+		{
+			Name:            "glob",
+			DataSize:        2500,
+			VolumeSize:      512,
+			ExpectedVolumes: 5,
+			UseGlob:         true,
+		},
+		{
+			Name:            "empty input",
+			DataSize:        0,
+			VolumeSize:      512,
+			ExpectedVolumes: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			basePath := filepath.Join(t.TempDir(), "archive.tar.gz")
+			data := make([]byte, tc.DataSize)
+			if _, err := rand.Read(data); err != nil {
+				t.Errorf("failed to generate data: %v", err)
+				return
+			}
+			w, err := NewWriter(logger, basePath, tc.VolumeSize)
+			if err != nil {
+				t.Errorf("failed to create volume writer: %v", err)
+				return
+			}
+			// write in odd sized pieces so writes straddle volume boundaries
+			for offset := 0; offset < len(data); offset += 300 {
+				end := min(offset+300, len(data))
+				if _, err := w.Write(data[offset:end]); err != nil {
+					t.Errorf("failed to write data: %v", err)
+					return
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Errorf("failed to close volume writer: %v", err)
+				return
+			}
+			manifest := w.Manifest()
+			if len(manifest.Volumes) != tc.ExpectedVolumes {
+				t.Errorf("got %d volumes expected %d", len(manifest.Volumes), tc.ExpectedVolumes)
+				return
+			}
+			for _, v := range manifest.Volumes[:len(manifest.Volumes)-1] {
+				if v.Size != tc.VolumeSize {
+					t.Errorf("volume %s was %d bytes expected %d", v.Name, v.Size, tc.VolumeSize)
+				}
+			}
+			pattern := Path(basePath, 1)
+			if tc.UseGlob {
+				pattern = basePath + ".*"
+			}
+			paths, err := ResolveVolumes(logger, pattern)
+			if err != nil {
+				t.Errorf("failed to resolve volumes: %v", err)
+				return
+			}
+			r, err := OpenReader(logger, paths)
+			if err != nil {
+				t.Errorf("failed to open volumes: %v", err)
+				return
+			}
+			defer r.Close()
+			readData, err := io.ReadAll(r)
+			if err != nil {
+				t.Errorf("failed to read volumes: %v", err)
+				return
+			}
+			if !bytes.Equal(data, readData) {
+				t.Error("data read from volumes does not match data written")
+			}
+		})
+	}
+}
+
+func TestResolveVolumesManifestMismatch(t *testing.T) {
+	logger := mock.NewMockLogger()
+	basePath := filepath.Join(t.TempDir(), "archive.tar")
+	w, err := NewWriter(logger, basePath, 512)
+	if err != nil {
+		t.Errorf("failed to create volume writer: %v", err)
+		return
+	}
+	if _, err := w.Write(make([]byte, 1500)); err != nil {
+		t.Errorf("failed to write data: %v", err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("failed to close volume writer: %v", err)
+		return
+	}
+	if err := os.Remove(Path(basePath, 3)); err != nil {
+		t.Errorf("failed to remove volume: %v", err)
+		return
+	}
+	if _, err := ResolveVolumes(logger, Path(basePath, 1)); !errors.Is(err, ErrVolumeMismatch) {
+		t.Errorf("expected volume mismatch error got %v", err)
+	}
+}
+
+func TestNewWriterInvalidSize(t *testing.T) {
+	if _, err := NewWriter(mock.NewMockLogger(), "archive.tar", 1000); !errors.Is(err, ErrInvalidVolumeSize) {
+		t.Errorf("expected invalid volume size error got %v", err)
+	}
+}
+
+// writeVolumes writes size bytes of random data as 512 byte volumes and returns the base path.
+func writeVolumes(t *testing.T, size int) string {
+	t.Helper()
+	basePath := filepath.Join(t.TempDir(), "archive.tar")
+	w, err := NewWriter(mock.NewMockLogger(), basePath, 512)
+	if err != nil {
+		t.Fatalf("failed to create volume writer: %v", err)
+	}
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate data: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("failed to write data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close volume writer: %v", err)
+	}
+	return basePath
+}
+
+func TestResolveVolumesGaps(t *testing.T) {
+	logger := mock.NewMockLogger()
+	basePath := writeVolumes(t, 1500)
+	if err := os.Remove(ManifestPath(basePath)); err != nil {
+		t.Fatalf("failed to remove manifest: %v", err)
+	}
+	if _, err := ResolveVolumes(logger, Path(basePath, 2)); !errors.Is(err, ErrMissingVolume) {
+		t.Errorf("expected missing volume error when not starting at the first volume got %v", err)
+	}
+	if err := os.Remove(Path(basePath, 2)); err != nil {
+		t.Fatalf("failed to remove volume: %v", err)
+	}
+	if _, err := ResolveVolumes(logger, basePath+".*"); !errors.Is(err, ErrMissingVolume) {
+		t.Errorf("expected missing volume error for a glob with a gap got %v", err)
+	}
+}
+
+func TestOpenReaderVerifiesChecksums(t *testing.T) {
+	logger := mock.NewMockLogger()
+	basePath := writeVolumes(t, 1500)
+	// change the middle volume without changing its size
+	if err := os.WriteFile(Path(basePath, 2), make([]byte, 512), 0644); err != nil {
+		t.Fatalf("failed to overwrite volume: %v", err)
+	}
+	paths, err := ResolveVolumes(logger, Path(basePath, 1))
+	if err != nil {
+		t.Fatalf("failed to resolve volumes: %v", err)
+	}
+	r, err := OpenReader(logger, paths)
+	if err != nil {
+		t.Fatalf("failed to open volumes: %v", err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrVolumeMismatch) {
+		t.Errorf("expected volume mismatch error got %v", err)
+	}
+}
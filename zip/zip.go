@@ -0,0 +1,389 @@
+package zip
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/calvine/filejitsu/encrypt"
+	fgzip "github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/util"
+	"github.com/calvine/filejitsu/util/archivepath"
+)
+
+const (
+	DefaultPermission = 0754
+)
+
+var (
+	ErrTestFailed = errors.New("zip archive test failed")
+)
+
+type EncryptionOptions struct {
+	Passphrase []byte
+}
+
+type ZipPackageParams struct {
+	InputPaths []string
+	// FilesFrom are listed paths packaged after the input paths.
+	FilesFrom      archivepath.FilesFrom
+	ExcludeOptions archivepath.ExcludeOptions
+	Output         io.Writer
+	// CompressionLevel is the deflate level of each member. NoCompression stores members without compressing them.
+	CompressionLevel  fgzip.GZipCompressionLevel
+	UseEncryption     bool
+	EncryptionOptions EncryptionOptions
+}
+
+// ZipReadParams are the params shared by every operation that reads a zip archive.
+type ZipReadParams struct {
+	Input             io.Reader
+	UseEncryption     bool
+	EncryptionOptions EncryptionOptions
+}
+
+type ZipUnpackageParams struct {
+	ZipReadParams
+	OutputPath string
+}
+
+// ListEntry describes a single member of a zip archive.
+type ListEntry struct {
+	Name           string    `json:"name"`
+	IsDir          bool      `json:"isDir,omitempty"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressedSize"`
+	Method         string    `json:"method"`
+	Mode           string    `json:"mode"`
+	Modified       time.Time `json:"modified"`
+	CRC32          uint32    `json:"crc32"`
+}
+
+// TestReport is the result of reading every member of a zip archive and checking it against its CRC-32.
+type TestReport struct {
+	OK         bool          `json:"ok"`
+	NumChecked int           `json:"numChecked"`
+	Failures   []TestFailure `json:"failures"`
+}
+
+type TestFailure struct {
+	Name         string `json:"name"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func ZipPackage(logger *slog.Logger, params ZipPackageParams) error {
+	logger.Debug("attempting to zip package the target path", slog.Any("params", params))
+	if len(params.InputPaths) == 0 && len(params.FilesFrom.Paths) == 0 {
+		errMsg := "no input paths provided for zip archive"
+		logger.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	method := zip.Deflate
+	level := flate.DefaultCompression
+	if params.CompressionLevel == fgzip.NoCompression {
+		method = zip.Store
+	} else if len(params.CompressionLevel) > 0 {
+		var err error
+		level, err = fgzip.GZipCompressionLevelToLevel(params.CompressionLevel)
+		if err != nil {
+			logger.Error("invalid compression level provided", slog.String("compressionLevel", string(params.CompressionLevel)))
+			return err
+		}
+	}
+	out := params.Output
+	if params.UseEncryption {
+		logger.Debug("encryption enabled")
+		encryptedOut, err := encrypt.NewAESEncryptionWriter(logger, out, params.EncryptionOptions.Passphrase)
+		if err != nil {
+			logger.Error("failed to create encrypted stream writer", slog.String("errorMessage", err.Error()))
+			return err
+		}
+		out = encryptedOut
+		defer func() {
+			logger.Debug("closing encryption writer")
+			if err := encryptedOut.Close(); err != nil {
+				logger.Warn("encryption writer failed to close", slog.String("errorMessage", err.Error()))
+			}
+		}()
+	}
+
+	entries, err := archivepath.CollectInputEntries(logger, params.InputPaths, params.FilesFrom, params.ExcludeOptions)
+	if err != nil {
+		logger.Error("failed to collect entities to package", slog.String("errorMessage", err.Error()))
+		return err
+	}
+
+	zipWriter := zip.NewWriter(out)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	for _, entry := range entries {
+		if err := writeZipEntry(logger, zipWriter, entry, method); err != nil {
+			return err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		logger.Error("failed to close zip writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	logger.Info("finished writing zip archive", slog.Int("numEntries", len(entries)))
+	return nil
+}
+
+// writeZipEntry writes the entry to the zip writer keeping its modification time and permissions.
+func writeZipEntry(logger *slog.Logger, zipWriter *zip.Writer, entry archivepath.Entry, method uint16) error {
+	entryLogger := logger.With(slog.String("path", entry.Path))
+	header, err := zip.FileInfoHeader(entry.Info)
+	if err != nil {
+		entryLogger.Error("failed to create zip header for file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	header.Name = filepath.ToSlash(entry.Name)
+	if entry.Info.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	} else {
+		header.Method = method
+	}
+	w, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		entryLogger.Error("failed to write zip header for file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if !entry.Info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		entryLogger.Error("failed to open file", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			entryLogger.Error("failed to close file", slog.String("errorMessage", err.Error()))
+		}
+	}()
+	bytesWritten, err := io.Copy(w, f)
+	entryLogger.Debug("bytes written to zip writer", slog.Int64("bytesWritten", bytesWritten))
+	if err != nil {
+		entryLogger.Error("failed to copy file to zip writer", slog.String("errorMessage", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// OpenZipReader returns a zip reader for the input. Zip archives are read from the end so unless the input is an unencrypted file it is spooled to a temporary file first.
+// The returned func must be called when done reading.
+func OpenZipReader(logger *slog.Logger, params ZipReadParams) (*zip.Reader, func(), error) {
+	closeFunc := func() {}
+	if f, ok := params.Input.(*os.File); ok && !params.UseEncryption {
+		info, err := f.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			logger.Debug("reading zip archive directly from input file")
+			r, err := zip.NewReader(f, info.Size())
+			if err != nil {
+				logger.Error("failed to read zip archive", slog.String("errorMessage", err.Error()))
+			}
+			return r, closeFunc, err
+		}
+	}
+	in := params.Input
+	if params.UseEncryption {
+		logger.Debug("using decryption for zip read")
+		decryptionReader, err := encrypt.NewAESDecryptionReader(logger, in, params.EncryptionOptions.Passphrase)
+		if err != nil {
+			logger.Error("failed to create decryption reader", slog.String("errorMessage", err.Error()))
+			return nil, closeFunc, err
+		}
+		in = decryptionReader
+	}
+	tmp, err := os.CreateTemp("", "filejitsu-zip-*")
+	if err != nil {
+		logger.Error("failed to create temporary file for zip archive", slog.String("errorMessage", err.Error()))
+		return nil, closeFunc, err
+	}
+	closeFunc = func() {
+		tmp.Close()
+		if err := os.Remove(tmp.Name()); err != nil {
+			logger.Warn("failed to remove temporary zip archive", slog.String("path", tmp.Name()), slog.String("errorMessage", err.Error()))
+		}
+	}
+	logger.Debug("spooling zip archive to temporary file", slog.String("path", tmp.Name()))
+	size, err := io.Copy(tmp, in)
+	if err != nil {
+		logger.Error("failed to spool zip archive", slog.String("errorMessage", err.Error()))
+		closeFunc()
+		return nil, func() {}, err
+	}
+	r, err := zip.NewReader(tmp, size)
+	if err != nil {
+		logger.Error("failed to read zip archive", slog.String("errorMessage", err.Error()))
+		closeFunc()
+		return nil, func() {}, err
+	}
+	return r, closeFunc, nil
+}
+
+func ZipUnpackage(logger *slog.Logger, params ZipUnpackageParams) error {
+	zipReader, closeZipReader, err := OpenZipReader(logger, params.ZipReadParams)
+	if err != nil {
+		return err
+	}
+	defer closeZipReader()
+	if err := util.MakeAllDirIfNotExists(logger, params.OutputPath, DefaultPermission); err != nil {
+		logger.Error("failed to create output directory", slog.String("outputPath", params.OutputPath), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	type dirTime struct {
+		target   string
+		modified time.Time
+	}
+	// directory times are set last, otherwise creating their contents would change them again
+	dirTimes := make([]dirTime, 0)
+	numFiles := 0
+	for _, f := range zipReader.File {
+		target, err := archivepath.SafeTargetPath(params.OutputPath, f.Name)
+		if err != nil {
+			logger.Error("refusing to unpackage item outside of output path", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			logger.Debug("got directory from zip", slog.String("target", target))
+			if err := util.MakeAllDirIfNotExists(logger, target, DefaultPermission); err != nil {
+				logger.Error("failed to make target directory", slog.String("target", target))
+				return err
+			}
+			dirTimes = append(dirTimes, dirTime{target: target, modified: f.Modified})
+		case mode.IsRegular():
+			logger.Debug("got regular file from zip", slog.String("target", target))
+			if err := extractZipFile(logger, f, target); err != nil {
+				return err
+			}
+			numFiles++
+		default:
+			logger.Warn("skipping zip member that is not a regular file or directory", slog.String("name", f.Name), slog.String("mode", mode.String()))
+		}
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].target, dirTimes[i].modified, dirTimes[i].modified); err != nil {
+			logger.Warn("failed to set directory modification time", slog.String("target", dirTimes[i].target), slog.String("errorMessage", err.Error()))
+		}
+	}
+	logger.Debug("finished reading zip file", slog.Int("numFiles", numFiles))
+	return nil
+}
+
+func extractZipFile(logger *slog.Logger, f *zip.File, target string) error {
+	if err := util.MakeAllDirIfNotExists(logger, filepath.Dir(target), DefaultPermission); err != nil {
+		logger.Error("failed to create directory for file", slog.String("target", target), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		logger.Error("failed to open zip member", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		logger.Error("failed to open target file for unpackaging", slog.String("target", target))
+		return err
+	}
+	bytesWritten, err := io.Copy(out, rc)
+	logger.Debug("bytes written to output file", slog.String("target", target), slog.Int64("bytesWritten", bytesWritten))
+	if err != nil {
+		out.Close()
+		logger.Error("failed to write zip data to output file", slog.String("target", target), slog.String("errorMessage", err.Error()))
+		return err
+	}
+	if err := out.Close(); err != nil {
+		logger.Warn("failed to close target file", slog.String("target", target), slog.String("errorMessage", err.Error()))
+	}
+	if err := os.Chtimes(target, f.Modified, f.Modified); err != nil {
+		logger.Warn("failed to set file modification time", slog.String("target", target), slog.String("errorMessage", err.Error()))
+	}
+	return nil
+}
+
+// ZipList returns the members of the archive in the order they are stored.
+func ZipList(logger *slog.Logger, params ZipReadParams) ([]ListEntry, error) {
+	zipReader, closeZipReader, err := OpenZipReader(logger, params)
+	if err != nil {
+		return nil, err
+	}
+	defer closeZipReader()
+	entries := make([]ListEntry, 0, len(zipReader.File))
+	for _, f := range zipReader.File {
+		entries = append(entries, ListEntry{
+			Name:           f.Name,
+			IsDir:          f.Mode().IsDir(),
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			Method:         methodName(f.Method),
+			Mode:           f.Mode().String(),
+			Modified:       f.Modified,
+			CRC32:          f.CRC32,
+		})
+	}
+	return entries, nil
+}
+
+// ZipTest reads every member of the archive, which checks the content against the stored CRC-32, and reports the members that fail.
+// Member names that would escape the output directory on extraction are reported as failures too.
+func ZipTest(logger *slog.Logger, params ZipReadParams) (TestReport, error) {
+	report := TestReport{
+		Failures: make([]TestFailure, 0),
+	}
+	zipReader, closeZipReader, err := OpenZipReader(logger, params)
+	if err != nil {
+		return report, err
+	}
+	defer closeZipReader()
+	for _, f := range zipReader.File {
+		report.NumChecked++
+		if err := testZipFile(f); err != nil {
+			logger.Debug("zip member failed test", slog.String("name", f.Name), slog.String("errorMessage", err.Error()))
+			report.Failures = append(report.Failures, TestFailure{
+				Name:         f.Name,
+				ErrorMessage: err.Error(),
+			})
+		}
+	}
+	report.OK = len(report.Failures) == 0
+	logger.Info("finished testing zip archive", slog.Bool("ok", report.OK), slog.Int("numChecked", report.NumChecked), slog.Int("numFailures", len(report.Failures)))
+	return report, nil
+}
+
+func testZipFile(f *zip.File) error {
+	if _, err := archivepath.SafeTargetPath(".", f.Name); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// the zip reader checks the CRC-32 once the member is fully read
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func methodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("method-%d", method)
+}
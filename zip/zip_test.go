@@ -0,0 +1,136 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fgzip "github.com/calvine/filejitsu/gzip"
+	"github.com/calvine/filejitsu/util/archivepath"
+	"github.com/calvine/filejitsu/util/mock"
+)
+
+func TestZipRoundTrip(t *testing.T) {
+	type testCase struct {
+		Name             string
+		CompressionLevel fgzip.GZipCompressionLevel
+		UseEncryption    bool
+	}
+	testCases := []testCase{
+		{Name: "deflate", CompressionLevel: fgzip.BestCompression},
+		{Name: "store", CompressionLevel: fgzip.NoCompression},
+		{Name: "encrypted", CompressionLevel: fgzip.DefaultCompression, UseEncryption: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			logger := mock.NewMockLogger()
+			inputPath, content, cleanup, err := mock.MakeGenericMockDirTree()
+			if err != nil {
+				t.Errorf("failed to create test dir tree: %v", err)
+				return
+			}
+			defer cleanup()
+			modTime := time.Date(2020, 5, 6, 7, 8, 10, 0, time.UTC)
+			file1Path := filepath.Join(inputPath, "file1.txt")
+			if err := os.Chtimes(file1Path, modTime, modTime); err != nil {
+				t.Errorf("failed to set mod time: %v", err)
+				return
+			}
+			if err := os.Chmod(file1Path, 0600); err != nil {
+				t.Errorf("failed to set mode: %v", err)
+				return
+			}
+			encryptionOptions := EncryptionOptions{Passphrase: []byte("test1")}
+			var archive bytes.Buffer
+			err = ZipPackage(logger, ZipPackageParams{
+				InputPaths:        []string{inputPath},
+				Output:            &archive,
+				CompressionLevel:  tc.CompressionLevel,
+				UseEncryption:     tc.UseEncryption,
+				EncryptionOptions: encryptionOptions,
+			})
+			if err != nil {
+				t.Errorf("failed to package zip: %v", err)
+				return
+			}
+			readParams := ZipReadParams{
+				Input:             bytes.NewReader(archive.Bytes()),
+				UseEncryption:     tc.UseEncryption,
+				EncryptionOptions: encryptionOptions,
+			}
+			report, err := ZipTest(logger, readParams)
+			if err != nil || !report.OK {
+				t.Errorf("expected zip to pass test: %v %+v", err, report)
+				return
+			}
+			outputPath := mock.GetRandomTmpDirName()
+			defer os.RemoveAll(outputPath)
+			readParams.Input = bytes.NewReader(archive.Bytes())
+			err = ZipUnpackage(logger, ZipUnpackageParams{
+				ZipReadParams: readParams,
+				OutputPath:    outputPath,
+			})
+			if err != nil {
+				t.Errorf("failed to unpackage zip: %v", err)
+				return
+			}
+			if err := mock.ConfirmContentMapMatches(outputPath, content); err != nil {
+				t.Errorf("failed in comparison of unzipped files: %v", err)
+				return
+			}
+			info, err := os.Stat(filepath.Join(outputPath, "file1.txt"))
+			if err != nil {
+				t.Errorf("failed to stat unzipped file: %v", err)
+				return
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("mod time was %v expected %v", info.ModTime(), modTime)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("mode was %o expected %o", info.Mode().Perm(), 0600)
+			}
+		})
+	}
+}
+
+func TestZipUnpackagePathTraversal(t *testing.T) {
+	logger := mock.NewMockLogger()
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	w, err := zipWriter.Create("../escaped.txt")
+	if err != nil {
+		t.Errorf("failed to create zip member: %v", err)
+		return
+	}
+	if _, err := w.Write([]byte("evil")); err != nil {
+		t.Errorf("failed to write zip member: %v", err)
+		return
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Errorf("failed to close zip writer: %v", err)
+		return
+	}
+	outputPath := filepath.Join(t.TempDir(), "out")
+	err = ZipUnpackage(logger, ZipUnpackageParams{
+		ZipReadParams: ZipReadParams{Input: bytes.NewReader(archive.Bytes())},
+		OutputPath:    outputPath,
+	})
+	if !errors.Is(err, archivepath.ErrPathTraversal) {
+		t.Errorf("expected path traversal error got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(outputPath), "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected member to not be written outside of output path: %v", err)
+	}
+	report, err := ZipTest(logger, ZipReadParams{Input: bytes.NewReader(archive.Bytes())})
+	if err != nil {
+		t.Errorf("failed to test zip: %v", err)
+		return
+	}
+	if report.OK || len(report.Failures) != 1 {
+		t.Errorf("expected traversal member to fail the test: %+v", report)
+	}
+}